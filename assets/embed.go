@@ -7,3 +7,4 @@ var AssetDir embed.FS
 
 var ChangePasswordPageKey string = "html/changePasswordPage.html"
 var ResetPwEmailKey string = "html/resetPwEmail.html"
+var HouseholdInviteEmailKey string = "html/householdInviteEmail.html"
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
+	"github.com/frozenkro/dirtie-srv/internal/hub"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+var breadcrumbCmds = map[string]command{
+	"publish": {
+		usage: "breadcrumb publish [-count <n>] [-interval <d>] -r <name=value>... <macAddr>",
+		help:  "publish test breadcrumbs to the broker as a device would",
+		run:   breadcrumbPublish,
+	},
+}
+
+// readingsFlag collects repeated -r name=value flags
+type readingsFlag map[string]float64
+
+func (r readingsFlag) String() string {
+	parts := make([]string, 0, len(r))
+	for k, v := range r {
+		parts = append(parts, fmt.Sprintf("%v=%v", k, v))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (r readingsFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected name=value")
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	r[name] = v
+	return nil
+}
+
+func breadcrumbPublish(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("breadcrumb publish", flag.ContinueOnError)
+	readings := readingsFlag{}
+	fs.Var(readings, "r", "reading as name=value, repeatable")
+	count := fs.Int("count", 1, "breadcrumbs to publish")
+	interval := fs.Duration("interval", time.Second, "wait between breadcrumbs")
+	pos, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if len(readings) == 0 || *count < 1 {
+		return errUsage
+	}
+	macAddr := pos[0]
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	// fail here rather than have the server dead-letter them
+	for name, v := range readings {
+		m, err := deps.Measurements.Get(name)
+		if err != nil {
+			return err
+		}
+		if err = m.Validate(v); err != nil {
+			return err
+		}
+	}
+
+	client, err := connectBroker()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(250)
+	deps.MqttPublisher.SetClient(client)
+
+	topic := core_topics.DeviceTopic(macAddr, core_topics.DeviceBreadcrumb)
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		ts := time.Now().Unix()
+		payload, err := json.Marshal(services.BreadCrumb{
+			MacAddr:   macAddr,
+			Readings:  readings,
+			Timestamp: &ts,
+		})
+		if err != nil {
+			return err
+		}
+		if err = deps.MqttPublisher.Publish(ctx, topic, 1, payload); err != nil {
+			return err
+		}
+		fmt.Printf("published to %v: %s\n", topic, payload)
+	}
+	return nil
+}
+
+// connectBroker opens a connection of its own, so it doesn't take over
+// the server's client id
+func connectBroker() (mqtt.Client, error) {
+	opts := hub.ClientOptions(fmt.Sprintf("dirtie_cli_%d", os.Getpid()))
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return nil, fmt.Errorf("Error connecting to mqtt broker %v: timed out", hub.BrokerURI())
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("Error connecting to mqtt broker %v: %w", hub.BrokerURI(), token.Error())
+	}
+	return client, nil
+}
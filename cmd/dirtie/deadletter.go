@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/frozenkro/dirtie-srv/internal/hub"
+)
+
+var deadLetterCmds = map[string]command{
+	"list": {
+		usage: "deadletter list [-limit <n>] [-offset <n>]",
+		help:  "list payloads the hub failed to handle",
+		run:   deadLetterList,
+	},
+	"replay": {
+		usage: "deadletter replay <id>...",
+		help:  "run dead-letter payloads through the topic handlers again",
+		run:   deadLetterReplay,
+	},
+}
+
+func deadLetterList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("deadletter list", flag.ContinueOnError)
+	limit := fs.Int("limit", 50, "rows to list")
+	offset := fs.Int("offset", 0, "rows to skip")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	letters, err := deps.DeadLetterSvc.List(ctx, int32(*limit), int32(*offset))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTOPIC\tATTEMPTS\tCREATED\tERROR")
+	for _, dl := range letters {
+		// handler errors are wrapped over several lines
+		errMsg := strings.Join(strings.Fields(dl.Error), " ")
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
+			dl.DeadLetterID, dl.Topic, dl.Attempts, formatTime(dl.CreatedAt.Time), errMsg)
+	}
+	return w.Flush()
+}
+
+// deadLetterReplay handles the payloads in this process, against the
+// same stores the server uses. Handlers may publish (push
+// notifications, for one), so it connects to the broker under its own
+// client id first. Replays that fail stay in the store with their
+// attempt count raised.
+func deadLetterReplay(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	ids := make([]int64, 0, len(args))
+	for _, a := range args {
+		id, err := strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			return errUsage
+		}
+		ids = append(ids, id)
+	}
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	client, err := connectBroker()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(250)
+	deps.MqttPublisher.SetClient(client)
+
+	if err := hub.StartOffline(deps); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, id := range ids {
+		dl, err := deps.DeadLetterSvc.Replay(ctx, id)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "dead letter %v: %v\n", id, err)
+			continue
+		}
+		fmt.Printf("dead letter %v replayed (%v)\n", id, dl.Topic)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v replay(s) failed", failed, len(ids))
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+var deviceCmds = map[string]command{
+	"list": {
+		usage: "device list [-email <owner>]",
+		help:  "list devices with their last-seen times",
+		run:   deviceList,
+	},
+	"bind": {
+		usage: "device bind <deviceId> <macAddr>",
+		help:  "bind a mac address to a device, taking it from any other",
+		run:   deviceBind,
+	},
+	"provision": {
+		usage: "device provision -email <owner> [-name <name>]",
+		help:  "create a device and its provision contract for a user",
+		run:   deviceProvision,
+	},
+	"export": {
+		usage: "device export [-start <time>] [-end <time>] [-out <file>] <deviceId>",
+		help:  "write a device's raw readings as csv",
+		run:   deviceExport,
+	},
+}
+
+func deviceList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("device list", flag.ContinueOnError)
+	email := fs.String("email", "", "only list devices owned by this user")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	var devices []sqlc.Device
+	var err error
+	if *email == "" {
+		devices, err = deps.DeviceRepo.GetAllDevices(ctx)
+	} else {
+		var user sqlc.User
+		if user, err = lookupUser(ctx, deps.UserRepo, *email); err != nil {
+			return err
+		}
+		devices, err = deps.DeviceRepo.GetDevicesByUser(ctx, user.UserID)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER\tNAME\tMAC\tSTATUS\tLAST SEEN")
+	for _, d := range devices {
+		status := "offline"
+		if d.Online {
+			status = "online"
+		}
+		lastSeen := "never"
+		if d.LastSeen.Valid {
+			lastSeen = formatTime(d.LastSeen.Time)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			d.DeviceID, d.UserID, d.DisplayName.String, orDash(d.MacAddr.String), status, lastSeen)
+	}
+	return w.Flush()
+}
+
+func deviceBind(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	deviceId, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil {
+		return errUsage
+	}
+
+	// looked up as it will be stored, so the previous owner is found
+	macAddr := services.NormalizeMacAddr(args[1])
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	previous, err := deps.DeviceRepo.GetDeviceByMacAddress(ctx, macAddr)
+	if err != nil {
+		return err
+	}
+	device, err := deps.DeviceSvc.ForceBindDevice(ctx, int32(deviceId), macAddr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("device %v bound to %v\n", device.DeviceID, device.MacAddr.String)
+	if previous.DeviceID > 0 && previous.DeviceID != device.DeviceID {
+		fmt.Printf("unbound from device %v\n", previous.DeviceID)
+	}
+	return nil
+}
+
+func deviceProvision(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("device provision", flag.ContinueOnError)
+	email := fs.String("email", "", "user the device is created for")
+	name := fs.String("name", "", "display name for the device")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errUsage
+	}
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	user, err := lookupUser(ctx, deps.UserRepo, *email)
+	if err != nil {
+		return err
+	}
+	if user.DisabledAt.Valid {
+		return fmt.Errorf("user '%v': %w", *email, services.ErrUserDisabled)
+	}
+
+	// provisioning acts for the user in context, as it does behind the api
+	ctx = context.WithValue(ctx, "user", &user)
+	prv, err := deps.DeviceSvc.CreateDeviceProvision(ctx, *name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("device:   %v\ncontract: %v\nexpires:  %v\n", prv.DeviceID, prv.Contract.String, formatTime(prv.ExpiresAt.Time))
+	return nil
+}
+
+func deviceExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("device export", flag.ContinueOnError)
+	start := fs.String("start", "", "RFC 3339 time to export from (default 30 days before end)")
+	end := fs.String("end", "", "RFC 3339 time to export up to (default now)")
+	out := fs.String("out", "", "file to write (default stdout)")
+	pos, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	deviceId, err := strconv.Atoi(pos[0])
+	if err != nil {
+		return errUsage
+	}
+
+	q := db.RangeQuery{End: time.Now()}
+	if *end != "" {
+		if q.End, err = time.Parse(time.RFC3339, *end); err != nil {
+			return fmt.Errorf("Invalid end time: %w", err)
+		}
+	}
+	q.Start = q.End.Add(-30 * 24 * time.Hour)
+	if *start != "" {
+		if q.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+			return fmt.Errorf("Invalid start time: %w", err)
+		}
+	}
+	if !q.Start.Before(q.End) {
+		return fmt.Errorf("start must be before end")
+	}
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	device, err := deps.DeviceRepo.GetDevice(ctx, int32(deviceId))
+	if err != nil {
+		return err
+	}
+	if device.DeviceID <= 0 {
+		return fmt.Errorf("device %v: %w", deviceId, services.ErrNoDevice)
+	}
+
+	var points []db.DeviceDataPoint
+	for _, m := range deps.Measurements.All() {
+		p, err := deps.InfluxRepo.QueryRange(ctx, deviceId, m.Name, q)
+		if err != nil {
+			return err
+		}
+		points = append(points, p...)
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "measurement", "value"})
+	for _, p := range points {
+		cw.Write([]string{
+			p.Time.UTC().Format(time.RFC3339),
+			p.Key,
+			strconv.FormatFloat(p.Value, 'f', -1, 64),
+		})
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		return err
+	}
+
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "wrote %v reading(s) to %v\n", len(points), *out)
+	}
+	return nil
+}
+
+type userEmailGetter interface {
+	GetUserFromEmail(ctx context.Context, email string) (sqlc.User, error)
+}
+
+func lookupUser(ctx context.Context, users userEmailGetter, email string) (sqlc.User, error) {
+	user, err := users.GetUserFromEmail(ctx, email)
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	if user.UserID <= 0 {
+		return sqlc.User{}, fmt.Errorf("user '%v': %w", email, services.ErrNoUser)
+	}
+	return user, nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
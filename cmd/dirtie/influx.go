@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+var influxCmds = map[string]command{
+	"backfill": {
+		usage: "influx backfill",
+		help:  "aggregate all existing breadcrumbs into the rollup buckets",
+		run:   influxBackfill,
+	},
+}
+
+// influxBackfill is run once before retention is first shortened, so
+// raw data older than the rollup tasks' lookback reaches the rollups
+// before it expires
+func influxBackfill(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	if err := deps.InfluxRepo.BackfillRollups(ctx); err != nil {
+		return err
+	}
+	fmt.Println("rollups backfilled")
+	return nil
+}
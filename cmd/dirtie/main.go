@@ -0,0 +1,114 @@
+// dirtie runs the server and the admin commands used to operate a
+// deployment. Admin commands build the same dependencies as the server
+// from the same environment.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+)
+
+// command is a leaf of the command tree, run with the arguments left
+// after its name
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]map[string]command{
+	"serve":      {"": serveCmd},
+	"migrate":    migrateCmds,
+	"user":       userCmds,
+	"device":     deviceCmds,
+	"deadletter": deadLetterCmds,
+	"breadcrumb": breadcrumbCmds,
+	"influx":     influxCmds,
+}
+
+// errUsage makes main print the command's usage instead of the error
+var errUsage = fmt.Errorf("Invalid arguments")
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	group, ok := commands[os.Args[1]]
+	if !ok {
+		printUsage()
+		os.Exit(2)
+	}
+	name, args := "", os.Args[2:]
+	if _, single := group[""]; !single {
+		if len(args) == 0 {
+			printUsage()
+			os.Exit(2)
+		}
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := group[name]
+	if !ok {
+		printUsage()
+		os.Exit(2)
+	}
+
+	core.SetupEnv()
+	if err := cmd.run(context.Background(), args); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "usage: dirtie %v\n", cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	var lines []string
+	for _, group := range commands {
+		for _, cmd := range group {
+			lines = append(lines, fmt.Sprintf("  %v\n      %v", cmd.usage, cmd.help))
+		}
+	}
+	sort.Strings(lines)
+	fmt.Fprintf(os.Stderr, "usage: dirtie <command>\n\ncommands:\n%v\n", strings.Join(lines, "\n"))
+}
+
+// adminDeps builds the server's dependencies for an admin command, and
+// a func that releases them. Admin commands leave the schema alone;
+// `dirtie migrate` changes it.
+func adminDeps(ctx context.Context) (*di.Deps, func()) {
+	core.POSTGRES_MIGRATE_ON_START = false
+	deps := di.NewDeps(ctx)
+	return deps, func() {
+		deps.InfluxRepo.Disconnect()
+		deps.RepoFactory.Close()
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05 MST")
+}
+
+// parseFlags parses args into fs, which must leave exactly nArgs
+// positional arguments
+func parseFlags(fs *flag.FlagSet, args []string, nArgs int) ([]string, error) {
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != nArgs {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
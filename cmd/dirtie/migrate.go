@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/frozenkro/dirtie-srv/internal/db"
+	"github.com/frozenkro/dirtie-srv/internal/db/migrate"
+)
+
+var migrateCmds = map[string]command{
+	"up": {
+		usage: "migrate up",
+		help:  "apply every pending migration",
+		run:   migrateUp,
+	},
+	"down": {
+		usage: "migrate down [n]",
+		help:  "roll back the last n applied migrations (default 1)",
+		run:   migrateDown,
+	},
+	"status": {
+		usage: "migrate status",
+		help:  "list migrations and whether they are applied",
+		run:   migrateStatus,
+	},
+}
+
+// withMigrator connects without migrating, so down and status see the
+// schema as it is
+func withMigrator(ctx context.Context, fn func(m *migrate.Migrator) error) error {
+	pool, err := db.PgOpen(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	m, err := db.NewMigrator(pool)
+	if err != nil {
+		return err
+	}
+	return fn(m)
+}
+
+func migrateUp(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	return withMigrator(ctx, func(m *migrate.Migrator) error {
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %v migration(s)\n", len(applied))
+		return nil
+	})
+}
+
+func migrateDown(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	steps := 1
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return errUsage
+		}
+		steps = n
+	}
+
+	return withMigrator(ctx, func(m *migrate.Migrator) error {
+		reverted, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %v migration(s)\n", len(reverted))
+		return nil
+	})
+}
+
+func migrateStatus(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	return withMigrator(ctx, func(m *migrate.Migrator) error {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + formatTime(s.AppliedAt)
+			}
+			fmt.Printf("%04d  %-30v %v\n", s.Version, s.Name, applied)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/api"
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/hub"
+	"github.com/frozenkro/dirtie-srv/internal/lifecycle"
+)
+
+var serveCmd = command{
+	usage: "serve",
+	help:  "run the api, mqtt hub and device sweeper",
+	run:   serve,
+}
+
+func serve(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	fmt.Print("Running dirtie-srv mono driver\n")
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	deps := di.NewDeps(context.Background())
+
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	sweepDone := make(chan struct{})
+
+	lc := lifecycle.NewManager()
+	// started top to bottom, stopped bottom to top
+	components := []lifecycle.Component{
+		{
+			Name: "postgres",
+			Stop: func(ctx context.Context) error {
+				deps.RepoFactory.Close()
+				return nil
+			},
+		},
+		{
+			Name: "influx",
+			Start: func(ctx context.Context) error {
+				if !core.INFLUX_MANAGE_TIERS {
+					return nil
+				}
+				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				defer cancel()
+				// influx isn't needed to serve most requests, so don't
+				// hold up startup over it
+				if err := deps.InfluxRepo.EnsureTiers(ctx); err != nil {
+					utils.LogErr(fmt.Sprintf("influx retention tiers not updated: %v\n", err))
+				}
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				deps.InfluxRepo.Disconnect()
+				return nil
+			},
+		},
+		{
+			Name: "hub",
+			Start: func(ctx context.Context) error {
+				return hub.Start(deps)
+			},
+			Stop:        hub.Stop,
+			StopTimeout: 15 * time.Second,
+		},
+		{
+			Name: "device sweeper",
+			Start: func(ctx context.Context) error {
+				go func() {
+					defer close(sweepDone)
+					deps.DeviceStatusSvc.RunSweeper(sweepCtx,
+						time.Duration(core.DEVICE_SWEEP_INTERVAL_SEC)*time.Second)
+				}()
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				stopSweep()
+				select {
+				case <-sweepDone:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			},
+		},
+		{
+			Name: "api",
+			Start: func(ctx context.Context) error {
+				return api.Start(deps, lc)
+			},
+			Stop:        api.Shutdown,
+			StopTimeout: 10 * time.Second,
+		},
+	}
+	for _, c := range components {
+		if err := lc.Add(c); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := lc.Start(ctx); err != nil {
+		return fmt.Errorf("startup failed: %v", err)
+	}
+
+	<-ctx.Done()
+	fmt.Println("SIGTERM rcvd, shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(core.SHUTDOWN_TIMEOUT_SEC)*time.Second)
+	defer cancel()
+
+	if err := lc.Stop(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown incomplete: %v", err)
+	}
+	fmt.Println("shutdown complete")
+	return nil
+}
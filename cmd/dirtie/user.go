@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+var userCmds = map[string]command{
+	"create": {
+		usage: "user create -email <email> -name <name>",
+		help:  "create a user, reading the password from stdin",
+		run:   userCreate,
+	},
+	"disable": {
+		usage: "user disable <email>",
+		help:  "stop a user signing in and end their sessions",
+		run:   userDisable,
+	},
+	"enable": {
+		usage: "user enable <email>",
+		help:  "let a disabled user sign in again",
+		run:   userEnable,
+	},
+}
+
+func userCreate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	email := fs.String("email", "", "email address the user signs in with")
+	name := fs.String("name", "", "display name")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if *email == "" || *name == "" {
+		return errUsage
+	}
+
+	// kept out of the arguments so it doesn't end up in shell history
+	fmt.Fprint(os.Stderr, "password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("Error reading password: %w", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fmt.Errorf("password can't be empty")
+	}
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	user, err := deps.AuthSvc.CreateUser(ctx, *email, password, *name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created user %v (%v)\n", user.UserID, user.Email)
+	return nil
+}
+
+func userDisable(ctx context.Context, args []string) error {
+	return setUserDisabled(ctx, args, true)
+}
+
+func userEnable(ctx context.Context, args []string) error {
+	return setUserDisabled(ctx, args, false)
+}
+
+func setUserDisabled(ctx context.Context, args []string, disabled bool) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	deps, done := adminDeps(ctx)
+	defer done()
+
+	var user sqlc.User
+	var err error
+	if disabled {
+		user, err = deps.AuthSvc.DisableUser(ctx, args[0])
+	} else {
+		user, err = deps.AuthSvc.EnableUser(ctx, args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.DisabledAt.Valid {
+		fmt.Printf("user %v (%v) disabled since %v\n", user.UserID, user.Email, formatTime(user.DisabledAt.Time))
+	} else {
+		fmt.Printf("user %v (%v) enabled\n", user.UserID, user.Email)
+	}
+	return nil
+}
@@ -1,18 +1,26 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/frozenkro/dirtie-srv/internal/api/handlers"
 	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/core"
 	"github.com/frozenkro/dirtie-srv/internal/core/utils"
 	"github.com/frozenkro/dirtie-srv/internal/di"
 )
 
 const PORT = 8080
 
-func Init(deps *di.Deps) {
+var server, metricsServer *http.Server
+
+// Start registers handlers and begins serving in the background.
+// Binding the port happens up front so a taken port fails Start.
+func Start(deps *di.Deps, ready handlers.ReadinessChecker) error {
 	rootHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "request sent to root /\n")
 	})
@@ -22,14 +30,64 @@ func Init(deps *di.Deps) {
 		middleware.Authorize(deps.AuthSvc),
 	))
 
+	handlers.SetupProbeHandlers(deps, ready)
 	handlers.SetupAuthHandlers(deps)
 	handlers.SetupDeviceHandlers(deps)
+	handlers.SetupCommandHandlers(deps)
+	handlers.SetupHubHandlers(deps)
+	handlers.SetupDeadLetterHandlers(deps)
 	handlers.SetupDatahanders(deps)
+	handlers.SetupAlertHandlers(deps)
+	handlers.SetupCalibrationHandlers(deps)
+	handlers.SetupHouseholdHandlers(deps)
+	handlers.SetupNotificationHandlers(deps)
+
+	portStr := fmt.Sprintf(":%v", PORT)
+	ln, err := net.Listen("tcp", portStr)
+	if err != nil {
+		return fmt.Errorf("Error api Start -> Listen: %w", err)
+	}
 
+	server = &http.Server{
+		Addr: portStr,
+		Handler: middleware.Adapt(http.DefaultServeMux,
+			middleware.Instrument(deps.Metrics, http.DefaultServeMux)),
+	}
 	utils.LogInfo(fmt.Sprintf("Starting web server on port %v", PORT))
 
-	portStr := fmt.Sprintf(":%v", PORT)
-	if err := http.ListenAndServe(portStr, nil); err != nil {
-		utils.LogErr(fmt.Sprintf("Web server error: %v\n", err))
+	// metrics get a listener of their own so only the cluster's
+	// scraper can reach them
+	metricsPortStr := fmt.Sprintf(":%v", core.METRICS_PORT)
+	metricsLn, err := net.Listen("tcp", metricsPortStr)
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("Error api Start -> Listen (metrics): %w", err)
+	}
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", deps.Metrics.Handler())
+	metricsServer = &http.Server{Addr: metricsPortStr, Handler: metricsMux}
+	utils.LogInfo(fmt.Sprintf("Serving metrics on port %v", core.METRICS_PORT))
+
+	go serve(server, ln)
+	go serve(metricsServer, metricsLn)
+	return nil
+}
+
+func serve(s *http.Server, ln net.Listener) {
+	if err := s.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		utils.LogErr(fmt.Sprintf("Web server error (%v): %v\n", s.Addr, err))
+	}
+}
+
+// Shutdown stops accepting connections and waits for in-flight
+// requests to finish or ctx to expire
+func Shutdown(ctx context.Context) error {
+	if server == nil {
+		return nil
+	}
+	err := server.Shutdown(ctx)
+	if metricsServer != nil {
+		err = errors.Join(err, metricsServer.Shutdown(ctx))
 	}
+	return err
 }
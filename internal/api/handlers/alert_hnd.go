@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/dto"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+func SetupAlertHandlers(deps *di.Deps) {
+	http.Handle("GET /devices/{id}/alerts", middleware.Adapt(
+		getAlertRulesHandler(deps.AlertSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("POST /devices/{id}/alerts", middleware.Adapt(
+		createAlertRuleHandler(deps.AlertSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("GET /devices/{id}/alerts/history", middleware.Adapt(
+		getAlertHistoryHandler(deps.AlertSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("GET /devices/{id}/alerts/{ruleId}", middleware.Adapt(
+		getAlertRuleHandler(deps.AlertSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("PUT /devices/{id}/alerts/{ruleId}", middleware.Adapt(
+		updateAlertRuleHandler(deps.AlertSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("DELETE /devices/{id}/alerts/{ruleId}", middleware.Adapt(
+		deleteAlertRuleHandler(deps.AlertSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("POST /devices/{id}/alerts/{ruleId}/snooze", middleware.Adapt(
+		snoozeAlertRuleHandler(deps.AlertSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+}
+
+func getAlertRulesHandler(alertSvc services.AlertSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		rules, err := alertSvc.GetRules(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), alertErrStatus(err))
+			return
+		}
+
+		dtoList := make([]dto.AlertRuleDto, len(rules))
+		for i, rule := range rules {
+			dtoList[i] = *dto.NewAlertRuleDto(rule)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func createAlertRuleHandler(alertSvc services.AlertSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req services.AlertRuleRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		rule, err := alertSvc.CreateRule(r.Context(), deviceId, req)
+		if err != nil {
+			http.Error(w, err.Error(), alertErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewAlertRuleDto(rule))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(res)
+	})
+}
+
+func getAlertRuleHandler(alertSvc services.AlertSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+		ruleId, ok := ruleIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		rule, err := alertSvc.GetRule(r.Context(), deviceId, ruleId)
+		if err != nil {
+			http.Error(w, err.Error(), alertErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewAlertRuleDto(rule))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func updateAlertRuleHandler(alertSvc services.AlertSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+		ruleId, ok := ruleIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req services.AlertRuleRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		rule, err := alertSvc.UpdateRule(r.Context(), deviceId, ruleId, req)
+		if err != nil {
+			http.Error(w, err.Error(), alertErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewAlertRuleDto(rule))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func deleteAlertRuleHandler(alertSvc services.AlertSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+		ruleId, ok := ruleIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		err := alertSvc.DeleteRule(r.Context(), deviceId, ruleId)
+		if err != nil {
+			http.Error(w, err.Error(), alertErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func snoozeAlertRuleHandler(alertSvc services.AlertSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+		ruleId, ok := ruleIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req services.SnoozeRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		rule, err := alertSvc.SnoozeRule(r.Context(), deviceId, ruleId, req)
+		if err != nil {
+			http.Error(w, err.Error(), alertErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewAlertRuleDto(rule))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func getAlertHistoryHandler(alertSvc services.AlertSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+		limit, err := queryInt(r, "limit")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		events, err := alertSvc.GetHistory(r.Context(), deviceId, int32(limit))
+		if err != nil {
+			http.Error(w, err.Error(), alertErrStatus(err))
+			return
+		}
+
+		dtoList := make([]dto.AlertEventDto, len(events))
+		for i, e := range events {
+			dtoList[i] = *dto.NewAlertEventDto(e)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func ruleIdFromPath(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	ruleId, err := strconv.Atoi(r.PathValue("ruleId"))
+	if err != nil {
+		http.Error(w, "path parameter 'ruleId' must be a number", http.StatusBadRequest)
+		return 0, false
+	}
+	return int32(ruleId), true
+}
+
+func alertErrStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidAlertRule):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrAlertRuleNotFound):
+		return http.StatusNotFound
+	default:
+		return deviceErrStatus(err)
+	}
+}
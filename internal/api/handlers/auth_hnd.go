@@ -108,10 +108,12 @@ func LoginHandler(authSvc services.AuthSvc) http.Handler {
 		if err != nil {
 			if errors.Is(err, services.ErrInvalidPassword) {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
+			} else if errors.Is(err, services.ErrUserDisabled) {
+				http.Error(w, err.Error(), http.StatusForbidden)
 			} else {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
 			}
+			return
 		}
 
 		cookie := http.Cookie{
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/dto"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+func SetupCalibrationHandlers(deps *di.Deps) {
+	http.Handle("GET /devices/{id}/calibration", middleware.Adapt(
+		getCalibrationHandler(deps.CalibrationSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("PUT /devices/{id}/calibration", middleware.Adapt(
+		setCalibrationHandler(deps.CalibrationSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("DELETE /devices/{id}/calibration", middleware.Adapt(
+		deleteCalibrationHandler(deps.CalibrationSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("POST /devices/{id}/calibration/{reference}", middleware.Adapt(
+		recordCalibrationHandler(deps.CalibrationSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+}
+
+func getCalibrationHandler(calibrationSvc services.CalibrationSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		profile, err := calibrationSvc.GetProfile(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), calibrationErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewCalibrationProfileDto(profile))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func setCalibrationHandler(calibrationSvc services.CalibrationSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req services.CalibrationRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		profile, err := calibrationSvc.SetProfile(r.Context(), deviceId, req)
+		if err != nil {
+			http.Error(w, err.Error(), calibrationErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewCalibrationProfileDto(profile))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+// records the device's current reading as the dry or wet reference
+func recordCalibrationHandler(calibrationSvc services.CalibrationSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		profile, err := calibrationSvc.RecordReference(r.Context(), deviceId, r.PathValue("reference"))
+		if err != nil {
+			http.Error(w, err.Error(), calibrationErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewCalibrationProfileDto(profile))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func deleteCalibrationHandler(calibrationSvc services.CalibrationSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		err := calibrationSvc.DeleteProfile(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), calibrationErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func calibrationErrStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidCalibration):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrCalibrationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrNoCalibrationReading):
+		return http.StatusConflict
+	default:
+		return deviceErrStatus(err)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/dto"
+	"github.com/frozenkro/dirtie-srv/internal/hub/publisher"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+func SetupCommandHandlers(deps *di.Deps) {
+	http.Handle("GET /devices/{id}/commands", middleware.Adapt(
+		getCommandsHandler(deps.CommandSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("POST /devices/{id}/commands", middleware.Adapt(
+		sendCommandHandler(deps.CommandSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("GET /devices/{id}/commands/{commandId}", middleware.Adapt(
+		getCommandHandler(deps.CommandSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+}
+
+func getCommandsHandler(commandSvc services.CommandSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		cmds, err := commandSvc.GetCommands(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), commandErrStatus(err))
+			return
+		}
+
+		dtoList := make([]dto.CommandDto, len(cmds))
+		for i, c := range cmds {
+			dtoList[i] = *dto.NewCommandDto(c)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func sendCommandHandler(commandSvc services.CommandSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req services.CommandRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		cmd, err := commandSvc.SendCommand(r.Context(), deviceId, req)
+		if err != nil {
+			http.Error(w, err.Error(), commandErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewCommandDto(cmd))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(res)
+	})
+}
+
+func getCommandHandler(commandSvc services.CommandSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		cmd, err := commandSvc.GetCommand(r.Context(), deviceId, r.PathValue("commandId"))
+		if err != nil {
+			http.Error(w, err.Error(), commandErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewCommandDto(cmd))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func commandErrStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidCommand),
+		errors.Is(err, services.ErrDeviceNotProvisioned):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrCommandNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, publisher.ErrNotConnected):
+		return http.StatusServiceUnavailable
+	default:
+		return deviceErrStatus(err)
+	}
+}
@@ -3,71 +3,52 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
 	"github.com/frozenkro/dirtie-srv/internal/core"
-	"github.com/frozenkro/dirtie-srv/internal/db"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
 	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/dto"
+	"github.com/frozenkro/dirtie-srv/internal/services"
 )
 
-type capReader interface {
-	CapacitanceData(context.Context, int, string) ([]db.DeviceDataPoint, error)
+type measurementReader interface {
+	MeasurementData(ctx context.Context, deviceId int, q services.DataQuery) (services.DataSeries, error)
 }
 
-type tempReader interface {
-	TemperatureData(context.Context, int, string) ([]db.DeviceDataPoint, error)
+type measurementLister interface {
+	ListMeasurements() []measurements.Measurement
 }
 
 func SetupDatahanders(deps *di.Deps) {
-	http.Handle("GET /data/capacitance", middleware.Adapt(
-		getCapHandler(deps.DataSvc),
+	http.Handle("GET /measurements", middleware.Adapt(
+		getMeasurementsHandler(deps.DataSvc),
 		middleware.LogTransaction(),
 		middleware.Authorize(deps.AuthSvc),
 	))
-	http.Handle("GET /data/temperature", middleware.Adapt(
-		getTempHandler(deps.DataSvc),
+	http.Handle("GET /data/{measurement}", middleware.Adapt(
+		GetDataHandler(deps.DataSvc),
 		middleware.LogTransaction(),
 		middleware.Authorize(deps.AuthSvc),
 	))
 }
 
-func getCapHandler(cr capReader) http.Handler {
+func getMeasurementsHandler(ml measurementLister) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		params := r.URL.Query()
-		deviceIdStr := params.Get("deviceId")
-		if deviceIdStr == "" {
-			http.Error(w, core.GetMissingParamError("deviceId"), http.StatusBadRequest)
-			return
-		}
-		deviceId, err := strconv.Atoi(deviceIdStr)
-		if err != nil {
-			http.Error(w, "parameter 'deviceId' must be a number", http.StatusBadRequest)
-			return
-		}
-
-		startTime := params.Get("startTime")
-		if startTime == "" {
-			http.Error(w, core.GetMissingParamError("startTime"), http.StatusBadRequest)
-			return
-		}
-
-		data, err := cr.CapacitanceData(r.Context(), deviceId, startTime)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-
-		res, err := json.Marshal(data)
+		res, err := json.Marshal(ml.ListMeasurements())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		w.Write(res)
 	})
 }
 
-func getTempHandler(tr tempReader) http.Handler {
+func GetDataHandler(mr measurementReader) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		params := r.URL.Query()
 		deviceIdStr := params.Get("deviceId")
@@ -87,16 +68,37 @@ func getTempHandler(tr tempReader) http.Handler {
 			return
 		}
 
-		data, err := tr.TemperatureData(r.Context(), deviceId, startTime)
+		series, err := mr.MeasurementData(r.Context(), deviceId, services.DataQuery{
+			Measurement: r.PathValue("measurement"),
+			StartTime:   startTime,
+			EndTime:     params.Get("endTime"),
+			Window:      params.Get("window"),
+			Aggregate:   params.Get("aggregate"),
+		})
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), dataErrStatus(err))
+			return
 		}
 
-		res, err := json.Marshal(data)
+		res, err := json.Marshal(dto.NewDataSeriesDto(series))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		w.Write(res)
 	})
 }
+
+func dataErrStatus(err error) int {
+	switch {
+	case errors.Is(err, measurements.ErrUnknownMeasurement):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidStartTime),
+		errors.Is(err, services.ErrInvalidDataQuery),
+		errors.Is(err, services.ErrTooManyPoints):
+		return http.StatusBadRequest
+	default:
+		return deviceErrStatus(err)
+	}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/dto"
+	"github.com/frozenkro/dirtie-srv/internal/hub/dispatch"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+type purgeResponse struct {
+	Purged int64 `json:"purged"`
+}
+
+func SetupDeadLetterHandlers(deps *di.Deps) {
+	http.Handle("GET /admin/dead-letters", middleware.Adapt(
+		listDeadLettersHandler(deps.DeadLetterSvc),
+		middleware.RequireAdmin(core.ADMIN_EMAILS),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("DELETE /admin/dead-letters", middleware.Adapt(
+		purgeDeadLettersHandler(deps.DeadLetterSvc),
+		middleware.RequireAdmin(core.ADMIN_EMAILS),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("GET /admin/dead-letters/{id}", middleware.Adapt(
+		getDeadLetterHandler(deps.DeadLetterSvc),
+		middleware.RequireAdmin(core.ADMIN_EMAILS),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("DELETE /admin/dead-letters/{id}", middleware.Adapt(
+		purgeDeadLetterHandler(deps.DeadLetterSvc),
+		middleware.RequireAdmin(core.ADMIN_EMAILS),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("POST /admin/dead-letters/{id}/replay", middleware.Adapt(
+		replayDeadLetterHandler(deps.DeadLetterSvc),
+		middleware.RequireAdmin(core.ADMIN_EMAILS),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+}
+
+func listDeadLettersHandler(dlSvc services.DeadLetterSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, err := queryInt(r, "limit")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		offset, err := queryInt(r, "offset")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dls, err := dlSvc.List(r.Context(), int32(limit), int32(offset))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		dtoList := make([]dto.DeadLetterDto, len(dls))
+		for i, d := range dls {
+			dtoList[i] = *dto.NewDeadLetterDto(d)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func getDeadLetterHandler(dlSvc services.DeadLetterSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := deadLetterIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		dl, err := dlSvc.Get(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), deadLetterErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewDeadLetterDto(dl))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func replayDeadLetterHandler(dlSvc services.DeadLetterSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := deadLetterIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		_, err := dlSvc.Replay(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), deadLetterErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func purgeDeadLetterHandler(dlSvc services.DeadLetterSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := deadLetterIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		err := dlSvc.Purge(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), deadLetterErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Purges everything created before ?before= (RFC3339), or everything
+// when it is omitted
+func purgeDeadLettersHandler(dlSvc services.DeadLetterSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		before := time.Now()
+		if v := r.URL.Query().Get("before"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "query parameter 'before' must be an RFC3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			before = t
+		}
+
+		n, err := dlSvc.PurgeBefore(r.Context(), before)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res, err := json.Marshal(purgeResponse{Purged: n})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func deadLetterIdFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "path parameter 'id' must be a number", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func queryInt(r *http.Request, key string) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("query parameter '%v' must be a number", key)
+	}
+	return n, nil
+}
+
+func deadLetterErrStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDeadLetterNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, dispatch.ErrNoDispatcher):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, services.ErrReplayFailed):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
 	"github.com/frozenkro/dirtie-srv/internal/core"
@@ -11,8 +15,19 @@ import (
 	"github.com/frozenkro/dirtie-srv/internal/services"
 )
 
+type latestBrdCrmGetter interface {
+	GetLatestBrdCrm(ctx context.Context, deviceId int) (*services.BreadCrumb, error)
+}
+
+// householdId null stops sharing the device
+type ShareDeviceRequest struct {
+	HouseholdId *int32 `json:"householdId"`
+}
+
 type CreateProvisionResponse struct {
-	Contract string `json:"contract"`
+	DeviceId  int32     `json:"deviceId"`
+	Contract  string    `json:"contract"`
+	ExpiresAt time.Time `json:"expiresAt"`
 }
 
 func SetupDeviceHandlers(deps *di.Deps) {
@@ -22,11 +37,59 @@ func SetupDeviceHandlers(deps *di.Deps) {
 		middleware.Authorize(deps.AuthSvc),
 	))
 
+	http.Handle("GET /devices/{id}", middleware.Adapt(
+		getDeviceHandler(deps.DeviceSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
+	http.Handle("PATCH /devices/{id}", middleware.Adapt(
+		updateDeviceHandler(deps.DeviceSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
+	http.Handle("DELETE /devices/{id}", middleware.Adapt(
+		deleteDeviceHandler(deps.DeviceSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
+	http.Handle("GET /devices/{id}/latest", middleware.Adapt(
+		getLatestReadingHandler(deps.DeviceSvc, deps.BrdCrmSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
 	http.Handle("POST /devices/createProvision", middleware.Adapt(
 		createDeviceProvisionHandler(deps.DeviceSvc),
 		middleware.LogTransaction(),
 		middleware.Authorize(deps.AuthSvc),
 	))
+
+	http.Handle("DELETE /devices/{id}/provision", middleware.Adapt(
+		cancelDeviceProvisionHandler(deps.DeviceSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
+	http.Handle("POST /devices/{id}/reset", middleware.Adapt(
+		resetDeviceHandler(deps.DeviceSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
+	http.Handle("PUT /devices/{id}/household", middleware.Adapt(
+		shareDeviceHandler(deps.DeviceSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
+	http.Handle("GET /devices/{id}/connections", middleware.Adapt(
+		getDeviceConnectionsHandler(deps.DeviceConnectionSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
 }
 
 func getUserDevicesHandler(deviceSvc services.DeviceSvc) http.Handler {
@@ -42,7 +105,116 @@ func getUserDevicesHandler(deviceSvc services.DeviceSvc) http.Handler {
 			dtoList[i] = *dto.NewDeviceDto(d)
 		}
 
-		res, err := json.Marshal(devices)
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func getDeviceHandler(deviceSvc services.DeviceSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		device, err := deviceSvc.GetUserDevice(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewDeviceDto(device))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func updateDeviceHandler(deviceSvc services.DeviceSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req services.DeviceUpdateRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		device, err := deviceSvc.UpdateDevice(r.Context(), deviceId, req)
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewDeviceDto(device))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func deleteDeviceHandler(deviceSvc services.DeviceSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		err := deviceSvc.DeleteDevice(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// reads the latest value of each measurement from the time series
+// store, rather than the snapshot kept on the device row
+func getLatestReadingHandler(deviceSvc services.DeviceSvc, brdCrmGetter latestBrdCrmGetter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		device, err := deviceSvc.GetUserDevice(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrStatus(err))
+			return
+		}
+		brdCrm, err := brdCrmGetter.GetLatestBrdCrm(r.Context(), int(device.DeviceID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		d := dto.NewDeviceDto(device)
+		d.Latest = nil
+		if brdCrm.Timestamp != nil {
+			d.Latest = &dto.LatestReadingDto{
+				Time:     time.Unix(*brdCrm.Timestamp, 0).UTC(),
+				Readings: brdCrm.Readings,
+			}
+		}
+
+		res, err := json.Marshal(d)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -61,12 +233,16 @@ func createDeviceProvisionHandler(deviceSvc services.DeviceSvc) http.Handler {
 			return
 		}
 
-		contract, err := deviceSvc.CreateDeviceProvision(r.Context(), displayName)
+		prv, err := deviceSvc.CreateDeviceProvision(r.Context(), displayName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		res := CreateProvisionResponse{Contract: contract}
+		res := CreateProvisionResponse{
+			DeviceId:  prv.DeviceID,
+			Contract:  prv.Contract.String,
+			ExpiresAt: prv.ExpiresAt.Time,
+		}
 		res_b, err := json.Marshal(res)
 		if err != nil {
 			// todo log stuff like this
@@ -76,3 +252,134 @@ func createDeviceProvisionHandler(deviceSvc services.DeviceSvc) http.Handler {
 		w.Write(res_b)
 	})
 }
+
+func cancelDeviceProvisionHandler(deviceSvc services.DeviceSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		err := deviceSvc.CancelDeviceProvision(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func resetDeviceHandler(deviceSvc services.DeviceSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		device, err := deviceSvc.ResetDevice(r.Context(), deviceId)
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewDeviceDto(device))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func shareDeviceHandler(deviceSvc services.DeviceSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req ShareDeviceRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		device, err := deviceSvc.ShareDevice(r.Context(), deviceId, req.HouseholdId)
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewDeviceDto(device))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func getDeviceConnectionsHandler(connSvc services.DeviceConnectionSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId, ok := deviceIdFromPath(w, r)
+		if !ok {
+			return
+		}
+		limit, err := queryInt(r, "limit")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		events, err := connSvc.GetHistory(r.Context(), deviceId, int32(limit))
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrStatus(err))
+			return
+		}
+
+		dtoList := make([]dto.DeviceConnectionEventDto, len(events))
+		for i, e := range events {
+			dtoList[i] = *dto.NewDeviceConnectionEventDto(e)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func deviceIdFromPath(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	deviceId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "path parameter 'id' must be a number", http.StatusBadRequest)
+		return 0, false
+	}
+	return int32(deviceId), true
+}
+
+func deviceErrStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrNoDevice):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrDeviceForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrDeviceReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrInvalidDevice):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrNoProvision):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrDeviceNotProvisioned):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
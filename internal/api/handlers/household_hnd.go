@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/dto"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+type AcceptInviteRequest struct {
+	Token string `json:"token"`
+}
+
+type AcceptInviteResponse struct {
+	HouseholdId int32  `json:"householdId"`
+	Role        string `json:"role"`
+}
+
+func SetupHouseholdHandlers(deps *di.Deps) {
+	http.Handle("GET /households", middleware.Adapt(
+		getHouseholdsHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("POST /households", middleware.Adapt(
+		createHouseholdHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("DELETE /households/{householdId}", middleware.Adapt(
+		deleteHouseholdHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
+	http.Handle("GET /households/{householdId}/members", middleware.Adapt(
+		getHouseholdMembersHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("PUT /households/{householdId}/members/{userId}", middleware.Adapt(
+		updateHouseholdMemberHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("DELETE /households/{householdId}/members/{userId}", middleware.Adapt(
+		removeHouseholdMemberHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+
+	http.Handle("GET /households/{householdId}/invites", middleware.Adapt(
+		getHouseholdInvitesHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("POST /households/{householdId}/invites", middleware.Adapt(
+		createHouseholdInviteHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("DELETE /households/{householdId}/invites/{inviteId}", middleware.Adapt(
+		revokeHouseholdInviteHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("POST /households/invites/accept", middleware.Adapt(
+		acceptHouseholdInviteHandler(deps.HouseholdSvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+}
+
+func getHouseholdsHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		households, err := householdSvc.GetHouseholds(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		dtoList := make([]dto.HouseholdDto, len(households))
+		for i, h := range households {
+			household := sqlc.Household{HouseholdID: h.HouseholdID, Name: h.Name, CreatedAt: h.CreatedAt}
+			dtoList[i] = *dto.NewHouseholdDto(household, h.Role)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func createHouseholdHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req services.HouseholdRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		household, err := householdSvc.CreateHousehold(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewHouseholdDto(household, services.RoleOwner))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(res)
+	})
+}
+
+func deleteHouseholdHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		householdId, ok := pathInt(w, r, "householdId")
+		if !ok {
+			return
+		}
+
+		err := householdSvc.DeleteHousehold(r.Context(), int32(householdId))
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func getHouseholdMembersHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		householdId, ok := pathInt(w, r, "householdId")
+		if !ok {
+			return
+		}
+
+		members, err := householdSvc.GetMembers(r.Context(), int32(householdId))
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		dtoList := make([]dto.HouseholdMemberDto, len(members))
+		for i, m := range members {
+			dtoList[i] = *dto.NewHouseholdMemberDto(m)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func updateHouseholdMemberHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		householdId, ok := pathInt(w, r, "householdId")
+		if !ok {
+			return
+		}
+		userId, ok := pathInt(w, r, "userId")
+		if !ok {
+			return
+		}
+
+		var req services.MemberRoleRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		err = householdSvc.UpdateMemberRole(r.Context(), int32(householdId), int32(userId), req)
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// owners remove members, members remove themselves to leave
+func removeHouseholdMemberHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		householdId, ok := pathInt(w, r, "householdId")
+		if !ok {
+			return
+		}
+		userId, ok := pathInt(w, r, "userId")
+		if !ok {
+			return
+		}
+
+		err := householdSvc.RemoveMember(r.Context(), int32(householdId), int32(userId))
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func getHouseholdInvitesHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		householdId, ok := pathInt(w, r, "householdId")
+		if !ok {
+			return
+		}
+
+		invites, err := householdSvc.GetInvites(r.Context(), int32(householdId))
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		dtoList := make([]dto.HouseholdInviteDto, len(invites))
+		for i, inv := range invites {
+			dtoList[i] = *dto.NewHouseholdInviteDto(inv)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func createHouseholdInviteHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		householdId, ok := pathInt(w, r, "householdId")
+		if !ok {
+			return
+		}
+
+		var req services.InviteRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		invite, err := householdSvc.Invite(r.Context(), int32(householdId), req)
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewHouseholdInviteDto(invite))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(res)
+	})
+}
+
+func revokeHouseholdInviteHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		householdId, ok := pathInt(w, r, "householdId")
+		if !ok {
+			return
+		}
+		inviteId, ok := pathInt(w, r, "inviteId")
+		if !ok {
+			return
+		}
+
+		err := householdSvc.RevokeInvite(r.Context(), int32(householdId), inviteId)
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func acceptHouseholdInviteHandler(householdSvc services.HouseholdSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AcceptInviteRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Token == "" {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		member, err := householdSvc.AcceptInvite(r.Context(), req.Token)
+		if err != nil {
+			http.Error(w, err.Error(), householdErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(AcceptInviteResponse{
+			HouseholdId: member.HouseholdID,
+			Role:        member.Role,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func pathInt(w http.ResponseWriter, r *http.Request, key string) (int64, bool) {
+	n, err := strconv.ParseInt(r.PathValue(key), 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("path parameter '%v' must be a number", key), http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+func householdErrStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidHousehold):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrHouseholdNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrNoUser):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrHouseholdForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrInviteInvalid):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrLastOwner):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrNotificationLimited):
+		return http.StatusTooManyRequests
+	default:
+		return deviceErrStatus(err)
+	}
+}
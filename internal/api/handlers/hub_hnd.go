@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/hub"
+)
+
+func SetupHubHandlers(deps *di.Deps) {
+	http.Handle("GET /hub/stats", middleware.Adapt(
+		hubStatsHandler(),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	// unauthenticated so it can back a probe
+	http.Handle("GET /hub/health", hubHealthHandler())
+}
+
+func hubStatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := json.Marshal(hub.Stats())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+// 200 only while connected with subscriptions in place
+func hubHealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := hub.ConnectionStatus()
+
+		res, err := json.Marshal(status)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if status.State != hub.StateConnected || !status.Subscribed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(res)
+	})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/dto"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+func SetupNotificationHandlers(deps *di.Deps) {
+	http.Handle("GET /notifications/prefs", middleware.Adapt(
+		getNotificationPrefsHandler(deps.NotifySvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("PUT /notifications/prefs/{channel}", middleware.Adapt(
+		updateNotificationPrefHandler(deps.NotifySvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	http.Handle("GET /notifications/log", middleware.Adapt(
+		getNotificationLogHandler(deps.NotifySvc),
+		middleware.LogTransaction(),
+		middleware.Authorize(deps.AuthSvc),
+	))
+}
+
+func getNotificationPrefsHandler(notifySvc services.NotificationSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefs, err := notifySvc.GetPrefs(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		dtoList := make([]dto.NotificationPrefDto, len(prefs))
+		for i, p := range prefs {
+			dtoList[i] = *dto.NewNotificationPrefDto(p)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func updateNotificationPrefHandler(notifySvc services.NotificationSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req services.NotificationPrefRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, core.RequestParseError, http.StatusBadRequest)
+			return
+		}
+
+		pref, err := notifySvc.UpdatePref(r.Context(), r.PathValue("channel"), req)
+		if err != nil {
+			http.Error(w, err.Error(), notificationErrStatus(err))
+			return
+		}
+
+		res, err := json.Marshal(dto.NewNotificationPrefDto(pref))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func getNotificationLogHandler(notifySvc services.NotificationSvc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, err := queryInt(r, "limit")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, err := notifySvc.GetLog(r.Context(), int32(limit))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		dtoList := make([]dto.NotificationLogDto, len(entries))
+		for i, e := range entries {
+			dtoList[i] = *dto.NewNotificationLogDto(e)
+		}
+
+		res, err := json.Marshal(dtoList)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+func notificationErrStatus(err error) int {
+	if errors.Is(err, services.ErrInvalidNotificationPref) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
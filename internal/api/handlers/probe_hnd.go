@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/health"
+	"github.com/frozenkro/dirtie-srv/internal/hub"
+)
+
+type ReadinessChecker interface {
+	Ready() bool
+}
+
+// Unauthenticated, polled by the k8s probes.
+// Only postgres is critical: everything else degrades part of the
+// api, and pulling every replica out of the service because the
+// broker is down would take the rest of it with them.
+func SetupProbeHandlers(deps *di.Deps, ready ReadinessChecker) {
+	checker := health.NewChecker(0,
+		health.Check{Name: "postgres", Critical: true, Fn: deps.RepoFactory.Ping},
+		health.Check{Name: "influx", Fn: deps.InfluxRepo.Ping},
+		health.Check{Name: "mqtt", Fn: mqttCheck},
+		health.Check{Name: "loki", Fn: deps.LokiClient.Ping},
+	)
+
+	http.Handle("GET /healthz", healthHandler(checker))
+	http.Handle("GET /readyz", readyHandler(checker, ready))
+}
+
+// Liveness: reports on dependencies but only fails if the server
+// can't answer at all, so an outage elsewhere doesn't restart pods
+func healthHandler(checker *health.Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, checker.Run(r.Context()), http.StatusOK)
+	})
+}
+
+// Readiness: 503 until startup completes, once shutdown begins, or
+// while a critical dependency is down
+func readyHandler(checker *health.Checker, ready ReadinessChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Run(r.Context())
+		if !ready.Ready() {
+			report.Ready = false
+		}
+
+		code := http.StatusOK
+		if !report.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeReport(w, report, code)
+	})
+}
+
+func writeReport(w http.ResponseWriter, report health.Report, code int) {
+	res, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(res)
+}
+
+func mqttCheck(ctx context.Context) error {
+	status := hub.ConnectionStatus()
+	if status.State != hub.StateConnected {
+		return fmt.Errorf("mqtt %v: %v", status.State, status.LastError)
+	}
+	if !status.Subscribed {
+		return fmt.Errorf("mqtt connected but not subscribed: %v", status.LastError)
+	}
+	return nil
+}
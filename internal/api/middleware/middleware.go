@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/core/utils"
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
@@ -63,9 +65,78 @@ func Authorize(authSvc TokenValidator) Adapter {
 	}
 }
 
+// RequireAdmin must run after Authorize, so list it before
+// Authorize when calling Adapt
+func RequireAdmin(adminEmails []string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := r.Context().Value("user").(*sqlc.User)
+			if !ok || user == nil || !isAdmin(user.Email, adminEmails) {
+				http.Error(w, "admin access required", http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isAdmin(email string, adminEmails []string) bool {
+	for _, admin := range adminEmails {
+		if strings.EqualFold(email, admin) {
+			return true
+		}
+	}
+	return false
+}
+
 func isUnauthorized(user *sqlc.User, err error) bool {
 	return errors.Is(err, services.ErrExpiredToken) ||
 		errors.Is(err, services.ErrInvalidToken) ||
 		user == nil ||
 		user.UserID < 1
 }
+
+type HttpObserver interface {
+	ObserveHttp(route string, method string, status int, d time.Duration)
+}
+
+type RouteResolver interface {
+	Handler(r *http.Request) (h http.Handler, pattern string)
+}
+
+// Instrument records every request against the mux pattern it matched
+// rather than the raw path, so ids in paths don't blow up cardinality
+func Instrument(observer HttpObserver, routes RouteResolver) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := routes.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			h.ServeHTTP(sw, r)
+			observer.ObserveHttp(route, r.Method, sw.status, time.Since(start))
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
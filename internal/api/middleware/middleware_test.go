@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/frozenkro/dirtie-srv/internal/services"
@@ -118,3 +119,85 @@ func TestAuthorize(t *testing.T) {
 		})
 	}
 }
+
+func TestRequireAdmin(t *testing.T) {
+	admins := []string{"admin@dirtie.dev"}
+
+	tests := []struct {
+		name           string
+		user           *sqlc.User
+		expectedStatus int
+	}{
+		{
+			name:           "Admin",
+			user:           &sqlc.User{UserID: 1, Email: "Admin@dirtie.dev"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Not admin",
+			user:           &sqlc.User{UserID: 2, Email: "user@dirtie.dev"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "No user",
+			user:           nil,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+			handler := RequireAdmin(admins)(mockHandler)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/admin/test", nil)
+			if tt.user != nil {
+				r = r.WithContext(context.WithValue(r.Context(), "user", tt.user))
+			}
+
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+type fakeHttpObserver struct {
+	route  string
+	method string
+	status int
+}
+
+func (f *fakeHttpObserver) ObserveHttp(route string, method string, status int, d time.Duration) {
+	f.route = route
+	f.method = method
+	f.status = status
+}
+
+func TestInstrument(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /devices/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+
+	t.Run("Matched", func(t *testing.T) {
+		obs := &fakeHttpObserver{}
+		handler := Adapt(mux, Instrument(obs, mux))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/devices/12", nil))
+
+		assert.Equal(t, "GET /devices/{id}", obs.route)
+		assert.Equal(t, "GET", obs.method)
+		assert.Equal(t, http.StatusNotFound, obs.status)
+	})
+
+	t.Run("Unmatched", func(t *testing.T) {
+		obs := &fakeHttpObserver{}
+		handler := Adapt(mux, Instrument(obs, mux))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+
+		assert.Equal(t, "unmatched", obs.route)
+	})
+}
@@ -0,0 +1,114 @@
+package calibration
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Moisture is the key used for derived moisture readings
+const Moisture = "moisture"
+
+type Point struct {
+	Raw     float64 `json:"raw"`
+	Percent float64 `json:"percent"`
+}
+
+// Profile maps a device's raw capacitance readings to a moisture
+// percentage. Dry and Wet are reference readings taken in dry and
+// saturated soil, and stand for 0% and 100%. Curve adds points in
+// between for soils that don't read linearly; it can also be used on
+// its own. Readings between points are interpolated linearly, and
+// readings outside the curve read as its nearest end.
+type Profile struct {
+	Dry   *float64
+	Wet   *float64
+	Curve []Point
+}
+
+var ErrInvalidProfile = fmt.Errorf("Invalid calibration profile")
+
+// Points returns every reference point of the profile ordered by raw
+// reading
+func (p Profile) Points() []Point {
+	pts := make([]Point, 0, len(p.Curve)+2)
+	if p.Dry != nil {
+		pts = append(pts, Point{Raw: *p.Dry, Percent: 0})
+	}
+	if p.Wet != nil {
+		pts = append(pts, Point{Raw: *p.Wet, Percent: 100})
+	}
+	pts = append(pts, p.Curve...)
+
+	sort.SliceStable(pts, func(i, j int) bool {
+		return pts[i].Raw < pts[j].Raw
+	})
+	return pts
+}
+
+// Complete is true once the profile has enough points to convert
+// readings
+func (p Profile) Complete() bool {
+	return len(p.Points()) >= 2
+}
+
+// Validate checks the points are usable. A profile with fewer than
+// two points is valid but can't convert anything yet, so the dry and
+// wet references can be recorded one at a time.
+func (p Profile) Validate() error {
+	pts := p.Points()
+
+	for i, pt := range pts {
+		if math.IsNaN(pt.Raw) || math.IsInf(pt.Raw, 0) {
+			return fmt.Errorf("calibration reading %v is not a number: %w", pt.Raw, ErrInvalidProfile)
+		}
+		if pt.Percent < 0 || pt.Percent > 100 {
+			return fmt.Errorf("calibration percent %v must be between 0 and 100: %w", pt.Percent, ErrInvalidProfile)
+		}
+		if i > 0 && pt.Raw == pts[i-1].Raw {
+			return fmt.Errorf("calibration reading %v is used twice: %w", pt.Raw, ErrInvalidProfile)
+		}
+	}
+
+	// capacitance usually drops as soil gets wetter, but it has to
+	// move the same way across the whole curve
+	var dir float64
+	for i := 1; i < len(pts); i++ {
+		d := pts[i].Percent - pts[i-1].Percent
+		if d == 0 {
+			continue
+		}
+		if dir != 0 && math.Signbit(d) != math.Signbit(dir) {
+			return fmt.Errorf("calibration curve must rise or fall steadily: %w", ErrInvalidProfile)
+		}
+		dir = d
+	}
+	if len(pts) >= 2 && dir == 0 {
+		return fmt.Errorf("calibration points all read the same percent: %w", ErrInvalidProfile)
+	}
+	return nil
+}
+
+// Moisture converts a raw reading, ok is false when the profile is
+// not complete
+func (p Profile) Moisture(raw float64) (float64, bool) {
+	pts := p.Points()
+	if len(pts) < 2 {
+		return 0, false
+	}
+
+	if raw <= pts[0].Raw {
+		return pts[0].Percent, true
+	}
+	last := pts[len(pts)-1]
+	if raw >= last.Raw {
+		return last.Percent, true
+	}
+
+	i := sort.Search(len(pts), func(i int) bool {
+		return pts[i].Raw >= raw
+	})
+	lo, hi := pts[i-1], pts[i]
+	frac := (raw - lo.Raw) / (hi.Raw - lo.Raw)
+	return lo.Percent + frac*(hi.Percent-lo.Percent), true
+}
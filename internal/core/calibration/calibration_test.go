@@ -0,0 +1,74 @@
+package calibration
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ref(v float64) *float64 {
+	return &v
+}
+
+func TestMoisture(t *testing.T) {
+	linear := Profile{Dry: ref(3000), Wet: ref(1000)}
+	curve := Profile{Dry: ref(3000), Wet: ref(1000), Curve: []Point{{Raw: 1500, Percent: 80}}}
+
+	tests := []struct {
+		name    string
+		p       Profile
+		raw     float64
+		percent float64
+	}{
+		{"Dry", linear, 3000, 0},
+		{"Wet", linear, 1000, 100},
+		{"Middle", linear, 2000, 50},
+		{"DrierThanDry", linear, 3500, 0},
+		{"WetterThanWet", linear, 500, 100},
+		{"CurveSteep", curve, 1250, 90},
+		{"CurveShallow", curve, 2250, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			percent, ok := tt.p.Moisture(tt.raw)
+			assert.True(t, ok)
+			assert.InDelta(t, tt.percent, percent, 1e-9)
+		})
+	}
+
+	t.Run("Incomplete", func(t *testing.T) {
+		_, ok := Profile{Dry: ref(3000)}.Moisture(2000)
+		assert.False(t, ok)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Profile
+		ok   bool
+	}{
+		{"Empty", Profile{}, true},
+		{"DryOnly", Profile{Dry: ref(3000)}, true},
+		{"DryAndWet", Profile{Dry: ref(3000), Wet: ref(1000)}, true},
+		{"CurveOnly", Profile{Curve: []Point{{Raw: 1000, Percent: 90}, {Raw: 2500, Percent: 10}}}, true},
+		{"SameReading", Profile{Dry: ref(2000), Wet: ref(2000)}, false},
+		{"PercentRange", Profile{Curve: []Point{{Raw: 1000, Percent: 120}}}, false},
+		{"NotMonotonic", Profile{Dry: ref(3000), Wet: ref(1000), Curve: []Point{{Raw: 2500, Percent: 60}, {Raw: 1500, Percent: 40}}}, false},
+		{"Flat", Profile{Curve: []Point{{Raw: 1000, Percent: 50}, {Raw: 2000, Percent: 50}}}, false},
+		{"NaN", Profile{Dry: ref(math.NaN())}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Validate()
+			if tt.ok {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidProfile)
+			}
+		})
+	}
+}
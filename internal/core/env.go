@@ -3,6 +3,8 @@ package core
 import (
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -15,10 +17,30 @@ var (
 	INFLUX_PASSWORD       string
 	INFLUX_URI            string
 
+	// retention tiers are created and maintained at startup unless
+	// disabled. The retention of buckets that already exist is only
+	// changed when INFLUX_APPLY_RETENTION is set, and only shortened
+	// once the rollups have been backfilled.
+	INFLUX_MANAGE_TIERS          bool = true
+	INFLUX_APPLY_RETENTION       bool = false
+	INFLUX_RAW_RETENTION_DAYS    int  = 30
+	INFLUX_HOURLY_RETENTION_DAYS int  = 365
+
+	// breadcrumbs are written in batches of INFLUX_BATCH_SIZE points or
+	// every INFLUX_FLUSH_INTERVAL_MS, whichever comes first. Failed
+	// batches are retried from a buffer of at most INFLUX_RETRY_BUFFER_LIMIT
+	// points; the oldest are dropped when it fills.
+	INFLUX_BATCH_SIZE         int = 500
+	INFLUX_FLUSH_INTERVAL_MS  int = 1000
+	INFLUX_RETRY_BUFFER_LIMIT int = 10000
+	INFLUX_MAX_RETRIES        int = 5
+
 	POSTGRES_SERVER   string
 	POSTGRES_DB       string
 	POSTGRES_USER     string
 	POSTGRES_PASSWORD string
+	// pending schema migrations are applied at startup unless disabled
+	POSTGRES_MIGRATE_ON_START bool = true
 
 	MOSQUITTO_URI    string
 	APP_HOST         string
@@ -27,6 +49,67 @@ var (
 	DOMAIN           string
 	SENDGRID_API_KEY string
 	LOKI_URL         string
+	ADMIN_EMAILS     []string
+
+	// the broker's acl keeps anonymous clients off dirtie-app/, so
+	// user notifications need the server to sign in
+	MOSQUITTO_USERNAME string
+	MOSQUITTO_PASSWORD string
+
+	// JSON measurement registry; built-in defaults when unset
+	MEASUREMENTS_FILE string
+
+	MQTT_LEGACY_TOPICS     bool   = true
+	MQTT_WORKERS           int    = 4
+	MQTT_QUEUE_SIZE        int    = 100
+	MQTT_TOPIC_CONCURRENCY int    = 0
+	MQTT_OVERLOAD_POLICY   string = "block"
+	// a message still being handled after MQTT_HANDLER_TIMEOUT_SEC is
+	// cancelled and dead-lettered
+	MQTT_HANDLER_TIMEOUT_SEC int = 30
+
+	MQTT_RECONNECT_INITIAL_MS int = 1000
+	MQTT_RECONNECT_MAX_SEC    int = 60
+
+	SHUTDOWN_TIMEOUT_SEC int = 25
+
+	// /metrics is served on its own port, kept off the public ingress
+	METRICS_PORT int = 9090
+
+	// device timestamps may run at most BRDCRM_MAX_CLOCK_SKEW_SEC ahead
+	// of the server, and backfilled readings may be at most
+	// BRDCRM_MAX_BACKFILL_DAYS old
+	BRDCRM_MAX_CLOCK_SKEW_SEC int = 60
+	BRDCRM_MAX_BACKFILL_DAYS  int = 7
+	// most breadcrumbs accepted in one batch message
+	BRDCRM_MAX_BATCH int = 500
+
+	// notifications with the same dedupe key go out once per
+	// NOTIFY_DEDUPE_WINDOW_MIN, and each user gets at most
+	// NOTIFY_RATE_LIMIT per channel per hour. NOTIFY_FAKE_CHANNELS
+	// swaps every channel for one that only logs, for local development.
+	NOTIFY_DEDUPE_WINDOW_MIN   int  = 60
+	NOTIFY_RATE_LIMIT          int  = 30
+	NOTIFY_WEBHOOK_TIMEOUT_SEC int  = 5
+	NOTIFY_FAKE_CHANNELS       bool = false
+
+	// provision contracts are single use and expire after
+	// PROVISION_CONTRACT_TTL_MIN
+	PROVISION_CONTRACT_TTL_MIN int = 60
+
+	// emailed household invites expire after HOUSEHOLD_INVITE_TTL_HOURS
+	HOUSEHOLD_INVITE_TTL_HOURS int = 168
+
+	// devices are expected to report every DEVICE_REPORT_INTERVAL_SEC
+	// until sent a sample interval, and are marked offline after missing
+	// DEVICE_OFFLINE_AFTER_INTERVALS of them. The sweeper checks every
+	// DEVICE_SWEEP_INTERVAL_SEC.
+	DEVICE_REPORT_INTERVAL_SEC     int = 300
+	DEVICE_OFFLINE_AFTER_INTERVALS int = 3
+	DEVICE_SWEEP_INTERVAL_SEC      int = 60
+
+	// upper bound on points returned by a single /data query
+	DATA_MAX_POINTS int = 2000
 
 	IS_TEST bool = false
 )
@@ -41,6 +124,7 @@ func SetupTestEnv() {
 	SetupEnv()
 	POSTGRES_SERVER = os.Getenv("POSTGRES_TEST_SERVER")
 	INFLUX_URI = os.Getenv("INFLUX_TEST_URI")
+	NOTIFY_FAKE_CHANNELS = true
 	IS_TEST = true
 }
 
@@ -61,6 +145,15 @@ func SetupEnv() {
 	if INFLUX_URI == "" {
 		INFLUX_URI = "localhost:8086"
 	}
+	INFLUX_MANAGE_TIERS = os.Getenv("INFLUX_MANAGE_TIERS") != "false"
+	INFLUX_APPLY_RETENTION = os.Getenv("INFLUX_APPLY_RETENTION") == "true"
+	POSTGRES_MIGRATE_ON_START = os.Getenv("POSTGRES_MIGRATE_ON_START") != "false"
+	INFLUX_RAW_RETENTION_DAYS = getEnvInt("INFLUX_RAW_RETENTION_DAYS", INFLUX_RAW_RETENTION_DAYS)
+	INFLUX_HOURLY_RETENTION_DAYS = getEnvInt("INFLUX_HOURLY_RETENTION_DAYS", INFLUX_HOURLY_RETENTION_DAYS)
+	INFLUX_BATCH_SIZE = getEnvInt("INFLUX_BATCH_SIZE", INFLUX_BATCH_SIZE)
+	INFLUX_FLUSH_INTERVAL_MS = getEnvInt("INFLUX_FLUSH_INTERVAL_MS", INFLUX_FLUSH_INTERVAL_MS)
+	INFLUX_RETRY_BUFFER_LIMIT = getEnvInt("INFLUX_RETRY_BUFFER_LIMIT", INFLUX_RETRY_BUFFER_LIMIT)
+	INFLUX_MAX_RETRIES = getEnvInt("INFLUX_MAX_RETRIES", INFLUX_MAX_RETRIES)
 
 	POSTGRES_SERVER = os.Getenv("POSTGRES_SERVER")
 	POSTGRES_DB = os.Getenv("POSTGRES_DB")
@@ -68,10 +161,59 @@ func SetupEnv() {
 	POSTGRES_PASSWORD = os.Getenv("POSTGRES_PASSWORD")
 
 	MOSQUITTO_URI = os.Getenv("MOSQUITTO_URI")
+	MOSQUITTO_USERNAME = os.Getenv("MOSQUITTO_USERNAME")
+	MOSQUITTO_PASSWORD = os.Getenv("MOSQUITTO_PASSWORD")
 	APP_HOST = os.Getenv("APP_HOST")
 	ASSETS_DIR = os.Getenv("ASSETS_DIR")
 	DIRTIE_ENV = os.Getenv("DIRTIE_ENV")
 	DOMAIN = os.Getenv("DOMAIN")
 	SENDGRID_API_KEY = os.Getenv("SENDGRID_API_KEY")
 	LOKI_URL = os.Getenv("LOKI_URL")
+	ADMIN_EMAILS = getEnvList("ADMIN_EMAILS")
+	MEASUREMENTS_FILE = os.Getenv("MEASUREMENTS_FILE")
+
+	MQTT_LEGACY_TOPICS = os.Getenv("MQTT_LEGACY_TOPICS") != "false"
+	MQTT_WORKERS = getEnvInt("MQTT_WORKERS", MQTT_WORKERS)
+	MQTT_QUEUE_SIZE = getEnvInt("MQTT_QUEUE_SIZE", MQTT_QUEUE_SIZE)
+	MQTT_TOPIC_CONCURRENCY = getEnvInt("MQTT_TOPIC_CONCURRENCY", MQTT_TOPIC_CONCURRENCY)
+	if policy := os.Getenv("MQTT_OVERLOAD_POLICY"); policy != "" {
+		MQTT_OVERLOAD_POLICY = policy
+	}
+	MQTT_HANDLER_TIMEOUT_SEC = getEnvInt("MQTT_HANDLER_TIMEOUT_SEC", MQTT_HANDLER_TIMEOUT_SEC)
+	MQTT_RECONNECT_INITIAL_MS = getEnvInt("MQTT_RECONNECT_INITIAL_MS", MQTT_RECONNECT_INITIAL_MS)
+	MQTT_RECONNECT_MAX_SEC = getEnvInt("MQTT_RECONNECT_MAX_SEC", MQTT_RECONNECT_MAX_SEC)
+
+	SHUTDOWN_TIMEOUT_SEC = getEnvInt("SHUTDOWN_TIMEOUT_SEC", SHUTDOWN_TIMEOUT_SEC)
+	METRICS_PORT = getEnvInt("METRICS_PORT", METRICS_PORT)
+	DATA_MAX_POINTS = getEnvInt("DATA_MAX_POINTS", DATA_MAX_POINTS)
+	BRDCRM_MAX_CLOCK_SKEW_SEC = getEnvInt("BRDCRM_MAX_CLOCK_SKEW_SEC", BRDCRM_MAX_CLOCK_SKEW_SEC)
+	BRDCRM_MAX_BACKFILL_DAYS = getEnvInt("BRDCRM_MAX_BACKFILL_DAYS", BRDCRM_MAX_BACKFILL_DAYS)
+	BRDCRM_MAX_BATCH = getEnvInt("BRDCRM_MAX_BATCH", BRDCRM_MAX_BATCH)
+	NOTIFY_DEDUPE_WINDOW_MIN = getEnvInt("NOTIFY_DEDUPE_WINDOW_MIN", NOTIFY_DEDUPE_WINDOW_MIN)
+	NOTIFY_RATE_LIMIT = getEnvInt("NOTIFY_RATE_LIMIT", NOTIFY_RATE_LIMIT)
+	NOTIFY_WEBHOOK_TIMEOUT_SEC = getEnvInt("NOTIFY_WEBHOOK_TIMEOUT_SEC", NOTIFY_WEBHOOK_TIMEOUT_SEC)
+	NOTIFY_FAKE_CHANNELS = os.Getenv("NOTIFY_FAKE_CHANNELS") == "true"
+	PROVISION_CONTRACT_TTL_MIN = getEnvInt("PROVISION_CONTRACT_TTL_MIN", PROVISION_CONTRACT_TTL_MIN)
+	HOUSEHOLD_INVITE_TTL_HOURS = getEnvInt("HOUSEHOLD_INVITE_TTL_HOURS", HOUSEHOLD_INVITE_TTL_HOURS)
+	DEVICE_REPORT_INTERVAL_SEC = getEnvInt("DEVICE_REPORT_INTERVAL_SEC", DEVICE_REPORT_INTERVAL_SEC)
+	DEVICE_OFFLINE_AFTER_INTERVALS = getEnvInt("DEVICE_OFFLINE_AFTER_INTERVALS", DEVICE_OFFLINE_AFTER_INTERVALS)
+	DEVICE_SWEEP_INTERVAL_SEC = getEnvInt("DEVICE_SWEEP_INTERVAL_SEC", DEVICE_SWEEP_INTERVAL_SEC)
+}
+
+func getEnvInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func getEnvList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
 }
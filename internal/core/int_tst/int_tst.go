@@ -11,6 +11,7 @@ import (
 
 	"github.com/frozenkro/dirtie-srv/internal/core"
 	drt_db "github.com/frozenkro/dirtie-srv/internal/db"
+	"github.com/frozenkro/dirtie-srv/internal/db/migrate"
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -22,6 +23,8 @@ var (
 	TestSession       sqlc.Session
 	TestPwResetToken  sqlc.PwResetToken
 	TestDevice        sqlc.Device
+	TestOtherUser     sqlc.User
+	TestOtherDevice   sqlc.Device
 	TestProvStgDevice sqlc.Device
 	TestProvStg       sqlc.ProvisionStaging
 )
@@ -90,10 +93,12 @@ func connectDb(ctx context.Context, t *testing.T) *pgx.Conn {
 }
 
 func setupDb(db *pgx.Conn) {
-	schema := drt_db.SchemaSql
-
-	if _, err := db.Exec(context.Background(), string(schema)); err != nil {
-		panic(fmt.Errorf("Error executing schema.sql: %w", err))
+	m, err := migrate.NewMigrator(db, drt_db.Migrations)
+	if err != nil {
+		panic(fmt.Errorf("Error loading migrations: %w", err))
+	}
+	if _, err = m.Up(context.Background()); err != nil {
+		panic(fmt.Errorf("Error applying migrations: %w", err))
 	}
 
 	setupData(db)
@@ -146,6 +151,29 @@ func setupData(db *pgx.Conn) {
 		panic(fmt.Errorf("Error creating test device record: %w", err))
 	}
 
+	// A second tenant, for checking users can't reach each other's devices
+	TestOtherUser.Name = "Other User"
+	TestOtherUser.Email = "other@email.test"
+	TestOtherUser.PwHash = TestUser.PwHash
+	otherUserSql := "INSERT INTO users (email, name, pw_hash) VALUES ($1, $2, $3) RETURNING user_id"
+	if err := db.QueryRow(context.Background(), otherUserSql, TestOtherUser.Email, TestOtherUser.Name, TestOtherUser.PwHash).Scan(&TestOtherUser.UserID); err != nil {
+		panic(fmt.Errorf("Error creating other test user record: %w", err))
+	}
+
+	TestOtherDevice.MacAddr = pgtype.Text{
+		String: "othermacaddr",
+		Valid:  true,
+	}
+	TestOtherDevice.UserID = TestOtherUser.UserID
+	TestOtherDevice.DisplayName = pgtype.Text{
+		String: "otherDeviceDisplay",
+		Valid:  true,
+	}
+	otherDeviceSql := "INSERT INTO devices (user_id, mac_addr, display_name) VALUES ($1, $2, $3) RETURNING device_id"
+	if err := db.QueryRow(context.Background(), otherDeviceSql, TestOtherDevice.UserID, TestOtherDevice.MacAddr, TestOtherDevice.DisplayName).Scan(&TestOtherDevice.DeviceID); err != nil {
+		panic(fmt.Errorf("Error creating other test device record: %w", err))
+	}
+
 	TestProvStgDevice.DisplayName = pgtype.Text{
 		String: "testprvstgdevice",
 		Valid:  true,
@@ -161,8 +189,9 @@ func setupData(db *pgx.Conn) {
 
 	TestProvStg.Contract.String = "testprvstgcontract"
 	TestProvStg.Contract.Valid = true
-	prvSql := "INSERT INTO provision_staging (device_id, contract) VALUES ($1, $2)"
-	if _, err := db.Exec(context.Background(), prvSql, TestProvStg.DeviceID, TestProvStg.Contract); err != nil {
+	TestProvStg.ExpiresAt = pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true}
+	prvSql := "INSERT INTO provision_staging (device_id, contract, expires_at) VALUES ($1, $2, $3)"
+	if _, err := db.Exec(context.Background(), prvSql, TestProvStg.DeviceID, TestProvStg.Contract, TestProvStg.ExpiresAt); err != nil {
 		panic(fmt.Errorf("Error creating test provision staging record: %w", err))
 	}
 }
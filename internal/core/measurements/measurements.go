@@ -0,0 +1,148 @@
+package measurements
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+)
+
+type Type string
+
+const (
+	// Int readings are stored as integer influx fields. Capacitance and
+	// temperature have always been written this way, and influx rejects
+	// writes that change the type of an existing field.
+	Int   Type = "int"
+	Float Type = "float"
+)
+
+type Measurement struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+	Unit        string   `json:"unit"`
+	Type        Type     `json:"type"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+}
+
+var (
+	ErrUnknownMeasurement = fmt.Errorf("Unknown measurement")
+	ErrInvalidReading     = fmt.Errorf("Invalid measurement reading")
+	ErrInvalidRegistry    = fmt.Errorf("Invalid measurement registry")
+)
+
+// names end up in flux queries, so keep them to a safe charset
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// SeqField carries the device's breadcrumb sequence number alongside
+// the readings, so it can't be used as a measurement name
+const SeqField = "seq"
+
+func bound(v float64) *float64 {
+	return &v
+}
+
+// Defaults is the registry used when no MEASUREMENTS_FILE is configured
+var Defaults = []Measurement{
+	{Name: "capacitance", DisplayName: "Soil Capacitance", Unit: "raw", Type: Int, Min: bound(0), Max: bound(65535)},
+	{Name: "temperature", DisplayName: "Temperature", Unit: "°C", Type: Int, Min: bound(-40), Max: bound(85)},
+	{Name: "humidity", DisplayName: "Humidity", Unit: "%", Type: Float, Min: bound(0), Max: bound(100)},
+	{Name: "light", DisplayName: "Light", Unit: "lx", Type: Float, Min: bound(0), Max: bound(200000)},
+	{Name: "battery_voltage", DisplayName: "Battery Voltage", Unit: "V", Type: Float, Min: bound(0), Max: bound(6)},
+}
+
+func (m Measurement) Validate(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("'%v' reading is not a number: %w", m.Name, ErrInvalidReading)
+	}
+	if m.Type == Int && value != math.Trunc(value) {
+		return fmt.Errorf("'%v' reading %v is not an integer: %w", m.Name, value, ErrInvalidReading)
+	}
+	if m.Min != nil && value < *m.Min {
+		return fmt.Errorf("'%v' reading %v is below minimum %v: %w", m.Name, value, *m.Min, ErrInvalidReading)
+	}
+	if m.Max != nil && value > *m.Max {
+		return fmt.Errorf("'%v' reading %v is above maximum %v: %w", m.Name, value, *m.Max, ErrInvalidReading)
+	}
+	return nil
+}
+
+// FieldValue converts a reading to the go type written to influx
+func (m Measurement) FieldValue(value float64) any {
+	if m.Type == Int {
+		return int64(value)
+	}
+	return value
+}
+
+// Registry is the set of measurements devices are allowed to report.
+// It is read-only once built.
+type Registry struct {
+	measurements []Measurement
+	byName       map[string]Measurement
+}
+
+func NewRegistry(ms ...Measurement) (*Registry, error) {
+	r := &Registry{byName: make(map[string]Measurement, len(ms))}
+
+	for _, m := range ms {
+		if !validName.MatchString(m.Name) {
+			return nil, fmt.Errorf("measurement name '%v' must match %v: %w", m.Name, validName, ErrInvalidRegistry)
+		}
+		if m.Name == SeqField {
+			return nil, fmt.Errorf("measurement name '%v' is reserved: %w", m.Name, ErrInvalidRegistry)
+		}
+		if _, ok := r.byName[m.Name]; ok {
+			return nil, fmt.Errorf("measurement '%v' defined twice: %w", m.Name, ErrInvalidRegistry)
+		}
+		if m.Type != Int && m.Type != Float {
+			return nil, fmt.Errorf("measurement '%v' has unknown type '%v': %w", m.Name, m.Type, ErrInvalidRegistry)
+		}
+		if m.Min != nil && m.Max != nil && *m.Min > *m.Max {
+			return nil, fmt.Errorf("measurement '%v' has min above max: %w", m.Name, ErrInvalidRegistry)
+		}
+		if m.DisplayName == "" {
+			m.DisplayName = m.Name
+		}
+
+		r.measurements = append(r.measurements, m)
+		r.byName[m.Name] = m
+	}
+	return r, nil
+}
+
+// Load reads a JSON array of measurements from path,
+// falling back to Defaults when path is empty
+func Load(path string) (*Registry, error) {
+	if path == "" {
+		return NewRegistry(Defaults...)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error measurements Load -> ReadFile: %w", err)
+	}
+
+	var ms []Measurement
+	if err = json.Unmarshal(b, &ms); err != nil {
+		return nil, fmt.Errorf("Error measurements Load -> Unmarshal: %w", err)
+	}
+	return NewRegistry(ms...)
+}
+
+func (r *Registry) Get(name string) (Measurement, error) {
+	m, ok := r.byName[name]
+	if !ok {
+		return Measurement{}, fmt.Errorf("measurement '%v': %w", name, ErrUnknownMeasurement)
+	}
+	return m, nil
+}
+
+// All returns the measurements in the order they were defined
+func (r *Registry) All() []Measurement {
+	ms := make([]Measurement, len(r.measurements))
+	copy(ms, r.measurements)
+	return ms
+}
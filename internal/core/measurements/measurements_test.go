@@ -0,0 +1,104 @@
+package measurements
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	temp := Measurement{Name: "temperature", Type: Int, Min: bound(-40), Max: bound(85)}
+	volts := Measurement{Name: "battery_voltage", Type: Float, Min: bound(0)}
+
+	tests := []struct {
+		name  string
+		m     Measurement
+		value float64
+		ok    bool
+	}{
+		{"InRange", temp, 21, true},
+		{"AtMin", temp, -40, true},
+		{"BelowMin", temp, -41, false},
+		{"AboveMax", temp, 86, false},
+		{"FractionalInt", temp, 21.5, false},
+		{"Float", volts, 3.71, true},
+		{"NoMax", volts, 1e9, true},
+		{"NaN", volts, math.NaN(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.m.Validate(tt.value)
+			if tt.ok {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidReading)
+			}
+		})
+	}
+}
+
+func TestFieldValue(t *testing.T) {
+	assert.Equal(t, int64(420), Measurement{Type: Int}.FieldValue(420))
+	assert.Equal(t, 3.7, Measurement{Type: Float}.FieldValue(3.7))
+}
+
+func TestNewRegistry(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		r, err := NewRegistry(Defaults...)
+		assert.Nil(t, err)
+		assert.Len(t, r.All(), len(Defaults))
+
+		m, err := r.Get("capacitance")
+		assert.Nil(t, err)
+		assert.Equal(t, Int, m.Type)
+
+		_, err = r.Get("co2")
+		assert.ErrorIs(t, err, ErrUnknownMeasurement)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		invalid := [][]Measurement{
+			{{Name: "Bad Name", Type: Int}},
+			{{Name: SeqField, Type: Int}},
+			{{Name: "dup", Type: Int}, {Name: "dup", Type: Float}},
+			{{Name: "untyped"}},
+			{{Name: "backwards", Type: Int, Min: bound(10), Max: bound(1)}},
+		}
+		for _, ms := range invalid {
+			_, err := NewRegistry(ms...)
+			assert.ErrorIs(t, err, ErrInvalidRegistry)
+		}
+	})
+
+	t.Run("DisplayNameDefault", func(t *testing.T) {
+		r, err := NewRegistry(Measurement{Name: "co2", Type: Float})
+		assert.Nil(t, err)
+		m, _ := r.Get("co2")
+		assert.Equal(t, "co2", m.DisplayName)
+	})
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("NoPath", func(t *testing.T) {
+		r, err := Load("")
+		assert.Nil(t, err)
+		assert.Len(t, r.All(), len(Defaults))
+	})
+
+	t.Run("File", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "measurements.json")
+		err := os.WriteFile(path, []byte(`[{"name":"co2","unit":"ppm","type":"float","min":0}]`), 0o644)
+		assert.Nil(t, err)
+
+		r, err := Load(path)
+		assert.Nil(t, err)
+		m, err := r.Get("co2")
+		assert.Nil(t, err)
+		assert.Equal(t, "ppm", m.Unit)
+		assert.Nil(t, m.Max)
+	})
+}
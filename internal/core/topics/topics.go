@@ -1,6 +1,86 @@
 package topics
 
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Legacy flat topics. Devices on these identify themselves
+// only by the macAddr in the payload body.
 var (
 	Breadcrumb string = "dirtie-breadcrumb"
 	Provision  string = "dirtie-provision"
+	LogDump    string = "dirtie-logdump"
+)
+
+// Per-device topics are namespaced as dirtie/<mac>/<suffix>
+var (
+	DeviceNamespace  string = "dirtie"
+	DeviceBreadcrumb string = "breadcrumb"
+	// timestamped readings a device stored while offline
+	DeviceBreadcrumbBatch string = "breadcrumbs"
+	DeviceProvision       string = "provision"
+	DeviceLogs            string = "logs"
+	DeviceCommand         string = "command"
+	DeviceCommandAck      string = "ack"
+	// retained birth and will messages
+	DeviceStatus string = "status"
 )
+
+// Outbound topics for the mobile app are namespaced per user as
+// dirtie-app/<userId>/<suffix>
+var (
+	AppNamespace     string = "dirtie-app"
+	UserNotification string = "notifications"
+)
+
+var (
+	ErrMacMismatch = fmt.Errorf("Payload macAddr does not match topic")
+	ErrNoMacAddr   = fmt.Errorf("No macAddr in topic or payload")
+)
+
+// DeviceFilter returns the wildcard filter matching suffix for every device
+func DeviceFilter(suffix string) string {
+	return DeviceTopic("+", suffix)
+}
+
+func DeviceTopic(macAddr string, suffix string) string {
+	return strings.Join([]string{DeviceNamespace, macAddr, suffix}, "/")
+}
+
+func UserTopic(userId string, suffix string) string {
+	return strings.Join([]string{AppNamespace, userId, suffix}, "/")
+}
+
+// ParseDeviceTopic extracts the mac address from a per-device topic.
+// ok is false for legacy flat topics.
+func ParseDeviceTopic(topic string) (macAddr string, suffix string, ok bool) {
+	levels := strings.Split(topic, "/")
+	if len(levels) != 3 || levels[0] != DeviceNamespace || levels[1] == "" {
+		return "", "", false
+	}
+	return levels[1], levels[2], true
+}
+
+func WithTopicMacAddr(ctx context.Context, macAddr string) context.Context {
+	return context.WithValue(ctx, "topicMacAddr", macAddr)
+}
+
+// ResolveMacAddr reconciles the device identity carried by the topic
+// (if any) with the one in the payload body
+func ResolveMacAddr(ctx context.Context, bodyMacAddr string) (string, error) {
+	topicMacAddr, _ := ctx.Value("topicMacAddr").(string)
+
+	if topicMacAddr == "" {
+		if bodyMacAddr == "" {
+			return "", ErrNoMacAddr
+		}
+		return bodyMacAddr, nil
+	}
+
+	if bodyMacAddr != "" && !strings.EqualFold(bodyMacAddr, topicMacAddr) {
+		return "", fmt.Errorf("topic '%v', payload '%v': %w", topicMacAddr, bodyMacAddr, ErrMacMismatch)
+	}
+	return topicMacAddr, nil
+}
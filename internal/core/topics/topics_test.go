@@ -0,0 +1,61 @@
+package topics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeviceTopic(t *testing.T) {
+	tests := []struct {
+		topic  string
+		mac    string
+		suffix string
+		ok     bool
+	}{
+		{"dirtie/aa:bb:cc:dd:ee:ff/breadcrumb", "aa:bb:cc:dd:ee:ff", "breadcrumb", true},
+		{"dirtie/aabbccddeeff/logs", "aabbccddeeff", "logs", true},
+		{"dirtie-breadcrumb", "", "", false},
+		{"dirtie//breadcrumb", "", "", false},
+		{"other/aabbccddeeff/breadcrumb", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			mac, suffix, ok := ParseDeviceTopic(tt.topic)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.mac, mac)
+			assert.Equal(t, tt.suffix, suffix)
+		})
+	}
+}
+
+func TestResolveMacAddr(t *testing.T) {
+	ctx := context.Background()
+	topicCtx := WithTopicMacAddr(ctx, "AABBCC")
+
+	t.Run("LegacyTopic", func(t *testing.T) {
+		mac, err := ResolveMacAddr(ctx, "aabbcc")
+		assert.Nil(t, err)
+		assert.Equal(t, "aabbcc", mac)
+
+		_, err = ResolveMacAddr(ctx, "")
+		assert.ErrorIs(t, err, ErrNoMacAddr)
+	})
+
+	t.Run("DeviceTopic", func(t *testing.T) {
+		mac, err := ResolveMacAddr(topicCtx, "")
+		assert.Nil(t, err)
+		assert.Equal(t, "AABBCC", mac)
+
+		mac, err = ResolveMacAddr(topicCtx, "aabbcc")
+		assert.Nil(t, err)
+		assert.Equal(t, "AABBCC", mac)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		_, err := ResolveMacAddr(topicCtx, "ddeeff")
+		assert.ErrorIs(t, err, ErrMacMismatch)
+	})
+}
@@ -1,6 +1,12 @@
 package db
 
-import _ "embed"
+import (
+	"embed"
+	"io/fs"
+)
 
-//go:embed sqlc/schema.sql
-var SchemaSql []byte
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrations holds the schema migrations, applied in version order
+var Migrations, _ = fs.Sub(migrationFiles, "migrations")
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+// checksum of schema.sql as deployed before migrations existed. Existing
+// databases are baselined against 0001, so it must never change.
+const baselineChecksum = "f955f8c6dd5e150716f1e5555e7a5063dff2b342096e6842efeb7ac6704819f5"
+
+func TestMigrations(t *testing.T) {
+	migrations, err := migrate.Load(Migrations)
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, migrations)
+	assert.Equal(t, baselineChecksum, migrations[0].Checksum)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions must be contiguous")
+		assert.NotEmpty(t, m.Down, "migration %v_%v has no down script", m.Version, m.Name)
+	}
+}
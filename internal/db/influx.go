@@ -7,41 +7,96 @@ import (
 	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
 	"github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
 )
 
 type DeviceDataPoint struct {
-	Value int64     `json:"value"`
+	Value float64   `json:"value"`
 	Time  time.Time `json:"time"`
 	Key   string    `json:"key"`
 }
 
+// Breadcrumb readings are written as fields of one point under this
+// measurement. Older data has one measurement per field, named after
+// the field; queries read both.
+const BreadcrumbMeasurement = "breadcrumb"
+
+type InfluxObserver interface {
+	ObserveInfluxQueued(points int)
+	ObserveInfluxWriteError(err error)
+}
+
 type InfluxRepo struct {
-	client *influxdb2.Client
+	client   *influxdb2.Client
+	writeAPI api.WriteAPI
+	observer InfluxObserver
 }
 
-func NewInfluxRepo() InfluxRepo {
+func NewInfluxRepo(observer InfluxObserver) InfluxRepo {
 	c := initIxClient()
-	return InfluxRepo{client: &c}
+	r := InfluxRepo{
+		client:   &c,
+		writeAPI: c.WriteAPI(core.INFLUX_ORG, core.INFLUX_DEFAULT_BUCKET),
+		observer: observer,
+	}
+	// must be subscribed before the first write; closed by Disconnect
+	go r.watchWriteErrors(r.writeAPI.Errors())
+	return r
 }
 
 func initIxClient() influxdb2.Client {
 	uri := core.INFLUX_URI
-	return influxdb2.NewClient("http://"+uri, core.INFLUX_TOKEN)
+	opts := influxdb2.DefaultOptions().
+		SetBatchSize(uint(core.INFLUX_BATCH_SIZE)).
+		SetFlushInterval(uint(core.INFLUX_FLUSH_INTERVAL_MS)).
+		SetRetryBufferLimit(uint(core.INFLUX_RETRY_BUFFER_LIMIT)).
+		SetMaxRetries(uint(core.INFLUX_MAX_RETRIES))
+	return influxdb2.NewClientWithOptions("http://"+uri, core.INFLUX_TOKEN, opts)
 }
 
-func (r InfluxRepo) Record(ctx context.Context, deviceId int, measurementKey string, value int64) error {
-	c := *r.client
-	writeAPI := c.WriteAPIBlocking(core.INFLUX_ORG, core.INFLUX_DEFAULT_BUCKET)
+func (r InfluxRepo) watchWriteErrors(errs <-chan error) {
+	for err := range errs {
+		utils.LogErr(fmt.Sprintf("influx write failed: %v", err))
+		if r.observer != nil {
+			r.observer.ObserveInfluxWriteError(err)
+		}
+	}
+}
 
-	p := influxdb2.NewPointWithMeasurement(measurementKey).
-		AddTag("device", strconv.Itoa(deviceId)).
-		AddField(measurementKey, value).
-		SetTime(time.Now())
-	err := writeAPI.WritePoint(ctx, p)
+// RecordPoint queues one breadcrumb point carrying every field.
+// Values should be int64 or float64 matching each measurement's
+// field type. The point is sent with the next batch; failed batches
+// are retried from a bounded buffer and reported through the error
+// log and metrics rather than to the caller.
+func (r InfluxRepo) RecordPoint(ctx context.Context, deviceId int, fields map[string]any, ts time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Error RecordPoint: %w", err)
+	}
 
-	return err
+	p := influxdb2.NewPoint(BreadcrumbMeasurement,
+		map[string]string{"device": strconv.Itoa(deviceId)},
+		fields,
+		ts)
+	r.writeAPI.WritePoint(p)
+
+	if r.observer != nil {
+		r.observer.ObserveInfluxQueued(1)
+	}
+	return nil
+}
+
+// Flush sends any queued points and waits for the write to finish
+func (r InfluxRepo) Flush() {
+	r.writeAPI.Flush()
+}
+
+// measurementFilter matches a field in both the breadcrumb point
+// layout and the legacy one-measurement-per-field layout
+func measurementFilter(measurementKey string, deviceId int) string {
+	return fmt.Sprintf(`(r._measurement == "%v" or r._measurement == "%v") and r._field == "%v" and r.device == "%v"`,
+		BreadcrumbMeasurement, measurementKey, measurementKey, strconv.Itoa(deviceId))
 }
 
 func (r InfluxRepo) GetLatestValue(
@@ -54,8 +109,10 @@ func (r InfluxRepo) GetLatestValue(
 	query := fmt.Sprintf(`
     from(bucket:"%v")
     |> range(start: -1w)
-    |> filter(fn: (r) => r._measurement == "%v" and r._field == "%v")
-    |> last()`, core.INFLUX_DEFAULT_BUCKET, measurementKey, measurementKey)
+    |> filter(fn: (r) => %v)
+    |> group(columns: ["_field", "device"])
+    |> sort(columns: ["_time"])
+    |> last()`, core.INFLUX_DEFAULT_BUCKET, measurementFilter(measurementKey, deviceId))
 
 	qRes, err := queryAPI.Query(ctx, query)
 	if err != nil {
@@ -75,18 +132,82 @@ func (r InfluxRepo) GetValuesRange(
 	measurementKey string,
 	start time.Time,
 	end time.Time) ([]DeviceDataPoint, error) {
+	return r.QueryRange(ctx, deviceId, measurementKey, RangeQuery{Start: start, End: end})
+}
+
+// DeleteDeviceData removes every point the device has written from the
+// raw bucket and each rollup bucket
+func (r InfluxRepo) DeleteDeviceData(ctx context.Context, deviceId int) error {
+	deleteAPI := (*r.client).DeleteAPI()
+	predicate := fmt.Sprintf(`device="%v"`, strconv.Itoa(deviceId))
+	// rollup points are stamped at the end of their window, which can
+	// be ahead of now
+	stop := time.Now().Add(7 * 24 * time.Hour)
+
+	for _, tier := range DataTiers() {
+		err := deleteAPI.DeleteWithName(ctx, core.INFLUX_ORG, tier.Bucket, time.Unix(0, 0), stop, predicate)
+		if err != nil {
+			return fmt.Errorf("Error DeleteDeviceData -> DeleteWithName '%v': %w", tier.Bucket, err)
+		}
+	}
+	return nil
+}
+
+// RangeQuery selects raw points when Every is zero, otherwise one
+// point per window aggregated with Fn. Windows with no data are
+// left out rather than filled with nulls.
+type RangeQuery struct {
+	// defaults to the raw bucket
+	Bucket string
+	// selects which stored aggregate to read from a rollup bucket
+	RollupAgg string
+	Start     time.Time
+	End       time.Time
+	Every     time.Duration
+	Fn        string
+	// 0 for no limit
+	Limit int
+}
+
+func (r InfluxRepo) QueryRange(
+	ctx context.Context,
+	deviceId int,
+	measurementKey string,
+	q RangeQuery) ([]DeviceDataPoint, error) {
 	c := *r.client
 	queryAPI := c.QueryAPI(core.INFLUX_ORG)
 
+	bucket := q.Bucket
+	if bucket == "" {
+		bucket = core.INFLUX_DEFAULT_BUCKET
+	}
+
 	query := fmt.Sprintf(`
     from(bucket:"%v")
     |> range(start: %v, stop: %v)
-    |> filter(fn: (r) => r._measurement == "%v" and r._field == "%v")
-  `, core.INFLUX_DEFAULT_BUCKET, start.Format(time.RFC3339), end.Format(time.RFC3339), measurementKey, measurementKey)
+    |> filter(fn: (r) => %v)
+  `, bucket, q.Start.Format(time.RFC3339), q.End.Format(time.RFC3339), measurementFilter(measurementKey, deviceId))
+
+	if q.RollupAgg != "" {
+		query += fmt.Sprintf(`|> filter(fn: (r) => r.agg == "%v")
+  `, q.RollupAgg)
+	}
+	// merge the legacy and breadcrumb series into one
+	query += `|> group(columns: ["_field", "device", "agg"])
+    |> sort(columns: ["_time"])
+  `
+	if q.Every > 0 {
+		query += fmt.Sprintf(`|> aggregateWindow(every: %ds, fn: %v, createEmpty: false)
+  `, int64(q.Every.Seconds()), q.Fn)
+	}
+	if q.Limit > 0 {
+		query += fmt.Sprintf(`|> limit(n: %d)
+  `, q.Limit)
+	}
 
 	qRes, err := queryAPI.Query(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("Error GetValuesRange -> Query: %w", err)
+		return nil, fmt.Errorf("Error QueryRange -> Query: %w", err)
 	}
 
 	return llToSlice(qRes)
@@ -114,8 +235,13 @@ func newDeviceDataPoint(r *api.QueryTableResult) (DeviceDataPoint, error) {
 		)
 	}
 
-	valF, succ := val.(float64)
-	if !succ {
+	var valF float64
+	switch v := val.(type) {
+	case float64:
+		valF = v
+	case int64:
+		valF = float64(v)
+	default:
 		return DeviceDataPoint{}, fmt.Errorf(
 			`Error in newDeviceDataPoint - failed to cast influx result. 
       deviceId: '%v', measurementKey: '%v'`,
@@ -124,15 +250,26 @@ func newDeviceDataPoint(r *api.QueryTableResult) (DeviceDataPoint, error) {
 		)
 	}
 
-	valInt := int64(valF)
-
 	return DeviceDataPoint{
-		Value: valInt,
+		Value: valF,
 		Time:  r.Record().Time(),
 		Key:   r.Record().Field(),
 	}, nil
 }
 
+func (r InfluxRepo) Ping(ctx context.Context) error {
+	c := *r.client
+	ok, err := c.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("Error InfluxRepo Ping: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("Error InfluxRepo Ping: server not running")
+	}
+	return nil
+}
+
+// Disconnect flushes queued points before closing the client
 func (r *InfluxRepo) Disconnect() {
 	c := *r.client
 	c.Close()
@@ -0,0 +1,354 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// BucketTier is one level of the retention hierarchy. The raw tier
+// holds breadcrumbs as written; rollup tiers hold one point per
+// Resolution window for each of the rollupAggregates, tagged with
+// "agg", written by a flux task reading from Source.
+type BucketTier struct {
+	Bucket     string
+	Retention  time.Duration // 0 keeps data forever
+	Resolution time.Duration // 0 for the raw tier
+	Source     string
+	// how far back each rollup run re-aggregates, so readings
+	// backfilled by devices that were offline reach the rollups
+	Lookback time.Duration
+}
+
+const rollupTaskOffset = "5m"
+
+// every rollup stores each of these so min/max queries over
+// long ranges stay exact
+var rollupAggregates = []string{"mean", "min", "max", "last"}
+
+// DataTiers returns the bucket hierarchy, finest first
+func DataTiers() []BucketTier {
+	raw := core.INFLUX_DEFAULT_BUCKET
+	hourly := raw + "_hourly"
+	daily := raw + "_daily"
+	backfill := time.Duration(core.BRDCRM_MAX_BACKFILL_DAYS) * 24 * time.Hour
+
+	return []BucketTier{
+		{
+			Bucket:    raw,
+			Retention: time.Duration(core.INFLUX_RAW_RETENTION_DAYS) * 24 * time.Hour,
+		},
+		{
+			Bucket:     hourly,
+			Retention:  time.Duration(core.INFLUX_HOURLY_RETENTION_DAYS) * 24 * time.Hour,
+			Resolution: time.Hour,
+			Source:     raw,
+			Lookback:   backfill + time.Hour,
+		},
+		{
+			Bucket:     daily,
+			Resolution: 24 * time.Hour,
+			Source:     hourly,
+			Lookback:   backfill + 24*time.Hour,
+		},
+	}
+}
+
+// ErrRollupsNotBackfilled is returned by EnsureTiers when a retention
+// period would be shortened before BackfillRollups has run, since the
+// data it drops might not have been rolled up yet
+var ErrRollupsNotBackfilled = fmt.Errorf("Rollups have not been backfilled from existing data; run `dirtie influx backfill` first")
+
+// EnsureTiers creates any missing buckets and rollup tasks and brings
+// task definitions in line with DataTiers. Buckets that already exist
+// keep their retention unless INFLUX_APPLY_RETENTION is set.
+func (r InfluxRepo) EnsureTiers(ctx context.Context) error {
+	c := *r.client
+
+	org, err := c.OrganizationsAPI().FindOrganizationByName(ctx, core.INFLUX_ORG)
+	if err != nil {
+		return fmt.Errorf("Error EnsureTiers -> FindOrganizationByName: %w", err)
+	}
+
+	existing, err := c.BucketsAPI().FindBucketsByOrgID(ctx, *org.Id, api.PagingWithLimit(100))
+	if err != nil {
+		return fmt.Errorf("Error EnsureTiers -> FindBucketsByOrgID: %w", err)
+	}
+
+	var changes []retentionChange
+	for _, tier := range DataTiers() {
+		change, err := r.ensureBucket(ctx, org, *existing, tier)
+		if err != nil {
+			return err
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+		if tier.Source == "" {
+			continue
+		}
+		if err := r.ensureRollupTask(ctx, *org.Id, tier); err != nil {
+			return err
+		}
+	}
+
+	if len(changes) == 0 || !core.INFLUX_APPLY_RETENTION {
+		return nil
+	}
+	return r.applyRetention(ctx, changes)
+}
+
+// retentionChange is an existing bucket whose retention differs from
+// its tier's. Retentions are in seconds, 0 keeping data forever.
+type retentionChange struct {
+	bucket domain.Bucket
+	from   int64
+	to     int64
+}
+
+// shortens reports whether the change drops data the bucket keeps now
+func (c retentionChange) shortens() bool {
+	return c.to > 0 && (c.from == 0 || c.to < c.from)
+}
+
+// ensureBucket creates the tier's bucket if it's missing, and returns
+// the change needed if an existing bucket's retention differs
+func (r InfluxRepo) ensureBucket(ctx context.Context, org *domain.Organization, existing []domain.Bucket, tier BucketTier) (*retentionChange, error) {
+	c := *r.client
+	rule := domain.RetentionRule{EverySeconds: int64(tier.Retention.Seconds())}
+
+	for _, b := range existing {
+		if b.Name != tier.Bucket {
+			continue
+		}
+		var from int64
+		if len(b.RetentionRules) > 0 {
+			from = b.RetentionRules[0].EverySeconds
+		}
+		if from == rule.EverySeconds {
+			return nil, nil
+		}
+		return &retentionChange{bucket: b, from: from, to: rule.EverySeconds}, nil
+	}
+
+	if _, err := c.BucketsAPI().CreateBucketWithName(ctx, org, tier.Bucket, rule); err != nil {
+		return nil, fmt.Errorf("Error EnsureTiers -> CreateBucketWithName '%v': %w", tier.Bucket, err)
+	}
+	return nil, nil
+}
+
+// applyRetention updates the retention of existing buckets, refusing
+// to shorten any until the rollups have been backfilled
+func (r InfluxRepo) applyRetention(ctx context.Context, changes []retentionChange) error {
+	c := *r.client
+
+	shortens := false
+	for _, change := range changes {
+		shortens = shortens || change.shortens()
+	}
+	if shortens {
+		backfilled, err := r.rollupsBackfilled(ctx)
+		if err != nil {
+			return err
+		}
+		if !backfilled {
+			return ErrRollupsNotBackfilled
+		}
+	}
+
+	for _, change := range changes {
+		b := change.bucket
+		b.RetentionRules = domain.RetentionRules{{EverySeconds: change.to}}
+		if _, err := c.BucketsAPI().UpdateBucket(ctx, &b); err != nil {
+			return fmt.Errorf("Error EnsureTiers -> UpdateBucket '%v': %w", b.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r InfluxRepo) ensureRollupTask(ctx context.Context, orgId string, tier BucketTier) error {
+	tasksAPI := (*r.client).TasksAPI()
+	name := rollupTaskName(tier)
+	flux := rollupFlux(tier)
+
+	tasks, err := tasksAPI.FindTasks(ctx, &api.TaskFilter{Name: name, OrgID: orgId})
+	if err != nil {
+		return fmt.Errorf("Error EnsureTiers -> FindTasks '%v': %w", name, err)
+	}
+
+	if len(tasks) == 0 {
+		_, err = tasksAPI.CreateTaskByFlux(ctx, flux, orgId)
+		if err != nil {
+			return fmt.Errorf("Error EnsureTiers -> CreateTaskByFlux '%v': %w", name, err)
+		}
+		return nil
+	}
+
+	task := tasks[0]
+	if task.Flux == flux {
+		return nil
+	}
+	task.Flux = flux
+	// every/offset are taken from the task option in the flux
+	task.Every, task.Offset, task.Cron = nil, nil, nil
+	if _, err = tasksAPI.UpdateTask(ctx, &task); err != nil {
+		return fmt.Errorf("Error EnsureTiers -> UpdateTask '%v': %w", name, err)
+	}
+	return nil
+}
+
+func rollupTaskName(tier BucketTier) string {
+	return "dirtie_rollup_" + tier.Bucket
+}
+
+// rollupFlux builds the task that aggregates tier.Source into
+// tier.Bucket, from tier.Lookback ago up to the end of the previous
+// window. Windows already rolled up are simply rewritten. Values are
+// cast to float so integer measurements don't clash with their own
+// means in the rollup bucket.
+func rollupFlux(tier BucketTier) string {
+	every := fmt.Sprintf("%ds", int64(tier.Resolution.Seconds()))
+	lookback := fmt.Sprintf("%ds", int64(max(tier.Lookback, tier.Resolution).Seconds()))
+
+	var b strings.Builder
+	b.WriteString("import \"date\"\n\n")
+	fmt.Fprintf(&b, "option task = {name: %q, every: %v, offset: %v}\n\n", rollupTaskName(tier), every, rollupTaskOffset)
+	writeRollup(&b, tier, fmt.Sprintf("start: date.truncate(t: -%v, unit: task.every)", lookback), "task.every")
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// backfillFlux aggregates tier.Source into tier.Bucket between start
+// and stop, which should fall on window boundaries
+func backfillFlux(tier BucketTier, start, stop time.Time) string {
+	var b strings.Builder
+	writeRollup(&b, tier,
+		fmt.Sprintf("start: %v, stop: %v", start.UTC().Format(time.RFC3339), stop.UTC().Format(time.RFC3339)),
+		fmt.Sprintf("%ds", int64(tier.Resolution.Seconds())))
+	return b.String()
+}
+
+func writeRollup(b *strings.Builder, tier BucketTier, rangeArgs string, every string) {
+	// rollups of rollups only read back the matching aggregate
+	fromRollup := tier.Source != core.INFLUX_DEFAULT_BUCKET
+
+	fmt.Fprintf(b, "data = from(bucket: %q)\n    |> range(%v)\n    |> filter(fn: (r) => exists r.device and r._field != %q)\n    |> toFloat()\n\n", tier.Source, rangeArgs, measurements.SeqField)
+
+	for _, agg := range rollupAggregates {
+		src := "data"
+		if fromRollup {
+			src = fmt.Sprintf("data\n    |> filter(fn: (r) => r.agg == %q)", agg)
+		}
+		fmt.Fprintf(b, "%v\n    |> aggregateWindow(every: %v, fn: %v, createEmpty: false)\n    |> set(key: \"agg\", value: %q)\n    |> to(bucket: %q, org: %q)\n\n",
+			src, every, agg, agg, tier.Bucket, core.INFLUX_ORG)
+	}
+}
+
+// backfillChunk bounds how much source data one backfill query reads.
+// It's a whole number of days so chunks stay on window boundaries.
+const backfillChunk = 30 * 24 * time.Hour
+
+// backfillMeasurement is written to the last tier once BackfillRollups
+// has finished. It has no device tag, so queries and tasks skip it.
+const backfillMeasurement = "dirtie_rollup_backfill"
+
+// BackfillRollups aggregates all existing data into every rollup tier,
+// finest first, then records that it has run so EnsureTiers may
+// shorten retention. Windows already rolled up are rewritten with the
+// same values, so it's safe to rerun. Windows that haven't closed yet
+// are left to the rollup tasks.
+func (r InfluxRepo) BackfillRollups(ctx context.Context) error {
+	c := *r.client
+	queryAPI := c.QueryAPI(core.INFLUX_ORG)
+	tiers := DataTiers()
+
+	first, err := r.earliestPoint(ctx, tiers[0].Bucket)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, tier := range tiers {
+		if tier.Source == "" || first.IsZero() {
+			continue
+		}
+		end := now.Truncate(tier.Resolution)
+		for start := first.Truncate(tier.Resolution); start.Before(end); start = start.Add(backfillChunk) {
+			stop := start.Add(backfillChunk)
+			if stop.After(end) {
+				stop = end
+			}
+			qRes, err := queryAPI.Query(ctx, backfillFlux(tier, start, stop))
+			if err != nil {
+				return fmt.Errorf("Error BackfillRollups -> Query '%v' from %v: %w", tier.Bucket, start.Format(time.RFC3339), err)
+			}
+			for qRes.Next() {
+			}
+			err = qRes.Err()
+			qRes.Close()
+			if err != nil {
+				return fmt.Errorf("Error BackfillRollups -> Query '%v' from %v: %w", tier.Bucket, start.Format(time.RFC3339), err)
+			}
+		}
+	}
+
+	last := tiers[len(tiers)-1].Bucket
+	p := influxdb2.NewPoint(backfillMeasurement, nil, map[string]interface{}{"done": true}, now)
+	if err := c.WriteAPIBlocking(core.INFLUX_ORG, last).WritePoint(ctx, p); err != nil {
+		return fmt.Errorf("Error BackfillRollups -> WritePoint: %w", err)
+	}
+	return nil
+}
+
+// earliestPoint returns the time of the oldest device point in bucket,
+// or the zero time if it's empty
+func (r InfluxRepo) earliestPoint(ctx context.Context, bucket string) (time.Time, error) {
+	queryAPI := (*r.client).QueryAPI(core.INFLUX_ORG)
+
+	query := fmt.Sprintf(`
+    from(bucket: "%v")
+    |> range(start: 0)
+    |> filter(fn: (r) => exists r.device)
+    |> first()
+    |> keep(columns: ["_time"])
+    |> group()
+    |> sort(columns: ["_time"])
+    |> limit(n: 1)`, bucket)
+
+	qRes, err := queryAPI.Query(ctx, query)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Error BackfillRollups -> earliestPoint: %w", err)
+	}
+	defer qRes.Close()
+
+	if !qRes.Next() {
+		return time.Time{}, qRes.Err()
+	}
+	return qRes.Record().Time(), nil
+}
+
+func (r InfluxRepo) rollupsBackfilled(ctx context.Context) (bool, error) {
+	tiers := DataTiers()
+	queryAPI := (*r.client).QueryAPI(core.INFLUX_ORG)
+
+	query := fmt.Sprintf(`
+    from(bucket: "%v")
+    |> range(start: 0)
+    |> filter(fn: (r) => r._measurement == "%v")
+    |> limit(n: 1)`, tiers[len(tiers)-1].Bucket, backfillMeasurement)
+
+	qRes, err := queryAPI.Query(ctx, query)
+	if err != nil {
+		return false, fmt.Errorf("Error EnsureTiers -> rollupsBackfilled: %w", err)
+	}
+	defer qRes.Close()
+
+	found := qRes.Next()
+	return found, qRes.Err()
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,15 +14,21 @@ import (
 	"github.com/frozenkro/dirtie-srv/internal/core"
 )
 
+type LokiObserver interface {
+	ObserveLokiPush(err error)
+}
+
 type LokiClient struct{
 	client *http.Client
+	observer LokiObserver
 }
 
-func NewLokiClient() *LokiClient {
+func NewLokiClient(observer LokiObserver) *LokiClient {
 	return &LokiClient{
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		observer: observer,
 	}
 }
 
@@ -57,6 +64,14 @@ type LokiLogData struct {
 }
 
 func (c *LokiClient) PostLogs(data LokiLogData) error {
+	err := c.postLogs(data)
+	if c.observer != nil {
+		c.observer.ObserveLokiPush(err)
+	}
+	return err
+}
+
+func (c *LokiClient) postLogs(data LokiLogData) error {
 	b, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("Error marshaling payload in LokiClient.PostLogs: %w\n", err)
@@ -90,3 +105,27 @@ func (c *LokiClient) PostLogs(data LokiLogData) error {
 
 	return nil
 }
+
+// Ping checks Loki's /ready endpoint
+func (c *LokiClient) Ping(ctx context.Context) error {
+	lokiUri, err := url.JoinPath(strings.Trim(core.LOKI_URL, "/"), "ready")
+	if err != nil {
+		return fmt.Errorf("Error creating URI in LokiClient.Ping: %w\n", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lokiUri, nil)
+	if err != nil {
+		return fmt.Errorf("Error creating http Request in LokiClient.Ping: %w\n", err)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error reaching Loki in LokiClient.Ping: %w\n", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Response code '%v' in LokiClient.Ping\n", res.StatusCode)
+	}
+	return nil
+}
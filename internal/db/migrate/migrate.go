@@ -0,0 +1,386 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// lockKey is the advisory lock held while migrating, so replicas
+// starting together apply each migration once
+const lockKey int64 = 0x6469727469650001
+
+// scratchSchema holds a copy of the first migration while an existing
+// database is compared against it
+const scratchSchema = "dirtie_migrate_baseline"
+
+var (
+	ErrInvalidMigration = fmt.Errorf("Invalid migration")
+	ErrChecksumMismatch = fmt.Errorf("Applied migration was changed")
+	ErrUnknownVersion   = fmt.Errorf("Database has a migration this build doesn't know")
+	ErrNoDown           = fmt.Errorf("Migration has no down script")
+	ErrUnknownSchema    = fmt.Errorf("Database has tables but no migration history, and they don't match the first migration")
+)
+
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql
+var fileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// sha256 of Up, recorded when applied
+	Checksum string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Beginner is satisfied by *pgx.Conn and *pgxpool.Pool
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type Migrator struct {
+	db         Beginner
+	migrations []Migration
+	// called with each migration as it is applied or rolled back
+	Log func(msg string)
+}
+
+// Load reads the migrations in the root of fsys, ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("Error Load -> ReadDir: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := fileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration file '%v' is not named <version>_<name>.up|down.sql: %w", e.Name(), ErrInvalidMigration)
+		}
+		version, _ := strconv.Atoi(m[1])
+		if version <= 0 {
+			return nil, fmt.Errorf("migration file '%v' must have a version above 0: %w", e.Name(), ErrInvalidMigration)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration version %v is used by '%v' and '%v': %w", version, mig.Name, m[2], ErrInvalidMigration)
+		}
+
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("Error Load -> ReadFile '%v': %w", e.Name(), err)
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %v_%v has no up script: %w", mig.Version, mig.Name, ErrInvalidMigration)
+		}
+		sum := sha256.Sum256([]byte(mig.Up))
+		mig.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func NewMigrator(db Beginner, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies every pending migration. They run in one transaction
+// holding the advisory lock, so either all of them are applied or
+// none are, and scripts can't use statements that refuse to run in a
+// transaction, like CREATE INDEX CONCURRENTLY.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(ctx, func(tx pgx.Tx, done map[int]Status) error {
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			if _, err := tx.Exec(ctx, mig.Up); err != nil {
+				return fmt.Errorf("Error applying migration %v_%v: %w", mig.Version, mig.Name, err)
+			}
+			_, err := tx.Exec(ctx,
+				`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+				mig.Version, mig.Name, mig.Checksum)
+			if err != nil {
+				return fmt.Errorf("Error recording migration %v_%v: %w", mig.Version, mig.Name, err)
+			}
+			m.log(fmt.Sprintf("applied migration %v_%v", mig.Version, mig.Name))
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("Error Down (steps: %v): must roll back at least one migration", steps)
+	}
+
+	var reverted []Migration
+	err := m.locked(ctx, func(tx pgx.Tx, done map[int]Status) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("Error reverting migration %v_%v: %w", mig.Version, mig.Name, ErrNoDown)
+			}
+			if _, err := tx.Exec(ctx, mig.Down); err != nil {
+				return fmt.Errorf("Error reverting migration %v_%v: %w", mig.Version, mig.Name, err)
+			}
+			_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+			if err != nil {
+				return fmt.Errorf("Error unrecording migration %v_%v: %w", mig.Version, mig.Name, err)
+			}
+			m.log(fmt.Sprintf("reverted migration %v_%v", mig.Version, mig.Name))
+			reverted = append(reverted, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(tx pgx.Tx, done map[int]Status) error {
+		for _, mig := range m.migrations {
+			s := Status{Migration: mig}
+			if d, ok := done[mig.Version]; ok {
+				s.Applied = true
+				s.AppliedAt = d.AppliedAt
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// locked runs fn in a transaction holding the advisory lock, with the
+// applied migrations checked against the known ones
+func (m *Migrator) locked(ctx context.Context, fn func(tx pgx.Tx, done map[int]Status) error) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Error migrating -> Begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("Error migrating -> advisory lock: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum VARCHAR(64) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("Error migrating -> create schema_migrations: %w", err)
+	}
+
+	done, err := m.applied(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err = m.baseline(ctx, tx, done); err != nil {
+		return err
+	}
+	if err = m.verify(done); err != nil {
+		return err
+	}
+
+	if err = fn(tx, done); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Error migrating -> Commit: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context, tx pgx.Tx) (map[int]Status, error) {
+	rows, err := tx.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("Error migrating -> read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	done := map[int]Status{}
+	for rows.Next() {
+		var s Status
+		if err = rows.Scan(&s.Version, &s.Name, &s.Checksum, &s.AppliedAt); err != nil {
+			return nil, fmt.Errorf("Error migrating -> scan schema_migrations: %w", err)
+		}
+		s.Applied = true
+		done[s.Version] = s
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error migrating -> read schema_migrations: %w", err)
+	}
+	return done, nil
+}
+
+// baseline records the first migration as applied on databases that
+// were set up from schema.sql before migrations existed. Only a schema
+// that matches the first migration column for column is baselined;
+// anything else needs an operator to bring it in line by hand.
+func (m *Migrator) baseline(ctx context.Context, tx pgx.Tx, done map[int]Status) error {
+	if len(done) > 0 || len(m.migrations) == 0 {
+		return nil
+	}
+
+	var tables int
+	err := tx.QueryRow(ctx, `
+		SELECT count(*) FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'`).Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("Error migrating -> check for existing schema: %w", err)
+	}
+	if tables == 0 {
+		return nil
+	}
+
+	first := m.migrations[0]
+	matches, err := m.matchesMigration(ctx, tx, first)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return fmt.Errorf("Error migrating -> baseline at %v_%v: %w", first.Version, first.Name, ErrUnknownSchema)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+		first.Version, first.Name, first.Checksum)
+	if err != nil {
+		return fmt.Errorf("Error migrating -> record baseline: %w", err)
+	}
+	m.log(fmt.Sprintf("existing schema found, recorded migration %v_%v as applied", first.Version, first.Name))
+	done[first.Version] = Status{Migration: first, Applied: true, AppliedAt: time.Now()}
+	return nil
+}
+
+// matchesMigration applies mig to an empty scratch schema and compares
+// its columns with the current schema's. The scratch schema is rolled
+// back before returning.
+func (m *Migrator) matchesMigration(ctx context.Context, tx pgx.Tx, mig Migration) (bool, error) {
+	var current string
+	if err := tx.QueryRow(ctx, `SELECT current_schema()`).Scan(&current); err != nil {
+		return false, fmt.Errorf("Error migrating -> current_schema: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `SAVEPOINT baseline_check`); err != nil {
+		return false, fmt.Errorf("Error migrating -> baseline savepoint: %w", err)
+	}
+	// undoes the scratch schema and the search_path change
+	defer tx.Exec(ctx, `ROLLBACK TO SAVEPOINT baseline_check`)
+
+	_, err := tx.Exec(ctx, `CREATE SCHEMA `+scratchSchema+`; SET LOCAL search_path TO `+scratchSchema)
+	if err != nil {
+		return false, fmt.Errorf("Error migrating -> create scratch schema: %w", err)
+	}
+	if _, err = tx.Exec(ctx, mig.Up); err != nil {
+		return false, fmt.Errorf("Error migrating -> apply %v_%v to scratch schema: %w", mig.Version, mig.Name, err)
+	}
+
+	var diff int
+	err = tx.QueryRow(ctx, `
+		WITH cols AS (
+			SELECT table_schema, table_name, column_name, data_type,
+				character_maximum_length, is_nullable
+			FROM information_schema.columns
+			WHERE table_schema IN ($1, $2) AND table_name <> 'schema_migrations'
+		)
+		SELECT count(*) FROM (
+			(SELECT table_name, column_name, data_type, character_maximum_length, is_nullable FROM cols WHERE table_schema = $1
+			EXCEPT
+			SELECT table_name, column_name, data_type, character_maximum_length, is_nullable FROM cols WHERE table_schema = $2)
+			UNION ALL
+			(SELECT table_name, column_name, data_type, character_maximum_length, is_nullable FROM cols WHERE table_schema = $2
+			EXCEPT
+			SELECT table_name, column_name, data_type, character_maximum_length, is_nullable FROM cols WHERE table_schema = $1)
+		) d`, current, scratchSchema).Scan(&diff)
+	if err != nil {
+		return false, fmt.Errorf("Error migrating -> compare with scratch schema: %w", err)
+	}
+	return diff == 0, nil
+}
+
+// verify checks applied migrations still match their scripts
+func (m *Migrator) verify(done map[int]Status) error {
+	known := map[int]Migration{}
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+
+	for version, s := range done {
+		mig, ok := known[version]
+		if !ok {
+			return fmt.Errorf("migration %v_%v: %w", version, s.Name, ErrUnknownVersion)
+		}
+		if mig.Checksum != s.Checksum {
+			return fmt.Errorf("migration %v_%v: %w", version, mig.Name, ErrChecksumMismatch)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) log(msg string) {
+	if m.Log != nil {
+		m.Log(msg)
+	}
+}
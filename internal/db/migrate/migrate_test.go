@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0010_add_notes.up.sql":   file("ALTER TABLE devices ADD notes TEXT;"),
+			"0002_add_index.up.sql":   file("CREATE INDEX x ON devices (user_id);"),
+			"0002_add_index.down.sql": file("DROP INDEX x;"),
+			"0001_init.up.sql":        file("CREATE TABLE users ();"),
+		}
+
+		migrations, err := Load(fsys)
+
+		assert.Nil(t, err)
+		assert.Len(t, migrations, 3)
+		assert.Equal(t, []int{1, 2, 10}, []int{migrations[0].Version, migrations[1].Version, migrations[2].Version})
+		assert.Equal(t, "add_index", migrations[1].Name)
+		assert.Equal(t, "DROP INDEX x;", migrations[1].Down)
+		assert.Equal(t, "", migrations[2].Down)
+		assert.Len(t, migrations[0].Checksum, 64)
+	})
+	t.Run("ChecksumFollowsUpScript", func(t *testing.T) {
+		a, _ := Load(fstest.MapFS{"0001_init.up.sql": file("CREATE TABLE users ();")})
+		b, _ := Load(fstest.MapFS{
+			"0001_init.up.sql":   file("CREATE TABLE users ();"),
+			"0001_init.down.sql": file("DROP TABLE users;"),
+		})
+		c, _ := Load(fstest.MapFS{"0001_init.up.sql": file("CREATE TABLE users (id INT);")})
+
+		assert.Equal(t, a[0].Checksum, b[0].Checksum)
+		assert.NotEqual(t, a[0].Checksum, c[0].Checksum)
+	})
+	t.Run("BadName", func(t *testing.T) {
+		_, err := Load(fstest.MapFS{"init.sql": file("CREATE TABLE users ();")})
+		assert.ErrorIs(t, err, ErrInvalidMigration)
+	})
+	t.Run("VersionZero", func(t *testing.T) {
+		_, err := Load(fstest.MapFS{"0000_init.up.sql": file("CREATE TABLE users ();")})
+		assert.ErrorIs(t, err, ErrInvalidMigration)
+	})
+	t.Run("MissingUp", func(t *testing.T) {
+		_, err := Load(fstest.MapFS{"0001_init.down.sql": file("DROP TABLE users;")})
+		assert.ErrorIs(t, err, ErrInvalidMigration)
+	})
+	t.Run("VersionReused", func(t *testing.T) {
+		_, err := Load(fstest.MapFS{
+			"0002_add_notes.up.sql": file("ALTER TABLE devices ADD notes TEXT;"),
+			"0002_add_index.up.sql": file("CREATE INDEX x ON devices (user_id);"),
+		})
+		assert.ErrorIs(t, err, ErrInvalidMigration)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	migrations, _ := Load(fstest.MapFS{"0001_init.up.sql": file("CREATE TABLE users ();")})
+	m := Migrator{migrations: migrations}
+
+	t.Run("Success", func(t *testing.T) {
+		err := m.verify(map[int]Status{1: {Migration: migrations[0], Applied: true}})
+		assert.Nil(t, err)
+	})
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		changed := migrations[0]
+		changed.Checksum = "abc"
+		err := m.verify(map[int]Status{1: {Migration: changed, Applied: true}})
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+	t.Run("UnknownVersion", func(t *testing.T) {
+		err := m.verify(map[int]Status{
+			1: {Migration: migrations[0], Applied: true},
+			2: {Migration: Migration{Version: 2, Name: "newer"}, Applied: true},
+		})
+		assert.ErrorIs(t, err, ErrUnknownVersion)
+	})
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS provision_staging;
+DROP TABLE IF EXISTS devices;
+DROP TABLE IF EXISTS pw_reset_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,11 @@
+CREATE TABLE device_commands (
+  command_id VARCHAR(64) PRIMARY KEY,
+  device_id INTEGER NOT NULL REFERENCES devices(device_id) ON DELETE CASCADE,
+  command_type VARCHAR(32) NOT NULL,
+  args JSONB,
+  status VARCHAR(16) NOT NULL,
+  error VARCHAR(250),
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  acked_at TIMESTAMP WITH TIME ZONE
+);
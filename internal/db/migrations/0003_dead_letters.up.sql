@@ -0,0 +1,9 @@
+CREATE TABLE dead_letters (
+  dead_letter_id BIGSERIAL PRIMARY KEY,
+  topic VARCHAR(250) NOT NULL,
+  payload BYTEA NOT NULL,
+  error TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 1,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  last_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,27 @@
+CREATE TABLE alert_rules (
+  rule_id SERIAL PRIMARY KEY,
+  device_id INTEGER NOT NULL REFERENCES devices(device_id) ON DELETE CASCADE,
+  name VARCHAR(250) NOT NULL,
+  measurement VARCHAR(64) NOT NULL,
+  min_value DOUBLE PRECISION,
+  max_value DOUBLE PRECISION,
+  duration_sec INTEGER NOT NULL DEFAULT 0,
+  hysteresis DOUBLE PRECISION NOT NULL DEFAULT 0,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  state VARCHAR(16) NOT NULL DEFAULT 'ok',
+  pending_since TIMESTAMP WITH TIME ZONE,
+  evaluated_at TIMESTAMP WITH TIME ZONE,
+  snoozed_until TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE alert_events (
+  event_id BIGSERIAL PRIMARY KEY,
+  rule_id INTEGER NOT NULL REFERENCES alert_rules(rule_id) ON DELETE CASCADE,
+  device_id INTEGER NOT NULL REFERENCES devices(device_id) ON DELETE CASCADE,
+  kind VARCHAR(16) NOT NULL,
+  value DOUBLE PRECISION NOT NULL,
+  snoozed BOOLEAN NOT NULL DEFAULT FALSE,
+  occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,25 @@
+CREATE TABLE notification_prefs (
+  user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  channel VARCHAR(16) NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  -- email address or webhook url, empty for the channel default
+  target TEXT NOT NULL DEFAULT '',
+  alerts BOOLEAN NOT NULL DEFAULT TRUE,
+  device_events BOOLEAN NOT NULL DEFAULT TRUE,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, channel)
+);
+
+CREATE TABLE notification_log (
+  notification_id BIGSERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  channel VARCHAR(16) NOT NULL,
+  kind VARCHAR(16) NOT NULL,
+  dedupe_key VARCHAR(250) NOT NULL DEFAULT '',
+  subject VARCHAR(250) NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX notification_log_user_idx ON notification_log (user_id, channel, created_at);
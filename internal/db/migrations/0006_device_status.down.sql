@@ -0,0 +1,6 @@
+ALTER TABLE devices
+  DROP COLUMN latest_reading_at,
+  DROP COLUMN latest_readings,
+  DROP COLUMN online,
+  DROP COLUMN last_seen,
+  DROP COLUMN report_interval_sec;
@@ -0,0 +1,7 @@
+ALTER TABLE devices
+  -- seconds between readings, NULL for the server default
+  ADD COLUMN report_interval_sec INTEGER,
+  ADD COLUMN last_seen TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN online BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN latest_readings JSONB,
+  ADD COLUMN latest_reading_at TIMESTAMP WITH TIME ZONE;
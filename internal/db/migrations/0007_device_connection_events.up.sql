@@ -0,0 +1,10 @@
+CREATE TABLE device_connection_events (
+  event_id BIGSERIAL PRIMARY KEY,
+  device_id INTEGER NOT NULL REFERENCES devices(device_id) ON DELETE CASCADE,
+  -- connected or disconnected, from the device's mqtt birth and will
+  event VARCHAR(16) NOT NULL,
+  occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX device_connection_events_device_idx ON device_connection_events (device_id, occurred_at);
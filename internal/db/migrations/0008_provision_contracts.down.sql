@@ -0,0 +1,7 @@
+ALTER TABLE provision_staging
+  DROP COLUMN created_at,
+  DROP COLUMN expires_at,
+  DROP CONSTRAINT provision_staging_contract_key;
+
+ALTER TABLE devices
+  DROP CONSTRAINT devices_mac_addr_key;
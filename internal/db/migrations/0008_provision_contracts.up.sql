@@ -0,0 +1,18 @@
+-- a mac address could be bound to more than one device before it was
+-- unique; the most recently created device keeps it
+UPDATE devices d
+SET mac_addr = NULL
+WHERE mac_addr IS NOT NULL
+  AND EXISTS (SELECT 1 FROM devices o WHERE o.mac_addr = d.mac_addr AND o.device_id > d.device_id);
+
+ALTER TABLE devices
+  ADD CONSTRAINT devices_mac_addr_key UNIQUE (mac_addr);
+
+-- contracts handed out before they expired stay valid for a day
+ALTER TABLE provision_staging
+  ADD CONSTRAINT provision_staging_contract_key UNIQUE (contract),
+  ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP + INTERVAL '1 day',
+  ADD COLUMN created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+
+ALTER TABLE provision_staging
+  ALTER COLUMN expires_at DROP DEFAULT;
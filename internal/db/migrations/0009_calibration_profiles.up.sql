@@ -0,0 +1,10 @@
+CREATE TABLE calibration_profiles (
+  device_id INTEGER PRIMARY KEY REFERENCES devices(device_id) ON DELETE CASCADE,
+  soil_type VARCHAR(64) NOT NULL DEFAULT '',
+  -- raw capacitance read in dry and saturated soil
+  dry_value DOUBLE PRECISION,
+  wet_value DOUBLE PRECISION,
+  -- extra [{"raw": .., "percent": ..}] points between dry and wet
+  curve JSONB,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
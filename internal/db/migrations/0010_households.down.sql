@@ -0,0 +1,6 @@
+ALTER TABLE devices
+  DROP COLUMN household_id;
+
+DROP TABLE IF EXISTS household_invites;
+DROP TABLE IF EXISTS household_members;
+DROP TABLE IF EXISTS households;
@@ -0,0 +1,31 @@
+CREATE TABLE households (
+  household_id SERIAL PRIMARY KEY,
+  name VARCHAR(250) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE household_members (
+  household_id INTEGER NOT NULL REFERENCES households(household_id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  -- owner, editor or viewer
+  role VARCHAR(16) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (household_id, user_id)
+);
+
+CREATE INDEX household_members_user_idx ON household_members (user_id);
+
+CREATE TABLE household_invites (
+  invite_id BIGSERIAL PRIMARY KEY,
+  household_id INTEGER NOT NULL REFERENCES households(household_id) ON DELETE CASCADE,
+  email VARCHAR(250) NOT NULL,
+  role VARCHAR(16) NOT NULL,
+  token VARCHAR(64) NOT NULL UNIQUE,
+  invited_by INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE devices
+  -- shared with the household's members, NULL for the owner only
+  ADD COLUMN household_id INTEGER REFERENCES households(household_id) ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE devices
+  DROP COLUMN notes,
+  DROP COLUMN plant_species,
+  DROP COLUMN location;
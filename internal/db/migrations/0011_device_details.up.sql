@@ -0,0 +1,4 @@
+ALTER TABLE devices
+  ADD COLUMN location VARCHAR(250),
+  ADD COLUMN plant_species VARCHAR(250),
+  ADD COLUMN notes TEXT;
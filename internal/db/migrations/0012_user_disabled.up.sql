@@ -0,0 +1,2 @@
+-- disabled users can't sign in; NULL while the account is active
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP WITH TIME ZONE;
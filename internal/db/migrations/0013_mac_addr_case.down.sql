@@ -0,0 +1,4 @@
+DROP INDEX devices_mac_addr_lower_key;
+
+ALTER TABLE devices
+  ADD CONSTRAINT devices_mac_addr_key UNIQUE (mac_addr);
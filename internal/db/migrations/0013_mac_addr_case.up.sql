@@ -0,0 +1,13 @@
+-- devices report their mac address in whatever case their firmware
+-- uses, so addresses differing only in case are the same device. The
+-- most recently created device keeps it, as in 0008. The stored case
+-- is kept since it is also the device's topic.
+UPDATE devices d
+SET mac_addr = NULL
+WHERE mac_addr IS NOT NULL
+  AND EXISTS (SELECT 1 FROM devices o WHERE lower(o.mac_addr) = lower(d.mac_addr) AND o.device_id > d.device_id);
+
+ALTER TABLE devices
+  DROP CONSTRAINT devices_mac_addr_key;
+
+CREATE UNIQUE INDEX devices_mac_addr_lower_key ON devices (lower(mac_addr));
@@ -6,10 +6,30 @@ import (
 
 	"github.com/frozenkro/dirtie-srv/internal/core"
 	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+	"github.com/frozenkro/dirtie-srv/internal/db/migrate"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 func PgConnect(ctx context.Context) (*pgxpool.Pool, error) {
+	pool, err := PgOpen(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !core.IS_TEST && core.POSTGRES_MIGRATE_ON_START {
+		// serving against a schema this build doesn't match would
+		// fail request by request, so refuse to start instead
+		if err = migrateSchema(ctx, pool); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("Error migrating schema: %w", err)
+		}
+	}
+
+	return pool, nil
+}
+
+// PgOpen connects without touching the schema
+func PgOpen(ctx context.Context) (*pgxpool.Pool, error) {
 	dbName := getDbName(ctx)
 
 	connstr := fmt.Sprintf("postgres://%v:%v@%v/%v",
@@ -23,37 +43,34 @@ func PgConnect(ctx context.Context) (*pgxpool.Pool, error) {
 		return nil, err
 	}
 
-	pool, err := pgxpool.NewWithConfig(ctx, config)
+	return pgxpool.NewWithConfig(ctx, config)
+}
+
+// NewMigrator returns a migrator for the embedded migrations
+func NewMigrator(pool *pgxpool.Pool) (*migrate.Migrator, error) {
+	m, err := migrate.NewMigrator(pool, Migrations)
 	if err != nil {
 		return nil, err
 	}
-
-	if !core.IS_TEST {
-		if initErr := initSchema(ctx, pool); initErr != nil {
-			utils.LogErr(fmt.Sprintf("schema init: %v", initErr))
-		}
-	}
-
-	return pool, nil
+	m.Log = utils.LogInfo
+	return m, nil
 }
 
-func initSchema(ctx context.Context, pool *pgxpool.Pool) error {
-	var tableExists int
-	row := pool.QueryRow(ctx, `
-		SELECT 1 FROM information_schema.tables
-		WHERE table_schema = 'public' AND table_name = 'users'`)
-	err := row.Scan(&tableExists)
-	if err == nil {
-		// init schema already run
-		return nil
+// migrateSchema applies pending migrations. Replicas starting together
+// wait on each other through the migrator's advisory lock.
+func migrateSchema(ctx context.Context, pool *pgxpool.Pool) error {
+	m, err := NewMigrator(pool)
+	if err != nil {
+		return err
 	}
 
-	utils.LogInfo("schema not found, applying schema.sql")
-	_, err = pool.Exec(ctx, string(SchemaSql))
+	applied, err := m.Up(ctx)
 	if err != nil {
 		return err
 	}
-	utils.LogInfo("schema applied")
+	if len(applied) == 0 {
+		utils.LogInfo("schema up to date")
+	}
 	return nil
 }
 
@@ -0,0 +1,172 @@
+package repos
+
+import (
+	"context"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type AlertRepo struct {
+	sr SqlRunner
+}
+
+// CreateAlertRule inserts the rule's settings; ids, state and
+// timestamps on rule are ignored
+func (r AlertRepo) CreateAlertRule(ctx context.Context, rule sqlc.AlertRule) (sqlc.AlertRule, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CreateAlertRuleParams{
+			DeviceID:    rule.DeviceID,
+			Name:        rule.Name,
+			Measurement: rule.Measurement,
+			MinValue:    rule.MinValue,
+			MaxValue:    rule.MaxValue,
+			DurationSec: rule.DurationSec,
+			Hysteresis:  rule.Hysteresis,
+			Enabled:     rule.Enabled,
+		}
+		return q.CreateAlertRule(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.AlertRule{}, err
+	}
+	return res.(sqlc.AlertRule), err
+}
+
+func (r AlertRepo) GetAlertRule(ctx context.Context, ruleId int32) (sqlc.AlertRule, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetAlertRule(ctx, ruleId)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.AlertRule{}, err
+	}
+	return res.(sqlc.AlertRule), err
+}
+
+func (r AlertRepo) GetAlertRulesByDevice(ctx context.Context, deviceId int32) ([]sqlc.AlertRule, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetAlertRulesByDevice(ctx, deviceId)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.AlertRule), err
+}
+
+func (r AlertRepo) GetEnabledAlertRules(ctx context.Context, deviceId int32) ([]sqlc.AlertRule, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetEnabledAlertRules(ctx, deviceId)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.AlertRule), err
+}
+
+// UpdateAlertRule replaces the rule's settings and resets its state
+func (r AlertRepo) UpdateAlertRule(ctx context.Context, rule sqlc.AlertRule) (sqlc.AlertRule, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.UpdateAlertRuleParams{
+			RuleID:      rule.RuleID,
+			Name:        rule.Name,
+			Measurement: rule.Measurement,
+			MinValue:    rule.MinValue,
+			MaxValue:    rule.MaxValue,
+			DurationSec: rule.DurationSec,
+			Hysteresis:  rule.Hysteresis,
+			Enabled:     rule.Enabled,
+		}
+		return q.UpdateAlertRule(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.AlertRule{}, err
+	}
+	return res.(sqlc.AlertRule), err
+}
+
+// UpdateAlertRuleState only applies if no later reading has already
+// been evaluated against the rule. Returns false when it was skipped.
+func (r AlertRepo) UpdateAlertRuleState(ctx context.Context,
+	ruleId int32,
+	state string,
+	pendingSince time.Time,
+	evaluatedAt time.Time) (bool, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.UpdateAlertRuleStateParams{
+			RuleID:       ruleId,
+			State:        state,
+			PendingSince: pgtype.Timestamptz{Time: pendingSince, Valid: !pendingSince.IsZero()},
+			EvaluatedAt:  pgtype.Timestamptz{Time: evaluatedAt, Valid: true},
+		}
+		return q.UpdateAlertRuleState(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return false, err
+	}
+	return res.(int64) > 0, err
+}
+
+// SnoozeAlertRule silences the rule until the given time, a zero
+// time clears the snooze
+func (r AlertRepo) SnoozeAlertRule(ctx context.Context, ruleId int32, until time.Time) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.SnoozeAlertRuleParams{
+			RuleID:       ruleId,
+			SnoozedUntil: pgtype.Timestamptz{Time: until, Valid: !until.IsZero()},
+		}
+		return q.SnoozeAlertRule(ctx, params)
+	})
+}
+
+func (r AlertRepo) DeleteAlertRule(ctx context.Context, ruleId int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.DeleteAlertRule(ctx, ruleId)
+	})
+}
+
+func (r AlertRepo) CreateAlertEvent(ctx context.Context,
+	ruleId int32,
+	deviceId int32,
+	kind string,
+	value float64,
+	snoozed bool,
+	occurredAt time.Time) (sqlc.AlertEvent, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CreateAlertEventParams{
+			RuleID:     ruleId,
+			DeviceID:   deviceId,
+			Kind:       kind,
+			Value:      value,
+			Snoozed:    snoozed,
+			OccurredAt: pgtype.Timestamptz{Time: occurredAt, Valid: true},
+		}
+		return q.CreateAlertEvent(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.AlertEvent{}, err
+	}
+	return res.(sqlc.AlertEvent), err
+}
+
+func (r AlertRepo) GetAlertEventsByDevice(ctx context.Context, deviceId int32, limit int32) ([]sqlc.AlertEvent, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.GetAlertEventsByDeviceParams{
+			DeviceID: deviceId,
+			Limit:    limit,
+		}
+		return q.GetAlertEventsByDevice(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.AlertEvent), err
+}
@@ -0,0 +1,48 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+type CalibrationRepo struct {
+	sr SqlRunner
+}
+
+func (r CalibrationRepo) GetCalibrationProfile(ctx context.Context, deviceId int32) (sqlc.CalibrationProfile, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetCalibrationProfile(ctx, deviceId)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.CalibrationProfile{}, err
+	}
+	return res.(sqlc.CalibrationProfile), err
+}
+
+// UpsertCalibrationProfile creates or replaces the device's profile;
+// UpdatedAt on profile is ignored
+func (r CalibrationRepo) UpsertCalibrationProfile(ctx context.Context, profile sqlc.CalibrationProfile) (sqlc.CalibrationProfile, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.UpsertCalibrationProfileParams{
+			DeviceID: profile.DeviceID,
+			SoilType: profile.SoilType,
+			DryValue: profile.DryValue,
+			WetValue: profile.WetValue,
+			Curve:    profile.Curve,
+		}
+		return q.UpsertCalibrationProfile(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.CalibrationProfile{}, err
+	}
+	return res.(sqlc.CalibrationProfile), err
+}
+
+func (r CalibrationRepo) DeleteCalibrationProfile(ctx context.Context, deviceId int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.DeleteCalibrationProfile(ctx, deviceId)
+	})
+}
@@ -0,0 +1,82 @@
+package repos
+
+import (
+	"context"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type DeadLetterRepo struct {
+	sr SqlRunner
+}
+
+func (r DeadLetterRepo) CreateDeadLetter(ctx context.Context, topic string, payload []byte, errMsg string) (sqlc.DeadLetter, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CreateDeadLetterParams{
+			Topic:   topic,
+			Payload: payload,
+			Error:   errMsg,
+		}
+		return q.CreateDeadLetter(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.DeadLetter{}, err
+	}
+	return res.(sqlc.DeadLetter), err
+}
+
+func (r DeadLetterRepo) GetDeadLetter(ctx context.Context, deadLetterId int64) (sqlc.DeadLetter, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetDeadLetter(ctx, deadLetterId)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.DeadLetter{}, err
+	}
+	return res.(sqlc.DeadLetter), err
+}
+
+func (r DeadLetterRepo) GetDeadLetters(ctx context.Context, limit int32, offset int32) ([]sqlc.DeadLetter, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.GetDeadLettersParams{
+			Limit:  limit,
+			Offset: offset,
+		}
+		return q.GetDeadLetters(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.DeadLetter), err
+}
+
+func (r DeadLetterRepo) UpdateDeadLetterAttempt(ctx context.Context, deadLetterId int64, errMsg string) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.UpdateDeadLetterAttemptParams{
+			DeadLetterID: deadLetterId,
+			Error:        errMsg,
+		}
+		return q.UpdateDeadLetterAttempt(ctx, params)
+	})
+}
+
+func (r DeadLetterRepo) DeleteDeadLetter(ctx context.Context, deadLetterId int64) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.DeleteDeadLetter(ctx, deadLetterId)
+	})
+}
+
+func (r DeadLetterRepo) DeleteDeadLettersBefore(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.DeleteDeadLettersBefore(ctx, pgtype.Timestamptz{Time: before, Valid: true})
+	})
+
+	if err != nil || res == nil {
+		return 0, err
+	}
+	return res.(int64), err
+}
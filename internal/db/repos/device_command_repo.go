@@ -0,0 +1,91 @@
+package repos
+
+import (
+	"context"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type DeviceCommandRepo struct {
+	sr SqlRunner
+}
+
+func (r DeviceCommandRepo) CreateDeviceCommand(ctx context.Context,
+	commandId string,
+	deviceId int32,
+	commandType string,
+	args []byte,
+	status string,
+	expiresAt time.Time) (sqlc.DeviceCommand, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CreateDeviceCommandParams{
+			CommandID:   commandId,
+			DeviceID:    deviceId,
+			CommandType: commandType,
+			Args:        args,
+			Status:      status,
+			ExpiresAt: pgtype.Timestamptz{
+				Time:  expiresAt,
+				Valid: true,
+			},
+		}
+		return q.CreateDeviceCommand(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.DeviceCommand{}, err
+	}
+	return res.(sqlc.DeviceCommand), err
+}
+
+func (r DeviceCommandRepo) GetDeviceCommand(ctx context.Context, commandId string) (sqlc.DeviceCommand, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetDeviceCommand(ctx, commandId)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.DeviceCommand{}, err
+	}
+	return res.(sqlc.DeviceCommand), err
+}
+
+func (r DeviceCommandRepo) GetDeviceCommands(ctx context.Context, deviceId int32) ([]sqlc.DeviceCommand, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetDeviceCommands(ctx, deviceId)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.DeviceCommand), err
+}
+
+func (r DeviceCommandRepo) UpdateDeviceCommandStatus(ctx context.Context, commandId string, status string, errMsg string) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.UpdateDeviceCommandStatusParams{
+			CommandID: commandId,
+			Status:    status,
+			Error:     pgtype.Text{String: errMsg, Valid: errMsg != ""},
+		}
+		return q.UpdateDeviceCommandStatus(ctx, params)
+	})
+}
+
+func (r DeviceCommandRepo) AckDeviceCommand(ctx context.Context, commandId string, status string, errMsg string) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.AckDeviceCommandParams{
+			CommandID: commandId,
+			Status:    status,
+			Error:     pgtype.Text{String: errMsg, Valid: errMsg != ""},
+		}
+		return q.AckDeviceCommand(ctx, params)
+	})
+}
+
+func (r DeviceCommandRepo) ExpireDeviceCommands(ctx context.Context) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.ExpireDeviceCommands(ctx)
+	})
+}
@@ -2,6 +2,7 @@ package repos
 
 import (
 	"context"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -25,9 +26,21 @@ func (r DeviceRepo) CreateDevice(ctx context.Context, userId int32, displayName
 	return res.(sqlc.Device), err
 }
 
+func (r DeviceRepo) GetDevice(ctx context.Context, deviceId int32) (sqlc.Device, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetDevice(ctx, deviceId)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.Device{}, err
+	}
+	return res.(sqlc.Device), err
+}
+
+// GetDeviceByMacAddress matches macAddr regardless of case
 func (r DeviceRepo) GetDeviceByMacAddress(ctx context.Context, macAddr string) (sqlc.Device, error) {
 	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
-		return q.GetDeviceByMacAddress(ctx, pgtype.Text{String: macAddr, Valid: true})
+		return q.GetDeviceByMacAddress(ctx, macAddr)
 	})
 
 	if err != nil || res == nil {
@@ -47,6 +60,42 @@ func (r DeviceRepo) GetDevicesByUser(ctx context.Context, userId int32) ([]sqlc.
 	return res.([]sqlc.Device), err
 }
 
+func (r DeviceRepo) GetAllDevices(ctx context.Context) ([]sqlc.Device, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetAllDevices(ctx)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.Device), err
+}
+
+// GetAccessibleDevices returns devices the user owns or that are
+// shared with one of their households
+func (r DeviceRepo) GetAccessibleDevices(ctx context.Context, userId int32) ([]sqlc.Device, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetAccessibleDevices(ctx, userId)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.Device), err
+}
+
+// SetDeviceHousehold shares the device with a household, or stops
+// sharing it when householdId is nil
+func (r DeviceRepo) SetDeviceHousehold(ctx context.Context, deviceId int32, householdId *int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.SetDeviceHouseholdParams{DeviceID: deviceId}
+		if householdId != nil {
+			params.HouseholdID = pgtype.Int4{Int32: *householdId, Valid: true}
+		}
+		return q.SetDeviceHousehold(ctx, params)
+	})
+}
+
 func (r DeviceRepo) RenameDevice(ctx context.Context, deviceId int32, displayName string) error {
 	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
 		params := sqlc.RenameDeviceParams{
@@ -57,6 +106,20 @@ func (r DeviceRepo) RenameDevice(ctx context.Context, deviceId int32, displayNam
 	})
 }
 
+// UpdateDeviceDetails writes the user editable fields of device
+func (r DeviceRepo) UpdateDeviceDetails(ctx context.Context, device sqlc.Device) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.UpdateDeviceDetailsParams{
+			DeviceID:     device.DeviceID,
+			DisplayName:  device.DisplayName,
+			Location:     device.Location,
+			PlantSpecies: device.PlantSpecies,
+			Notes:        device.Notes,
+		}
+		return q.UpdateDeviceDetails(ctx, params)
+	})
+}
+
 func (r DeviceRepo) UpdateDeviceMacAddress(ctx context.Context, deviceId int32, macAddr string) error {
 	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
 		params := sqlc.UpdateDeviceMacAddressParams{
@@ -66,3 +129,167 @@ func (r DeviceRepo) UpdateDeviceMacAddress(ctx context.Context, deviceId int32,
 		return q.UpdateDeviceMacAddress(ctx, params)
 	})
 }
+
+// ForceDeviceMacAddress binds macAddr to the device in one transaction,
+// taking it from whichever device held it and dropping the device's
+// pending provision
+func (r DeviceRepo) ForceDeviceMacAddress(ctx context.Context, deviceId int32, macAddr string) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		err := q.UnbindMacAddress(ctx, sqlc.UnbindMacAddressParams{MacAddr: macAddr, DeviceID: deviceId})
+		if err != nil {
+			return err
+		}
+		if err = q.DeleteProvisionStaging(ctx, deviceId); err != nil {
+			return err
+		}
+		return q.UpdateDeviceMacAddress(ctx, sqlc.UpdateDeviceMacAddressParams{
+			DeviceID: deviceId,
+			MacAddr:  pgtype.Text{String: macAddr, Valid: true},
+		})
+	})
+}
+
+func (r DeviceRepo) ResetDeviceMacAddress(ctx context.Context, deviceId int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.ResetDeviceMacAddress(ctx, deviceId)
+	})
+}
+
+func (r DeviceRepo) DeleteDevice(ctx context.Context, deviceId int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.DeleteDevice(ctx, deviceId)
+	})
+}
+
+// MarkDeviceOnline reports whether the device was offline until now
+func (r DeviceRepo) MarkDeviceOnline(ctx context.Context, deviceId int32, seenAt time.Time) (bool, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.MarkDeviceOnlineParams{
+			DeviceID: deviceId,
+			LastSeen: pgtype.Timestamptz{Time: seenAt, Valid: true},
+		}
+		return q.MarkDeviceOnline(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return false, err
+	}
+	return res.(int64) > 0, err
+}
+
+// UpdateDeviceLastSeen only ever moves last_seen forward
+func (r DeviceRepo) UpdateDeviceLastSeen(ctx context.Context, deviceId int32, seenAt time.Time) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.UpdateDeviceLastSeenParams{
+			DeviceID: deviceId,
+			LastSeen: pgtype.Timestamptz{Time: seenAt, Valid: true},
+		}
+		return q.UpdateDeviceLastSeen(ctx, params)
+	})
+}
+
+// MarkDeviceOffline only applies if the device hasn't been seen since
+// lastSeen, and reports whether it did
+func (r DeviceRepo) MarkDeviceOffline(ctx context.Context, deviceId int32, lastSeen time.Time) (bool, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.MarkDeviceOfflineParams{
+			DeviceID: deviceId,
+			LastSeen: pgtype.Timestamptz{Time: lastSeen, Valid: true},
+		}
+		return q.MarkDeviceOffline(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return false, err
+	}
+	return res.(int64) > 0, err
+}
+
+// GetOverdueDevices returns online devices that have missed
+// missedIntervals of their report interval as of now. Time before
+// upSince doesn't count, so a server outage doesn't mark every device
+// offline at once.
+func (r DeviceRepo) GetOverdueDevices(ctx context.Context,
+	now time.Time,
+	upSince time.Time,
+	defaultIntervalSec int32,
+	missedIntervals int32) ([]sqlc.Device, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.GetOverdueDevicesParams{
+			UpSince:            pgtype.Timestamptz{Time: upSince, Valid: true},
+			DefaultIntervalSec: defaultIntervalSec,
+			MissedIntervals:    missedIntervals,
+			Now:                pgtype.Timestamptz{Time: now, Valid: true},
+		}
+		return q.GetOverdueDevices(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.Device), err
+}
+
+// UpdateDeviceLatestReading ignores readings older than the stored one
+func (r DeviceRepo) UpdateDeviceLatestReading(ctx context.Context, deviceId int32, readings []byte, ts time.Time) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.UpdateDeviceLatestReadingParams{
+			DeviceID:        deviceId,
+			LatestReadings:  readings,
+			LatestReadingAt: pgtype.Timestamptz{Time: ts, Valid: true},
+		}
+		return q.UpdateDeviceLatestReading(ctx, params)
+	})
+}
+
+func (r DeviceRepo) UpdateDeviceReportInterval(ctx context.Context, deviceId int32, intervalSec int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.UpdateDeviceReportIntervalParams{
+			DeviceID:          deviceId,
+			ReportIntervalSec: pgtype.Int4{Int32: intervalSec, Valid: true},
+		}
+		return q.UpdateDeviceReportInterval(ctx, params)
+	})
+}
+
+func (r DeviceRepo) CreateDeviceConnectionEvent(ctx context.Context, deviceId int32, event string, occurredAt time.Time) (sqlc.DeviceConnectionEvent, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CreateDeviceConnectionEventParams{
+			DeviceID:   deviceId,
+			Event:      event,
+			OccurredAt: pgtype.Timestamptz{Time: occurredAt, Valid: true},
+		}
+		return q.CreateDeviceConnectionEvent(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.DeviceConnectionEvent{}, err
+	}
+	return res.(sqlc.DeviceConnectionEvent), err
+}
+
+func (r DeviceRepo) GetLatestDeviceConnectionEvent(ctx context.Context, deviceId int32) (sqlc.DeviceConnectionEvent, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetLatestDeviceConnectionEvent(ctx, deviceId)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.DeviceConnectionEvent{}, err
+	}
+	return res.(sqlc.DeviceConnectionEvent), err
+}
+
+func (r DeviceRepo) GetDeviceConnectionEvents(ctx context.Context, deviceId int32, limit int32) ([]sqlc.DeviceConnectionEvent, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.GetDeviceConnectionEventsParams{
+			DeviceID: deviceId,
+			Limit:    limit,
+		}
+		return q.GetDeviceConnectionEvents(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.DeviceConnectionEvent), err
+}
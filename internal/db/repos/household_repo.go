@@ -0,0 +1,220 @@
+package repos
+
+import (
+	"context"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type HouseholdRepo struct {
+	sr SqlRunner
+}
+
+// CreateHousehold inserts the household with ownerId as its first
+// owner, in one transaction
+func (r HouseholdRepo) CreateHousehold(ctx context.Context, name string, ownerId int32) (sqlc.Household, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		household, err := q.CreateHousehold(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		params := sqlc.AddHouseholdMemberParams{
+			HouseholdID: household.HouseholdID,
+			UserID:      ownerId,
+			Role:        "owner",
+		}
+		if _, err = q.AddHouseholdMember(ctx, params); err != nil {
+			return nil, err
+		}
+		return household, nil
+	})
+
+	if err != nil || res == nil {
+		return sqlc.Household{}, err
+	}
+	return res.(sqlc.Household), err
+}
+
+func (r HouseholdRepo) GetHousehold(ctx context.Context, householdId int32) (sqlc.Household, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetHousehold(ctx, householdId)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.Household{}, err
+	}
+	return res.(sqlc.Household), err
+}
+
+func (r HouseholdRepo) GetHouseholdsByUser(ctx context.Context, userId int32) ([]sqlc.GetHouseholdsByUserRow, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetHouseholdsByUser(ctx, userId)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.GetHouseholdsByUserRow), err
+}
+
+func (r HouseholdRepo) DeleteHousehold(ctx context.Context, householdId int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.DeleteHousehold(ctx, householdId)
+	})
+}
+
+func (r HouseholdRepo) GetHouseholdMember(ctx context.Context, householdId int32, userId int32) (sqlc.HouseholdMember, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.GetHouseholdMemberParams{
+			HouseholdID: householdId,
+			UserID:      userId,
+		}
+		return q.GetHouseholdMember(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.HouseholdMember{}, err
+	}
+	return res.(sqlc.HouseholdMember), err
+}
+
+func (r HouseholdRepo) GetHouseholdMembers(ctx context.Context, householdId int32) ([]sqlc.GetHouseholdMembersRow, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetHouseholdMembers(ctx, householdId)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.GetHouseholdMembersRow), err
+}
+
+func (r HouseholdRepo) CountHouseholdOwners(ctx context.Context, householdId int32) (int64, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.CountHouseholdOwners(ctx, householdId)
+	})
+
+	if err != nil || res == nil {
+		return 0, err
+	}
+	return res.(int64), err
+}
+
+func (r HouseholdRepo) UpdateHouseholdMemberRole(ctx context.Context, householdId int32, userId int32, role string) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.UpdateHouseholdMemberRoleParams{
+			HouseholdID: householdId,
+			UserID:      userId,
+			Role:        role,
+		}
+		return q.UpdateHouseholdMemberRole(ctx, params)
+	})
+}
+
+// DeleteHouseholdMember removes the member and stops sharing their
+// devices with the household, in one transaction
+func (r HouseholdRepo) DeleteHouseholdMember(ctx context.Context, householdId int32, userId int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		unshare := sqlc.UnshareUserDevicesParams{
+			UserID:      userId,
+			HouseholdID: pgtype.Int4{Int32: householdId, Valid: true},
+		}
+		if err := q.UnshareUserDevices(ctx, unshare); err != nil {
+			return err
+		}
+
+		params := sqlc.DeleteHouseholdMemberParams{
+			HouseholdID: householdId,
+			UserID:      userId,
+		}
+		return q.DeleteHouseholdMember(ctx, params)
+	})
+}
+
+// CreateHouseholdInvite inserts the invite; its id and CreatedAt are
+// ignored
+func (r HouseholdRepo) CreateHouseholdInvite(ctx context.Context, invite sqlc.HouseholdInvite) (sqlc.HouseholdInvite, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CreateHouseholdInviteParams{
+			HouseholdID: invite.HouseholdID,
+			Email:       invite.Email,
+			Role:        invite.Role,
+			Token:       invite.Token,
+			InvitedBy:   invite.InvitedBy,
+			ExpiresAt:   invite.ExpiresAt,
+		}
+		return q.CreateHouseholdInvite(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.HouseholdInvite{}, err
+	}
+	return res.(sqlc.HouseholdInvite), err
+}
+
+func (r HouseholdRepo) GetHouseholdInviteByToken(ctx context.Context, token string) (sqlc.HouseholdInvite, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetHouseholdInviteByToken(ctx, token)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.HouseholdInvite{}, err
+	}
+	return res.(sqlc.HouseholdInvite), err
+}
+
+// GetHouseholdInvites returns the household's invites still pending at now
+func (r HouseholdRepo) GetHouseholdInvites(ctx context.Context, householdId int32, now time.Time) ([]sqlc.HouseholdInvite, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.GetHouseholdInvitesParams{
+			HouseholdID: householdId,
+			ExpiresAt:   pgtype.Timestamptz{Time: now, Valid: true},
+		}
+		return q.GetHouseholdInvites(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.HouseholdInvite), err
+}
+
+// AcceptHouseholdInvite spends the invite and adds userId to its
+// household with the invited role, in one transaction. A zero member
+// means the invite was already used or has expired.
+func (r HouseholdRepo) AcceptHouseholdInvite(ctx context.Context, token string, userId int32, now time.Time) (sqlc.HouseholdMember, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		invite, err := q.ConsumeHouseholdInvite(ctx, sqlc.ConsumeHouseholdInviteParams{
+			Token:     token,
+			ExpiresAt: pgtype.Timestamptz{Time: now, Valid: true},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		params := sqlc.AddHouseholdMemberParams{
+			HouseholdID: invite.HouseholdID,
+			UserID:      userId,
+			Role:        invite.Role,
+		}
+		return q.AddHouseholdMember(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.HouseholdMember{}, err
+	}
+	return res.(sqlc.HouseholdMember), err
+}
+
+func (r HouseholdRepo) DeleteHouseholdInvite(ctx context.Context, householdId int32, inviteId int64) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		params := sqlc.DeleteHouseholdInviteParams{
+			HouseholdID: householdId,
+			InviteID:    inviteId,
+		}
+		return q.DeleteHouseholdInvite(ctx, params)
+	})
+}
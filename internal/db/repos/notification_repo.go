@@ -0,0 +1,119 @@
+package repos
+
+import (
+	"context"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type NotificationRepo struct {
+	sr SqlRunner
+}
+
+func (r NotificationRepo) GetNotificationPrefs(ctx context.Context, userId int32) ([]sqlc.NotificationPref, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetNotificationPrefs(ctx, userId)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.NotificationPref), err
+}
+
+// UpsertNotificationPref creates or replaces the user's settings for
+// pref.Channel; UpdatedAt on pref is ignored
+func (r NotificationRepo) UpsertNotificationPref(ctx context.Context, pref sqlc.NotificationPref) (sqlc.NotificationPref, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.UpsertNotificationPrefParams{
+			UserID:       pref.UserID,
+			Channel:      pref.Channel,
+			Enabled:      pref.Enabled,
+			Target:       pref.Target,
+			Alerts:       pref.Alerts,
+			DeviceEvents: pref.DeviceEvents,
+		}
+		return q.UpsertNotificationPref(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.NotificationPref{}, err
+	}
+	return res.(sqlc.NotificationPref), err
+}
+
+// CreateNotificationLog records a delivery attempt; the id and
+// CreatedAt on entry are ignored
+func (r NotificationRepo) CreateNotificationLog(ctx context.Context, entry sqlc.NotificationLog) (sqlc.NotificationLog, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CreateNotificationLogParams{
+			UserID:    entry.UserID,
+			Channel:   entry.Channel,
+			Kind:      entry.Kind,
+			DedupeKey: entry.DedupeKey,
+			Subject:   entry.Subject,
+			Status:    entry.Status,
+			Error:     entry.Error,
+		}
+		return q.CreateNotificationLog(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.NotificationLog{}, err
+	}
+	return res.(sqlc.NotificationLog), err
+}
+
+// CountSentNotifications counts notifications delivered to the user on
+// channel after since
+func (r NotificationRepo) CountSentNotifications(ctx context.Context, userId int32, channel string, since time.Time) (int64, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CountSentNotificationsParams{
+			UserID:    userId,
+			Channel:   channel,
+			CreatedAt: pgtype.Timestamptz{Time: since, Valid: true},
+		}
+		return q.CountSentNotifications(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return 0, err
+	}
+	return res.(int64), err
+}
+
+// CountSentNotificationsByKey counts notifications with dedupeKey
+// delivered to the user on channel after since
+func (r NotificationRepo) CountSentNotificationsByKey(ctx context.Context, userId int32, channel string, dedupeKey string, since time.Time) (int64, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.CountSentNotificationsByKeyParams{
+			UserID:    userId,
+			Channel:   channel,
+			DedupeKey: dedupeKey,
+			CreatedAt: pgtype.Timestamptz{Time: since, Valid: true},
+		}
+		return q.CountSentNotificationsByKey(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return 0, err
+	}
+	return res.(int64), err
+}
+
+func (r NotificationRepo) GetNotificationLog(ctx context.Context, userId int32, limit int32) ([]sqlc.NotificationLog, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.GetNotificationLogParams{
+			UserID: userId,
+			Limit:  limit,
+		}
+		return q.GetNotificationLog(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]sqlc.NotificationLog), err
+}
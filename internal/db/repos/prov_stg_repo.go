@@ -2,6 +2,7 @@ package repos
 
 import (
 	"context"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -11,17 +12,23 @@ type ProvisionStagingRepo struct {
 	sr SqlRunner
 }
 
-func (r ProvisionStagingRepo) CreateProvisionStaging(ctx context.Context, deviceId int32, contract string) error {
-	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+func (r ProvisionStagingRepo) CreateProvisionStaging(ctx context.Context, deviceId int32, contract string, expiresAt time.Time) (sqlc.ProvisionStaging, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
 		params := sqlc.CreateProvisionStagingParams{
 			DeviceID: deviceId,
 			Contract: pgtype.Text{
 				String: contract,
 				Valid:  true,
 			},
+			ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
 		}
 		return q.CreateProvisionStaging(ctx, params)
 	})
+
+	if err != nil || res == nil {
+		return sqlc.ProvisionStaging{}, err
+	}
+	return res.(sqlc.ProvisionStaging), err
 }
 
 func (r ProvisionStagingRepo) GetProvisionStagingByContract(ctx context.Context, contract string) (sqlc.ProvisionStaging, error) {
@@ -40,6 +47,37 @@ func (r ProvisionStagingRepo) GetProvisionStagingByContract(ctx context.Context,
 	return res.(sqlc.ProvisionStaging), err
 }
 
+func (r ProvisionStagingRepo) GetProvisionStagingByDevice(ctx context.Context, deviceId int32) (sqlc.ProvisionStaging, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.GetProvisionStagingByDevice(ctx, deviceId)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.ProvisionStaging{}, err
+	}
+	return res.(sqlc.ProvisionStaging), err
+}
+
+// ConsumeProvisionStaging deletes and returns the staging record for an
+// unexpired contract, so each contract can only be used once
+func (r ProvisionStagingRepo) ConsumeProvisionStaging(ctx context.Context, contract string, now time.Time) (sqlc.ProvisionStaging, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		params := sqlc.ConsumeProvisionStagingParams{
+			Contract: pgtype.Text{
+				String: contract,
+				Valid:  true,
+			},
+			ExpiresAt: pgtype.Timestamptz{Time: now, Valid: true},
+		}
+		return q.ConsumeProvisionStaging(ctx, params)
+	})
+
+	if err != nil || res == nil {
+		return sqlc.ProvisionStaging{}, err
+	}
+	return res.(sqlc.ProvisionStaging), err
+}
+
 func (r ProvisionStagingRepo) DeleteProvisionStaging(ctx context.Context, deviceId int32) error {
 	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
 		return q.DeleteProvisionStaging(ctx, deviceId)
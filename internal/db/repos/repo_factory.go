@@ -9,6 +9,7 @@ import (
 	"context"
 
 	"github.com/frozenkro/dirtie-srv/internal/db"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type RepoFactory struct {
@@ -26,6 +27,19 @@ func NewRepoFactory(ctx context.Context) (RepoFactory, error) {
 	return RepoFactory{tm: tm}, nil
 }
 
+// Close releases the shared connection pool, call once on shutdown
+func (f RepoFactory) Close() {
+	f.tm.Close()
+}
+
+func (f RepoFactory) Stat() *pgxpool.Stat {
+	return f.tm.pool.Stat()
+}
+
+func (f RepoFactory) Ping(ctx context.Context) error {
+	return f.tm.Ping(ctx)
+}
+
 func (f RepoFactory) NewUserRepo() UserRepo {
 	return UserRepo{sr: f.tm}
 }
@@ -45,3 +59,27 @@ func (f RepoFactory) NewProvisionStagingRepo() ProvisionStagingRepo {
 func (f RepoFactory) NewPwResetRepo() PwResetRepo {
 	return PwResetRepo{sr: f.tm}
 }
+
+func (f RepoFactory) NewDeviceCommandRepo() DeviceCommandRepo {
+	return DeviceCommandRepo{sr: f.tm}
+}
+
+func (f RepoFactory) NewDeadLetterRepo() DeadLetterRepo {
+	return DeadLetterRepo{sr: f.tm}
+}
+
+func (f RepoFactory) NewAlertRepo() AlertRepo {
+	return AlertRepo{sr: f.tm}
+}
+
+func (f RepoFactory) NewNotificationRepo() NotificationRepo {
+	return NotificationRepo{sr: f.tm}
+}
+
+func (f RepoFactory) NewCalibrationRepo() CalibrationRepo {
+	return CalibrationRepo{sr: f.tm}
+}
+
+func (f RepoFactory) NewHouseholdRepo() HouseholdRepo {
+	return HouseholdRepo{sr: f.tm}
+}
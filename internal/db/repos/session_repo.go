@@ -46,3 +46,13 @@ func (r SessionRepo) DeleteUserSessions(ctx context.Context, userId int32) error
 		return q.DeleteUserSessions(ctx, userId)
 	})
 }
+
+func (r SessionRepo) CountActiveSessions(ctx context.Context) (int64, error) {
+	res, err := r.sr.Query(ctx, func(q *sqlc.Queries) (interface{}, error) {
+		return q.CountActiveSessions(ctx)
+	})
+	if err != nil || res == nil {
+		return 0, err
+	}
+	return res.(int64), err
+}
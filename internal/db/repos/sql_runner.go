@@ -20,6 +20,14 @@ func NewTxManager(pool *pgxpool.Pool) *TxManager {
 	return &TxManager{pool: pool}
 }
 
+func (tm *TxManager) Close() {
+	tm.pool.Close()
+}
+
+func (tm *TxManager) Ping(ctx context.Context) error {
+	return tm.pool.Ping(ctx)
+}
+
 func (tm *TxManager) Query(ctx context.Context, fn func(*sqlc.Queries) (interface{}, error)) (interface{}, error) {
 	tx, err := tm.pool.Begin(ctx)
 	if err != nil {
@@ -63,3 +63,15 @@ func (r UserRepo) UpdateLastLoginTime(ctx context.Context, userId int32) error {
 		return q.UpdateLastLoginTime(ctx, userId)
 	})
 }
+
+func (r UserRepo) DisableUser(ctx context.Context, userId int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.DisableUser(ctx, userId)
+	})
+}
+
+func (r UserRepo) EnableUser(ctx context.Context, userId int32) error {
+	return r.sr.Execute(ctx, func(q *sqlc.Queries) error {
+		return q.EnableUser(ctx, userId)
+	})
+}
@@ -8,16 +8,140 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AlertEvent struct {
+	EventID    int64
+	RuleID     int32
+	DeviceID   int32
+	Kind       string
+	Value      float64
+	Snoozed    bool
+	OccurredAt pgtype.Timestamptz
+	CreatedAt  pgtype.Timestamptz
+}
+
+type AlertRule struct {
+	RuleID       int32
+	DeviceID     int32
+	Name         string
+	Measurement  string
+	MinValue     pgtype.Float8
+	MaxValue     pgtype.Float8
+	DurationSec  int32
+	Hysteresis   float64
+	Enabled      bool
+	State        string
+	PendingSince pgtype.Timestamptz
+	EvaluatedAt  pgtype.Timestamptz
+	SnoozedUntil pgtype.Timestamptz
+	CreatedAt    pgtype.Timestamptz
+}
+
+type CalibrationProfile struct {
+	DeviceID  int32
+	SoilType  string
+	DryValue  pgtype.Float8
+	WetValue  pgtype.Float8
+	Curve     []byte
+	UpdatedAt pgtype.Timestamptz
+}
+
+type DeadLetter struct {
+	DeadLetterID  int64
+	Topic         string
+	Payload       []byte
+	Error         string
+	Attempts      int32
+	CreatedAt     pgtype.Timestamptz
+	LastAttemptAt pgtype.Timestamptz
+}
+
 type Device struct {
+	DeviceID          int32
+	UserID            int32
+	MacAddr           pgtype.Text
+	DisplayName       pgtype.Text
+	ReportIntervalSec pgtype.Int4
+	LastSeen          pgtype.Timestamptz
+	Online            bool
+	LatestReadings    []byte
+	LatestReadingAt   pgtype.Timestamptz
+	HouseholdID       pgtype.Int4
+	Location          pgtype.Text
+	PlantSpecies      pgtype.Text
+	Notes             pgtype.Text
+}
+
+type DeviceCommand struct {
+	CommandID   string
 	DeviceID    int32
+	CommandType string
+	Args        []byte
+	Status      string
+	Error       pgtype.Text
+	ExpiresAt   pgtype.Timestamptz
+	CreatedAt   pgtype.Timestamptz
+	AckedAt     pgtype.Timestamptz
+}
+
+type DeviceConnectionEvent struct {
+	EventID    int64
+	DeviceID   int32
+	Event      string
+	OccurredAt pgtype.Timestamptz
+	CreatedAt  pgtype.Timestamptz
+}
+
+type Household struct {
+	HouseholdID int32
+	Name        string
+	CreatedAt   pgtype.Timestamptz
+}
+
+type HouseholdInvite struct {
+	InviteID    int64
+	HouseholdID int32
+	Email       string
+	Role        string
+	Token       string
+	InvitedBy   int32
+	ExpiresAt   pgtype.Timestamptz
+	CreatedAt   pgtype.Timestamptz
+}
+
+type HouseholdMember struct {
+	HouseholdID int32
 	UserID      int32
-	MacAddr     pgtype.Text
-	DisplayName pgtype.Text
+	Role        string
+	CreatedAt   pgtype.Timestamptz
+}
+
+type NotificationLog struct {
+	NotificationID int64
+	UserID         int32
+	Channel        string
+	Kind           string
+	DedupeKey      string
+	Subject        string
+	Status         string
+	Error          string
+	CreatedAt      pgtype.Timestamptz
+}
+
+type NotificationPref struct {
+	UserID       int32
+	Channel      string
+	Enabled      bool
+	Target       string
+	Alerts       bool
+	DeviceEvents bool
+	UpdatedAt    pgtype.Timestamptz
 }
 
 type ProvisionStaging struct {
-	DeviceID int32
-	Contract pgtype.Text
+	DeviceID  int32
+	Contract  pgtype.Text
+	ExpiresAt pgtype.Timestamptz
+	CreatedAt pgtype.Timestamptz
 }
 
 type PwResetToken struct {
@@ -37,10 +161,11 @@ type Session struct {
 }
 
 type User struct {
-	UserID    int32
-	Email     string
-	Name      string
-	PwHash    []byte
-	CreatedAt pgtype.Timestamptz
-	LastLogin pgtype.Timestamptz
+	UserID     int32
+	Email      string
+	Name       string
+	PwHash     []byte
+	CreatedAt  pgtype.Timestamptz
+	LastLogin  pgtype.Timestamptz
+	DisabledAt pgtype.Timestamptz
 }
@@ -21,6 +21,16 @@ UPDATE users
 SET last_login = CURRENT_TIMESTAMP
 WHERE user_id = $1;
 
+-- name: DisableUser :exec
+UPDATE users
+SET disabled_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND disabled_at IS NULL;
+
+-- name: EnableUser :exec
+UPDATE users
+SET disabled_at = NULL
+WHERE user_id = $1;
+
 -- name: CreateSession :exec
 INSERT INTO sessions (user_id, token, expires_at)
 VALUES ($1, $2, $3);
@@ -37,6 +47,10 @@ WHERE token = $1;
 DELETE FROM sessions
 WHERE user_id = $1;
 
+-- name: CountActiveSessions :one
+SELECT count(*) FROM sessions
+WHERE expires_at > CURRENT_TIMESTAMP;
+
 -- name: CreatePwResetToken :one
 INSERT INTO pw_reset_tokens (user_id, token, expires_at)
 VALUES ($1, $2, $3)
@@ -59,32 +73,357 @@ INSERT INTO devices (user_id, display_name)
 VALUES ($1, $2)
 RETURNING *;
 
+-- name: GetDevice :one
+SELECT * FROM devices
+WHERE device_id = $1 LIMIT 1;
+
 -- name: GetDeviceByMacAddress :one
 SELECT * FROM devices
-WHERE mac_addr = $1 LIMIT 1;
+WHERE lower(mac_addr) = lower(@mac_addr::text) LIMIT 1;
 
 -- name: GetDevicesByUser :many
 SELECT * FROM devices
 WHERE user_id = $1;
 
+-- name: GetAllDevices :many
+SELECT * FROM devices
+ORDER BY device_id;
+
+-- name: GetAccessibleDevices :many
+SELECT * FROM devices
+WHERE user_id = $1
+  OR household_id IN (SELECT household_id FROM household_members WHERE user_id = $1)
+ORDER BY device_id;
+
+-- name: SetDeviceHousehold :exec
+UPDATE devices
+SET household_id = $2
+WHERE device_id = $1;
+
+-- name: UnshareUserDevices :exec
+UPDATE devices
+SET household_id = NULL
+WHERE user_id = $1 AND household_id = $2;
+
 -- name: RenameDevice :exec
 UPDATE devices
 SET display_name = $2
 WHERE device_id = $1;
 
+-- name: UpdateDeviceDetails :exec
+UPDATE devices
+SET display_name = $2, location = $3, plant_species = $4, notes = $5
+WHERE device_id = $1;
+
 -- name: UpdateDeviceMacAddress :exec
 UPDATE devices
 SET mac_addr = $2
 WHERE device_id = $1;
 
--- name: CreateProvisionStaging :exec
-INSERT INTO provision_staging (device_id, contract)
-VALUES ($1, $2);
+-- name: UnbindMacAddress :exec
+UPDATE devices
+SET mac_addr = NULL, online = FALSE
+WHERE lower(mac_addr) = lower(@mac_addr::text) AND device_id <> @device_id;
+
+-- name: ResetDeviceMacAddress :exec
+UPDATE devices
+SET mac_addr = NULL, online = FALSE
+WHERE device_id = $1;
+
+-- name: DeleteDevice :exec
+DELETE FROM devices
+WHERE device_id = $1;
+
+-- name: MarkDeviceOnline :execrows
+UPDATE devices
+SET online = TRUE, last_seen = $2
+WHERE device_id = $1 AND NOT online;
+
+-- name: UpdateDeviceLastSeen :exec
+UPDATE devices
+SET last_seen = $2
+WHERE device_id = $1 AND (last_seen IS NULL OR last_seen < $2);
+
+-- name: MarkDeviceOffline :execrows
+UPDATE devices
+SET online = FALSE
+WHERE device_id = $1 AND online AND last_seen = $2;
+
+-- name: GetOverdueDevices :many
+SELECT * FROM devices
+WHERE online
+  AND GREATEST(last_seen, @up_since::timestamptz)
+    + COALESCE(report_interval_sec, @default_interval_sec::int) * @missed_intervals::int * INTERVAL '1 second'
+    < @now::timestamptz;
+
+-- name: UpdateDeviceLatestReading :exec
+UPDATE devices
+SET latest_readings = $2, latest_reading_at = $3
+WHERE device_id = $1 AND (latest_reading_at IS NULL OR latest_reading_at <= $3);
+
+-- name: UpdateDeviceReportInterval :exec
+UPDATE devices
+SET report_interval_sec = $2
+WHERE device_id = $1;
+
+-- name: CreateProvisionStaging :one
+INSERT INTO provision_staging (device_id, contract, expires_at)
+VALUES ($1, $2, $3)
+RETURNING *;
 
 -- name: GetProvisionStagingByContract :one
 SELECT * FROM provision_staging
 WHERE contract = $1 LIMIT 1;
 
+-- name: GetProvisionStagingByDevice :one
+SELECT * FROM provision_staging
+WHERE device_id = $1 LIMIT 1;
+
+-- name: ConsumeProvisionStaging :one
+DELETE FROM provision_staging
+WHERE contract = $1 AND expires_at > $2
+RETURNING *;
+
 -- name: DeleteProvisionStaging :exec
 DELETE FROM provision_staging 
 WHERE device_id = $1;
+
+-- name: CreateDeviceCommand :one
+INSERT INTO device_commands (command_id, device_id, command_type, args, status, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetDeviceCommand :one
+SELECT * FROM device_commands
+WHERE command_id = $1 LIMIT 1;
+
+-- name: GetDeviceCommands :many
+SELECT * FROM device_commands
+WHERE device_id = $1
+ORDER BY created_at DESC;
+
+-- name: UpdateDeviceCommandStatus :exec
+UPDATE device_commands
+SET status = $2, error = $3
+WHERE command_id = $1;
+
+-- name: AckDeviceCommand :exec
+UPDATE device_commands
+SET status = $2, error = $3, acked_at = CURRENT_TIMESTAMP
+WHERE command_id = $1;
+
+-- name: ExpireDeviceCommands :exec
+UPDATE device_commands
+SET status = 'expired'
+WHERE status IN ('pending', 'sent') AND expires_at < CURRENT_TIMESTAMP;
+
+-- name: CreateDeadLetter :one
+INSERT INTO dead_letters (topic, payload, error)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetDeadLetter :one
+SELECT * FROM dead_letters
+WHERE dead_letter_id = $1 LIMIT 1;
+
+-- name: GetDeadLetters :many
+SELECT * FROM dead_letters
+ORDER BY created_at DESC
+LIMIT $1 OFFSET $2;
+
+-- name: UpdateDeadLetterAttempt :exec
+UPDATE dead_letters
+SET attempts = attempts + 1, error = $2, last_attempt_at = CURRENT_TIMESTAMP
+WHERE dead_letter_id = $1;
+
+-- name: DeleteDeadLetter :exec
+DELETE FROM dead_letters
+WHERE dead_letter_id = $1;
+
+-- name: DeleteDeadLettersBefore :execrows
+DELETE FROM dead_letters
+WHERE created_at < $1;
+
+-- name: CreateAlertRule :one
+INSERT INTO alert_rules (device_id, name, measurement, min_value, max_value, duration_sec, hysteresis, enabled)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: GetAlertRule :one
+SELECT * FROM alert_rules
+WHERE rule_id = $1 LIMIT 1;
+
+-- name: GetAlertRulesByDevice :many
+SELECT * FROM alert_rules
+WHERE device_id = $1
+ORDER BY rule_id;
+
+-- name: GetEnabledAlertRules :many
+SELECT * FROM alert_rules
+WHERE device_id = $1 AND enabled = TRUE
+ORDER BY rule_id;
+
+-- name: UpdateAlertRule :one
+UPDATE alert_rules
+SET name = $2, measurement = $3, min_value = $4, max_value = $5, duration_sec = $6, hysteresis = $7, enabled = $8,
+  state = 'ok', pending_since = NULL
+WHERE rule_id = $1
+RETURNING *;
+
+-- name: UpdateAlertRuleState :execrows
+UPDATE alert_rules
+SET state = $2, pending_since = $3, evaluated_at = $4
+WHERE rule_id = $1 AND (evaluated_at IS NULL OR evaluated_at <= $4);
+
+-- name: SnoozeAlertRule :exec
+UPDATE alert_rules
+SET snoozed_until = $2
+WHERE rule_id = $1;
+
+-- name: DeleteAlertRule :exec
+DELETE FROM alert_rules
+WHERE rule_id = $1;
+
+-- name: CreateAlertEvent :one
+INSERT INTO alert_events (rule_id, device_id, kind, value, snoozed, occurred_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetAlertEventsByDevice :many
+SELECT * FROM alert_events
+WHERE device_id = $1
+ORDER BY occurred_at DESC
+LIMIT $2;
+
+-- name: CreateHousehold :one
+INSERT INTO households (name)
+VALUES ($1)
+RETURNING *;
+
+-- name: GetHousehold :one
+SELECT * FROM households
+WHERE household_id = $1;
+
+-- name: GetHouseholdsByUser :many
+SELECT h.household_id, h.name, h.created_at, m.role FROM households h
+JOIN household_members m ON m.household_id = h.household_id
+WHERE m.user_id = $1
+ORDER BY h.household_id;
+
+-- name: DeleteHousehold :exec
+DELETE FROM households
+WHERE household_id = $1;
+
+-- name: AddHouseholdMember :one
+INSERT INTO household_members (household_id, user_id, role)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetHouseholdMember :one
+SELECT * FROM household_members
+WHERE household_id = $1 AND user_id = $2;
+
+-- name: GetHouseholdMembers :many
+SELECT m.household_id, m.user_id, m.role, m.created_at, u.email, u.name FROM household_members m
+JOIN users u ON u.user_id = m.user_id
+WHERE m.household_id = $1
+ORDER BY m.created_at, m.user_id;
+
+-- name: CountHouseholdOwners :one
+SELECT COUNT(*) FROM household_members
+WHERE household_id = $1 AND role = 'owner';
+
+-- name: UpdateHouseholdMemberRole :exec
+UPDATE household_members
+SET role = $3
+WHERE household_id = $1 AND user_id = $2;
+
+-- name: DeleteHouseholdMember :exec
+DELETE FROM household_members
+WHERE household_id = $1 AND user_id = $2;
+
+-- name: CreateHouseholdInvite :one
+INSERT INTO household_invites (household_id, email, role, token, invited_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetHouseholdInviteByToken :one
+SELECT * FROM household_invites
+WHERE token = $1;
+
+-- name: GetHouseholdInvites :many
+SELECT * FROM household_invites
+WHERE household_id = $1 AND expires_at > $2
+ORDER BY created_at;
+
+-- name: ConsumeHouseholdInvite :one
+DELETE FROM household_invites
+WHERE token = $1 AND expires_at > $2
+RETURNING *;
+
+-- name: DeleteHouseholdInvite :exec
+DELETE FROM household_invites
+WHERE household_id = $1 AND invite_id = $2;
+
+-- name: GetCalibrationProfile :one
+SELECT * FROM calibration_profiles
+WHERE device_id = $1;
+
+-- name: UpsertCalibrationProfile :one
+INSERT INTO calibration_profiles (device_id, soil_type, dry_value, wet_value, curve)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (device_id) DO UPDATE
+SET soil_type = $2, dry_value = $3, wet_value = $4, curve = $5, updated_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: DeleteCalibrationProfile :exec
+DELETE FROM calibration_profiles
+WHERE device_id = $1;
+
+-- name: CreateDeviceConnectionEvent :one
+INSERT INTO device_connection_events (device_id, event, occurred_at)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetLatestDeviceConnectionEvent :one
+SELECT * FROM device_connection_events
+WHERE device_id = $1
+ORDER BY occurred_at DESC, event_id DESC
+LIMIT 1;
+
+-- name: GetDeviceConnectionEvents :many
+SELECT * FROM device_connection_events
+WHERE device_id = $1
+ORDER BY occurred_at DESC, event_id DESC
+LIMIT $2;
+
+-- name: GetNotificationPrefs :many
+SELECT * FROM notification_prefs
+WHERE user_id = $1
+ORDER BY channel;
+
+-- name: UpsertNotificationPref :one
+INSERT INTO notification_prefs (user_id, channel, enabled, target, alerts, device_events)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, channel) DO UPDATE
+SET enabled = $3, target = $4, alerts = $5, device_events = $6, updated_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: CreateNotificationLog :one
+INSERT INTO notification_log (user_id, channel, kind, dedupe_key, subject, status, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: CountSentNotifications :one
+SELECT COUNT(*) FROM notification_log
+WHERE user_id = $1 AND channel = $2 AND status = 'sent' AND created_at > $3;
+
+-- name: CountSentNotificationsByKey :one
+SELECT COUNT(*) FROM notification_log
+WHERE user_id = $1 AND channel = $2 AND dedupe_key = $3 AND status = 'sent' AND created_at > $4;
+
+-- name: GetNotificationLog :many
+SELECT * FROM notification_log
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
@@ -11,6 +11,47 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const ackDeviceCommand = `-- name: AckDeviceCommand :exec
+UPDATE device_commands
+SET status = $2, error = $3, acked_at = CURRENT_TIMESTAMP
+WHERE command_id = $1
+`
+
+type AckDeviceCommandParams struct {
+	CommandID string
+	Status    string
+	Error     pgtype.Text
+}
+
+func (q *Queries) AckDeviceCommand(ctx context.Context, arg AckDeviceCommandParams) error {
+	_, err := q.db.Exec(ctx, ackDeviceCommand, arg.CommandID, arg.Status, arg.Error)
+	return err
+}
+
+const addHouseholdMember = `-- name: AddHouseholdMember :one
+INSERT INTO household_members (household_id, user_id, role)
+VALUES ($1, $2, $3)
+RETURNING household_id, user_id, role, created_at
+`
+
+type AddHouseholdMemberParams struct {
+	HouseholdID int32
+	UserID      int32
+	Role        string
+}
+
+func (q *Queries) AddHouseholdMember(ctx context.Context, arg AddHouseholdMemberParams) (HouseholdMember, error) {
+	row := q.db.QueryRow(ctx, addHouseholdMember, arg.HouseholdID, arg.UserID, arg.Role)
+	var i HouseholdMember
+	err := row.Scan(
+		&i.HouseholdID,
+		&i.UserID,
+		&i.Role,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const changePassword = `-- name: ChangePassword :exec
 UPDATE users
 SET pw_hash = $2
@@ -27,10 +68,239 @@ func (q *Queries) ChangePassword(ctx context.Context, arg ChangePasswordParams)
 	return err
 }
 
+const consumeHouseholdInvite = `-- name: ConsumeHouseholdInvite :one
+DELETE FROM household_invites
+WHERE token = $1 AND expires_at > $2
+RETURNING invite_id, household_id, email, role, token, invited_by, expires_at, created_at
+`
+
+type ConsumeHouseholdInviteParams struct {
+	Token     string
+	ExpiresAt pgtype.Timestamptz
+}
+
+func (q *Queries) ConsumeHouseholdInvite(ctx context.Context, arg ConsumeHouseholdInviteParams) (HouseholdInvite, error) {
+	row := q.db.QueryRow(ctx, consumeHouseholdInvite, arg.Token, arg.ExpiresAt)
+	var i HouseholdInvite
+	err := row.Scan(
+		&i.InviteID,
+		&i.HouseholdID,
+		&i.Email,
+		&i.Role,
+		&i.Token,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const consumeProvisionStaging = `-- name: ConsumeProvisionStaging :one
+DELETE FROM provision_staging
+WHERE contract = $1 AND expires_at > $2
+RETURNING device_id, contract, expires_at, created_at
+`
+
+type ConsumeProvisionStagingParams struct {
+	Contract  pgtype.Text
+	ExpiresAt pgtype.Timestamptz
+}
+
+func (q *Queries) ConsumeProvisionStaging(ctx context.Context, arg ConsumeProvisionStagingParams) (ProvisionStaging, error) {
+	row := q.db.QueryRow(ctx, consumeProvisionStaging, arg.Contract, arg.ExpiresAt)
+	var i ProvisionStaging
+	err := row.Scan(
+		&i.DeviceID,
+		&i.Contract,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const countActiveSessions = `-- name: CountActiveSessions :one
+SELECT count(*) FROM sessions
+WHERE expires_at > CURRENT_TIMESTAMP
+`
+
+func (q *Queries) CountActiveSessions(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countActiveSessions)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countHouseholdOwners = `-- name: CountHouseholdOwners :one
+SELECT COUNT(*) FROM household_members
+WHERE household_id = $1 AND role = 'owner'
+`
+
+func (q *Queries) CountHouseholdOwners(ctx context.Context, householdID int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countHouseholdOwners, householdID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countSentNotifications = `-- name: CountSentNotifications :one
+SELECT COUNT(*) FROM notification_log
+WHERE user_id = $1 AND channel = $2 AND status = 'sent' AND created_at > $3
+`
+
+type CountSentNotificationsParams struct {
+	UserID    int32
+	Channel   string
+	CreatedAt pgtype.Timestamptz
+}
+
+func (q *Queries) CountSentNotifications(ctx context.Context, arg CountSentNotificationsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countSentNotifications, arg.UserID, arg.Channel, arg.CreatedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countSentNotificationsByKey = `-- name: CountSentNotificationsByKey :one
+SELECT COUNT(*) FROM notification_log
+WHERE user_id = $1 AND channel = $2 AND dedupe_key = $3 AND status = 'sent' AND created_at > $4
+`
+
+type CountSentNotificationsByKeyParams struct {
+	UserID    int32
+	Channel   string
+	DedupeKey string
+	CreatedAt pgtype.Timestamptz
+}
+
+func (q *Queries) CountSentNotificationsByKey(ctx context.Context, arg CountSentNotificationsByKeyParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countSentNotificationsByKey,
+		arg.UserID,
+		arg.Channel,
+		arg.DedupeKey,
+		arg.CreatedAt,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAlertEvent = `-- name: CreateAlertEvent :one
+INSERT INTO alert_events (rule_id, device_id, kind, value, snoozed, occurred_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING event_id, rule_id, device_id, kind, value, snoozed, occurred_at, created_at
+`
+
+type CreateAlertEventParams struct {
+	RuleID     int32
+	DeviceID   int32
+	Kind       string
+	Value      float64
+	Snoozed    bool
+	OccurredAt pgtype.Timestamptz
+}
+
+func (q *Queries) CreateAlertEvent(ctx context.Context, arg CreateAlertEventParams) (AlertEvent, error) {
+	row := q.db.QueryRow(ctx, createAlertEvent,
+		arg.RuleID,
+		arg.DeviceID,
+		arg.Kind,
+		arg.Value,
+		arg.Snoozed,
+		arg.OccurredAt,
+	)
+	var i AlertEvent
+	err := row.Scan(
+		&i.EventID,
+		&i.RuleID,
+		&i.DeviceID,
+		&i.Kind,
+		&i.Value,
+		&i.Snoozed,
+		&i.OccurredAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createAlertRule = `-- name: CreateAlertRule :one
+INSERT INTO alert_rules (device_id, name, measurement, min_value, max_value, duration_sec, hysteresis, enabled)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING rule_id, device_id, name, measurement, min_value, max_value, duration_sec, hysteresis, enabled, state, pending_since, evaluated_at, snoozed_until, created_at
+`
+
+type CreateAlertRuleParams struct {
+	DeviceID    int32
+	Name        string
+	Measurement string
+	MinValue    pgtype.Float8
+	MaxValue    pgtype.Float8
+	DurationSec int32
+	Hysteresis  float64
+	Enabled     bool
+}
+
+func (q *Queries) CreateAlertRule(ctx context.Context, arg CreateAlertRuleParams) (AlertRule, error) {
+	row := q.db.QueryRow(ctx, createAlertRule,
+		arg.DeviceID,
+		arg.Name,
+		arg.Measurement,
+		arg.MinValue,
+		arg.MaxValue,
+		arg.DurationSec,
+		arg.Hysteresis,
+		arg.Enabled,
+	)
+	var i AlertRule
+	err := row.Scan(
+		&i.RuleID,
+		&i.DeviceID,
+		&i.Name,
+		&i.Measurement,
+		&i.MinValue,
+		&i.MaxValue,
+		&i.DurationSec,
+		&i.Hysteresis,
+		&i.Enabled,
+		&i.State,
+		&i.PendingSince,
+		&i.EvaluatedAt,
+		&i.SnoozedUntil,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createDeadLetter = `-- name: CreateDeadLetter :one
+INSERT INTO dead_letters (topic, payload, error)
+VALUES ($1, $2, $3)
+RETURNING dead_letter_id, topic, payload, error, attempts, created_at, last_attempt_at
+`
+
+type CreateDeadLetterParams struct {
+	Topic   string
+	Payload []byte
+	Error   string
+}
+
+func (q *Queries) CreateDeadLetter(ctx context.Context, arg CreateDeadLetterParams) (DeadLetter, error) {
+	row := q.db.QueryRow(ctx, createDeadLetter, arg.Topic, arg.Payload, arg.Error)
+	var i DeadLetter
+	err := row.Scan(
+		&i.DeadLetterID,
+		&i.Topic,
+		&i.Payload,
+		&i.Error,
+		&i.Attempts,
+		&i.CreatedAt,
+		&i.LastAttemptAt,
+	)
+	return i, err
+}
+
 const createDevice = `-- name: CreateDevice :one
 INSERT INTO devices (user_id, display_name)
 VALUES ($1, $2)
-RETURNING device_id, user_id, mac_addr, display_name
+RETURNING device_id, user_id, mac_addr, display_name, report_interval_sec, last_seen, online, latest_readings, latest_reading_at, household_id, location, plant_species, notes
 `
 
 type CreateDeviceParams struct {
@@ -46,23 +316,197 @@ func (q *Queries) CreateDevice(ctx context.Context, arg CreateDeviceParams) (Dev
 		&i.UserID,
 		&i.MacAddr,
 		&i.DisplayName,
+		&i.ReportIntervalSec,
+		&i.LastSeen,
+		&i.Online,
+		&i.LatestReadings,
+		&i.LatestReadingAt,
+		&i.HouseholdID,
+		&i.Location,
+		&i.PlantSpecies,
+		&i.Notes,
 	)
 	return i, err
 }
 
-const createProvisionStaging = `-- name: CreateProvisionStaging :exec
-INSERT INTO provision_staging (device_id, contract)
-VALUES ($1, $2)
+const createDeviceCommand = `-- name: CreateDeviceCommand :one
+INSERT INTO device_commands (command_id, device_id, command_type, args, status, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING command_id, device_id, command_type, args, status, error, expires_at, created_at, acked_at
+`
+
+type CreateDeviceCommandParams struct {
+	CommandID   string
+	DeviceID    int32
+	CommandType string
+	Args        []byte
+	Status      string
+	ExpiresAt   pgtype.Timestamptz
+}
+
+func (q *Queries) CreateDeviceCommand(ctx context.Context, arg CreateDeviceCommandParams) (DeviceCommand, error) {
+	row := q.db.QueryRow(ctx, createDeviceCommand,
+		arg.CommandID,
+		arg.DeviceID,
+		arg.CommandType,
+		arg.Args,
+		arg.Status,
+		arg.ExpiresAt,
+	)
+	var i DeviceCommand
+	err := row.Scan(
+		&i.CommandID,
+		&i.DeviceID,
+		&i.CommandType,
+		&i.Args,
+		&i.Status,
+		&i.Error,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.AckedAt,
+	)
+	return i, err
+}
+
+const createDeviceConnectionEvent = `-- name: CreateDeviceConnectionEvent :one
+INSERT INTO device_connection_events (device_id, event, occurred_at)
+VALUES ($1, $2, $3)
+RETURNING event_id, device_id, event, occurred_at, created_at
+`
+
+type CreateDeviceConnectionEventParams struct {
+	DeviceID   int32
+	Event      string
+	OccurredAt pgtype.Timestamptz
+}
+
+func (q *Queries) CreateDeviceConnectionEvent(ctx context.Context, arg CreateDeviceConnectionEventParams) (DeviceConnectionEvent, error) {
+	row := q.db.QueryRow(ctx, createDeviceConnectionEvent, arg.DeviceID, arg.Event, arg.OccurredAt)
+	var i DeviceConnectionEvent
+	err := row.Scan(
+		&i.EventID,
+		&i.DeviceID,
+		&i.Event,
+		&i.OccurredAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createHousehold = `-- name: CreateHousehold :one
+INSERT INTO households (name)
+VALUES ($1)
+RETURNING household_id, name, created_at
+`
+
+func (q *Queries) CreateHousehold(ctx context.Context, name string) (Household, error) {
+	row := q.db.QueryRow(ctx, createHousehold, name)
+	var i Household
+	err := row.Scan(&i.HouseholdID, &i.Name, &i.CreatedAt)
+	return i, err
+}
+
+const createHouseholdInvite = `-- name: CreateHouseholdInvite :one
+INSERT INTO household_invites (household_id, email, role, token, invited_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING invite_id, household_id, email, role, token, invited_by, expires_at, created_at
+`
+
+type CreateHouseholdInviteParams struct {
+	HouseholdID int32
+	Email       string
+	Role        string
+	Token       string
+	InvitedBy   int32
+	ExpiresAt   pgtype.Timestamptz
+}
+
+func (q *Queries) CreateHouseholdInvite(ctx context.Context, arg CreateHouseholdInviteParams) (HouseholdInvite, error) {
+	row := q.db.QueryRow(ctx, createHouseholdInvite,
+		arg.HouseholdID,
+		arg.Email,
+		arg.Role,
+		arg.Token,
+		arg.InvitedBy,
+		arg.ExpiresAt,
+	)
+	var i HouseholdInvite
+	err := row.Scan(
+		&i.InviteID,
+		&i.HouseholdID,
+		&i.Email,
+		&i.Role,
+		&i.Token,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createNotificationLog = `-- name: CreateNotificationLog :one
+INSERT INTO notification_log (user_id, channel, kind, dedupe_key, subject, status, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING notification_id, user_id, channel, kind, dedupe_key, subject, status, error, created_at
+`
+
+type CreateNotificationLogParams struct {
+	UserID    int32
+	Channel   string
+	Kind      string
+	DedupeKey string
+	Subject   string
+	Status    string
+	Error     string
+}
+
+func (q *Queries) CreateNotificationLog(ctx context.Context, arg CreateNotificationLogParams) (NotificationLog, error) {
+	row := q.db.QueryRow(ctx, createNotificationLog,
+		arg.UserID,
+		arg.Channel,
+		arg.Kind,
+		arg.DedupeKey,
+		arg.Subject,
+		arg.Status,
+		arg.Error,
+	)
+	var i NotificationLog
+	err := row.Scan(
+		&i.NotificationID,
+		&i.UserID,
+		&i.Channel,
+		&i.Kind,
+		&i.DedupeKey,
+		&i.Subject,
+		&i.Status,
+		&i.Error,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createProvisionStaging = `-- name: CreateProvisionStaging :one
+INSERT INTO provision_staging (device_id, contract, expires_at)
+VALUES ($1, $2, $3)
+RETURNING device_id, contract, expires_at, created_at
 `
 
 type CreateProvisionStagingParams struct {
-	DeviceID int32
-	Contract pgtype.Text
+	DeviceID  int32
+	Contract  pgtype.Text
+	ExpiresAt pgtype.Timestamptz
 }
 
-func (q *Queries) CreateProvisionStaging(ctx context.Context, arg CreateProvisionStagingParams) error {
-	_, err := q.db.Exec(ctx, createProvisionStaging, arg.DeviceID, arg.Contract)
-	return err
+func (q *Queries) CreateProvisionStaging(ctx context.Context, arg CreateProvisionStagingParams) (ProvisionStaging, error) {
+	row := q.db.QueryRow(ctx, createProvisionStaging, arg.DeviceID, arg.Contract, arg.ExpiresAt)
+	var i ProvisionStaging
+	err := row.Scan(
+		&i.DeviceID,
+		&i.Contract,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
 }
 
 const createPwResetToken = `-- name: CreatePwResetToken :one
@@ -109,7 +553,7 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) er
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (email, pw_hash, name)
 VALUES ($1, $2, $3)
-RETURNING user_id, email, name, pw_hash, created_at, last_login
+RETURNING user_id, email, name, pw_hash, created_at, last_login, disabled_at
 `
 
 type CreateUserParams struct {
@@ -128,10 +572,104 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.PwHash,
 		&i.CreatedAt,
 		&i.LastLogin,
+		&i.DisabledAt,
 	)
 	return i, err
 }
 
+const deleteAlertRule = `-- name: DeleteAlertRule :exec
+DELETE FROM alert_rules
+WHERE rule_id = $1
+`
+
+func (q *Queries) DeleteAlertRule(ctx context.Context, ruleID int32) error {
+	_, err := q.db.Exec(ctx, deleteAlertRule, ruleID)
+	return err
+}
+
+const deleteCalibrationProfile = `-- name: DeleteCalibrationProfile :exec
+DELETE FROM calibration_profiles
+WHERE device_id = $1
+`
+
+func (q *Queries) DeleteCalibrationProfile(ctx context.Context, deviceID int32) error {
+	_, err := q.db.Exec(ctx, deleteCalibrationProfile, deviceID)
+	return err
+}
+
+const deleteDeadLetter = `-- name: DeleteDeadLetter :exec
+DELETE FROM dead_letters
+WHERE dead_letter_id = $1
+`
+
+func (q *Queries) DeleteDeadLetter(ctx context.Context, deadLetterID int64) error {
+	_, err := q.db.Exec(ctx, deleteDeadLetter, deadLetterID)
+	return err
+}
+
+const deleteDeadLettersBefore = `-- name: DeleteDeadLettersBefore :execrows
+DELETE FROM dead_letters
+WHERE created_at < $1
+`
+
+func (q *Queries) DeleteDeadLettersBefore(ctx context.Context, createdAt pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteDeadLettersBefore, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteDevice = `-- name: DeleteDevice :exec
+DELETE FROM devices
+WHERE device_id = $1
+`
+
+func (q *Queries) DeleteDevice(ctx context.Context, deviceID int32) error {
+	_, err := q.db.Exec(ctx, deleteDevice, deviceID)
+	return err
+}
+
+const deleteHousehold = `-- name: DeleteHousehold :exec
+DELETE FROM households
+WHERE household_id = $1
+`
+
+func (q *Queries) DeleteHousehold(ctx context.Context, householdID int32) error {
+	_, err := q.db.Exec(ctx, deleteHousehold, householdID)
+	return err
+}
+
+const deleteHouseholdInvite = `-- name: DeleteHouseholdInvite :exec
+DELETE FROM household_invites
+WHERE household_id = $1 AND invite_id = $2
+`
+
+type DeleteHouseholdInviteParams struct {
+	HouseholdID int32
+	InviteID    int64
+}
+
+func (q *Queries) DeleteHouseholdInvite(ctx context.Context, arg DeleteHouseholdInviteParams) error {
+	_, err := q.db.Exec(ctx, deleteHouseholdInvite, arg.HouseholdID, arg.InviteID)
+	return err
+}
+
+const deleteHouseholdMember = `-- name: DeleteHouseholdMember :exec
+DELETE FROM household_members
+WHERE household_id = $1 AND user_id = $2
+`
+
+type DeleteHouseholdMemberParams struct {
+	HouseholdID int32
+	UserID      int32
+}
+
+func (q *Queries) DeleteHouseholdMember(ctx context.Context, arg DeleteHouseholdMemberParams) error {
+	_, err := q.db.Exec(ctx, deleteHouseholdMember, arg.HouseholdID, arg.UserID)
+	return err
+}
+
 const deleteProvisionStaging = `-- name: DeleteProvisionStaging :exec
 DELETE FROM provision_staging 
 WHERE device_id = $1
@@ -182,30 +720,48 @@ func (q *Queries) DeleteUserSessions(ctx context.Context, userID int32) error {
 	return err
 }
 
-const getDeviceByMacAddress = `-- name: GetDeviceByMacAddress :one
-SELECT device_id, user_id, mac_addr, display_name FROM devices
-WHERE mac_addr = $1 LIMIT 1
+const disableUser = `-- name: DisableUser :exec
+UPDATE users
+SET disabled_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND disabled_at IS NULL
 `
 
-func (q *Queries) GetDeviceByMacAddress(ctx context.Context, macAddr pgtype.Text) (Device, error) {
-	row := q.db.QueryRow(ctx, getDeviceByMacAddress, macAddr)
-	var i Device
-	err := row.Scan(
-		&i.DeviceID,
-		&i.UserID,
-		&i.MacAddr,
-		&i.DisplayName,
-	)
-	return i, err
+func (q *Queries) DisableUser(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, disableUser, userID)
+	return err
 }
 
-const getDevicesByUser = `-- name: GetDevicesByUser :many
-SELECT device_id, user_id, mac_addr, display_name FROM devices
+const enableUser = `-- name: EnableUser :exec
+UPDATE users
+SET disabled_at = NULL
 WHERE user_id = $1
 `
 
-func (q *Queries) GetDevicesByUser(ctx context.Context, userID int32) ([]Device, error) {
-	rows, err := q.db.Query(ctx, getDevicesByUser, userID)
+func (q *Queries) EnableUser(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, enableUser, userID)
+	return err
+}
+
+const expireDeviceCommands = `-- name: ExpireDeviceCommands :exec
+UPDATE device_commands
+SET status = 'expired'
+WHERE status IN ('pending', 'sent') AND expires_at < CURRENT_TIMESTAMP
+`
+
+func (q *Queries) ExpireDeviceCommands(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, expireDeviceCommands)
+	return err
+}
+
+const getAccessibleDevices = `-- name: GetAccessibleDevices :many
+SELECT device_id, user_id, mac_addr, display_name, report_interval_sec, last_seen, online, latest_readings, latest_reading_at, household_id, location, plant_species, notes FROM devices
+WHERE user_id = $1
+  OR household_id IN (SELECT household_id FROM household_members WHERE user_id = $1)
+ORDER BY device_id
+`
+
+func (q *Queries) GetAccessibleDevices(ctx context.Context, userID int32) ([]Device, error) {
+	rows, err := q.db.Query(ctx, getAccessibleDevices, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -218,6 +774,15 @@ func (q *Queries) GetDevicesByUser(ctx context.Context, userID int32) ([]Device,
 			&i.UserID,
 			&i.MacAddr,
 			&i.DisplayName,
+			&i.ReportIntervalSec,
+			&i.LastSeen,
+			&i.Online,
+			&i.LatestReadings,
+			&i.LatestReadingAt,
+			&i.HouseholdID,
+			&i.Location,
+			&i.PlantSpecies,
+			&i.Notes,
 		); err != nil {
 			return nil, err
 		}
@@ -229,15 +794,818 @@ func (q *Queries) GetDevicesByUser(ctx context.Context, userID int32) ([]Device,
 	return items, nil
 }
 
-const getProvisionStagingByContract = `-- name: GetProvisionStagingByContract :one
-SELECT device_id, contract FROM provision_staging
-WHERE contract = $1 LIMIT 1
+const getAlertEventsByDevice = `-- name: GetAlertEventsByDevice :many
+SELECT event_id, rule_id, device_id, kind, value, snoozed, occurred_at, created_at FROM alert_events
+WHERE device_id = $1
+ORDER BY occurred_at DESC
+LIMIT $2
+`
+
+type GetAlertEventsByDeviceParams struct {
+	DeviceID int32
+	Limit    int32
+}
+
+func (q *Queries) GetAlertEventsByDevice(ctx context.Context, arg GetAlertEventsByDeviceParams) ([]AlertEvent, error) {
+	rows, err := q.db.Query(ctx, getAlertEventsByDevice, arg.DeviceID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AlertEvent
+	for rows.Next() {
+		var i AlertEvent
+		if err := rows.Scan(
+			&i.EventID,
+			&i.RuleID,
+			&i.DeviceID,
+			&i.Kind,
+			&i.Value,
+			&i.Snoozed,
+			&i.OccurredAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAlertRule = `-- name: GetAlertRule :one
+SELECT rule_id, device_id, name, measurement, min_value, max_value, duration_sec, hysteresis, enabled, state, pending_since, evaluated_at, snoozed_until, created_at FROM alert_rules
+WHERE rule_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetAlertRule(ctx context.Context, ruleID int32) (AlertRule, error) {
+	row := q.db.QueryRow(ctx, getAlertRule, ruleID)
+	var i AlertRule
+	err := row.Scan(
+		&i.RuleID,
+		&i.DeviceID,
+		&i.Name,
+		&i.Measurement,
+		&i.MinValue,
+		&i.MaxValue,
+		&i.DurationSec,
+		&i.Hysteresis,
+		&i.Enabled,
+		&i.State,
+		&i.PendingSince,
+		&i.EvaluatedAt,
+		&i.SnoozedUntil,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getAlertRulesByDevice = `-- name: GetAlertRulesByDevice :many
+SELECT rule_id, device_id, name, measurement, min_value, max_value, duration_sec, hysteresis, enabled, state, pending_since, evaluated_at, snoozed_until, created_at FROM alert_rules
+WHERE device_id = $1
+ORDER BY rule_id
+`
+
+func (q *Queries) GetAlertRulesByDevice(ctx context.Context, deviceID int32) ([]AlertRule, error) {
+	rows, err := q.db.Query(ctx, getAlertRulesByDevice, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AlertRule
+	for rows.Next() {
+		var i AlertRule
+		if err := rows.Scan(
+			&i.RuleID,
+			&i.DeviceID,
+			&i.Name,
+			&i.Measurement,
+			&i.MinValue,
+			&i.MaxValue,
+			&i.DurationSec,
+			&i.Hysteresis,
+			&i.Enabled,
+			&i.State,
+			&i.PendingSince,
+			&i.EvaluatedAt,
+			&i.SnoozedUntil,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAllDevices = `-- name: GetAllDevices :many
+SELECT device_id, user_id, mac_addr, display_name, report_interval_sec, last_seen, online, latest_readings, latest_reading_at, household_id, location, plant_species, notes FROM devices
+ORDER BY device_id
+`
+
+func (q *Queries) GetAllDevices(ctx context.Context) ([]Device, error) {
+	rows, err := q.db.Query(ctx, getAllDevices)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Device
+	for rows.Next() {
+		var i Device
+		if err := rows.Scan(
+			&i.DeviceID,
+			&i.UserID,
+			&i.MacAddr,
+			&i.DisplayName,
+			&i.ReportIntervalSec,
+			&i.LastSeen,
+			&i.Online,
+			&i.LatestReadings,
+			&i.LatestReadingAt,
+			&i.HouseholdID,
+			&i.Location,
+			&i.PlantSpecies,
+			&i.Notes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCalibrationProfile = `-- name: GetCalibrationProfile :one
+SELECT device_id, soil_type, dry_value, wet_value, curve, updated_at FROM calibration_profiles
+WHERE device_id = $1
+`
+
+func (q *Queries) GetCalibrationProfile(ctx context.Context, deviceID int32) (CalibrationProfile, error) {
+	row := q.db.QueryRow(ctx, getCalibrationProfile, deviceID)
+	var i CalibrationProfile
+	err := row.Scan(
+		&i.DeviceID,
+		&i.SoilType,
+		&i.DryValue,
+		&i.WetValue,
+		&i.Curve,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getDeadLetter = `-- name: GetDeadLetter :one
+SELECT dead_letter_id, topic, payload, error, attempts, created_at, last_attempt_at FROM dead_letters
+WHERE dead_letter_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetDeadLetter(ctx context.Context, deadLetterID int64) (DeadLetter, error) {
+	row := q.db.QueryRow(ctx, getDeadLetter, deadLetterID)
+	var i DeadLetter
+	err := row.Scan(
+		&i.DeadLetterID,
+		&i.Topic,
+		&i.Payload,
+		&i.Error,
+		&i.Attempts,
+		&i.CreatedAt,
+		&i.LastAttemptAt,
+	)
+	return i, err
+}
+
+const getDeadLetters = `-- name: GetDeadLetters :many
+SELECT dead_letter_id, topic, payload, error, attempts, created_at, last_attempt_at FROM dead_letters
+ORDER BY created_at DESC
+LIMIT $1 OFFSET $2
+`
+
+type GetDeadLettersParams struct {
+	Limit  int32
+	Offset int32
+}
+
+func (q *Queries) GetDeadLetters(ctx context.Context, arg GetDeadLettersParams) ([]DeadLetter, error) {
+	rows, err := q.db.Query(ctx, getDeadLetters, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeadLetter
+	for rows.Next() {
+		var i DeadLetter
+		if err := rows.Scan(
+			&i.DeadLetterID,
+			&i.Topic,
+			&i.Payload,
+			&i.Error,
+			&i.Attempts,
+			&i.CreatedAt,
+			&i.LastAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDevice = `-- name: GetDevice :one
+SELECT device_id, user_id, mac_addr, display_name, report_interval_sec, last_seen, online, latest_readings, latest_reading_at, household_id, location, plant_species, notes FROM devices
+WHERE device_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetDevice(ctx context.Context, deviceID int32) (Device, error) {
+	row := q.db.QueryRow(ctx, getDevice, deviceID)
+	var i Device
+	err := row.Scan(
+		&i.DeviceID,
+		&i.UserID,
+		&i.MacAddr,
+		&i.DisplayName,
+		&i.ReportIntervalSec,
+		&i.LastSeen,
+		&i.Online,
+		&i.LatestReadings,
+		&i.LatestReadingAt,
+		&i.HouseholdID,
+		&i.Location,
+		&i.PlantSpecies,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const getDeviceByMacAddress = `-- name: GetDeviceByMacAddress :one
+SELECT device_id, user_id, mac_addr, display_name, report_interval_sec, last_seen, online, latest_readings, latest_reading_at, household_id, location, plant_species, notes FROM devices
+WHERE lower(mac_addr) = lower($1::text) LIMIT 1
+`
+
+func (q *Queries) GetDeviceByMacAddress(ctx context.Context, macAddr string) (Device, error) {
+	row := q.db.QueryRow(ctx, getDeviceByMacAddress, macAddr)
+	var i Device
+	err := row.Scan(
+		&i.DeviceID,
+		&i.UserID,
+		&i.MacAddr,
+		&i.DisplayName,
+		&i.ReportIntervalSec,
+		&i.LastSeen,
+		&i.Online,
+		&i.LatestReadings,
+		&i.LatestReadingAt,
+		&i.HouseholdID,
+		&i.Location,
+		&i.PlantSpecies,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const getDeviceCommand = `-- name: GetDeviceCommand :one
+SELECT command_id, device_id, command_type, args, status, error, expires_at, created_at, acked_at FROM device_commands
+WHERE command_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetDeviceCommand(ctx context.Context, commandID string) (DeviceCommand, error) {
+	row := q.db.QueryRow(ctx, getDeviceCommand, commandID)
+	var i DeviceCommand
+	err := row.Scan(
+		&i.CommandID,
+		&i.DeviceID,
+		&i.CommandType,
+		&i.Args,
+		&i.Status,
+		&i.Error,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.AckedAt,
+	)
+	return i, err
+}
+
+const getDeviceCommands = `-- name: GetDeviceCommands :many
+SELECT command_id, device_id, command_type, args, status, error, expires_at, created_at, acked_at FROM device_commands
+WHERE device_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) GetDeviceCommands(ctx context.Context, deviceID int32) ([]DeviceCommand, error) {
+	rows, err := q.db.Query(ctx, getDeviceCommands, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeviceCommand
+	for rows.Next() {
+		var i DeviceCommand
+		if err := rows.Scan(
+			&i.CommandID,
+			&i.DeviceID,
+			&i.CommandType,
+			&i.Args,
+			&i.Status,
+			&i.Error,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.AckedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeviceConnectionEvents = `-- name: GetDeviceConnectionEvents :many
+SELECT event_id, device_id, event, occurred_at, created_at FROM device_connection_events
+WHERE device_id = $1
+ORDER BY occurred_at DESC, event_id DESC
+LIMIT $2
+`
+
+type GetDeviceConnectionEventsParams struct {
+	DeviceID int32
+	Limit    int32
+}
+
+func (q *Queries) GetDeviceConnectionEvents(ctx context.Context, arg GetDeviceConnectionEventsParams) ([]DeviceConnectionEvent, error) {
+	rows, err := q.db.Query(ctx, getDeviceConnectionEvents, arg.DeviceID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeviceConnectionEvent
+	for rows.Next() {
+		var i DeviceConnectionEvent
+		if err := rows.Scan(
+			&i.EventID,
+			&i.DeviceID,
+			&i.Event,
+			&i.OccurredAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDevicesByUser = `-- name: GetDevicesByUser :many
+SELECT device_id, user_id, mac_addr, display_name, report_interval_sec, last_seen, online, latest_readings, latest_reading_at, household_id, location, plant_species, notes FROM devices
+WHERE user_id = $1
+`
+
+func (q *Queries) GetDevicesByUser(ctx context.Context, userID int32) ([]Device, error) {
+	rows, err := q.db.Query(ctx, getDevicesByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Device
+	for rows.Next() {
+		var i Device
+		if err := rows.Scan(
+			&i.DeviceID,
+			&i.UserID,
+			&i.MacAddr,
+			&i.DisplayName,
+			&i.ReportIntervalSec,
+			&i.LastSeen,
+			&i.Online,
+			&i.LatestReadings,
+			&i.LatestReadingAt,
+			&i.HouseholdID,
+			&i.Location,
+			&i.PlantSpecies,
+			&i.Notes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEnabledAlertRules = `-- name: GetEnabledAlertRules :many
+SELECT rule_id, device_id, name, measurement, min_value, max_value, duration_sec, hysteresis, enabled, state, pending_since, evaluated_at, snoozed_until, created_at FROM alert_rules
+WHERE device_id = $1 AND enabled = TRUE
+ORDER BY rule_id
+`
+
+func (q *Queries) GetEnabledAlertRules(ctx context.Context, deviceID int32) ([]AlertRule, error) {
+	rows, err := q.db.Query(ctx, getEnabledAlertRules, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AlertRule
+	for rows.Next() {
+		var i AlertRule
+		if err := rows.Scan(
+			&i.RuleID,
+			&i.DeviceID,
+			&i.Name,
+			&i.Measurement,
+			&i.MinValue,
+			&i.MaxValue,
+			&i.DurationSec,
+			&i.Hysteresis,
+			&i.Enabled,
+			&i.State,
+			&i.PendingSince,
+			&i.EvaluatedAt,
+			&i.SnoozedUntil,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHousehold = `-- name: GetHousehold :one
+SELECT household_id, name, created_at FROM households
+WHERE household_id = $1
+`
+
+func (q *Queries) GetHousehold(ctx context.Context, householdID int32) (Household, error) {
+	row := q.db.QueryRow(ctx, getHousehold, householdID)
+	var i Household
+	err := row.Scan(&i.HouseholdID, &i.Name, &i.CreatedAt)
+	return i, err
+}
+
+const getHouseholdInviteByToken = `-- name: GetHouseholdInviteByToken :one
+SELECT invite_id, household_id, email, role, token, invited_by, expires_at, created_at FROM household_invites
+WHERE token = $1
+`
+
+func (q *Queries) GetHouseholdInviteByToken(ctx context.Context, token string) (HouseholdInvite, error) {
+	row := q.db.QueryRow(ctx, getHouseholdInviteByToken, token)
+	var i HouseholdInvite
+	err := row.Scan(
+		&i.InviteID,
+		&i.HouseholdID,
+		&i.Email,
+		&i.Role,
+		&i.Token,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getHouseholdInvites = `-- name: GetHouseholdInvites :many
+SELECT invite_id, household_id, email, role, token, invited_by, expires_at, created_at FROM household_invites
+WHERE household_id = $1 AND expires_at > $2
+ORDER BY created_at
+`
+
+type GetHouseholdInvitesParams struct {
+	HouseholdID int32
+	ExpiresAt   pgtype.Timestamptz
+}
+
+func (q *Queries) GetHouseholdInvites(ctx context.Context, arg GetHouseholdInvitesParams) ([]HouseholdInvite, error) {
+	rows, err := q.db.Query(ctx, getHouseholdInvites, arg.HouseholdID, arg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []HouseholdInvite
+	for rows.Next() {
+		var i HouseholdInvite
+		if err := rows.Scan(
+			&i.InviteID,
+			&i.HouseholdID,
+			&i.Email,
+			&i.Role,
+			&i.Token,
+			&i.InvitedBy,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHouseholdMember = `-- name: GetHouseholdMember :one
+SELECT household_id, user_id, role, created_at FROM household_members
+WHERE household_id = $1 AND user_id = $2
+`
+
+type GetHouseholdMemberParams struct {
+	HouseholdID int32
+	UserID      int32
+}
+
+func (q *Queries) GetHouseholdMember(ctx context.Context, arg GetHouseholdMemberParams) (HouseholdMember, error) {
+	row := q.db.QueryRow(ctx, getHouseholdMember, arg.HouseholdID, arg.UserID)
+	var i HouseholdMember
+	err := row.Scan(
+		&i.HouseholdID,
+		&i.UserID,
+		&i.Role,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getHouseholdMembers = `-- name: GetHouseholdMembers :many
+SELECT m.household_id, m.user_id, m.role, m.created_at, u.email, u.name FROM household_members m
+JOIN users u ON u.user_id = m.user_id
+WHERE m.household_id = $1
+ORDER BY m.created_at, m.user_id
+`
+
+type GetHouseholdMembersRow struct {
+	HouseholdID int32
+	UserID      int32
+	Role        string
+	CreatedAt   pgtype.Timestamptz
+	Email       string
+	Name        string
+}
+
+func (q *Queries) GetHouseholdMembers(ctx context.Context, householdID int32) ([]GetHouseholdMembersRow, error) {
+	rows, err := q.db.Query(ctx, getHouseholdMembers, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetHouseholdMembersRow
+	for rows.Next() {
+		var i GetHouseholdMembersRow
+		if err := rows.Scan(
+			&i.HouseholdID,
+			&i.UserID,
+			&i.Role,
+			&i.CreatedAt,
+			&i.Email,
+			&i.Name,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHouseholdsByUser = `-- name: GetHouseholdsByUser :many
+SELECT h.household_id, h.name, h.created_at, m.role FROM households h
+JOIN household_members m ON m.household_id = h.household_id
+WHERE m.user_id = $1
+ORDER BY h.household_id
+`
+
+type GetHouseholdsByUserRow struct {
+	HouseholdID int32
+	Name        string
+	CreatedAt   pgtype.Timestamptz
+	Role        string
+}
+
+func (q *Queries) GetHouseholdsByUser(ctx context.Context, userID int32) ([]GetHouseholdsByUserRow, error) {
+	rows, err := q.db.Query(ctx, getHouseholdsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetHouseholdsByUserRow
+	for rows.Next() {
+		var i GetHouseholdsByUserRow
+		if err := rows.Scan(
+			&i.HouseholdID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestDeviceConnectionEvent = `-- name: GetLatestDeviceConnectionEvent :one
+SELECT event_id, device_id, event, occurred_at, created_at FROM device_connection_events
+WHERE device_id = $1
+ORDER BY occurred_at DESC, event_id DESC
+LIMIT 1
+`
+
+func (q *Queries) GetLatestDeviceConnectionEvent(ctx context.Context, deviceID int32) (DeviceConnectionEvent, error) {
+	row := q.db.QueryRow(ctx, getLatestDeviceConnectionEvent, deviceID)
+	var i DeviceConnectionEvent
+	err := row.Scan(
+		&i.EventID,
+		&i.DeviceID,
+		&i.Event,
+		&i.OccurredAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getNotificationLog = `-- name: GetNotificationLog :many
+SELECT notification_id, user_id, channel, kind, dedupe_key, subject, status, error, created_at FROM notification_log
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT $2
+`
+
+type GetNotificationLogParams struct {
+	UserID int32
+	Limit  int32
+}
+
+func (q *Queries) GetNotificationLog(ctx context.Context, arg GetNotificationLogParams) ([]NotificationLog, error) {
+	rows, err := q.db.Query(ctx, getNotificationLog, arg.UserID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NotificationLog
+	for rows.Next() {
+		var i NotificationLog
+		if err := rows.Scan(
+			&i.NotificationID,
+			&i.UserID,
+			&i.Channel,
+			&i.Kind,
+			&i.DedupeKey,
+			&i.Subject,
+			&i.Status,
+			&i.Error,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNotificationPrefs = `-- name: GetNotificationPrefs :many
+SELECT user_id, channel, enabled, target, alerts, device_events, updated_at FROM notification_prefs
+WHERE user_id = $1
+ORDER BY channel
+`
+
+func (q *Queries) GetNotificationPrefs(ctx context.Context, userID int32) ([]NotificationPref, error) {
+	rows, err := q.db.Query(ctx, getNotificationPrefs, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NotificationPref
+	for rows.Next() {
+		var i NotificationPref
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Channel,
+			&i.Enabled,
+			&i.Target,
+			&i.Alerts,
+			&i.DeviceEvents,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOverdueDevices = `-- name: GetOverdueDevices :many
+SELECT device_id, user_id, mac_addr, display_name, report_interval_sec, last_seen, online, latest_readings, latest_reading_at, household_id, location, plant_species, notes FROM devices
+WHERE online
+  AND GREATEST(last_seen, $1::timestamptz)
+    + COALESCE(report_interval_sec, $2::int) * $3::int * INTERVAL '1 second'
+    < $4::timestamptz
+`
+
+type GetOverdueDevicesParams struct {
+	UpSince            pgtype.Timestamptz
+	DefaultIntervalSec int32
+	MissedIntervals    int32
+	Now                pgtype.Timestamptz
+}
+
+func (q *Queries) GetOverdueDevices(ctx context.Context, arg GetOverdueDevicesParams) ([]Device, error) {
+	rows, err := q.db.Query(ctx, getOverdueDevices,
+		arg.UpSince,
+		arg.DefaultIntervalSec,
+		arg.MissedIntervals,
+		arg.Now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Device
+	for rows.Next() {
+		var i Device
+		if err := rows.Scan(
+			&i.DeviceID,
+			&i.UserID,
+			&i.MacAddr,
+			&i.DisplayName,
+			&i.ReportIntervalSec,
+			&i.LastSeen,
+			&i.Online,
+			&i.LatestReadings,
+			&i.LatestReadingAt,
+			&i.HouseholdID,
+			&i.Location,
+			&i.PlantSpecies,
+			&i.Notes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getProvisionStagingByContract = `-- name: GetProvisionStagingByContract :one
+SELECT device_id, contract, expires_at, created_at FROM provision_staging
+WHERE contract = $1 LIMIT 1
 `
 
 func (q *Queries) GetProvisionStagingByContract(ctx context.Context, contract pgtype.Text) (ProvisionStaging, error) {
 	row := q.db.QueryRow(ctx, getProvisionStagingByContract, contract)
 	var i ProvisionStaging
-	err := row.Scan(&i.DeviceID, &i.Contract)
+	err := row.Scan(
+		&i.DeviceID,
+		&i.Contract,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getProvisionStagingByDevice = `-- name: GetProvisionStagingByDevice :one
+SELECT device_id, contract, expires_at, created_at FROM provision_staging
+WHERE device_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetProvisionStagingByDevice(ctx context.Context, deviceID int32) (ProvisionStaging, error) {
+	row := q.db.QueryRow(ctx, getProvisionStagingByDevice, deviceID)
+	var i ProvisionStaging
+	err := row.Scan(
+		&i.DeviceID,
+		&i.Contract,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
 	return i, err
 }
 
@@ -278,7 +1646,7 @@ func (q *Queries) GetSession(ctx context.Context, token string) (Session, error)
 }
 
 const getUser = `-- name: GetUser :one
-SELECT user_id, email, name, pw_hash, created_at, last_login FROM users
+SELECT user_id, email, name, pw_hash, created_at, last_login, disabled_at FROM users
 WHERE user_id = $1 LIMIT 1
 `
 
@@ -292,12 +1660,13 @@ func (q *Queries) GetUser(ctx context.Context, userID int32) (User, error) {
 		&i.PwHash,
 		&i.CreatedAt,
 		&i.LastLogin,
+		&i.DisabledAt,
 	)
 	return i, err
 }
 
 const getUserFromEmail = `-- name: GetUserFromEmail :one
-SELECT user_id, email, name, pw_hash, created_at, last_login FROM users 
+SELECT user_id, email, name, pw_hash, created_at, last_login, disabled_at FROM users 
 WHERE email = $1 LIMIT 1
 `
 
@@ -311,10 +1680,49 @@ func (q *Queries) GetUserFromEmail(ctx context.Context, email string) (User, err
 		&i.PwHash,
 		&i.CreatedAt,
 		&i.LastLogin,
+		&i.DisabledAt,
 	)
 	return i, err
 }
 
+const markDeviceOffline = `-- name: MarkDeviceOffline :execrows
+UPDATE devices
+SET online = FALSE
+WHERE device_id = $1 AND online AND last_seen = $2
+`
+
+type MarkDeviceOfflineParams struct {
+	DeviceID int32
+	LastSeen pgtype.Timestamptz
+}
+
+func (q *Queries) MarkDeviceOffline(ctx context.Context, arg MarkDeviceOfflineParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markDeviceOffline, arg.DeviceID, arg.LastSeen)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markDeviceOnline = `-- name: MarkDeviceOnline :execrows
+UPDATE devices
+SET online = TRUE, last_seen = $2
+WHERE device_id = $1 AND NOT online
+`
+
+type MarkDeviceOnlineParams struct {
+	DeviceID int32
+	LastSeen pgtype.Timestamptz
+}
+
+func (q *Queries) MarkDeviceOnline(ctx context.Context, arg MarkDeviceOnlineParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markDeviceOnline, arg.DeviceID, arg.LastSeen)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const renameDevice = `-- name: RenameDevice :exec
 UPDATE devices
 SET display_name = $2
@@ -331,6 +1739,248 @@ func (q *Queries) RenameDevice(ctx context.Context, arg RenameDeviceParams) erro
 	return err
 }
 
+const resetDeviceMacAddress = `-- name: ResetDeviceMacAddress :exec
+UPDATE devices
+SET mac_addr = NULL, online = FALSE
+WHERE device_id = $1
+`
+
+func (q *Queries) ResetDeviceMacAddress(ctx context.Context, deviceID int32) error {
+	_, err := q.db.Exec(ctx, resetDeviceMacAddress, deviceID)
+	return err
+}
+
+const setDeviceHousehold = `-- name: SetDeviceHousehold :exec
+UPDATE devices
+SET household_id = $2
+WHERE device_id = $1
+`
+
+type SetDeviceHouseholdParams struct {
+	DeviceID    int32
+	HouseholdID pgtype.Int4
+}
+
+func (q *Queries) SetDeviceHousehold(ctx context.Context, arg SetDeviceHouseholdParams) error {
+	_, err := q.db.Exec(ctx, setDeviceHousehold, arg.DeviceID, arg.HouseholdID)
+	return err
+}
+
+const snoozeAlertRule = `-- name: SnoozeAlertRule :exec
+UPDATE alert_rules
+SET snoozed_until = $2
+WHERE rule_id = $1
+`
+
+type SnoozeAlertRuleParams struct {
+	RuleID       int32
+	SnoozedUntil pgtype.Timestamptz
+}
+
+func (q *Queries) SnoozeAlertRule(ctx context.Context, arg SnoozeAlertRuleParams) error {
+	_, err := q.db.Exec(ctx, snoozeAlertRule, arg.RuleID, arg.SnoozedUntil)
+	return err
+}
+
+const unbindMacAddress = `-- name: UnbindMacAddress :exec
+UPDATE devices
+SET mac_addr = NULL, online = FALSE
+WHERE lower(mac_addr) = lower($1::text) AND device_id <> $2
+`
+
+type UnbindMacAddressParams struct {
+	MacAddr  string
+	DeviceID int32
+}
+
+func (q *Queries) UnbindMacAddress(ctx context.Context, arg UnbindMacAddressParams) error {
+	_, err := q.db.Exec(ctx, unbindMacAddress, arg.MacAddr, arg.DeviceID)
+	return err
+}
+
+const unshareUserDevices = `-- name: UnshareUserDevices :exec
+UPDATE devices
+SET household_id = NULL
+WHERE user_id = $1 AND household_id = $2
+`
+
+type UnshareUserDevicesParams struct {
+	UserID      int32
+	HouseholdID pgtype.Int4
+}
+
+func (q *Queries) UnshareUserDevices(ctx context.Context, arg UnshareUserDevicesParams) error {
+	_, err := q.db.Exec(ctx, unshareUserDevices, arg.UserID, arg.HouseholdID)
+	return err
+}
+
+const updateAlertRule = `-- name: UpdateAlertRule :one
+UPDATE alert_rules
+SET name = $2, measurement = $3, min_value = $4, max_value = $5, duration_sec = $6, hysteresis = $7, enabled = $8,
+  state = 'ok', pending_since = NULL
+WHERE rule_id = $1
+RETURNING rule_id, device_id, name, measurement, min_value, max_value, duration_sec, hysteresis, enabled, state, pending_since, evaluated_at, snoozed_until, created_at
+`
+
+type UpdateAlertRuleParams struct {
+	RuleID      int32
+	Name        string
+	Measurement string
+	MinValue    pgtype.Float8
+	MaxValue    pgtype.Float8
+	DurationSec int32
+	Hysteresis  float64
+	Enabled     bool
+}
+
+func (q *Queries) UpdateAlertRule(ctx context.Context, arg UpdateAlertRuleParams) (AlertRule, error) {
+	row := q.db.QueryRow(ctx, updateAlertRule,
+		arg.RuleID,
+		arg.Name,
+		arg.Measurement,
+		arg.MinValue,
+		arg.MaxValue,
+		arg.DurationSec,
+		arg.Hysteresis,
+		arg.Enabled,
+	)
+	var i AlertRule
+	err := row.Scan(
+		&i.RuleID,
+		&i.DeviceID,
+		&i.Name,
+		&i.Measurement,
+		&i.MinValue,
+		&i.MaxValue,
+		&i.DurationSec,
+		&i.Hysteresis,
+		&i.Enabled,
+		&i.State,
+		&i.PendingSince,
+		&i.EvaluatedAt,
+		&i.SnoozedUntil,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const updateAlertRuleState = `-- name: UpdateAlertRuleState :execrows
+UPDATE alert_rules
+SET state = $2, pending_since = $3, evaluated_at = $4
+WHERE rule_id = $1 AND (evaluated_at IS NULL OR evaluated_at <= $4)
+`
+
+type UpdateAlertRuleStateParams struct {
+	RuleID       int32
+	State        string
+	PendingSince pgtype.Timestamptz
+	EvaluatedAt  pgtype.Timestamptz
+}
+
+func (q *Queries) UpdateAlertRuleState(ctx context.Context, arg UpdateAlertRuleStateParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateAlertRuleState,
+		arg.RuleID,
+		arg.State,
+		arg.PendingSince,
+		arg.EvaluatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateDeadLetterAttempt = `-- name: UpdateDeadLetterAttempt :exec
+UPDATE dead_letters
+SET attempts = attempts + 1, error = $2, last_attempt_at = CURRENT_TIMESTAMP
+WHERE dead_letter_id = $1
+`
+
+type UpdateDeadLetterAttemptParams struct {
+	DeadLetterID int64
+	Error        string
+}
+
+func (q *Queries) UpdateDeadLetterAttempt(ctx context.Context, arg UpdateDeadLetterAttemptParams) error {
+	_, err := q.db.Exec(ctx, updateDeadLetterAttempt, arg.DeadLetterID, arg.Error)
+	return err
+}
+
+const updateDeviceCommandStatus = `-- name: UpdateDeviceCommandStatus :exec
+UPDATE device_commands
+SET status = $2, error = $3
+WHERE command_id = $1
+`
+
+type UpdateDeviceCommandStatusParams struct {
+	CommandID string
+	Status    string
+	Error     pgtype.Text
+}
+
+func (q *Queries) UpdateDeviceCommandStatus(ctx context.Context, arg UpdateDeviceCommandStatusParams) error {
+	_, err := q.db.Exec(ctx, updateDeviceCommandStatus, arg.CommandID, arg.Status, arg.Error)
+	return err
+}
+
+const updateDeviceDetails = `-- name: UpdateDeviceDetails :exec
+UPDATE devices
+SET display_name = $2, location = $3, plant_species = $4, notes = $5
+WHERE device_id = $1
+`
+
+type UpdateDeviceDetailsParams struct {
+	DeviceID     int32
+	DisplayName  pgtype.Text
+	Location     pgtype.Text
+	PlantSpecies pgtype.Text
+	Notes        pgtype.Text
+}
+
+func (q *Queries) UpdateDeviceDetails(ctx context.Context, arg UpdateDeviceDetailsParams) error {
+	_, err := q.db.Exec(ctx, updateDeviceDetails,
+		arg.DeviceID,
+		arg.DisplayName,
+		arg.Location,
+		arg.PlantSpecies,
+		arg.Notes,
+	)
+	return err
+}
+
+const updateDeviceLastSeen = `-- name: UpdateDeviceLastSeen :exec
+UPDATE devices
+SET last_seen = $2
+WHERE device_id = $1 AND (last_seen IS NULL OR last_seen < $2)
+`
+
+type UpdateDeviceLastSeenParams struct {
+	DeviceID int32
+	LastSeen pgtype.Timestamptz
+}
+
+func (q *Queries) UpdateDeviceLastSeen(ctx context.Context, arg UpdateDeviceLastSeenParams) error {
+	_, err := q.db.Exec(ctx, updateDeviceLastSeen, arg.DeviceID, arg.LastSeen)
+	return err
+}
+
+const updateDeviceLatestReading = `-- name: UpdateDeviceLatestReading :exec
+UPDATE devices
+SET latest_readings = $2, latest_reading_at = $3
+WHERE device_id = $1 AND (latest_reading_at IS NULL OR latest_reading_at <= $3)
+`
+
+type UpdateDeviceLatestReadingParams struct {
+	DeviceID        int32
+	LatestReadings  []byte
+	LatestReadingAt pgtype.Timestamptz
+}
+
+func (q *Queries) UpdateDeviceLatestReading(ctx context.Context, arg UpdateDeviceLatestReadingParams) error {
+	_, err := q.db.Exec(ctx, updateDeviceLatestReading, arg.DeviceID, arg.LatestReadings, arg.LatestReadingAt)
+	return err
+}
+
 const updateDeviceMacAddress = `-- name: UpdateDeviceMacAddress :exec
 UPDATE devices
 SET mac_addr = $2
@@ -347,6 +1997,39 @@ func (q *Queries) UpdateDeviceMacAddress(ctx context.Context, arg UpdateDeviceMa
 	return err
 }
 
+const updateDeviceReportInterval = `-- name: UpdateDeviceReportInterval :exec
+UPDATE devices
+SET report_interval_sec = $2
+WHERE device_id = $1
+`
+
+type UpdateDeviceReportIntervalParams struct {
+	DeviceID          int32
+	ReportIntervalSec pgtype.Int4
+}
+
+func (q *Queries) UpdateDeviceReportInterval(ctx context.Context, arg UpdateDeviceReportIntervalParams) error {
+	_, err := q.db.Exec(ctx, updateDeviceReportInterval, arg.DeviceID, arg.ReportIntervalSec)
+	return err
+}
+
+const updateHouseholdMemberRole = `-- name: UpdateHouseholdMemberRole :exec
+UPDATE household_members
+SET role = $3
+WHERE household_id = $1 AND user_id = $2
+`
+
+type UpdateHouseholdMemberRoleParams struct {
+	HouseholdID int32
+	UserID      int32
+	Role        string
+}
+
+func (q *Queries) UpdateHouseholdMemberRole(ctx context.Context, arg UpdateHouseholdMemberRoleParams) error {
+	_, err := q.db.Exec(ctx, updateHouseholdMemberRole, arg.HouseholdID, arg.UserID, arg.Role)
+	return err
+}
+
 const updateLastLoginTime = `-- name: UpdateLastLoginTime :exec
 UPDATE users
 SET last_login = CURRENT_TIMESTAMP
@@ -357,3 +2040,78 @@ func (q *Queries) UpdateLastLoginTime(ctx context.Context, userID int32) error {
 	_, err := q.db.Exec(ctx, updateLastLoginTime, userID)
 	return err
 }
+
+const upsertCalibrationProfile = `-- name: UpsertCalibrationProfile :one
+INSERT INTO calibration_profiles (device_id, soil_type, dry_value, wet_value, curve)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (device_id) DO UPDATE
+SET soil_type = $2, dry_value = $3, wet_value = $4, curve = $5, updated_at = CURRENT_TIMESTAMP
+RETURNING device_id, soil_type, dry_value, wet_value, curve, updated_at
+`
+
+type UpsertCalibrationProfileParams struct {
+	DeviceID int32
+	SoilType string
+	DryValue pgtype.Float8
+	WetValue pgtype.Float8
+	Curve    []byte
+}
+
+func (q *Queries) UpsertCalibrationProfile(ctx context.Context, arg UpsertCalibrationProfileParams) (CalibrationProfile, error) {
+	row := q.db.QueryRow(ctx, upsertCalibrationProfile,
+		arg.DeviceID,
+		arg.SoilType,
+		arg.DryValue,
+		arg.WetValue,
+		arg.Curve,
+	)
+	var i CalibrationProfile
+	err := row.Scan(
+		&i.DeviceID,
+		&i.SoilType,
+		&i.DryValue,
+		&i.WetValue,
+		&i.Curve,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertNotificationPref = `-- name: UpsertNotificationPref :one
+INSERT INTO notification_prefs (user_id, channel, enabled, target, alerts, device_events)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, channel) DO UPDATE
+SET enabled = $3, target = $4, alerts = $5, device_events = $6, updated_at = CURRENT_TIMESTAMP
+RETURNING user_id, channel, enabled, target, alerts, device_events, updated_at
+`
+
+type UpsertNotificationPrefParams struct {
+	UserID       int32
+	Channel      string
+	Enabled      bool
+	Target       string
+	Alerts       bool
+	DeviceEvents bool
+}
+
+func (q *Queries) UpsertNotificationPref(ctx context.Context, arg UpsertNotificationPrefParams) (NotificationPref, error) {
+	row := q.db.QueryRow(ctx, upsertNotificationPref,
+		arg.UserID,
+		arg.Channel,
+		arg.Enabled,
+		arg.Target,
+		arg.Alerts,
+		arg.DeviceEvents,
+	)
+	var i NotificationPref
+	err := row.Scan(
+		&i.UserID,
+		&i.Channel,
+		&i.Enabled,
+		&i.Target,
+		&i.Alerts,
+		&i.DeviceEvents,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
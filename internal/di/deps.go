@@ -2,35 +2,68 @@ package di
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
 	"github.com/frozenkro/dirtie-srv/internal/core/utils"
 	"github.com/frozenkro/dirtie-srv/internal/db"
 	"github.com/frozenkro/dirtie-srv/internal/db/repos"
+	"github.com/frozenkro/dirtie-srv/internal/hub/dispatch"
+	"github.com/frozenkro/dirtie-srv/internal/hub/publisher"
 	"github.com/frozenkro/dirtie-srv/internal/hub/topics/brdcrmtopic"
+	"github.com/frozenkro/dirtie-srv/internal/hub/topics/cmdacktopic"
 	"github.com/frozenkro/dirtie-srv/internal/hub/topics/logdumptopic"
 	"github.com/frozenkro/dirtie-srv/internal/hub/topics/prvtopic"
+	"github.com/frozenkro/dirtie-srv/internal/hub/topics/statustopic"
+	"github.com/frozenkro/dirtie-srv/internal/metrics"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
 	"github.com/frozenkro/dirtie-srv/internal/services"
 )
 
 type Deps struct {
-	BrdCrmTopic    *brdcrmtopic.BrdCrmTopic
-	LogDumpTopic   *logdumptopic.LogDumpTopic
-	ProvisionTopic *prvtopic.ProvisionTopic
+	BrdCrmTopic      *brdcrmtopic.BrdCrmTopic
+	BrdCrmBatchTopic *brdcrmtopic.BrdCrmBatchTopic
+	CmdAckTopic      *cmdacktopic.CmdAckTopic
+	LogDumpTopic     *logdumptopic.LogDumpTopic
+	ProvisionTopic   *prvtopic.ProvisionTopic
+	StatusTopic      *statustopic.StatusTopic
 
-	AuthSvc    services.AuthSvc
-	BrdCrmSvc  services.BrdCrmSvc
-	DataSvc    services.DataSvc
-	DeviceSvc  services.DeviceSvc
-	LogDumpSvc services.LogDumpSvc
+	AlertSvc            services.AlertSvc
+	AuthSvc             services.AuthSvc
+	BrdCrmSvc           services.BrdCrmSvc
+	CalibrationSvc      services.CalibrationSvc
+	CommandSvc          services.CommandSvc
+	DataSvc             services.DataSvc
+	DeadLetterSvc       services.DeadLetterSvc
+	DeviceSvc           services.DeviceSvc
+	DeviceConnectionSvc services.DeviceConnectionSvc
+	DeviceStatusSvc     services.DeviceStatusSvc
+	HouseholdSvc        services.HouseholdSvc
+	LogDumpSvc          services.LogDumpSvc
+	NotifySvc           services.NotificationSvc
 
-	DeviceRepo  repos.DeviceRepo
-	ProvStgRepo repos.ProvisionStagingRepo
-	PwResetRepo repos.PwResetRepo
-	SessionRepo repos.SessionRepo
-	UserRepo    repos.UserRepo
+	AlertRepo         repos.AlertRepo
+	CalibrationRepo   repos.CalibrationRepo
+	DeadLetterRepo    repos.DeadLetterRepo
+	DeviceRepo        repos.DeviceRepo
+	DeviceCommandRepo repos.DeviceCommandRepo
+	HouseholdRepo     repos.HouseholdRepo
+	NotificationRepo  repos.NotificationRepo
+	ProvStgRepo       repos.ProvisionStagingRepo
+	PwResetRepo       repos.PwResetRepo
+	SessionRepo       repos.SessionRepo
+	UserRepo          repos.UserRepo
 
-	InfluxRepo db.InfluxRepo
-	LokiClient db.LokiClient
+	RepoFactory repos.RepoFactory
+	InfluxRepo  db.InfluxRepo
+	LokiClient  db.LokiClient
+
+	Measurements    *measurements.Registry
+	Metrics         *metrics.Metrics
+	MqttPublisher   *publisher.MqttPublisher
+	TopicDispatcher *dispatch.Dispatcher
 
 	EmailUtil utils.EmailUtil
 	HtmlUtil  utils.HtmlUtil
@@ -42,22 +75,56 @@ type Deps struct {
 func NewDeps(ctx context.Context) *Deps {
 	rf, err := repos.NewRepoFactory(ctx)
 	if err != nil {
-		panic("Failed to setup repositories")
+		panic(fmt.Sprintf("Failed to setup repositories: %v", err))
 	}
 
+	registry, err := measurements.Load(core.MEASUREMENTS_FILE)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load measurement registry: %v", err))
+	}
+
+	alertRepo := rf.NewAlertRepo()
+	calibrationRepo := rf.NewCalibrationRepo()
+	deadLetterRepo := rf.NewDeadLetterRepo()
 	deviceRepo := rf.NewDeviceRepo()
+	deviceCommandRepo := rf.NewDeviceCommandRepo()
+	householdRepo := rf.NewHouseholdRepo()
+	notificationRepo := rf.NewNotificationRepo()
 	provStgRepo := rf.NewProvisionStagingRepo()
 	pwResetRepo := rf.NewPwResetRepo()
 	sessionRepo := rf.NewSessionRepo()
 	userRepo := rf.NewUserRepo()
 
-	influxRepo := db.NewInfluxRepo()
-	lokiClient := db.NewLokiClient()
+	m := metrics.NewMetrics()
+	m.MustRegister(
+		metrics.NewPgPoolCollector(rf.Stat),
+		services.NewActiveSessionsCollector(sessionRepo),
+	)
+
+	influxRepo := db.NewInfluxRepo(m)
+	lokiClient := db.NewLokiClient(m)
+	mqttPublisher := publisher.NewMqttPublisher()
+	topicDispatcher := dispatch.NewDispatcher()
 
 	emailUtil := &utils.EmailUtil{}
 	htmlUtil := &utils.HtmlUtil{}
 	ctxUtil := &utils.CtxUtil{}
 
+	notifySvc := services.NewNotificationSvc(
+		notificationRepo,
+		notificationRepo,
+		notificationRepo,
+		notificationRepo,
+		userRepo,
+		ctxUtil,
+		services.NotificationPolicy{
+			DedupeWindow: time.Duration(core.NOTIFY_DEDUPE_WINDOW_MIN) * time.Minute,
+			RateLimit:    core.NOTIFY_RATE_LIMIT,
+			RateWindow:   time.Hour,
+		},
+		m,
+		notificationChannels(emailUtil, mqttPublisher)...,
+	)
 	authSvc := services.NewAuthSvc(userRepo,
 		userRepo,
 		sessionRepo,
@@ -65,47 +132,158 @@ func NewDeps(ctx context.Context) *Deps {
 		pwResetRepo,
 		pwResetRepo,
 		htmlUtil,
-		emailUtil)
+		notifySvc)
 	deviceSvc := services.NewDeviceSvc(deviceRepo,
 		deviceRepo,
 		provStgRepo,
 		provStgRepo,
-		ctxUtil)
+		ctxUtil,
+		householdRepo,
+		influxRepo,
+		time.Duration(core.PROVISION_CONTRACT_TTL_MIN)*time.Minute)
+	householdSvc := services.NewHouseholdSvc(
+		householdRepo,
+		householdRepo,
+		householdRepo,
+		householdRepo,
+		ctxUtil,
+		htmlUtil,
+		notifySvc,
+		time.Duration(core.HOUSEHOLD_INVITE_TTL_HOURS)*time.Hour,
+	)
+	deviceStatusSvc := services.NewDeviceStatusSvc(
+		deviceRepo,
+		deviceRepo,
+		notifySvc,
+		m,
+		services.HeartbeatPolicy{
+			DefaultInterval: time.Duration(core.DEVICE_REPORT_INTERVAL_SEC) * time.Second,
+			MissedIntervals: core.DEVICE_OFFLINE_AFTER_INTERVALS,
+		},
+	)
+	deviceConnectionSvc := services.NewDeviceConnectionSvc(
+		deviceRepo,
+		deviceRepo,
+		deviceSvc,
+		deviceSvc,
+		deviceSvc,
+		deviceStatusSvc,
+	)
+	alertSvc := services.NewAlertSvc(
+		alertRepo,
+		alertRepo,
+		deviceSvc,
+		registry,
+		notifySvc,
+	)
 	brdCrmSvc := services.NewBrdCrmSvc(
 		influxRepo,
 		influxRepo,
 		deviceSvc,
 		deviceSvc,
+		registry,
+		services.TimestampPolicy{
+			MaxSkew:     time.Duration(core.BRDCRM_MAX_CLOCK_SKEW_SEC) * time.Second,
+			MaxBackfill: time.Duration(core.BRDCRM_MAX_BACKFILL_DAYS) * 24 * time.Hour,
+			MaxBatch:    core.BRDCRM_MAX_BATCH,
+		},
+		m,
+		alertSvc,
+		deviceStatusSvc,
 	)
 	logDumpSvc := services.NewLogDumpSvc(
 		deviceSvc,
 		deviceSvc,
 		lokiClient,
+		deviceStatusSvc,
 	)
 	dataSvc := services.NewDataSvc(
-		influxRepo)
+		influxRepo,
+		registry,
+		deviceSvc,
+		calibrationRepo,
+		db.DataTiers(),
+		core.DATA_MAX_POINTS)
+	calibrationSvc := services.NewCalibrationSvc(
+		calibrationRepo,
+		calibrationRepo,
+		deviceSvc,
+	)
+	commandSvc := services.NewCommandSvc(
+		deviceCommandRepo,
+		deviceCommandRepo,
+		mqttPublisher,
+		deviceSvc,
+		deviceSvc,
+		deviceRepo,
+	)
+	deadLetterSvc := services.NewDeadLetterSvc(
+		deadLetterRepo,
+		deadLetterRepo,
+		topicDispatcher,
+	)
 
 	brdCrmTopic := brdcrmtopic.NewBrdCrmTopic(brdCrmSvc)
+	brdCrmBatchTopic := brdcrmtopic.NewBrdCrmBatchTopic(brdCrmSvc)
+	cmdAckTopic := cmdacktopic.NewCmdAckTopic(commandSvc)
 	logDumpTopic := logdumptopic.NewLogDumpTopic(logDumpSvc)
 	prvTopic := prvtopic.NewProvisionTopic(*deviceSvc)
+	statusTopic := statustopic.NewStatusTopic(deviceConnectionSvc)
 
 	return &Deps{
-		BrdCrmTopic:    brdCrmTopic,
-		LogDumpTopic:   logDumpTopic,
-		ProvisionTopic: prvTopic,
-		AuthSvc:        authSvc,
-		BrdCrmSvc:      brdCrmSvc,
-		DataSvc:        dataSvc,
-		DeviceSvc:      *deviceSvc,
-		DeviceRepo:     deviceRepo,
-		ProvStgRepo:    provStgRepo,
-		PwResetRepo:    pwResetRepo,
-		SessionRepo:    sessionRepo,
-		UserRepo:       userRepo,
-		EmailUtil:      *emailUtil,
-		HtmlUtil:       *htmlUtil,
-		CtxUtil:        *ctxUtil,
-		InfluxRepo:     influxRepo,
-		LokiClient: 		*lokiClient,
+		BrdCrmTopic:         brdCrmTopic,
+		BrdCrmBatchTopic:    brdCrmBatchTopic,
+		CmdAckTopic:         cmdAckTopic,
+		LogDumpTopic:        logDumpTopic,
+		ProvisionTopic:      prvTopic,
+		StatusTopic:         statusTopic,
+		AlertSvc:            alertSvc,
+		AuthSvc:             authSvc,
+		BrdCrmSvc:           brdCrmSvc,
+		CalibrationSvc:      calibrationSvc,
+		CommandSvc:          commandSvc,
+		DataSvc:             dataSvc,
+		DeadLetterSvc:       deadLetterSvc,
+		NotifySvc:           notifySvc,
+		DeviceSvc:           *deviceSvc,
+		DeviceConnectionSvc: deviceConnectionSvc,
+		DeviceStatusSvc:     deviceStatusSvc,
+		HouseholdSvc:        householdSvc,
+		AlertRepo:           alertRepo,
+		CalibrationRepo:     calibrationRepo,
+		DeadLetterRepo:      deadLetterRepo,
+		DeviceRepo:          deviceRepo,
+		DeviceCommandRepo:   deviceCommandRepo,
+		HouseholdRepo:       householdRepo,
+		NotificationRepo:    notificationRepo,
+		ProvStgRepo:         provStgRepo,
+		PwResetRepo:         pwResetRepo,
+		SessionRepo:         sessionRepo,
+		UserRepo:            userRepo,
+		EmailUtil:           *emailUtil,
+		HtmlUtil:            *htmlUtil,
+		CtxUtil:             *ctxUtil,
+		RepoFactory:         rf,
+		InfluxRepo:          influxRepo,
+		LokiClient:          *lokiClient,
+		Measurements:        registry,
+		Metrics:             m,
+		MqttPublisher:       mqttPublisher,
+		TopicDispatcher:     topicDispatcher,
+	}
+}
+
+func notificationChannels(emailUtil *utils.EmailUtil, mqttPublisher *publisher.MqttPublisher) []services.NotificationChannel {
+	if core.NOTIFY_FAKE_CHANNELS {
+		return []services.NotificationChannel{
+			notify.NewFakeChannel(notify.ChannelEmail),
+			notify.NewFakeChannel(notify.ChannelWebhook),
+			notify.NewFakeChannel(notify.ChannelPush),
+		}
+	}
+	return []services.NotificationChannel{
+		notify.NewEmailChannel(emailUtil),
+		notify.NewWebhookChannel(time.Duration(core.NOTIFY_WEBHOOK_TIMEOUT_SEC) * time.Second),
+		notify.NewPushChannel(mqttPublisher),
 	}
 }
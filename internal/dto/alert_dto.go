@@ -0,0 +1,72 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+type AlertRuleDto struct {
+	RuleId       int32      `json:"ruleId"`
+	DeviceId     int32      `json:"deviceId"`
+	Name         string     `json:"name"`
+	Measurement  string     `json:"measurement"`
+	Min          *float64   `json:"min,omitempty"`
+	Max          *float64   `json:"max,omitempty"`
+	DurationSec  int32      `json:"durationSec"`
+	Hysteresis   float64    `json:"hysteresis"`
+	Enabled      bool       `json:"enabled"`
+	State        string     `json:"state"`
+	PendingSince *time.Time `json:"pendingSince,omitempty"`
+	SnoozedUntil *time.Time `json:"snoozedUntil,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+type AlertEventDto struct {
+	EventId    int64     `json:"eventId"`
+	RuleId     int32     `json:"ruleId"`
+	DeviceId   int32     `json:"deviceId"`
+	Kind       string    `json:"kind"`
+	Value      float64   `json:"value"`
+	Snoozed    bool      `json:"snoozed"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func NewAlertRuleDto(r sqlc.AlertRule) *AlertRuleDto {
+	d := &AlertRuleDto{
+		RuleId:      r.RuleID,
+		DeviceId:    r.DeviceID,
+		Name:        r.Name,
+		Measurement: r.Measurement,
+		DurationSec: r.DurationSec,
+		Hysteresis:  r.Hysteresis,
+		Enabled:     r.Enabled,
+		State:       r.State,
+		CreatedAt:   r.CreatedAt.Time,
+	}
+	if r.MinValue.Valid {
+		d.Min = &r.MinValue.Float64
+	}
+	if r.MaxValue.Valid {
+		d.Max = &r.MaxValue.Float64
+	}
+	if r.PendingSince.Valid {
+		d.PendingSince = &r.PendingSince.Time
+	}
+	if r.SnoozedUntil.Valid && r.SnoozedUntil.Time.After(time.Now()) {
+		d.SnoozedUntil = &r.SnoozedUntil.Time
+	}
+	return d
+}
+
+func NewAlertEventDto(e sqlc.AlertEvent) *AlertEventDto {
+	return &AlertEventDto{
+		EventId:    e.EventID,
+		RuleId:     e.RuleID,
+		DeviceId:   e.DeviceID,
+		Kind:       e.Kind,
+		Value:      e.Value,
+		Snoozed:    e.Snoozed,
+		OccurredAt: e.OccurredAt.Time,
+	}
+}
@@ -0,0 +1,41 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/calibration"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+type CalibrationProfileDto struct {
+	DeviceId int32               `json:"deviceId"`
+	SoilType string              `json:"soilType"`
+	Dry      *float64            `json:"dry,omitempty"`
+	Wet      *float64            `json:"wet,omitempty"`
+	Curve    []calibration.Point `json:"curve"`
+	// false until there are enough points to derive moisture
+	Complete  bool      `json:"complete"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func NewCalibrationProfileDto(p sqlc.CalibrationProfile) *CalibrationProfileDto {
+	d := &CalibrationProfileDto{
+		DeviceId:  p.DeviceID,
+		SoilType:  p.SoilType,
+		Curve:     []calibration.Point{},
+		UpdatedAt: p.UpdatedAt.Time,
+	}
+	if p.DryValue.Valid {
+		d.Dry = &p.DryValue.Float64
+	}
+	if p.WetValue.Valid {
+		d.Wet = &p.WetValue.Float64
+	}
+	if len(p.Curve) > 0 {
+		// written by the server after validation
+		json.Unmarshal(p.Curve, &d.Curve)
+	}
+	d.Complete = calibration.Profile{Dry: d.Dry, Wet: d.Wet, Curve: d.Curve}.Complete()
+	return d
+}
@@ -0,0 +1,37 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+type CommandDto struct {
+	CommandId string          `json:"commandId"`
+	DeviceId  int32           `json:"deviceId"`
+	Type      string          `json:"type"`
+	Args      json.RawMessage `json:"args,omitempty"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	ExpiresAt time.Time       `json:"expiresAt"`
+	CreatedAt time.Time       `json:"createdAt"`
+	AckedAt   *time.Time      `json:"ackedAt,omitempty"`
+}
+
+func NewCommandDto(c sqlc.DeviceCommand) *CommandDto {
+	d := &CommandDto{
+		CommandId: c.CommandID,
+		DeviceId:  c.DeviceID,
+		Type:      c.CommandType,
+		Args:      c.Args,
+		Status:    c.Status,
+		Error:     c.Error.String,
+		ExpiresAt: c.ExpiresAt.Time,
+		CreatedAt: c.CreatedAt.Time,
+	}
+	if c.AckedAt.Valid {
+		d.AckedAt = &c.AckedAt.Time
+	}
+	return d
+}
@@ -0,0 +1,46 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+type DataSeriesDto struct {
+	Measurement string               `json:"measurement"`
+	DisplayName string               `json:"displayName"`
+	Unit        string               `json:"unit"`
+	Start       time.Time            `json:"start"`
+	End         time.Time            `json:"end"`
+	Window      string               `json:"window,omitempty"`
+	WindowSec   int64                `json:"windowSec,omitempty"`
+	Aggregate   string               `json:"aggregate,omitempty"`
+	Points      []db.DeviceDataPoint `json:"points"`
+	// same length and times as Points, only for calibrated capacitance
+	Moisture []db.DeviceDataPoint `json:"moisture,omitempty"`
+}
+
+// With a window each point is stamped with the end of its window,
+// and windows without data are omitted, so gaps show up as missing
+// multiples of windowSec
+func NewDataSeriesDto(s services.DataSeries) *DataSeriesDto {
+	d := &DataSeriesDto{
+		Measurement: s.Measurement.Name,
+		DisplayName: s.Measurement.DisplayName,
+		Unit:        s.Measurement.Unit,
+		Start:       s.Start,
+		End:         s.End,
+		Aggregate:   string(s.Aggregate),
+		Points:      s.Points,
+		Moisture:    s.Moisture,
+	}
+	if d.Points == nil {
+		d.Points = []db.DeviceDataPoint{}
+	}
+	if s.Window > 0 {
+		d.Window = s.Window.String()
+		d.WindowSec = int64(s.Window.Seconds())
+	}
+	return d
+}
@@ -0,0 +1,37 @@
+package dto
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+type DeadLetterDto struct {
+	DeadLetterId  int64     `json:"deadLetterId"`
+	Topic         string    `json:"topic"`
+	Payload       []byte    `json:"payload"`
+	PayloadText   string    `json:"payloadText,omitempty"`
+	Error         string    `json:"error"`
+	Attempts      int32     `json:"attempts"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastAttemptAt time.Time `json:"lastAttemptAt"`
+}
+
+// Payload is always sent base64 encoded, PayloadText is only
+// filled in when the raw bytes are readable as-is
+func NewDeadLetterDto(d sqlc.DeadLetter) *DeadLetterDto {
+	dto := &DeadLetterDto{
+		DeadLetterId:  d.DeadLetterID,
+		Topic:         d.Topic,
+		Payload:       d.Payload,
+		Error:         d.Error,
+		Attempts:      d.Attempts,
+		CreatedAt:     d.CreatedAt.Time,
+		LastAttemptAt: d.LastAttemptAt.Time,
+	}
+	if utf8.Valid(d.Payload) {
+		dto.PayloadText = string(d.Payload)
+	}
+	return dto
+}
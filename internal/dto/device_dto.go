@@ -1,19 +1,70 @@
 package dto
 
-import "github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
 
 type DeviceDto struct {
-	DeviceId    int32  `json:"deviceId"`
-	UserId      int32  `json:"userId"`
-	MacAddr     string `json:"macAddr"`
-	DisplayName string `json:"displayName"`
+	DeviceId     int32             `json:"deviceId"`
+	UserId       int32             `json:"userId"`
+	HouseholdId  *int32            `json:"householdId"`
+	MacAddr      string            `json:"macAddr"`
+	DisplayName  string            `json:"displayName"`
+	Location     string            `json:"location"`
+	PlantSpecies string            `json:"plantSpecies"`
+	Notes        string            `json:"notes"`
+	LastSeen     *time.Time        `json:"lastSeen,omitempty"`
+	Online       bool              `json:"online"`
+	Latest       *LatestReadingDto `json:"latest,omitempty"`
+}
+
+type LatestReadingDto struct {
+	Time     time.Time          `json:"time"`
+	Readings map[string]float64 `json:"readings"`
 }
 
 func NewDeviceDto(d sqlc.Device) *DeviceDto {
-	return &DeviceDto{
-		DeviceId:    d.DeviceID,
-		UserId:      d.UserID,
-		MacAddr:     d.MacAddr.String,
-		DisplayName: d.DisplayName.String,
+	dto := &DeviceDto{
+		DeviceId:     d.DeviceID,
+		UserId:       d.UserID,
+		MacAddr:      d.MacAddr.String,
+		DisplayName:  d.DisplayName.String,
+		Location:     d.Location.String,
+		PlantSpecies: d.PlantSpecies.String,
+		Notes:        d.Notes.String,
+		Online:       d.Online,
+	}
+	if d.HouseholdID.Valid {
+		dto.HouseholdId = &d.HouseholdID.Int32
+	}
+	if d.LastSeen.Valid {
+		dto.LastSeen = &d.LastSeen.Time
+	}
+	if d.LatestReadingAt.Valid && len(d.LatestReadings) > 0 {
+		latest := &LatestReadingDto{Time: d.LatestReadingAt.Time}
+		// written by the server, so a bad document just means no reading
+		if json.Unmarshal(d.LatestReadings, &latest.Readings) == nil {
+			dto.Latest = latest
+		}
+	}
+	return dto
+}
+
+type DeviceConnectionEventDto struct {
+	EventId    int64     `json:"eventId"`
+	DeviceId   int32     `json:"deviceId"`
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func NewDeviceConnectionEventDto(e sqlc.DeviceConnectionEvent) *DeviceConnectionEventDto {
+	return &DeviceConnectionEventDto{
+		EventId:    e.EventID,
+		DeviceId:   e.DeviceID,
+		Event:      e.Event,
+		OccurredAt: e.OccurredAt.Time,
 	}
 }
@@ -0,0 +1,65 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+type HouseholdDto struct {
+	HouseholdId int32     `json:"householdId"`
+	Name        string    `json:"name"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// role is the requesting user's role in the household
+func NewHouseholdDto(h sqlc.Household, role string) *HouseholdDto {
+	return &HouseholdDto{
+		HouseholdId: h.HouseholdID,
+		Name:        h.Name,
+		Role:        role,
+		CreatedAt:   h.CreatedAt.Time,
+	}
+}
+
+type HouseholdMemberDto struct {
+	UserId   int32     `json:"userId"`
+	Email    string    `json:"email"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+func NewHouseholdMemberDto(m sqlc.GetHouseholdMembersRow) *HouseholdMemberDto {
+	return &HouseholdMemberDto{
+		UserId:   m.UserID,
+		Email:    m.Email,
+		Name:     m.Name,
+		Role:     m.Role,
+		JoinedAt: m.CreatedAt.Time,
+	}
+}
+
+// The invite code is only ever sent to the invited address
+type HouseholdInviteDto struct {
+	InviteId    int64     `json:"inviteId"`
+	HouseholdId int32     `json:"householdId"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	InvitedBy   int32     `json:"invitedBy"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func NewHouseholdInviteDto(i sqlc.HouseholdInvite) *HouseholdInviteDto {
+	return &HouseholdInviteDto{
+		InviteId:    i.InviteID,
+		HouseholdId: i.HouseholdID,
+		Email:       i.Email,
+		Role:        i.Role,
+		InvitedBy:   i.InvitedBy,
+		ExpiresAt:   i.ExpiresAt.Time,
+		CreatedAt:   i.CreatedAt.Time,
+	}
+}
@@ -0,0 +1,47 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+type NotificationPrefDto struct {
+	Channel      string `json:"channel"`
+	Enabled      bool   `json:"enabled"`
+	Target       string `json:"target,omitempty"`
+	Alerts       bool   `json:"alerts"`
+	DeviceEvents bool   `json:"deviceEvents"`
+}
+
+type NotificationLogDto struct {
+	NotificationId int64     `json:"notificationId"`
+	Channel        string    `json:"channel"`
+	Kind           string    `json:"kind"`
+	Subject        string    `json:"subject"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+func NewNotificationPrefDto(p sqlc.NotificationPref) *NotificationPrefDto {
+	return &NotificationPrefDto{
+		Channel:      p.Channel,
+		Enabled:      p.Enabled,
+		Target:       p.Target,
+		Alerts:       p.Alerts,
+		DeviceEvents: p.DeviceEvents,
+	}
+}
+
+func NewNotificationLogDto(l sqlc.NotificationLog) *NotificationLogDto {
+	return &NotificationLogDto{
+		NotificationId: l.NotificationID,
+		Channel:        l.Channel,
+		Kind:           l.Kind,
+		Subject:        l.Subject,
+		Status:         l.Status,
+		Error:          l.Error,
+		CreatedAt:      l.CreatedAt.Time,
+	}
+}
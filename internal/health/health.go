@@ -0,0 +1,116 @@
+// Per-dependency health checks behind /healthz and /readyz
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusOk       Status = "ok"
+	StatusDegraded Status = "degraded"
+	StatusDown     Status = "down"
+)
+
+const defaultCheckTimeout = 2 * time.Second
+
+type Check struct {
+	Name string
+	// A failing critical check fails readiness, any other failing
+	// check only marks the report degraded
+	Critical bool
+	Fn       func(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Status    Status  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status Status                 `json:"status"`
+	Ready  bool                   `json:"ready"`
+	Time   time.Time              `json:"time"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Run executes every check concurrently, each bounded by the
+// checker's timeout
+func (c *Checker) Run(ctx context.Context) Report {
+	results := make(map[string]CheckResult, len(c.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, chk := range c.checks {
+		wg.Add(1)
+		go func(chk Check) {
+			defer wg.Done()
+			res := c.run(ctx, chk)
+
+			mu.Lock()
+			results[chk.Name] = res
+			mu.Unlock()
+		}(chk)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOk, Ready: true, Time: time.Now(), Checks: results}
+	for _, res := range results {
+		if res.Status == StatusOk {
+			continue
+		}
+		if res.Critical {
+			report.Status = StatusDown
+			report.Ready = false
+		} else if report.Status == StatusOk {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, chk Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- chk.Fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := CheckResult{
+		Status:    StatusOk,
+		Critical:  chk.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		res.Status = StatusDown
+		res.Error = err.Error()
+	}
+	return res
+}
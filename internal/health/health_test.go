@@ -0,0 +1,67 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ok(ctx context.Context) error { return nil }
+
+func failing(ctx context.Context) error { return fmt.Errorf("connection refused") }
+
+func TestChecker(t *testing.T) {
+	t.Run("AllOk", func(t *testing.T) {
+		c := NewChecker(time.Second,
+			Check{Name: "postgres", Critical: true, Fn: ok},
+			Check{Name: "loki", Fn: ok})
+
+		r := c.Run(context.Background())
+
+		assert.Equal(t, StatusOk, r.Status)
+		assert.True(t, r.Ready)
+		assert.Len(t, r.Checks, 2)
+	})
+
+	t.Run("NonCriticalDown", func(t *testing.T) {
+		c := NewChecker(time.Second,
+			Check{Name: "postgres", Critical: true, Fn: ok},
+			Check{Name: "loki", Fn: failing})
+
+		r := c.Run(context.Background())
+
+		assert.Equal(t, StatusDegraded, r.Status)
+		assert.True(t, r.Ready)
+		assert.Equal(t, StatusDown, r.Checks["loki"].Status)
+		assert.Equal(t, "connection refused", r.Checks["loki"].Error)
+	})
+
+	t.Run("CriticalDown", func(t *testing.T) {
+		c := NewChecker(time.Second,
+			Check{Name: "postgres", Critical: true, Fn: failing},
+			Check{Name: "loki", Fn: ok})
+
+		r := c.Run(context.Background())
+
+		assert.Equal(t, StatusDown, r.Status)
+		assert.False(t, r.Ready)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		c := NewChecker(10*time.Millisecond,
+			Check{Name: "influx", Critical: true, Fn: func(ctx context.Context) error {
+				time.Sleep(time.Second)
+				return nil
+			}})
+
+		start := time.Now()
+		r := c.Run(context.Background())
+
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.False(t, r.Ready)
+		assert.Equal(t, context.DeadlineExceeded.Error(), r.Checks["influx"].Error)
+	})
+}
@@ -0,0 +1,41 @@
+// Inbound counterpart to the publisher package. Lets services push a
+// payload back through the hub's topic routing (e.g. dead-letter replay)
+// without importing the hub itself.
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type DispatchFunc func(ctx context.Context, topic string, payload []byte) error
+
+type Dispatcher struct {
+	mu sync.RWMutex
+	fn DispatchFunc
+}
+
+var ErrNoDispatcher error = fmt.Errorf("MQTT hub not running")
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{}
+}
+
+// SetHandler is called by the hub once its topic router is ready
+func (d *Dispatcher) SetHandler(fn DispatchFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fn = fn
+}
+
+func (d *Dispatcher) Dispatch(ctx context.Context, topic string, payload []byte) error {
+	d.mu.RLock()
+	fn := d.fn
+	d.mu.RUnlock()
+
+	if fn == nil {
+		return fmt.Errorf("Error Dispatcher Dispatch '%v': %w", topic, ErrNoDispatcher)
+	}
+	return fn(ctx, topic, payload)
+}
@@ -2,10 +2,13 @@ package hub
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/frozenkro/dirtie-srv/internal/core"
 	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
 	"github.com/frozenkro/dirtie-srv/internal/core/utils"
 	"github.com/frozenkro/dirtie-srv/internal/di"
@@ -15,79 +18,299 @@ type TopicInvoker interface {
 	InvokeTopic(ctx context.Context, payload []byte) error
 }
 
+const (
+	defaultQos byte = 1
+	// time given to paho to finish outstanding work on Disconnect
+	disconnectQuiesceMs uint = 250
+	reconnectJitter          = 0.2
+	// a handler that timed out still gets this long to dead-letter
+	// its payload
+	deadLetterSaveTimeout = 5 * time.Second
+	// replicas subscribe as one shared group, so the broker hands each
+	// message to just one of them
+	shareGroup = "dirtie-srv"
+)
+
 var (
-	totalReconnectAttempts int = 10
-	client                 mqtt.Client
-	deps                   *di.Deps
-	ErrTopicNotFound       error = fmt.Errorf("MQTT Topic Not Found")
+	client           mqtt.Client
+	router           *TopicRouter
+	pool             *WorkerPool
+	supervisor       *Supervisor
+	deadLetters      DeadLetterSaver
+	observer         MqttObserver
+	ErrTopicNotFound error = fmt.Errorf("MQTT Topic Not Found")
 )
 
 var messagePubHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	topic := string(msg.Topic())
 	utils.LogInfo(fmt.Sprintf("Received message: %s from topic %s\n", string(msg.Payload()), topic))
 
-	ivk, err := getTopicInvoker(msg.Topic())
-	if ivk != nil {
-		err = ivk.InvokeTopic(ctx, msg.Payload())
+	route, err := router.MatchRoute(topic)
+	if err != nil {
+		utils.LogErr(fmt.Errorf("Error MessagePubHandler -> MatchRoute: %w", err).Error())
+		msg.Ack()
+		return
 	}
 
+	// blocks here under OverloadBlock until a worker frees up. Each
+	// message gets its own callback goroutine (OrderMatters is off), so
+	// this holds up the ack rather than paho's router.
+	err = pool.Submit(route, msg)
+	if errors.Is(err, ErrPoolStopped) {
+		// shutting down, leave it unacked for redelivery
+		utils.LogInfo(fmt.Sprintf("hub stopping, not handling message from %s\n", topic))
+	} else if err != nil {
+		utils.LogErr(fmt.Errorf("Error MessagePubHandler -> Submit (dropped %s): %w", topic, err).Error())
+		msg.Ack()
+	}
+}
+
+type DeadLetterSaver interface {
+	Save(ctx context.Context, topic string, payload []byte, cause error) error
+}
+
+// Runs on a pool worker. Acks only once the topic has been handled
+// so QoS 1 redelivery covers messages lost to a crash mid-handling.
+// Payloads that fail are kept in the dead-letter store for replay.
+func handleMessage(ctx context.Context, route Route, msg mqtt.Message) error {
+	defer msg.Ack()
+
+	start := time.Now()
+	err := invokeRoute(ctx, route, msg.Topic(), msg.Payload())
+	if observer != nil {
+		observer.ObserveMqttMessage(route.Filter, time.Since(start), err)
+	}
 	if err != nil {
-		utils.LogErr(fmt.Errorf("Error MessagePubHandler -> InvokeTopic: %w", err).Error())
+		utils.LogErr(fmt.Errorf("Error handleMessage -> InvokeTopic: %w", err).Error())
+
+		if deadLetters != nil {
+			// ctx may be the reason the handler failed
+			saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deadLetterSaveTimeout)
+			dlErr := deadLetters.Save(saveCtx, msg.Topic(), msg.Payload(), err)
+			cancel()
+			if dlErr != nil {
+				utils.LogErr(fmt.Errorf("Error handleMessage -> Save dead letter: %w", dlErr).Error())
+			}
+		}
 	}
+	return err
 }
 
-func getTopicInvoker(topic string) (TopicInvoker, error) {
-	switch topic {
-	case core_topics.Breadcrumb:
-		return deps.BrdCrmTopic, nil
-	case core_topics.Provision:
-		return deps.ProvisionTopic, nil
-	default:
-		return nil, ErrTopicNotFound
+// Replays a payload through the router without touching the pool or
+// the dead-letter store; the caller decides what to do with failures
+func dispatchTopic(ctx context.Context, topic string, payload []byte) error {
+	route, err := router.MatchRoute(topic)
+	if err != nil {
+		return err
 	}
+	return invokeRoute(ctx, route, topic, payload)
 }
 
-var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
-	utils.LogInfo(fmt.Sprintf("connected to mqtt broker\n"))
+func invokeRoute(ctx context.Context, route Route, topic string, payload []byte) error {
+	if macAddr, _, ok := core_topics.ParseDeviceTopic(topic); ok {
+		ctx = core_topics.WithTopicMacAddr(ctx, macAddr)
+	}
+	return route.Invoker.InvokeTopic(ctx, payload)
 }
 
-var connectionLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	utils.LogInfo(fmt.Sprintf("disconnected from mqtt broker\n"))
-	attemptReconnect()
+// Stats reports queue depth and drop counters for the message worker pool
+func Stats() PoolStats {
+	if pool == nil {
+		return PoolStats{}
+	}
+	return pool.Stats()
 }
 
-func attemptReconnect() {
-	for i := 1; i <= totalReconnectAttempts; i++ {
-		utils.LogInfo(fmt.Sprintf("attempting to reconnect (%d/%d)\n", i, totalReconnectAttempts))
+func registerTopics(deps *di.Deps) (*TopicRouter, error) {
+	routes := []Route{
+		{Filter: core_topics.DeviceFilter(core_topics.DeviceBreadcrumb), Qos: defaultQos, Invoker: deps.BrdCrmTopic},
+		{Filter: core_topics.DeviceFilter(core_topics.DeviceBreadcrumbBatch), Qos: defaultQos, Invoker: deps.BrdCrmBatchTopic},
+		{Filter: core_topics.DeviceFilter(core_topics.DeviceProvision), Qos: defaultQos, Invoker: deps.ProvisionTopic},
+		{Filter: core_topics.DeviceFilter(core_topics.DeviceLogs), Qos: defaultQos, Invoker: deps.LogDumpTopic},
+		{Filter: core_topics.DeviceFilter(core_topics.DeviceCommandAck), Qos: defaultQos, Invoker: deps.CmdAckTopic},
+		{Filter: core_topics.DeviceFilter(core_topics.DeviceStatus), Qos: defaultQos, Invoker: deps.StatusTopic},
+	}
+	if core.MQTT_LEGACY_TOPICS {
+		routes = append(routes,
+			Route{Filter: core_topics.Breadcrumb, Qos: defaultQos, Invoker: deps.BrdCrmTopic},
+			Route{Filter: core_topics.Provision, Qos: defaultQos, Invoker: deps.ProvisionTopic},
+			Route{Filter: core_topics.LogDump, Qos: defaultQos, Invoker: deps.LogDumpTopic},
+		)
+	}
 
-		if token := client.Connect(); token.Wait() && token.Error() == nil {
-			utils.LogInfo(fmt.Sprintf("Reconnect successful\n"))
-			return
+	r := NewTopicRouter()
+	for _, rt := range routes {
+		if err := r.Register(rt.Filter, rt.Qos, rt.Invoker); err != nil {
+			return nil, err
 		}
 	}
-	panic("Failed to reconnect to mqtt broker")
+	return r, nil
+}
+
+// sharedSubscriptions returns the router's filters as subscriptions
+// in shareGroup. The broker delivers messages with their real topic,
+// so routing is unchanged.
+func sharedSubscriptions() map[string]byte {
+	subs := make(map[string]byte)
+	for filter, qos := range router.Subscriptions() {
+		subs[fmt.Sprintf("$share/%s/%s", shareGroup, filter)] = qos
+	}
+	return subs
+}
+
+// Subscriptions are not persisted by the broker for a clean session,
+// so the supervisor runs this on every (re)connect
+func subscribeAll(c mqtt.Client) error {
+	subs := sharedSubscriptions()
+	if len(subs) == 0 {
+		return nil
+	}
+
+	token := c.SubscribeMultiple(subs, messagePubHandler)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("Error subscribing to mqtt topics: %w", token.Error())
+	}
+	utils.LogInfo(fmt.Sprintf("subscribed to %d mqtt topic filter(s)\n", len(subs)))
+	return nil
+}
+
+// ConnectionStatus reports the broker connection as seen by the supervisor
+func ConnectionStatus() ConnStatus {
+	if supervisor == nil {
+		return ConnStatus{State: StateDisconnected}
+	}
+	return supervisor.Status()
 }
 
-func Init(deps *di.Deps) {
+// Start registers topic routes, starts the worker pool and hands the
+// broker connection to the supervisor. It doesn't wait for the broker,
+// so an unreachable broker doesn't hold up the rest of the app.
+func Start(deps *di.Deps) error {
+	r, err := registerTopics(deps)
+	if err != nil {
+		return fmt.Errorf("Error hub Start -> registerTopics: %w", err)
+	}
+	router = r
+	deadLetters = deps.DeadLetterSvc
+	deps.TopicDispatcher.SetHandler(dispatchTopic)
+	observer = deps.Metrics
+	if err := registerCollectors(deps.Metrics); err != nil {
+		return fmt.Errorf("Error hub Start -> registerCollectors: %w", err)
+	}
+
+	pool = NewWorkerPool(PoolConfig{
+		Workers:    core.MQTT_WORKERS,
+		QueueSize:  core.MQTT_QUEUE_SIZE,
+		TopicLimit: core.MQTT_TOPIC_CONCURRENCY,
+		Policy:     OverloadPolicy(core.MQTT_OVERLOAD_POLICY),
+		JobTimeout: time.Duration(core.MQTT_HANDLER_TIMEOUT_SEC) * time.Second,
+	}, handleMessage)
+	pool.Start()
+
+	opts := ClientOptions(hubClientID())
+	opts.SetDefaultPublishHandler(messagePubHandler)
+	opts.SetAutoAckDisabled(true)
+	// with ordering on, paho runs every callback on the goroutine that
+	// also reads acks for our own publishes, so a callback blocked on a
+	// full pool would stall handlers waiting on a QoS 1 publish
+	opts.SetOrderMatters(false)
+
+	supervisor = NewSupervisor(Backoff{
+		Initial: time.Duration(core.MQTT_RECONNECT_INITIAL_MS) * time.Millisecond,
+		Max:     time.Duration(core.MQTT_RECONNECT_MAX_SEC) * time.Second,
+		Jitter:  reconnectJitter,
+	}, subscribeAll)
+	supervisor.Configure(opts)
+
+	client = mqtt.NewClient(opts)
+	deps.MqttPublisher.SetClient(client)
+	supervisor.Connect(client)
+	return nil
+}
+
+// hubClientID is unique per replica, since the broker drops whichever
+// session already holds a client id when another connects with it.
+// In k8s the hostname is the pod name.
+func hubClientID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return fmt.Sprintf("dirtie_hub_%d", os.Getpid())
+	}
+	return "dirtie_hub_" + host
+}
+
+// StartOffline registers topic routes for dead-letter replay without
+// connecting to the broker or starting the worker pool. Used by the
+// admin cli, which mustn't take the server's client id and hands the
+// publisher a client of its own.
+func StartOffline(deps *di.Deps) error {
+	r, err := registerTopics(deps)
+	if err != nil {
+		return fmt.Errorf("Error hub StartOffline -> registerTopics: %w", err)
+	}
+	router = r
+	deps.TopicDispatcher.SetHandler(dispatchTopic)
+	return nil
+}
+
+// ClientOptions connects to BrokerURI as clientId, signing in as
+// MOSQUITTO_USERNAME when it is set
+func ClientOptions(clientId string) *mqtt.ClientOptions {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("tcp://%s", BrokerURI()))
+	opts.SetClientID(clientId)
+	if core.MOSQUITTO_USERNAME != "" {
+		opts.SetUsername(core.MOSQUITTO_USERNAME)
+		opts.SetPassword(core.MOSQUITTO_PASSWORD)
+	}
+	return opts
+}
+
+// BrokerURI is the mosquitto host:port from MOSQUITTO_URI
+func BrokerURI() string {
 	uri, ok := os.LookupEnv("MOSQUITTO_URI")
 	if !ok {
 		uri = "localhost:1883"
 	}
+	return uri
+}
 
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s", uri))
-	opts.SetClientID("dirtie_hub")
-	opts.SetDefaultPublishHandler(messagePubHandler)
-	opts.OnConnect = connectHandler
-	opts.OnConnectionLost = connectionLostHandler
-	client = mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+// Stop unsubscribes so the broker stops delivering, drains the worker
+// pool while the connection is still up (handlers ack through it),
+// then disconnects.
+func Stop(ctx context.Context) error {
+	var errs []error
+
+	if supervisor != nil {
+		supervisor.Stop()
+	}
+
+	if client != nil && client.IsConnectionOpen() {
+		subs := sharedSubscriptions()
+		filters := make([]string, 0, len(subs))
+		for f := range subs {
+			filters = append(filters, f)
+		}
+
+		token := client.Unsubscribe(filters...)
+		select {
+		case <-token.Done():
+			if token.Error() != nil {
+				errs = append(errs, fmt.Errorf("Error hub Stop -> Unsubscribe: %w", token.Error()))
+			}
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("Error hub Stop -> Unsubscribe: %w", ctx.Err()))
+		}
 	}
 
-	// this just keeps the goroutine alive
-	select {}
+	if pool != nil {
+		if err := pool.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("Error hub Stop -> pool Stop: %w", err))
+		}
+	}
+
+	if client != nil {
+		client.Disconnect(disconnectQuiesceMs)
+	}
+	return errors.Join(errs...)
 }
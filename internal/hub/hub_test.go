@@ -1,2 +1,376 @@
 // integration tests against mqtt handler routing
 package hub
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubInvoker struct {
+	name string
+}
+
+func (s *stubInvoker) InvokeTopic(ctx context.Context, payload []byte) error {
+	return nil
+}
+
+type failingInvoker struct {
+	err error
+}
+
+func (f *failingInvoker) InvokeTopic(ctx context.Context, payload []byte) error {
+	return f.err
+}
+
+type fakeDeadLetters struct {
+	topics  []string
+	ctxErrs []error
+}
+
+func (f *fakeDeadLetters) Save(ctx context.Context, topic string, payload []byte, cause error) error {
+	f.topics = append(f.topics, topic)
+	f.ctxErrs = append(f.ctxErrs, ctx.Err())
+	return nil
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"dirtie-breadcrumb", "dirtie-breadcrumb", true},
+		{"dirtie-breadcrumb", "dirtie-provision", false},
+		{"dirtie/+/breadcrumb", "dirtie/aabbcc/breadcrumb", true},
+		{"dirtie/+/breadcrumb", "dirtie/aabbcc/logs", false},
+		{"dirtie/+/breadcrumb", "dirtie/breadcrumb", false},
+		{"dirtie/#", "dirtie/aabbcc/logs", true},
+		{"dirtie/#", "dirtie", true},
+		{"dirtie/+", "dirtie/aabbcc/logs", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter+"|"+tt.topic, func(t *testing.T) {
+			assert.Equal(t, tt.match, matchTopic(tt.filter, tt.topic))
+		})
+	}
+}
+
+func TestTopicRouter(t *testing.T) {
+	brdCrm := &stubInvoker{name: "brdcrm"}
+	logs := &stubInvoker{name: "logs"}
+
+	t.Run("Match", func(t *testing.T) {
+		r := NewTopicRouter()
+		assert.Nil(t, r.Register("dirtie/+/breadcrumb", 1, brdCrm))
+		assert.Nil(t, r.Register("dirtie/+/logs", 0, logs))
+
+		ivk, err := r.Match("dirtie/aabbcc/logs")
+		assert.Nil(t, err)
+		assert.Same(t, logs, ivk)
+
+		_, err = r.Match("dirtie/aabbcc/unknown")
+		assert.ErrorIs(t, err, ErrTopicNotFound)
+
+		assert.Equal(t, map[string]byte{
+			"dirtie/+/breadcrumb": 1,
+			"dirtie/+/logs":       0,
+		}, r.Subscriptions())
+	})
+
+	t.Run("InvalidFilter", func(t *testing.T) {
+		r := NewTopicRouter()
+		assert.ErrorIs(t, r.Register("dirtie/#/logs", 0, logs), ErrInvalidTopicFilter)
+		assert.ErrorIs(t, r.Register("dirtie/a+/logs", 0, logs), ErrInvalidTopicFilter)
+		assert.ErrorIs(t, r.Register("dirtie/logs", 3, logs), ErrInvalidTopicFilter)
+		assert.ErrorIs(t, r.Register("dirtie/logs", 0, nil), ErrInvalidTopicFilter)
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		r := NewTopicRouter()
+		assert.Nil(t, r.Register("dirtie/+/logs", 0, logs))
+		assert.ErrorIs(t, r.Register("dirtie/+/logs", 1, brdCrm), ErrDuplicateRoute)
+	})
+}
+
+func TestSharedSubscriptions(t *testing.T) {
+	r := NewTopicRouter()
+	assert.Nil(t, r.Register("dirtie/+/breadcrumb", 1, &stubInvoker{name: "brdcrm"}))
+	assert.Nil(t, r.Register("logs", 0, &stubInvoker{name: "logs"}))
+	router = r
+	defer func() { router = nil }()
+
+	assert.Equal(t, map[string]byte{
+		"$share/dirtie-srv/dirtie/+/breadcrumb": 1,
+		"$share/dirtie-srv/logs":                0,
+	}, sharedSubscriptions())
+}
+
+type fakeMessage struct {
+	topic   string
+	payload []byte
+	acked   bool
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return defaultQos }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+func (m *fakeMessage) Ack()              { m.acked = true }
+
+func TestWorkerPool(t *testing.T) {
+	route := Route{Filter: "dirtie/+/breadcrumb", Qos: defaultQos, Invoker: &stubInvoker{name: "brdcrm"}}
+
+	t.Run("DropWhenFull", func(t *testing.T) {
+		release := make(chan struct{})
+		p := NewWorkerPool(PoolConfig{Workers: 1, QueueSize: 1, Policy: OverloadDrop},
+			func(ctx context.Context, rt Route, msg mqtt.Message) error {
+				<-release
+				return nil
+			})
+		p.Start()
+
+		// first is picked up by the worker, second fills the queue
+		assert.Nil(t, p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"}))
+		assert.Eventually(t, func() bool { return p.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+		assert.Nil(t, p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"}))
+
+		err := p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"})
+		assert.ErrorIs(t, err, ErrPoolFull)
+
+		stats := p.Stats()
+		assert.Equal(t, uint64(1), stats.Dropped)
+		assert.Equal(t, uint64(1), stats.DroppedByTopic[route.Filter])
+		assert.Equal(t, 1, stats.QueueDepth)
+
+		close(release)
+		assert.Nil(t, p.Stop(context.Background()))
+		assert.Equal(t, uint64(2), p.Stats().Processed)
+	})
+
+	t.Run("TopicLimit", func(t *testing.T) {
+		var cur atomic.Int32
+		var overlapped atomic.Bool
+		p := NewWorkerPool(PoolConfig{Workers: 4, QueueSize: 20, TopicLimit: 1},
+			func(ctx context.Context, rt Route, msg mqtt.Message) error {
+				if cur.Add(1) > 1 {
+					overlapped.Store(true)
+				}
+				time.Sleep(2 * time.Millisecond)
+				cur.Add(-1)
+				return nil
+			})
+		p.Start()
+
+		for i := 0; i < 10; i++ {
+			assert.Nil(t, p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"}))
+		}
+		assert.Nil(t, p.Stop(context.Background()))
+
+		assert.False(t, overlapped.Load())
+		assert.Equal(t, uint64(10), p.Stats().Processed)
+	})
+
+	t.Run("BusyTopicDoesNotBlockOthers", func(t *testing.T) {
+		release := make(chan struct{})
+		idle := Route{Filter: "dirtie/+/status", Qos: defaultQos, Invoker: &stubInvoker{name: "status"}}
+		var idleDone atomic.Bool
+		p := NewWorkerPool(PoolConfig{Workers: 2, QueueSize: 10, TopicLimit: 1},
+			func(ctx context.Context, rt Route, msg mqtt.Message) error {
+				if rt.Filter == idle.Filter {
+					idleDone.Store(true)
+					return nil
+				}
+				<-release
+				return nil
+			})
+		p.Start()
+
+		// the busy route saturates its limit with more queued behind
+		for i := 0; i < 3; i++ {
+			assert.Nil(t, p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"}))
+		}
+		assert.Eventually(t, func() bool { return p.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+		assert.Nil(t, p.Submit(idle, &fakeMessage{topic: "dirtie/bb/status"}))
+
+		assert.Eventually(t, idleDone.Load, time.Second, time.Millisecond)
+		assert.Equal(t, 2, p.Stats().QueueDepth)
+
+		close(release)
+		assert.Nil(t, p.Stop(context.Background()))
+		assert.Equal(t, uint64(4), p.Stats().Processed)
+	})
+
+	t.Run("SubmitAfterStop", func(t *testing.T) {
+		p := NewWorkerPool(PoolConfig{Workers: 1, QueueSize: 1},
+			func(ctx context.Context, rt Route, msg mqtt.Message) error {
+				return nil
+			})
+		p.Start()
+
+		assert.Nil(t, p.Stop(context.Background()))
+		assert.Nil(t, p.Stop(context.Background()))
+
+		err := p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"})
+		assert.ErrorIs(t, err, ErrPoolStopped)
+	})
+
+	t.Run("JobTimeout", func(t *testing.T) {
+		p := NewWorkerPool(PoolConfig{Workers: 1, QueueSize: 1, JobTimeout: 10 * time.Millisecond},
+			func(ctx context.Context, rt Route, msg mqtt.Message) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+		p.Start()
+
+		assert.Nil(t, p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"}))
+		assert.Nil(t, p.Stop(context.Background()))
+
+		assert.Equal(t, uint64(1), p.Stats().Failed)
+	})
+
+	t.Run("StopCancelsInFlight", func(t *testing.T) {
+		var ran atomic.Int32
+		p := NewWorkerPool(PoolConfig{Workers: 1, QueueSize: 2},
+			func(ctx context.Context, rt Route, msg mqtt.Message) error {
+				ran.Add(1)
+				<-ctx.Done()
+				return ctx.Err()
+			})
+		p.Start()
+
+		assert.Nil(t, p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"}))
+		assert.Eventually(t, func() bool { return p.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+		assert.Nil(t, p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, p.Stop(ctx), context.DeadlineExceeded)
+
+		// the stuck handler is released and the queued message left alone
+		assert.Eventually(t, func() bool { return p.Stats().InFlight == 0 }, time.Second, time.Millisecond)
+		p.wg.Wait()
+		assert.Equal(t, int32(1), ran.Load())
+		assert.Equal(t, 1, p.Stats().QueueDepth)
+	})
+
+	t.Run("RecoversPanic", func(t *testing.T) {
+		p := NewWorkerPool(PoolConfig{Workers: 1, QueueSize: 1},
+			func(ctx context.Context, rt Route, msg mqtt.Message) error {
+				panic("bad payload")
+			})
+		p.Start()
+
+		assert.Nil(t, p.Submit(route, &fakeMessage{topic: "dirtie/aa/breadcrumb"}))
+		assert.Nil(t, p.Stop(context.Background()))
+
+		assert.Equal(t, uint64(1), p.Stats().Failed)
+	})
+}
+
+func TestHandleMessageAcks(t *testing.T) {
+	msg := &fakeMessage{topic: "dirtie/aa/breadcrumb"}
+	route := Route{Filter: "dirtie/+/breadcrumb", Qos: defaultQos, Invoker: &stubInvoker{name: "brdcrm"}}
+
+	err := handleMessage(context.Background(), route, msg)
+
+	assert.Nil(t, err)
+	assert.True(t, msg.acked)
+}
+
+func TestHandleMessageDeadLetters(t *testing.T) {
+	dl := &fakeDeadLetters{}
+	deadLetters = dl
+	defer func() { deadLetters = nil }()
+
+	msg := &fakeMessage{topic: "dirtie/aa/breadcrumb", payload: []byte("{")}
+	route := Route{Filter: "dirtie/+/breadcrumb", Qos: defaultQos, Invoker: &failingInvoker{err: fmt.Errorf("bad payload")}}
+
+	err := handleMessage(context.Background(), route, msg)
+
+	assert.NotNil(t, err)
+	assert.True(t, msg.acked)
+	assert.Equal(t, []string{msg.topic}, dl.topics)
+}
+
+func TestHandleMessageDeadLettersAfterTimeout(t *testing.T) {
+	dl := &fakeDeadLetters{}
+	deadLetters = dl
+	defer func() { deadLetters = nil }()
+
+	msg := &fakeMessage{topic: "dirtie/aa/breadcrumb"}
+	route := Route{Filter: "dirtie/+/breadcrumb", Qos: defaultQos, Invoker: &failingInvoker{err: context.DeadlineExceeded}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := handleMessage(ctx, route, msg)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []string{msg.topic}, dl.topics)
+	assert.Equal(t, []error{nil}, dl.ctxErrs)
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 30 * time.Second}
+
+	assert.Equal(t, time.Second, b.Delay(0))
+	assert.Equal(t, 2*time.Second, b.Delay(1))
+	assert.Equal(t, 16*time.Second, b.Delay(4))
+	assert.Equal(t, 30*time.Second, b.Delay(5))
+	assert.Equal(t, 30*time.Second, b.Delay(500))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Delay(2)
+		assert.GreaterOrEqual(t, d, 4*time.Second)
+		assert.LessOrEqual(t, d, 6*time.Second)
+	}
+}
+
+func TestSupervisorStatus(t *testing.T) {
+	subscribeErr := fmt.Errorf("not authorized")
+	var fail bool
+	s := NewSupervisor(Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		func(c mqtt.Client) error {
+			if fail {
+				return subscribeErr
+			}
+			return nil
+		})
+
+	assert.Equal(t, StateDisconnected, s.Status().State)
+
+	s.handleConnect(nil)
+	assert.Equal(t, StateConnected, s.Status().State)
+	assert.True(t, s.Status().Subscribed)
+
+	s.handleConnectionLost(nil, fmt.Errorf("EOF"))
+	s.handleReconnecting(nil, nil)
+	s.handleReconnecting(nil, nil)
+	status := s.Status()
+	assert.Equal(t, StateReconnecting, status.State)
+	assert.False(t, status.Subscribed)
+	assert.Equal(t, 2, status.Attempts)
+	assert.Equal(t, "EOF", status.LastError)
+
+	fail = true
+	s.handleConnect(nil)
+	status = s.Status()
+	assert.Equal(t, StateConnected, status.State)
+	assert.False(t, status.Subscribed)
+	assert.Equal(t, 0, status.Attempts)
+	assert.Equal(t, uint64(1), status.Reconnects)
+	assert.Equal(t, subscribeErr.Error(), status.LastError)
+
+	s.Stop()
+	s.handleConnectionLost(nil, fmt.Errorf("EOF"))
+	assert.Equal(t, StateStopped, s.Status().State)
+}
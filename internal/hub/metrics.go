@@ -0,0 +1,60 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type MqttObserver interface {
+	ObserveMqttMessage(filter string, d time.Duration, err error)
+}
+
+type CollectorRegisterer interface {
+	Register(c prometheus.Collector) error
+}
+
+// registerCollectors exposes pool and connection state, read from
+// Stats and ConnectionStatus on every scrape
+func registerCollectors(r CollectorRegisterer) error {
+	collectors := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "mqtt",
+			Name:      "queue_depth",
+			Help:      "Messages waiting for a worker.",
+		}, func() float64 { return float64(Stats().QueueDepth) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "mqtt",
+			Name:      "in_flight",
+			Help:      "Messages currently being handled.",
+		}, func() float64 { return float64(Stats().InFlight) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "mqtt",
+			Name:      "dropped_total",
+			Help:      "Messages dropped because the worker queue was full.",
+		}, func() float64 { return float64(Stats().Dropped) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "mqtt",
+			Name:      "connected",
+			Help:      "1 while connected to the broker with subscriptions in place.",
+		}, func() float64 {
+			status := ConnectionStatus()
+			if status.State == StateConnected && status.Subscribed {
+				return 1
+			}
+			return 0
+		}),
+	}
+
+	for _, c := range collectors {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
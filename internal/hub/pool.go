@@ -0,0 +1,281 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+)
+
+type OverloadPolicy string
+
+const (
+	// Drop acks and discards messages while the queue is full
+	OverloadDrop OverloadPolicy = "drop"
+	// Block holds up the message's paho callback (and with it the QoS 1
+	// ack) until there is room, so the broker throttles delivery once
+	// its inflight window is used up
+	OverloadBlock OverloadPolicy = "block"
+)
+
+type PoolConfig struct {
+	Workers    int
+	QueueSize  int
+	TopicLimit int
+	Policy     OverloadPolicy
+	// deadline for handling a single message; none when zero
+	JobTimeout time.Duration
+}
+
+type PoolStats struct {
+	Workers        int               `json:"workers"`
+	QueueDepth     int               `json:"queueDepth"`
+	QueueCapacity  int               `json:"queueCapacity"`
+	InFlight       int64             `json:"inFlight"`
+	Processed      uint64            `json:"processed"`
+	Failed         uint64            `json:"failed"`
+	Dropped        uint64            `json:"dropped"`
+	DroppedByTopic map[string]uint64 `json:"droppedByTopic"`
+	Policy         OverloadPolicy    `json:"policy"`
+}
+
+type poolJob struct {
+	route Route
+	msg   mqtt.Message
+}
+
+type jobHandler func(ctx context.Context, route Route, msg mqtt.Message) error
+
+// WorkerPool moves topic handling off the paho callback goroutine.
+// Messages are queued per route and at most TopicLimit messages of a
+// single route are handled concurrently. Workers serve the routes
+// round robin and skip any at their limit, so a busy route can't hold
+// up the others.
+type WorkerPool struct {
+	cfg    PoolConfig
+	handle jobHandler
+
+	// parent of every job's context, cancelled when Stop gives up
+	// waiting so handlers stuck in flight let go of their workers
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// guards everything below. cond is broadcast whenever a message is
+	// queued or finished and when the pool stops, waking both workers
+	// and blocked submits.
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  map[string][]poolJob
+	order   []string
+	next    int
+	queued  int
+	running map[string]int
+	stopped bool
+
+	droppedByTopic map[string]uint64
+
+	inFlight  atomic.Int64
+	processed atomic.Uint64
+	failed    atomic.Uint64
+	dropped   atomic.Uint64
+
+	wg sync.WaitGroup
+}
+
+var (
+	ErrPoolFull    error = fmt.Errorf("MQTT worker queue full")
+	ErrPoolStopped error = fmt.Errorf("MQTT worker pool stopped")
+)
+
+func NewWorkerPool(cfg PoolConfig, handle jobHandler) *WorkerPool {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	// a message is queued before a worker takes it, so there must be
+	// room for at least one
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = 1
+	}
+	if cfg.TopicLimit <= 0 || cfg.TopicLimit > cfg.Workers {
+		cfg.TopicLimit = cfg.Workers
+	}
+	if cfg.Policy != OverloadDrop {
+		cfg.Policy = OverloadBlock
+	}
+
+	p := &WorkerPool{
+		cfg:            cfg,
+		handle:         handle,
+		queues:         make(map[string][]poolJob),
+		running:        make(map[string]int),
+		droppedByTopic: make(map[string]uint64),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p
+}
+
+func (p *WorkerPool) Start() {
+	for i := 0; i < p.cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+}
+
+// Submit queues a message for handling. Under OverloadDrop it returns
+// ErrPoolFull instead of waiting for room in the queue.
+func (p *WorkerPool) Submit(route Route, msg mqtt.Message) error {
+	filter := route.Filter
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for !p.stopped && p.queued >= p.cfg.QueueSize {
+		if p.cfg.Policy == OverloadDrop {
+			p.dropped.Add(1)
+			p.droppedByTopic[filter]++
+			return ErrPoolFull
+		}
+		p.cond.Wait()
+	}
+	if p.stopped {
+		return ErrPoolStopped
+	}
+
+	if _, ok := p.queues[filter]; !ok {
+		p.order = append(p.order, filter)
+	}
+	p.queues[filter] = append(p.queues[filter], poolJob{route: route, msg: msg})
+	p.queued++
+	p.cond.Broadcast()
+	return nil
+}
+
+// Stop lets workers drain the queue and waits for them. If ctx is done
+// first, in-flight handlers are cancelled and whatever is still queued
+// is left unacked. Submits after Stop return ErrPoolStopped.
+func (p *WorkerPool) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		queued := p.queued
+		p.cancel()
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		return fmt.Errorf("Error WorkerPool Stop - %d message(s) still queued: %w", queued, ctx.Err())
+	}
+}
+
+func (p *WorkerPool) Stats() PoolStats {
+	p.mu.Lock()
+	queued := p.queued
+	byTopic := make(map[string]uint64, len(p.droppedByTopic))
+	for k, v := range p.droppedByTopic {
+		byTopic[k] = v
+	}
+	p.mu.Unlock()
+
+	return PoolStats{
+		Workers:        p.cfg.Workers,
+		QueueDepth:     queued,
+		QueueCapacity:  p.cfg.QueueSize,
+		InFlight:       p.inFlight.Load(),
+		Processed:      p.processed.Load(),
+		Failed:         p.failed.Load(),
+		Dropped:        p.dropped.Load(),
+		DroppedByTopic: byTopic,
+		Policy:         p.cfg.Policy,
+	}
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.ctx.Err() != nil {
+			return
+		}
+		job, ok := p.take()
+		if !ok {
+			if p.stopped && p.queued == 0 {
+				return
+			}
+			p.cond.Wait()
+			continue
+		}
+		p.mu.Unlock()
+
+		p.inFlight.Add(1)
+		err := p.run(job)
+		p.inFlight.Add(-1)
+
+		p.processed.Add(1)
+		if err != nil {
+			p.failed.Add(1)
+		}
+
+		p.mu.Lock()
+		p.running[job.route.Filter]--
+		p.cond.Broadcast()
+	}
+}
+
+// take pops the oldest message of the next route, after the one last
+// served, that is under its limit. Called with mu held.
+func (p *WorkerPool) take() (poolJob, bool) {
+	for i := range p.order {
+		filter := p.order[(p.next+i)%len(p.order)]
+		q := p.queues[filter]
+		if len(q) == 0 || p.running[filter] >= p.cfg.TopicLimit {
+			continue
+		}
+
+		job := q[0]
+		q[0] = poolJob{}
+		p.queues[filter] = q[1:]
+		p.queued--
+		p.running[filter]++
+		p.next = (p.next + i + 1) % len(p.order)
+		return job, true
+	}
+	return poolJob{}, false
+}
+
+func (p *WorkerPool) run(job poolJob) (err error) {
+	// one bad payload shouldn't take a worker down with it
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic handling topic '%v': %v", job.msg.Topic(), r)
+			utils.LogErr(err.Error())
+		}
+	}()
+
+	ctx := p.ctx
+	if p.cfg.JobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.JobTimeout)
+		defer cancel()
+	}
+
+	return p.handle(ctx, job.route, job.msg)
+}
@@ -0,0 +1,50 @@
+// Outbound side of the mqtt hub. Lives in its own package so
+// services can publish without importing the hub (which depends on di).
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+type MqttPublisher struct {
+	mu     sync.RWMutex
+	client mqtt.Client
+}
+
+var ErrNotConnected error = fmt.Errorf("MQTT client not connected")
+
+func NewMqttPublisher() *MqttPublisher {
+	return &MqttPublisher{}
+}
+
+// SetClient is called by the hub once its client has been created
+func (p *MqttPublisher) SetClient(c mqtt.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.client = c
+}
+
+func (p *MqttPublisher) Publish(ctx context.Context, topic string, qos byte, payload []byte) error {
+	p.mu.RLock()
+	c := p.client
+	p.mu.RUnlock()
+
+	if c == nil || !c.IsConnectionOpen() {
+		return fmt.Errorf("Error MqttPublisher Publish '%v': %w", topic, ErrNotConnected)
+	}
+
+	token := c.Publish(topic, qos, false, payload)
+	select {
+	case <-token.Done():
+		if token.Error() != nil {
+			return fmt.Errorf("Error MqttPublisher Publish '%v': %w", topic, token.Error())
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Error MqttPublisher Publish '%v': %w", topic, ctx.Err())
+	}
+}
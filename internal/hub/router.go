@@ -0,0 +1,115 @@
+package hub
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type Route struct {
+	Filter  string
+	Qos     byte
+	Invoker TopicInvoker
+}
+
+// TopicRouter maps mqtt topic filters (including + and # wildcards)
+// to the TopicInvoker that handles them. Routes are matched in the
+// order they were registered.
+type TopicRouter struct {
+	mu     sync.RWMutex
+	routes []Route
+}
+
+var (
+	ErrInvalidTopicFilter error = fmt.Errorf("Invalid MQTT topic filter")
+	ErrDuplicateRoute     error = fmt.Errorf("MQTT topic filter already registered")
+)
+
+func NewTopicRouter() *TopicRouter {
+	return &TopicRouter{}
+}
+
+func (r *TopicRouter) Register(filter string, qos byte, ivk TopicInvoker) error {
+	if !validFilter(filter) || qos > 2 || ivk == nil {
+		return fmt.Errorf("Error TopicRouter Register '%v': %w", filter, ErrInvalidTopicFilter)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rt := range r.routes {
+		if rt.Filter == filter {
+			return fmt.Errorf("Error TopicRouter Register '%v': %w", filter, ErrDuplicateRoute)
+		}
+	}
+	r.routes = append(r.routes, Route{Filter: filter, Qos: qos, Invoker: ivk})
+	return nil
+}
+
+func (r *TopicRouter) Match(topic string) (TopicInvoker, error) {
+	rt, err := r.MatchRoute(topic)
+	if err != nil {
+		return nil, err
+	}
+	return rt.Invoker, nil
+}
+
+func (r *TopicRouter) MatchRoute(topic string) (Route, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, rt := range r.routes {
+		if matchTopic(rt.Filter, topic) {
+			return rt, nil
+		}
+	}
+	return Route{}, fmt.Errorf("Error TopicRouter Match '%v': %w", topic, ErrTopicNotFound)
+}
+
+// Subscriptions returns every registered filter with its QoS,
+// in the shape expected by mqtt.Client.SubscribeMultiple
+func (r *TopicRouter) Subscriptions() map[string]byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subs := make(map[string]byte, len(r.routes))
+	for _, rt := range r.routes {
+		subs[rt.Filter] = rt.Qos
+	}
+	return subs
+}
+
+func validFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, lvl := range levels {
+		if strings.Contains(lvl, "#") && (lvl != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(lvl, "+") && lvl != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTopic(filter string, topic string) bool {
+	fLevels := strings.Split(filter, "/")
+	tLevels := strings.Split(topic, "/")
+
+	for i, f := range fLevels {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tLevels) {
+			return false
+		}
+		if f != "+" && f != tLevels[i] {
+			return false
+		}
+	}
+	return len(fLevels) == len(tLevels)
+}
@@ -0,0 +1,228 @@
+package hub
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+)
+
+type ConnState string
+
+const (
+	StateDisconnected ConnState = "disconnected"
+	StateConnecting   ConnState = "connecting"
+	StateConnected    ConnState = "connected"
+	StateReconnecting ConnState = "reconnecting"
+	StateStopped      ConnState = "stopped"
+)
+
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	// Fraction of the delay added at random on top of it, so
+	// replicas that lost the broker together don't retry together
+	Jitter float64
+}
+
+// Delay returns the wait before retry number attempt (starting at 0):
+// Initial doubled per attempt, capped at Max, plus jitter
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.base(attempt)
+	return d + b.jitter(d)
+}
+
+func (b Backoff) base(attempt int) time.Duration {
+	d := b.Initial
+	for i := 0; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+func (b Backoff) jitter(d time.Duration) time.Duration {
+	if b.Jitter <= 0 || d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(float64(d)*b.Jitter) + 1))
+}
+
+type ConnStatus struct {
+	State      ConnState `json:"state"`
+	Since      time.Time `json:"since"`
+	Subscribed bool      `json:"subscribed"`
+	// failed attempts since the last successful connect
+	Attempts   int    `json:"attempts"`
+	Reconnects uint64 `json:"reconnects"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// Supervisor keeps the hub connected to the broker. It retries the
+// initial connect itself, since paho's ConnectRetry only supports a
+// fixed interval, and leaves reconnects after a lost connection to
+// paho's auto-reconnect, adding jitter before each attempt.
+type Supervisor struct {
+	backoff   Backoff
+	onConnect func(mqtt.Client) error
+
+	mu       sync.RWMutex
+	status   ConnStatus
+	connects uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewSupervisor(backoff Backoff, onConnect func(mqtt.Client) error) *Supervisor {
+	return &Supervisor{
+		backoff:   backoff,
+		onConnect: onConnect,
+		status:    ConnStatus{State: StateDisconnected, Since: time.Now()},
+		stop:      make(chan struct{}),
+	}
+}
+
+// Configure wires the supervisor into the client options. Call before
+// mqtt.NewClient.
+func (s *Supervisor) Configure(opts *mqtt.ClientOptions) {
+	opts.SetConnectRetry(false)
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(s.backoff.Max)
+	opts.SetOnConnectHandler(s.handleConnect)
+	opts.SetConnectionLostHandler(s.handleConnectionLost)
+	opts.SetReconnectingHandler(s.handleReconnecting)
+}
+
+// Connect retries the initial connect in the background until it
+// succeeds or the supervisor is stopped
+func (s *Supervisor) Connect(c mqtt.Client) {
+	go func() {
+		for attempt := 0; ; attempt++ {
+			s.setState(StateConnecting, nil)
+
+			token := c.Connect()
+			select {
+			case <-token.Done():
+			case <-s.stop:
+				return
+			}
+			if token.Error() == nil {
+				return
+			}
+
+			delay := s.backoff.Delay(attempt)
+			s.recordFailure(token.Error())
+			utils.LogErr(fmt.Sprintf("mqtt connect failed, retrying in %v: %v\n", delay, token.Error()))
+
+			select {
+			case <-time.After(delay):
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Supervisor) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.setState(StateStopped, nil)
+	})
+}
+
+func (s *Supervisor) Status() ConnStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+func (s *Supervisor) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Supervisor) handleConnect(c mqtt.Client) {
+	s.mu.Lock()
+	if s.connects > 0 {
+		s.status.Reconnects++
+	}
+	s.connects++
+	s.status.Attempts = 0
+	s.status.LastError = ""
+	s.mu.Unlock()
+	s.setState(StateConnected, nil)
+	utils.LogInfo("connected to mqtt broker\n")
+
+	// clean sessions drop subscriptions, so this runs on every (re)connect
+	err := s.onConnect(c)
+	s.mu.Lock()
+	s.status.Subscribed = err == nil
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		utils.LogErr(err.Error())
+	}
+}
+
+func (s *Supervisor) handleConnectionLost(c mqtt.Client, err error) {
+	utils.LogErr(fmt.Sprintf("disconnected from mqtt broker: %v\n", err))
+	s.setState(StateReconnecting, err)
+}
+
+// Called by paho before every reconnect attempt. Paho already backs off
+// exponentially between attempts, this only adds the jitter.
+func (s *Supervisor) handleReconnecting(c mqtt.Client, opts *mqtt.ClientOptions) {
+	s.mu.Lock()
+	attempt := s.status.Attempts
+	s.status.Attempts++
+	s.mu.Unlock()
+
+	if s.stopped() {
+		return
+	}
+	s.setState(StateReconnecting, nil)
+	utils.LogInfo(fmt.Sprintf("attempting to reconnect to mqtt broker (attempt %d)\n", attempt+1))
+
+	select {
+	case <-time.After(s.backoff.jitter(s.backoff.base(attempt))):
+	case <-s.stop:
+	}
+}
+
+func (s *Supervisor) recordFailure(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Attempts++
+	s.status.LastError = err.Error()
+}
+
+func (s *Supervisor) setState(state ConnState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status.State == StateStopped {
+		return
+	}
+	if s.status.State != state {
+		s.status.State = state
+		s.status.Since = time.Now()
+	}
+	if state != StateConnected {
+		s.status.Subscribed = false
+	}
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+}
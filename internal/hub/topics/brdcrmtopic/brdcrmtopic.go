@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
 	"github.com/frozenkro/dirtie-srv/internal/services"
 )
 
@@ -23,6 +24,11 @@ func (t *BrdCrmTopic) InvokeTopic(ctx context.Context, payload []byte) error {
 		return fmt.Errorf("Error BrdCrmTopic InvokeTopic -> Unmarshal: %w", err)
 	}
 
+	data.MacAddr, err = core_topics.ResolveMacAddr(ctx, data.MacAddr)
+	if err != nil {
+		return fmt.Errorf("Error BrdCrmTopic InvokeTopic -> ResolveMacAddr: %w", err)
+	}
+
 	err = t.brdCrmSvc.RecordBrdCrm(ctx, data)
 	if err != nil {
 		return fmt.Errorf("Error BrdCrmTopic InvokeTopic -> RecordBrdCrm: %w", err)
@@ -30,3 +36,32 @@ func (t *BrdCrmTopic) InvokeTopic(ctx context.Context, payload []byte) error {
 
 	return nil
 }
+
+// BrdCrmBatchTopic takes the readings a device stored while offline
+type BrdCrmBatchTopic struct {
+	brdCrmSvc services.BrdCrmSvc
+}
+
+func NewBrdCrmBatchTopic(brdCrmSvc services.BrdCrmSvc) *BrdCrmBatchTopic {
+	return &BrdCrmBatchTopic{brdCrmSvc: brdCrmSvc}
+}
+
+func (t *BrdCrmBatchTopic) InvokeTopic(ctx context.Context, payload []byte) error {
+	data := services.BreadCrumbBatch{}
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return fmt.Errorf("Error BrdCrmBatchTopic InvokeTopic -> Unmarshal: %w", err)
+	}
+
+	data.MacAddr, err = core_topics.ResolveMacAddr(ctx, data.MacAddr)
+	if err != nil {
+		return fmt.Errorf("Error BrdCrmBatchTopic InvokeTopic -> ResolveMacAddr: %w", err)
+	}
+
+	err = t.brdCrmSvc.RecordBrdCrmBatch(ctx, data)
+	if err != nil {
+		return fmt.Errorf("Error BrdCrmBatchTopic InvokeTopic -> RecordBrdCrmBatch: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package cmdacktopic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+type CommandAcker interface {
+	AckCommand(context.Context, services.CommandAckPayload) error
+}
+
+type CmdAckTopic struct {
+	ca CommandAcker
+}
+
+func NewCmdAckTopic(ca CommandAcker) *CmdAckTopic {
+	return &CmdAckTopic{ca: ca}
+}
+
+func (t *CmdAckTopic) InvokeTopic(ctx context.Context, payload []byte) error {
+	data := services.CommandAckPayload{}
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return fmt.Errorf("Error CmdAckTopic InvokeTopic -> Unmarshal: %w", err)
+	}
+
+	data.MacAddr, err = core_topics.ResolveMacAddr(ctx, data.MacAddr)
+	if err != nil {
+		return fmt.Errorf("Error CmdAckTopic InvokeTopic -> ResolveMacAddr: %w", err)
+	}
+
+	err = t.ca.AckCommand(ctx, data)
+	if err != nil {
+		return fmt.Errorf("Error CmdAckTopic InvokeTopic -> AckCommand: %w", err)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
 	"github.com/frozenkro/dirtie-srv/internal/services"
 )
 
@@ -29,6 +30,11 @@ func (t *LogDumpTopic) InvokeTopic(ctx context.Context, payload []byte) error {
 		return fmt.Errorf("Error LogDumpTopic InvokeTopic -> Unmarshal: %w", err)
 	}
 
+	data.MacAddr, err = core_topics.ResolveMacAddr(ctx, data.MacAddr)
+	if err != nil {
+		return fmt.Errorf("Error LogDumpTopic InvokeTopic -> ResolveMacAddr: %w", err)
+	}
+
 	err = t.ld.DumpLogs(ctx, data)
 	if err != nil {
 		return fmt.Errorf("Error LogDumpTopic InvokeTopic -> DumpLogs: %w", err)
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/frozenkro/dirtie-srv/internal/services"
 )
@@ -28,6 +29,11 @@ func (t *ProvisionTopic) InvokeTopic(ctx context.Context, payload []byte) error
 		return fmt.Errorf("Error ProvisionTopic InvokeTopic -> Unmarshal: %w", err)
 	}
 
+	data.MacAddr, err = core_topics.ResolveMacAddr(ctx, data.MacAddr)
+	if err != nil {
+		return fmt.Errorf("Error ProvisionTopic InvokeTopic -> ResolveMacAddr: %w", err)
+	}
+
 	_, err = t.dpc.CompleteDeviceProvision(ctx, data)
 	if err != nil {
 		return fmt.Errorf("Error ProvisionTopic InvokeTopic -> CompleteDeviceProvision: %w", err)
@@ -0,0 +1,57 @@
+package statustopic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
+	"github.com/frozenkro/dirtie-srv/internal/services"
+)
+
+type PresenceRecorder interface {
+	RecordPresence(context.Context, services.PresencePayload) error
+}
+
+// StatusTopic takes device birth and will messages. Either may be json
+// or just the bare status, since a will is fixed when the device
+// connects and is often kept minimal.
+type StatusTopic struct {
+	pr PresenceRecorder
+}
+
+func NewStatusTopic(pr PresenceRecorder) *StatusTopic {
+	return &StatusTopic{pr: pr}
+}
+
+func (t *StatusTopic) InvokeTopic(ctx context.Context, payload []byte) error {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 {
+		// a retained status being cleared
+		return nil
+	}
+
+	data := services.PresencePayload{}
+	if payload[0] == '{' {
+		err := json.Unmarshal(payload, &data)
+		if err != nil {
+			return fmt.Errorf("Error StatusTopic InvokeTopic -> Unmarshal: %w", err)
+		}
+	} else {
+		data.Status = string(payload)
+	}
+
+	var err error
+	data.MacAddr, err = core_topics.ResolveMacAddr(ctx, data.MacAddr)
+	if err != nil {
+		return fmt.Errorf("Error StatusTopic InvokeTopic -> ResolveMacAddr: %w", err)
+	}
+
+	err = t.pr.RecordPresence(ctx, data)
+	if err != nil {
+		return fmt.Errorf("Error StatusTopic InvokeTopic -> RecordPresence: %w", err)
+	}
+
+	return nil
+}
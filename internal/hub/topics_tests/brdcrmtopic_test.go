@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/core"
 	"github.com/frozenkro/dirtie-srv/internal/core/int_tst"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
+	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
 	"github.com/frozenkro/dirtie-srv/internal/di"
 	"github.com/frozenkro/dirtie-srv/internal/hub/topics/brdcrmtopic"
 	"github.com/frozenkro/dirtie-srv/internal/services"
@@ -24,9 +27,12 @@ func TestBrdCrmInvokeTopic(t *testing.T) {
 
 	t.Run("Success", func(t *testing.T) {
 		data := services.BreadCrumb{
-			MacAddr:     int_tst.TestDevice.MacAddr.String,
-			Capacitance: 1234,
-			Temperature: 69,
+			MacAddr: int_tst.TestDevice.MacAddr.String,
+			Readings: map[string]float64{
+				core.Capacitance:  1234,
+				core.Temperature:  69,
+				"battery_voltage": 3.7,
+			},
 		}
 		dBytes, err := json.Marshal(data)
 		if err != nil {
@@ -36,24 +42,97 @@ func TestBrdCrmInvokeTopic(t *testing.T) {
 		err = sut.InvokeTopic(ctx, dBytes)
 
 		assert.Nil(t, err, fmt.Sprintf("InvokeTopic error: %v", err))
+		deps.InfluxRepo.Flush()
 
 		capData, err := deps.InfluxRepo.GetLatestValue(ctx, int(int_tst.TestDevice.DeviceID), core.Capacitance)
 		if err != nil {
 			t.Errorf("Error retrieving capacitance data point: %v", err)
 		}
-		assert.Equal(t, data.Capacitance, capData.Value)
+		assert.Equal(t, data.Readings[core.Capacitance], capData.Value)
 
 		tempData, err := deps.InfluxRepo.GetLatestValue(ctx, int(int_tst.TestDevice.DeviceID), core.Temperature)
 		if err != nil {
 			t.Errorf("Error retrieving temperature data point: %v", err)
 		}
-		assert.Equal(t, data.Temperature, tempData.Value)
+		assert.Equal(t, data.Readings[core.Temperature], tempData.Value)
+
+		voltData, err := deps.InfluxRepo.GetLatestValue(ctx, int(int_tst.TestDevice.DeviceID), "battery_voltage")
+		if err != nil {
+			t.Errorf("Error retrieving battery_voltage data point: %v", err)
+		}
+		assert.Equal(t, data.Readings["battery_voltage"], voltData.Value)
+	})
+	t.Run("LegacyPayload", func(t *testing.T) {
+		payload := fmt.Sprintf(`{"macAddr":"%v","capacitance":4321,"temperature":70}`, int_tst.TestDevice.MacAddr.String)
+
+		err := sut.InvokeTopic(ctx, []byte(payload))
+
+		assert.Nil(t, err, fmt.Sprintf("InvokeTopic error: %v", err))
+		deps.InfluxRepo.Flush()
+
+		capData, err := deps.InfluxRepo.GetLatestValue(ctx, int(int_tst.TestDevice.DeviceID), core.Capacitance)
+		if err != nil {
+			t.Errorf("Error retrieving capacitance data point: %v", err)
+		}
+		assert.Equal(t, float64(4321), capData.Value)
+	})
+	t.Run("DeviceTopic", func(t *testing.T) {
+		data := services.BreadCrumb{
+			Readings: map[string]float64{
+				core.Capacitance: 4322,
+				core.Temperature: 70,
+			},
+		}
+		dBytes, err := json.Marshal(data)
+		if err != nil {
+			t.Errorf("Error encoding test breadcrumb: %v", err)
+		}
+
+		topicCtx := core_topics.WithTopicMacAddr(ctx, int_tst.TestDevice.MacAddr.String)
+		err = sut.InvokeTopic(topicCtx, dBytes)
+
+		assert.Nil(t, err, fmt.Sprintf("InvokeTopic error: %v", err))
+		deps.InfluxRepo.Flush()
+
+		capData, err := deps.InfluxRepo.GetLatestValue(ctx, int(int_tst.TestDevice.DeviceID), core.Capacitance)
+		if err != nil {
+			t.Errorf("Error retrieving capacitance data point: %v", err)
+		}
+		assert.Equal(t, data.Readings[core.Capacitance], capData.Value)
+	})
+	t.Run("OutOfRange", func(t *testing.T) {
+		data := services.BreadCrumb{
+			MacAddr:  int_tst.TestDevice.MacAddr.String,
+			Readings: map[string]float64{"humidity": 250},
+		}
+		dBytes, err := json.Marshal(data)
+		if err != nil {
+			t.Errorf("Error encoding test breadcrumb: %v", err)
+		}
+
+		err = sut.InvokeTopic(ctx, dBytes)
+
+		assert.ErrorIs(t, err, measurements.ErrInvalidReading)
+	})
+	t.Run("MacMismatch", func(t *testing.T) {
+		data := services.BreadCrumb{
+			MacAddr:  int_tst.TestDevice.MacAddr.String,
+			Readings: map[string]float64{core.Capacitance: 420},
+		}
+		dBytes, err := json.Marshal(data)
+		if err != nil {
+			t.Errorf("Error encoding test breadcrumb: %v", err)
+		}
+
+		topicCtx := core_topics.WithTopicMacAddr(ctx, "s0m30th3rm4c")
+		err = sut.InvokeTopic(topicCtx, dBytes)
+
+		assert.ErrorIs(t, err, core_topics.ErrMacMismatch)
 	})
 	t.Run("UnrecognizedDevice", func(t *testing.T) {
 		data := services.BreadCrumb{
-			MacAddr:     "d035n0t3x1st",
-			Capacitance: 420,
-			Temperature: 69,
+			MacAddr:  "d035n0t3x1st",
+			Readings: map[string]float64{core.Capacitance: 420},
 		}
 		dBytes, err := json.Marshal(data)
 		if err != nil {
@@ -66,3 +145,61 @@ func TestBrdCrmInvokeTopic(t *testing.T) {
 		assert.ErrorIs(t, err, services.ErrNoDevice)
 	})
 }
+
+func TestBrdCrmBatchInvokeTopic(t *testing.T) {
+	ctx := int_tst.TestContext(t)
+	db := int_tst.SetupTests(ctx, t)
+	defer db.Close(ctx)
+
+	deps := di.NewDeps(ctx)
+	sut := brdcrmtopic.NewBrdCrmBatchTopic(deps.BrdCrmSvc)
+	topicCtx := core_topics.WithTopicMacAddr(ctx, int_tst.TestDevice.MacAddr.String)
+
+	t.Run("Backfill", func(t *testing.T) {
+		// readings from a device that was offline, sent newest first
+		taken := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+		older, newer := taken.Unix(), taken.Add(time.Hour).Unix()
+		data := services.BreadCrumbBatch{
+			BreadCrumbs: []services.BreadCrumb{
+				{Readings: map[string]float64{core.Capacitance: 3002}, Timestamp: &newer},
+				{Readings: map[string]float64{core.Capacitance: 3001}, Timestamp: &older},
+			},
+		}
+		dBytes, err := json.Marshal(data)
+		if err != nil {
+			t.Errorf("Error encoding test batch: %v", err)
+		}
+
+		err = sut.InvokeTopic(topicCtx, dBytes)
+
+		assert.Nil(t, err, fmt.Sprintf("InvokeTopic error: %v", err))
+		deps.InfluxRepo.Flush()
+
+		points, err := deps.InfluxRepo.GetValuesRange(ctx, int(int_tst.TestDevice.DeviceID), core.Capacitance,
+			taken.Add(-time.Second), taken.Add(time.Hour+time.Second))
+		if err != nil {
+			t.Errorf("Error retrieving capacitance data points: %v", err)
+		}
+		if assert.Len(t, points, 2) {
+			assert.True(t, points[0].Time.Equal(taken))
+			assert.Equal(t, float64(3001), points[0].Value)
+			assert.Equal(t, float64(3002), points[1].Value)
+		}
+	})
+	t.Run("FutureTimestamp", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).Unix()
+		data := services.BreadCrumbBatch{
+			BreadCrumbs: []services.BreadCrumb{
+				{Readings: map[string]float64{core.Capacitance: 3003}, Timestamp: &future},
+			},
+		}
+		dBytes, err := json.Marshal(data)
+		if err != nil {
+			t.Errorf("Error encoding test batch: %v", err)
+		}
+
+		err = sut.InvokeTopic(topicCtx, dBytes)
+
+		assert.ErrorIs(t, err, services.ErrFutureTimestamp)
+	})
+}
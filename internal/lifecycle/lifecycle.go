@@ -0,0 +1,150 @@
+// Starts the app's long-running components in order and stops them
+// in reverse, each within its own deadline, so a SIGTERM from k3s
+// drains traffic instead of dropping it.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+type Component struct {
+	Name string
+	// Start must return once the component is up; long-running work
+	// belongs in its own goroutine. Nil means nothing to start.
+	Start func(ctx context.Context) error
+	// Nil means nothing to stop
+	Stop func(ctx context.Context) error
+	// Upper bound on Stop, on top of the deadline passed to Manager.Stop
+	StopTimeout time.Duration
+}
+
+type Manager struct {
+	mu         sync.Mutex
+	components []Component
+	started    []Component
+	ready      atomic.Bool
+	stopping   atomic.Bool
+}
+
+var (
+	ErrAlreadyStarted = fmt.Errorf("Lifecycle already started")
+	ErrDuplicateName  = fmt.Errorf("Lifecycle component name already registered")
+)
+
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+func (m *Manager) Add(c Component) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.started) > 0 {
+		return fmt.Errorf("Error Manager Add '%v': %w", c.Name, ErrAlreadyStarted)
+	}
+	for _, existing := range m.components {
+		if existing.Name == c.Name {
+			return fmt.Errorf("Error Manager Add '%v': %w", c.Name, ErrDuplicateName)
+		}
+	}
+	m.components = append(m.components, c)
+	return nil
+}
+
+// Start runs each component's Start in registration order. If one fails
+// the components already started are stopped again before returning.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	if len(m.started) > 0 {
+		m.mu.Unlock()
+		return ErrAlreadyStarted
+	}
+
+	for _, c := range m.components {
+		utils.LogInfo(fmt.Sprintf("starting %v\n", c.Name))
+		if c.Start != nil {
+			if err := c.Start(ctx); err != nil {
+				m.mu.Unlock()
+				startErr := fmt.Errorf("Error Manager Start -> %v: %w", c.Name, err)
+				if stopErr := m.Stop(context.Background()); stopErr != nil {
+					return errors.Join(startErr, stopErr)
+				}
+				return startErr
+			}
+		}
+		m.started = append(m.started, c)
+	}
+	m.mu.Unlock()
+
+	m.ready.Store(true)
+	utils.LogInfo("all components started\n")
+	return nil
+}
+
+// Ready is true between a successful Start and the beginning of Stop
+func (m *Manager) Ready() bool {
+	return m.ready.Load() && !m.stopping.Load()
+}
+
+// Stop flips readiness off, then stops started components in reverse
+// order. A component that misses its deadline doesn't prevent the rest
+// from being stopped.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.stopping.Store(true)
+	m.ready.Store(false)
+
+	m.mu.Lock()
+	started := m.started
+	m.started = nil
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		if c.Stop == nil {
+			continue
+		}
+
+		utils.LogInfo(fmt.Sprintf("stopping %v\n", c.Name))
+		if err := stopComponent(ctx, c); err != nil {
+			utils.LogErr(err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func stopComponent(ctx context.Context, c Component) error {
+	timeout := c.StopTimeout
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+	stopCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// run Stop on its own goroutine so a component that ignores
+	// its context can't hold up the rest of shutdown
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Stop(stopCtx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("Error Manager Stop -> %v: %w", c.Name, err)
+		}
+		return nil
+	case <-stopCtx.Done():
+		return fmt.Errorf("Error Manager Stop -> %v: %w", c.Name, stopCtx.Err())
+	}
+}
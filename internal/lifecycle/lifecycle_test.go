@@ -0,0 +1,94 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func recorder(events *[]string, name string) Component {
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			*events = append(*events, "start "+name)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			*events = append(*events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestManager(t *testing.T) {
+	t.Run("Order", func(t *testing.T) {
+		var events []string
+		m := NewManager()
+		assert.Nil(t, m.Add(recorder(&events, "db")))
+		assert.Nil(t, m.Add(recorder(&events, "hub")))
+		assert.Nil(t, m.Add(recorder(&events, "api")))
+
+		assert.False(t, m.Ready())
+		assert.Nil(t, m.Start(context.Background()))
+		assert.True(t, m.Ready())
+
+		assert.Nil(t, m.Stop(context.Background()))
+		assert.False(t, m.Ready())
+
+		assert.Equal(t, []string{
+			"start db", "start hub", "start api",
+			"stop api", "stop hub", "stop db",
+		}, events)
+	})
+
+	t.Run("DuplicateName", func(t *testing.T) {
+		var events []string
+		m := NewManager()
+		assert.Nil(t, m.Add(recorder(&events, "db")))
+		assert.ErrorIs(t, m.Add(recorder(&events, "db")), ErrDuplicateName)
+	})
+
+	t.Run("StartFailureRollsBack", func(t *testing.T) {
+		var events []string
+		startErr := fmt.Errorf("broker unreachable")
+		m := NewManager()
+		m.Add(recorder(&events, "db"))
+		m.Add(Component{
+			Name:  "hub",
+			Start: func(ctx context.Context) error { return startErr },
+			Stop: func(ctx context.Context) error {
+				events = append(events, "stop hub")
+				return nil
+			},
+		})
+		m.Add(recorder(&events, "api"))
+
+		err := m.Start(context.Background())
+
+		assert.ErrorIs(t, err, startErr)
+		assert.False(t, m.Ready())
+		assert.Equal(t, []string{"start db", "stop db"}, events)
+	})
+
+	t.Run("StopTimeout", func(t *testing.T) {
+		var events []string
+		m := NewManager()
+		m.Add(recorder(&events, "db"))
+		m.Add(Component{
+			Name: "stuck",
+			Stop: func(ctx context.Context) error {
+				select {}
+			},
+			StopTimeout: 10 * time.Millisecond,
+		})
+		assert.Nil(t, m.Start(context.Background()))
+
+		err := m.Stop(context.Background())
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, []string{"start db", "stop db"}, events)
+	})
+}
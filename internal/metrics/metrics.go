@@ -0,0 +1,198 @@
+// Prometheus metrics for the http api, mqtt hub and storage clients.
+// Each Metrics owns its own registry (rather than the global default)
+// so tests can build several sets of deps in one process.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const Namespace = "dirtie"
+
+type Metrics struct {
+	reg *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	mqttMessages *prometheus.CounterVec
+	mqttDuration *prometheus.HistogramVec
+	mqttErrors   *prometheus.CounterVec
+
+	brdcrmClockSkew prometheus.Histogram
+
+	influxPointsQueued  prometheus.Counter
+	influxWriteFailures prometheus.Counter
+
+	lokiPushes *prometheus.CounterVec
+
+	notifications *prometheus.CounterVec
+
+	deviceStatusChanges *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		reg: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+
+		// labelled by route filter rather than topic, since device
+		// topics carry a mac address and would be unbounded
+		mqttMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "mqtt",
+			Name:      "messages_total",
+			Help:      "MQTT messages handled by topic filter.",
+		}, []string{"filter"}),
+		mqttDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "mqtt",
+			Name:      "handler_duration_seconds",
+			Help:      "MQTT topic handler latency by topic filter.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"filter"}),
+		mqttErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "mqtt",
+			Name:      "handler_errors_total",
+			Help:      "MQTT topic handler failures by topic filter.",
+		}, []string{"filter"}),
+
+		brdcrmClockSkew: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "breadcrumb",
+			Name:      "clock_skew_seconds",
+			Help:      "Absolute difference between device timestamps and receive time for live breadcrumbs.",
+			Buckets:   []float64{1, 5, 15, 60, 300, 3600, 86400},
+		}),
+
+		influxPointsQueued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "influx",
+			Name:      "points_queued_total",
+			Help:      "Points handed to the batching Influx writer.",
+		}),
+		influxWriteFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "influx",
+			Name:      "write_failures_total",
+			Help:      "Failed Influx batch writes.",
+		}),
+
+		lokiPushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "loki",
+			Name:      "pushes_total",
+			Help:      "Loki log pushes by result.",
+		}, []string{"result"}),
+
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "notification",
+			Name:      "deliveries_total",
+			Help:      "Notification delivery attempts by channel and outcome.",
+		}, []string{"channel", "status"}),
+
+		deviceStatusChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "device",
+			Name:      "status_changes_total",
+			Help:      "Devices coming online or being marked offline.",
+		}, []string{"status"}),
+	}
+
+	m.reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.mqttMessages,
+		m.mqttDuration,
+		m.mqttErrors,
+		m.brdcrmClockSkew,
+		m.influxPointsQueued,
+		m.influxWriteFailures,
+		m.lokiPushes,
+		m.notifications,
+		m.deviceStatusChanges,
+	)
+	return m
+}
+
+// Register lets services add their own collectors
+func (m *Metrics) Register(c prometheus.Collector) error {
+	return m.reg.Register(c)
+}
+
+func (m *Metrics) MustRegister(cs ...prometheus.Collector) {
+	m.reg.MustRegister(cs...)
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{Registry: m.reg})
+}
+
+func (m *Metrics) ObserveHttp(route string, method string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(route, method, code).Inc()
+	m.httpDuration.WithLabelValues(route, method, code).Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveMqttMessage(filter string, d time.Duration, err error) {
+	m.mqttMessages.WithLabelValues(filter).Inc()
+	m.mqttDuration.WithLabelValues(filter).Observe(d.Seconds())
+	if err != nil {
+		m.mqttErrors.WithLabelValues(filter).Inc()
+	}
+}
+
+func (m *Metrics) ObserveClockSkew(skew time.Duration) {
+	m.brdcrmClockSkew.Observe(skew.Abs().Seconds())
+}
+
+func (m *Metrics) ObserveInfluxQueued(points int) {
+	m.influxPointsQueued.Add(float64(points))
+}
+
+func (m *Metrics) ObserveInfluxWriteError(err error) {
+	m.influxWriteFailures.Inc()
+}
+
+func (m *Metrics) ObserveLokiPush(err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.lokiPushes.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) ObserveNotification(channel string, status string) {
+	m.notifications.WithLabelValues(channel, status).Inc()
+}
+
+func (m *Metrics) ObserveDeviceStatus(online bool) {
+	status := "offline"
+	if online {
+		status = "online"
+	}
+	m.deviceStatusChanges.WithLabelValues(status).Inc()
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserve(t *testing.T) {
+	m := NewMetrics()
+
+	m.ObserveHttp("GET /devices/{id}", "GET", 200, time.Millisecond)
+	m.ObserveHttp("GET /devices/{id}", "GET", 200, time.Millisecond)
+	m.ObserveHttp("GET /devices/{id}", "GET", 404, time.Millisecond)
+	m.ObserveMqttMessage("dirtie/+/breadcrumb", time.Millisecond, nil)
+	m.ObserveMqttMessage("dirtie/+/breadcrumb", time.Millisecond, fmt.Errorf("bad payload"))
+	m.ObserveClockSkew(-2 * time.Minute)
+	m.ObserveInfluxQueued(3)
+	m.ObserveInfluxWriteError(fmt.Errorf("timeout"))
+	m.ObserveLokiPush(nil)
+	m.ObserveNotification("email", "sent")
+	m.ObserveDeviceStatus(false)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("GET /devices/{id}", "GET", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("GET /devices/{id}", "GET", "404")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.mqttMessages.WithLabelValues("dirtie/+/breadcrumb")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.mqttErrors.WithLabelValues("dirtie/+/breadcrumb")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.brdcrmClockSkew))
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.influxPointsQueued))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.influxWriteFailures))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.lokiPushes.WithLabelValues("ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.notifications.WithLabelValues("email", "sent")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.deviceStatusChanges.WithLabelValues("offline")))
+}
+
+func TestRegister(t *testing.T) {
+	m := NewMetrics()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: Namespace, Name: "test_gauge", Help: "test"})
+	g.Set(3)
+
+	assert.Nil(t, m.Register(g))
+	assert.NotNil(t, m.Register(g))
+
+	err := testutil.GatherAndCompare(m.reg, strings.NewReader(`
+# HELP dirtie_test_gauge test
+# TYPE dirtie_test_gauge gauge
+dirtie_test_gauge 3
+`), "dirtie_test_gauge")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,258 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/topics"
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+)
+
+type EmailSender interface {
+	SendEmail(ctx context.Context, emailAddress string, subject string, body string) error
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, topic string, qos byte, payload []byte) error
+}
+
+// EmailChannel sends to the preferred address, or the account address
+// when none is set. Account mail always goes to the account address,
+// since the preferred one is never verified and may carry reset links.
+type EmailChannel struct {
+	sender EmailSender
+}
+
+func NewEmailChannel(sender EmailSender) EmailChannel {
+	return EmailChannel{sender: sender}
+}
+
+func (c EmailChannel) Name() string {
+	return ChannelEmail
+}
+
+func (c EmailChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	addr := to.Target
+	if addr == "" || n.Kind == KindAccount {
+		addr = to.Email
+	}
+	if addr == "" {
+		return fmt.Errorf("Error EmailChannel Send (userId: %v): %w", to.UserId, ErrNoTarget)
+	}
+	return c.sender.SendEmail(ctx, addr, n.Subject, n.Body)
+}
+
+// WebhookChannel POSTs the notification as json to the user's url.
+// Any non-2xx response counts as a failed delivery, redirects
+// included. Urls that resolve to anything but a public address are
+// refused, both when saved and when dialled.
+type WebhookChannel struct {
+	client  *http.Client
+	checkIP func(ip net.IP) error
+}
+
+// ErrForbiddenTarget is returned for webhook urls that would reach the
+// server's own networks, such as loopback, private ranges or the cloud
+// metadata endpoint
+var ErrForbiddenTarget = fmt.Errorf("Webhook target is not a public address")
+
+// ranges to refuse on top of those net.IP already classifies
+var nonPublicNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade nat
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("64:ff9b::/96"), // nat64 can map onto any of the above
+}
+
+type webhookPayload struct {
+	Notification
+	UserId int32     `json:"userId"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+func NewWebhookChannel(timeout time.Duration) WebhookChannel {
+	return newWebhookChannel(timeout, checkPublicIP)
+}
+
+func newWebhookChannel(timeout time.Duration, checkIP func(ip net.IP) error) WebhookChannel {
+	dialer := &net.Dialer{
+		// runs on the address actually dialled, so a name that resolves
+		// somewhere else after ValidateTarget still can't get through
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return checkIP(net.ParseIP(host))
+		},
+	}
+	transport := &http.Transport{
+		// no proxy, so the dialler sees the real destination
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return WebhookChannel{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			// a public url could otherwise redirect to an internal one
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		checkIP: checkIP,
+	}
+}
+
+// ValidateTarget resolves the url's host and refuses it if any of its
+// addresses isn't public
+func (c WebhookChannel) ValidateTarget(ctx context.Context, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("Error WebhookChannel ValidateTarget -> LookupIPAddr: \n%w\n", err)
+	}
+	for _, addr := range addrs {
+		if err := c.checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPublicIP(ip net.IP) error {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("'%v': %w", ip, ErrForbiddenTarget)
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("'%v': %w", ip, ErrForbiddenTarget)
+		}
+	}
+	return nil
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func (c WebhookChannel) Name() string {
+	return ChannelWebhook
+}
+
+func (c WebhookChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	if to.Target == "" {
+		return fmt.Errorf("Error WebhookChannel Send (userId: %v): %w", to.UserId, ErrNoTarget)
+	}
+
+	body, err := json.Marshal(webhookPayload{Notification: n, UserId: to.UserId, SentAt: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("Error WebhookChannel Send -> Marshal: \n%w\n", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to.Target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error WebhookChannel Send -> NewRequest: \n%w\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error WebhookChannel Send -> Do: \n%w\n", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Error WebhookChannel Send: webhook responded %v", resp.Status)
+	}
+	return nil
+}
+
+// PushChannel relays notifications over mqtt to the user's app topic,
+// where the mobile app (or its push gateway) picks them up
+type PushChannel struct {
+	publisher Publisher
+}
+
+func NewPushChannel(publisher Publisher) PushChannel {
+	return PushChannel{publisher: publisher}
+}
+
+func (c PushChannel) Name() string {
+	return ChannelPush
+}
+
+func (c PushChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("Error PushChannel Send -> Marshal: \n%w\n", err)
+	}
+
+	topic := topics.UserTopic(strconv.Itoa(int(to.UserId)), topics.UserNotification)
+	return c.publisher.Publish(ctx, topic, 1, payload)
+}
+
+type Delivery struct {
+	To           Recipient
+	Notification Notification
+}
+
+// FakeChannel records notifications in memory and logs them instead of
+// delivering anything. Used in tests and for local development without
+// SendGrid credentials.
+type FakeChannel struct {
+	name string
+
+	mu   sync.Mutex
+	sent []Delivery
+	// returned from Send when set
+	Err error
+}
+
+func NewFakeChannel(name string) *FakeChannel {
+	return &FakeChannel{name: name}
+}
+
+func (c *FakeChannel) Name() string {
+	return c.name
+}
+
+func (c *FakeChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Err != nil {
+		return c.Err
+	}
+	c.sent = append(c.sent, Delivery{To: to, Notification: n})
+	utils.LogInfo(fmt.Sprintf("notification (%v) to user %v: %v\n", c.name, to.UserId, n.Subject))
+	return nil
+}
+
+// Sent returns a copy of everything delivered so far
+func (c *FakeChannel) Sent() []Delivery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Delivery(nil), c.sent...)
+}
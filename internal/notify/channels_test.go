@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSender struct {
+	to      string
+	subject string
+}
+
+func (s *recordingSender) SendEmail(ctx context.Context, emailAddress string, subject string, body string) error {
+	s.to, s.subject = emailAddress, subject
+	return nil
+}
+
+type recordingPublisher struct {
+	topic   string
+	payload []byte
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, topic string, qos byte, payload []byte) error {
+	p.topic, p.payload = topic, payload
+	return nil
+}
+
+func TestEmailChannel(t *testing.T) {
+	ctx := context.Background()
+	n := Notification{Kind: KindAlert, Subject: "dry"}
+
+	t.Run("AccountAddress", func(t *testing.T) {
+		sender := &recordingSender{}
+		err := NewEmailChannel(sender).Send(ctx, Recipient{UserId: 1, Email: "a@dirtie.app"}, n)
+		assert.Nil(t, err)
+		assert.Equal(t, "a@dirtie.app", sender.to)
+	})
+
+	t.Run("PreferredAddress", func(t *testing.T) {
+		sender := &recordingSender{}
+		err := NewEmailChannel(sender).Send(ctx, Recipient{UserId: 1, Email: "a@dirtie.app", Target: "b@dirtie.app"}, n)
+		assert.Nil(t, err)
+		assert.Equal(t, "b@dirtie.app", sender.to)
+	})
+
+	t.Run("AccountMailIgnoresPreferredAddress", func(t *testing.T) {
+		sender := &recordingSender{}
+		reset := Notification{Kind: KindAccount, Subject: "reset your password"}
+		err := NewEmailChannel(sender).Send(ctx, Recipient{UserId: 1, Email: "a@dirtie.app", Target: "b@dirtie.app"}, reset)
+		assert.Nil(t, err)
+		assert.Equal(t, "a@dirtie.app", sender.to)
+	})
+}
+
+func TestWebhookChannel(t *testing.T) {
+	ctx := context.Background()
+	n := Notification{Kind: KindAlert, Subject: "dry", DeviceId: 12}
+
+	t.Run("Success", func(t *testing.T) {
+		var got webhookPayload
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		err := newWebhookChannel(0, allowAnyIP).Send(ctx, Recipient{UserId: 3, Target: server.URL}, n)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int32(3), got.UserId)
+		assert.Equal(t, n.Subject, got.Subject)
+		assert.Equal(t, n.DeviceId, got.DeviceId)
+	})
+
+	t.Run("ErrorStatus", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		err := newWebhookChannel(0, allowAnyIP).Send(ctx, Recipient{UserId: 3, Target: server.URL}, n)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("NoTarget", func(t *testing.T) {
+		err := NewWebhookChannel(0).Send(ctx, Recipient{UserId: 3}, n)
+		assert.ErrorIs(t, err, ErrNoTarget)
+	})
+
+	t.Run("PrivateAddress", func(t *testing.T) {
+		called := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		defer server.Close()
+
+		err := NewWebhookChannel(0).Send(ctx, Recipient{UserId: 3, Target: server.URL}, n)
+
+		assert.ErrorIs(t, err, ErrForbiddenTarget)
+		assert.False(t, called)
+	})
+
+	t.Run("NoRedirects", func(t *testing.T) {
+		called := false
+		internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		defer internal.Close()
+		server := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusTemporaryRedirect))
+		defer server.Close()
+
+		err := newWebhookChannel(0, allowAnyIP).Send(ctx, Recipient{UserId: 3, Target: server.URL}, n)
+
+		assert.NotNil(t, err)
+		assert.False(t, called)
+	})
+}
+
+func TestWebhookValidateTarget(t *testing.T) {
+	ctx := context.Background()
+	ch := NewWebhookChannel(0)
+
+	forbidden := []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.20/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.100.100.200/",
+		"http://[::1]/hook",
+		"http://[fd00:ec2::254]/",
+		"http://[::ffff:127.0.0.1]/",
+		"http://0.0.0.0/",
+	}
+	for _, target := range forbidden {
+		assert.ErrorIs(t, ch.ValidateTarget(ctx, target), ErrForbiddenTarget, target)
+	}
+
+	assert.Nil(t, ch.ValidateTarget(ctx, "https://93.184.216.34/hook"))
+}
+
+func allowAnyIP(ip net.IP) error {
+	return nil
+}
+
+func TestPushChannel(t *testing.T) {
+	pub := &recordingPublisher{}
+	n := Notification{Kind: KindDevice, Subject: "offline", DeviceId: 12}
+
+	err := NewPushChannel(pub).Send(context.Background(), Recipient{UserId: 3}, n)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "dirtie-app/3/notifications", pub.topic)
+	var got Notification
+	assert.Nil(t, json.Unmarshal(pub.payload, &got))
+	assert.Equal(t, n.Subject, got.Subject)
+}
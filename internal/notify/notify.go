@@ -0,0 +1,51 @@
+// Outbound notification channels. Lives in its own package so channels
+// can be built in di and handed to the notification service without the
+// service knowing about SendGrid, http or mqtt.
+package notify
+
+import (
+	"context"
+	"fmt"
+)
+
+type Kind string
+
+const (
+	KindAlert  Kind = "alert"
+	KindDevice Kind = "device"
+	// password resets and the like; delivered by email only, and
+	// regardless of the user's preferences
+	KindAccount Kind = "account"
+)
+
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelPush    = "push"
+)
+
+var ErrNoTarget = fmt.Errorf("No delivery target for channel")
+
+type Notification struct {
+	Kind    Kind   `json:"kind"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// zero for account notifications
+	DeviceId int32 `json:"deviceId,omitempty"`
+	// notifications sharing a key are sent at most once per dedupe
+	// window; empty to always send
+	DedupeKey string `json:"-"`
+}
+
+type Recipient struct {
+	UserId int32
+	Email  string
+	// from the user's preferences for the channel; channels fall
+	// back to a default when it is empty
+	Target string
+}
+
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, to Recipient, n Notification) error
+}
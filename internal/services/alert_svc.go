@@ -0,0 +1,405 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	AlertStateOk = "ok"
+	// breached, but not yet for DurationSec
+	AlertStatePending = "pending"
+	AlertStateFiring  = "firing"
+)
+
+const (
+	AlertEventFired    = "fired"
+	AlertEventResolved = "resolved"
+)
+
+var (
+	maxAlertDuration             = 7 * 24 * time.Hour
+	maxAlertSnooze               = 30 * 24 * time.Hour
+	defaultAlertEventLimit int32 = 50
+	maxAlertEventLimit     int32 = 500
+)
+
+var (
+	ErrInvalidAlertRule  = fmt.Errorf("Invalid alert rule")
+	ErrAlertRuleNotFound = fmt.Errorf("Alert rule not found")
+)
+
+type AlertRuleReader interface {
+	GetAlertRule(ctx context.Context, ruleId int32) (sqlc.AlertRule, error)
+	GetAlertRulesByDevice(ctx context.Context, deviceId int32) ([]sqlc.AlertRule, error)
+	GetEnabledAlertRules(ctx context.Context, deviceId int32) ([]sqlc.AlertRule, error)
+	GetAlertEventsByDevice(ctx context.Context, deviceId int32, limit int32) ([]sqlc.AlertEvent, error)
+}
+type AlertRuleWriter interface {
+	CreateAlertRule(ctx context.Context, rule sqlc.AlertRule) (sqlc.AlertRule, error)
+	UpdateAlertRule(ctx context.Context, rule sqlc.AlertRule) (sqlc.AlertRule, error)
+	UpdateAlertRuleState(ctx context.Context, ruleId int32, state string, pendingSince time.Time, evaluatedAt time.Time) (bool, error)
+	SnoozeAlertRule(ctx context.Context, ruleId int32, until time.Time) error
+	DeleteAlertRule(ctx context.Context, ruleId int32) error
+	CreateAlertEvent(ctx context.Context, ruleId int32, deviceId int32, kind string, value float64, snoozed bool, occurredAt time.Time) (sqlc.AlertEvent, error)
+}
+
+type AlertSvc struct {
+	ruleReader       AlertRuleReader
+	ruleWriter       AlertRuleWriter
+	userDeviceGetter UserDeviceGetter
+	measurements     MeasurementRegistry
+	notifier         Notifier
+}
+
+// Sent by the user via rest api. The rule is breached while a reading
+// is below Min or above Max, and fires once it has been breached for
+// DurationSec. A firing rule resolves once the reading is back inside
+// the bounds by at least Hysteresis, so a value hovering around a
+// threshold doesn't fire over and over.
+type AlertRuleRequest struct {
+	Name        string   `json:"name"`
+	Measurement string   `json:"measurement"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	DurationSec int      `json:"durationSec"`
+	Hysteresis  float64  `json:"hysteresis"`
+	// defaults to true
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+type SnoozeRequest struct {
+	// 0 clears the snooze
+	DurationSec int `json:"durationSec"`
+}
+
+func NewAlertSvc(ruleReader AlertRuleReader,
+	ruleWriter AlertRuleWriter,
+	userDeviceGetter UserDeviceGetter,
+	registry MeasurementRegistry,
+	notifier Notifier) AlertSvc {
+
+	return AlertSvc{
+		ruleReader:       ruleReader,
+		ruleWriter:       ruleWriter,
+		userDeviceGetter: userDeviceGetter,
+		measurements:     registry,
+		notifier:         notifier,
+	}
+}
+
+func (s AlertSvc) GetRules(ctx context.Context, deviceId int32) ([]sqlc.AlertRule, error) {
+	device, err := s.userDeviceGetter.GetUserDevice(ctx, deviceId)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetRules -> GetUserDevice: \n%w\n", err)
+	}
+
+	rules, err := s.ruleReader.GetAlertRulesByDevice(ctx, device.DeviceID)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetRules -> GetAlertRulesByDevice: \n%w\n", err)
+	}
+	return rules, nil
+}
+
+func (s AlertSvc) GetRule(ctx context.Context, deviceId int32, ruleId int32) (sqlc.AlertRule, error) {
+	device, err := s.userDeviceGetter.GetUserDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error GetRule -> GetUserDevice: \n%w\n", err)
+	}
+	return s.deviceRule(ctx, device.DeviceID, ruleId)
+}
+
+func (s AlertSvc) CreateRule(ctx context.Context, deviceId int32, req AlertRuleRequest) (sqlc.AlertRule, error) {
+	rule, err := s.ruleFromRequest(req)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error CreateRule -> ruleFromRequest: \n%w\n", err)
+	}
+
+	device, err := s.userDeviceGetter.GetEditableDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error CreateRule -> GetEditableDevice: \n%w\n", err)
+	}
+	rule.DeviceID = device.DeviceID
+
+	rule, err = s.ruleWriter.CreateAlertRule(ctx, rule)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error CreateRule -> CreateAlertRule: \n%w\n", err)
+	}
+	return rule, nil
+}
+
+// UpdateRule replaces every setting of the rule. Its state is reset,
+// so a firing rule fires again if the new thresholds are still breached.
+func (s AlertSvc) UpdateRule(ctx context.Context, deviceId int32, ruleId int32, req AlertRuleRequest) (sqlc.AlertRule, error) {
+	rule, err := s.ruleFromRequest(req)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error UpdateRule -> ruleFromRequest: \n%w\n", err)
+	}
+
+	existing, err := s.editableRule(ctx, deviceId, ruleId)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error UpdateRule -> editableRule: \n%w\n", err)
+	}
+	rule.RuleID = existing.RuleID
+
+	rule, err = s.ruleWriter.UpdateAlertRule(ctx, rule)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error UpdateRule -> UpdateAlertRule: \n%w\n", err)
+	}
+	return rule, nil
+}
+
+func (s AlertSvc) DeleteRule(ctx context.Context, deviceId int32, ruleId int32) error {
+	rule, err := s.editableRule(ctx, deviceId, ruleId)
+	if err != nil {
+		return fmt.Errorf("Error DeleteRule -> editableRule: \n%w\n", err)
+	}
+
+	err = s.ruleWriter.DeleteAlertRule(ctx, rule.RuleID)
+	if err != nil {
+		return fmt.Errorf("Error DeleteRule -> DeleteAlertRule: \n%w\n", err)
+	}
+	return nil
+}
+
+// SnoozeRule keeps evaluating the rule but marks its events as snoozed
+// until the snooze runs out
+func (s AlertSvc) SnoozeRule(ctx context.Context, deviceId int32, ruleId int32, req SnoozeRequest) (sqlc.AlertRule, error) {
+	duration := time.Duration(req.DurationSec) * time.Second
+	if duration < 0 || duration > maxAlertSnooze {
+		return sqlc.AlertRule{}, fmt.Errorf("Error SnoozeRule (durationSec must be between 0 and %v): \n%w\n",
+			maxAlertSnooze.Seconds(), ErrInvalidAlertRule)
+	}
+
+	rule, err := s.editableRule(ctx, deviceId, ruleId)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error SnoozeRule -> editableRule: \n%w\n", err)
+	}
+
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	err = s.ruleWriter.SnoozeAlertRule(ctx, rule.RuleID, until)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error SnoozeRule -> SnoozeAlertRule: \n%w\n", err)
+	}
+	rule.SnoozedUntil = pgtype.Timestamptz{Time: until, Valid: !until.IsZero()}
+	return rule, nil
+}
+
+// GetHistory returns the device's most recent alert events, newest first
+func (s AlertSvc) GetHistory(ctx context.Context, deviceId int32, limit int32) ([]sqlc.AlertEvent, error) {
+	if limit <= 0 {
+		limit = defaultAlertEventLimit
+	}
+	if limit > maxAlertEventLimit {
+		limit = maxAlertEventLimit
+	}
+
+	device, err := s.userDeviceGetter.GetUserDevice(ctx, deviceId)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetHistory -> GetUserDevice: \n%w\n", err)
+	}
+
+	events, err := s.ruleReader.GetAlertEventsByDevice(ctx, device.DeviceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetHistory -> GetAlertEventsByDevice: \n%w\n", err)
+	}
+	return events, nil
+}
+
+// EvaluateAlerts runs a device's readings taken at ts through its
+// enabled rules and returns the events raised. Readings older than the
+// last one evaluated against a rule are ignored by that rule. The
+// device owner is notified of every event raised outside a snooze.
+func (s AlertSvc) EvaluateAlerts(ctx context.Context, device sqlc.Device, readings map[string]float64, ts time.Time) ([]sqlc.AlertEvent, error) {
+	deviceId := device.DeviceID
+	rules, err := s.ruleReader.GetEnabledAlertRules(ctx, deviceId)
+	if err != nil {
+		return nil, fmt.Errorf("Error EvaluateAlerts -> GetEnabledAlertRules: \n%w\n", err)
+	}
+
+	var events []sqlc.AlertEvent
+	for _, rule := range rules {
+		value, ok := readings[rule.Measurement]
+		if !ok {
+			continue
+		}
+		if rule.EvaluatedAt.Valid && ts.Before(rule.EvaluatedAt.Time) {
+			continue
+		}
+
+		state, pendingSince, kind := nextAlertState(rule, value, ts)
+		applied, err := s.ruleWriter.UpdateAlertRuleState(ctx, rule.RuleID, state, pendingSince, ts)
+		if err != nil {
+			return events, fmt.Errorf("Error EvaluateAlerts -> UpdateAlertRuleState (ruleId: %v): \n%w\n", rule.RuleID, err)
+		}
+		if !applied || kind == "" {
+			// a newer reading got there first
+			continue
+		}
+
+		snoozed := rule.SnoozedUntil.Valid && time.Now().Before(rule.SnoozedUntil.Time)
+		event, err := s.ruleWriter.CreateAlertEvent(ctx, rule.RuleID, deviceId, kind, value, snoozed, ts)
+		if err != nil {
+			return events, fmt.Errorf("Error EvaluateAlerts -> CreateAlertEvent (ruleId: %v): \n%w\n", rule.RuleID, err)
+		}
+		utils.LogInfo(fmt.Sprintf("alert rule %v '%v' on device %v %v at %v\n", rule.RuleID, rule.Name, deviceId, kind, value))
+		events = append(events, event)
+
+		if !snoozed {
+			s.notifyAlert(ctx, device, rule, event)
+		}
+	}
+	return events, nil
+}
+
+// notifyAlert tells the device owner about an event. Delivery problems
+// are logged rather than returned since the event is already recorded.
+func (s AlertSvc) notifyAlert(ctx context.Context, device sqlc.Device, rule sqlc.AlertRule, event sqlc.AlertEvent) {
+	if s.notifier == nil {
+		return
+	}
+
+	deviceName := device.DisplayName.String
+	if deviceName == "" {
+		deviceName = fmt.Sprintf("Device %v", device.DeviceID)
+	}
+	body := fmt.Sprintf("%v is back to %v.", rule.Measurement, event.Value)
+	if event.Kind == AlertEventFired {
+		body = fmt.Sprintf("%v is %v, outside %v.", rule.Measurement, event.Value, alertBounds(rule))
+	}
+
+	n := notify.Notification{
+		Kind:     notify.KindAlert,
+		Subject:  fmt.Sprintf("%v: %v %v", deviceName, rule.Name, event.Kind),
+		Body:     body,
+		DeviceId: device.DeviceID,
+		// a rule flapping across its threshold notifies once per window
+		DedupeKey: fmt.Sprintf("alert:%v:%v", rule.RuleID, event.Kind),
+	}
+	if err := s.notifier.Notify(ctx, device.UserID, n); err != nil {
+		utils.LogErr(fmt.Sprintf("Error EvaluateAlerts -> Notify (ruleId: %v): %v\n", rule.RuleID, err))
+	}
+}
+
+func alertBounds(rule sqlc.AlertRule) string {
+	switch {
+	case rule.MinValue.Valid && rule.MaxValue.Valid:
+		return fmt.Sprintf("%v to %v", rule.MinValue.Float64, rule.MaxValue.Float64)
+	case rule.MinValue.Valid:
+		return fmt.Sprintf("minimum %v", rule.MinValue.Float64)
+	default:
+		return fmt.Sprintf("maximum %v", rule.MaxValue.Float64)
+	}
+}
+
+// nextAlertState moves a rule through ok -> pending -> firing -> ok for
+// a reading taken at ts, returning the event kind when it fires or
+// resolves
+func nextAlertState(rule sqlc.AlertRule, value float64, ts time.Time) (state string, pendingSince time.Time, event string) {
+	breached := (rule.MinValue.Valid && value < rule.MinValue.Float64) ||
+		(rule.MaxValue.Valid && value > rule.MaxValue.Float64)
+	cleared := (!rule.MinValue.Valid || value >= rule.MinValue.Float64+rule.Hysteresis) &&
+		(!rule.MaxValue.Valid || value <= rule.MaxValue.Float64-rule.Hysteresis)
+	duration := time.Duration(rule.DurationSec) * time.Second
+
+	switch rule.State {
+	case AlertStateFiring:
+		if cleared {
+			return AlertStateOk, time.Time{}, AlertEventResolved
+		}
+		return AlertStateFiring, time.Time{}, ""
+	case AlertStatePending:
+		if !breached {
+			return AlertStateOk, time.Time{}, ""
+		}
+		pendingSince = rule.PendingSince.Time
+	default:
+		if !breached {
+			return AlertStateOk, time.Time{}, ""
+		}
+		pendingSince = ts
+	}
+
+	if ts.Sub(pendingSince) >= duration {
+		return AlertStateFiring, time.Time{}, AlertEventFired
+	}
+	return AlertStatePending, pendingSince, ""
+}
+
+// editableRule is GetRule for users about to change the rule
+func (s AlertSvc) editableRule(ctx context.Context, deviceId int32, ruleId int32) (sqlc.AlertRule, error) {
+	device, err := s.userDeviceGetter.GetEditableDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error editableRule -> GetEditableDevice: \n%w\n", err)
+	}
+	return s.deviceRule(ctx, device.DeviceID, ruleId)
+}
+
+func (s AlertSvc) deviceRule(ctx context.Context, deviceId int32, ruleId int32) (sqlc.AlertRule, error) {
+	rule, err := s.ruleReader.GetAlertRule(ctx, ruleId)
+	if err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("Error deviceRule -> GetAlertRule: \n%w\n", err)
+	}
+	if rule.RuleID <= 0 || rule.DeviceID != deviceId {
+		return sqlc.AlertRule{}, fmt.Errorf("Error deviceRule (ruleId: %v): \n%w\n", ruleId, ErrAlertRuleNotFound)
+	}
+	return rule, nil
+}
+
+func (s AlertSvc) ruleFromRequest(req AlertRuleRequest) (sqlc.AlertRule, error) {
+	if _, err := s.measurements.Get(req.Measurement); err != nil {
+		return sqlc.AlertRule{}, fmt.Errorf("%w: %w", err, ErrInvalidAlertRule)
+	}
+	if req.Min == nil && req.Max == nil {
+		return sqlc.AlertRule{}, fmt.Errorf("min or max is required: %w", ErrInvalidAlertRule)
+	}
+	for _, b := range []*float64{req.Min, req.Max} {
+		if b != nil && (math.IsNaN(*b) || math.IsInf(*b, 0)) {
+			return sqlc.AlertRule{}, fmt.Errorf("min and max must be numbers: %w", ErrInvalidAlertRule)
+		}
+	}
+	if req.Min != nil && req.Max != nil && *req.Min >= *req.Max {
+		return sqlc.AlertRule{}, fmt.Errorf("min must be below max: %w", ErrInvalidAlertRule)
+	}
+	if req.Hysteresis < 0 || math.IsNaN(req.Hysteresis) ||
+		(req.Min != nil && req.Max != nil && req.Hysteresis*2 >= *req.Max-*req.Min) {
+		return sqlc.AlertRule{}, fmt.Errorf("hysteresis must be positive and under half the min-max range: %w", ErrInvalidAlertRule)
+	}
+	duration := time.Duration(req.DurationSec) * time.Second
+	if duration < 0 || duration > maxAlertDuration {
+		return sqlc.AlertRule{}, fmt.Errorf("durationSec must be between 0 and %v: %w", maxAlertDuration.Seconds(), ErrInvalidAlertRule)
+	}
+
+	name := req.Name
+	if name == "" {
+		name = req.Measurement
+	}
+	if len(name) > 250 {
+		return sqlc.AlertRule{}, fmt.Errorf("name is longer than 250 characters: %w", ErrInvalidAlertRule)
+	}
+
+	rule := sqlc.AlertRule{
+		Name:        name,
+		Measurement: req.Measurement,
+		DurationSec: int32(req.DurationSec),
+		Hysteresis:  req.Hysteresis,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+	}
+	if req.Min != nil {
+		rule.MinValue = pgtype.Float8{Float64: *req.Min, Valid: true}
+	}
+	if req.Max != nil {
+		rule.MaxValue = pgtype.Float8{Float64: *req.Max, Valid: true}
+	}
+	return rule, nil
+}
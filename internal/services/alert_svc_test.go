@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
+	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	alertReader    mocks.MockAlertRuleReader
+	alertWriter    mocks.MockAlertRuleWriter
+	alertDevGetter mocks.MockUserDeviceGetter
+	alertNotifier  mocks.MockNotifier
+	alertSvc       AlertSvc
+)
+
+func setupAlertSvcTests() {
+	alertReader = mocks.MockAlertRuleReader{Mock: new(mock.Mock)}
+	alertWriter = mocks.MockAlertRuleWriter{Mock: new(mock.Mock)}
+	alertDevGetter = mocks.MockUserDeviceGetter{Mock: new(mock.Mock)}
+	alertNotifier = mocks.MockNotifier{Mock: new(mock.Mock)}
+	registry, _ = measurements.NewRegistry(measurements.Defaults...)
+
+	alertSvc = NewAlertSvc(alertReader, alertWriter, alertDevGetter, registry, alertNotifier)
+}
+
+func float8(v float64) pgtype.Float8 {
+	return pgtype.Float8{Float64: v, Valid: true}
+}
+
+func TestNextAlertState(t *testing.T) {
+	now := time.Now()
+	// dry soil: capacitance below 300 for 10 minutes, clears at 320
+	dry := sqlc.AlertRule{MinValue: float8(300), DurationSec: 600, Hysteresis: 20}
+	// temperature outside 5-30, immediately
+	temp := sqlc.AlertRule{MinValue: float8(5), MaxValue: float8(30)}
+
+	withState := func(r sqlc.AlertRule, state string, pendingSince time.Time) sqlc.AlertRule {
+		r.State = state
+		r.PendingSince = pgtype.Timestamptz{Time: pendingSince, Valid: !pendingSince.IsZero()}
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		rule    sqlc.AlertRule
+		value   float64
+		state   string
+		pending time.Time
+		event   string
+	}{
+		{"OkStaysOk", withState(dry, AlertStateOk, time.Time{}), 350, AlertStateOk, time.Time{}, ""},
+		{"OkToPending", withState(dry, AlertStateOk, time.Time{}), 250, AlertStatePending, now, ""},
+		{"PendingHolds", withState(dry, AlertStatePending, now.Add(-5*time.Minute)), 250, AlertStatePending, now.Add(-5 * time.Minute), ""},
+		{"PendingFires", withState(dry, AlertStatePending, now.Add(-10*time.Minute)), 250, AlertStateFiring, time.Time{}, AlertEventFired},
+		{"PendingRecovers", withState(dry, AlertStatePending, now.Add(-5*time.Minute)), 310, AlertStateOk, time.Time{}, ""},
+		{"FiringInsideHysteresis", withState(dry, AlertStateFiring, time.Time{}), 310, AlertStateFiring, time.Time{}, ""},
+		{"FiringResolves", withState(dry, AlertStateFiring, time.Time{}), 320, AlertStateOk, time.Time{}, AlertEventResolved},
+		{"NoDurationFiresAtOnce", withState(temp, AlertStateOk, time.Time{}), 31, AlertStateFiring, time.Time{}, AlertEventFired},
+		{"BelowRange", withState(temp, AlertStateOk, time.Time{}), 4, AlertStateFiring, time.Time{}, AlertEventFired},
+		{"InRange", withState(temp, AlertStateOk, time.Time{}), 20, AlertStateOk, time.Time{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, pending, event := nextAlertState(tt.rule, tt.value, now)
+			assert.Equal(t, tt.state, state)
+			assert.True(t, tt.pending.Equal(pending), "pendingSince %v, want %v", pending, tt.pending)
+			assert.Equal(t, tt.event, event)
+		})
+	}
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	ctx := context.Background()
+	deviceId := int32(12)
+	dvc := sqlc.Device{DeviceID: deviceId, UserID: 3, DisplayName: pgtype.Text{String: "Fern", Valid: true}}
+	now := time.Now()
+	readings := map[string]float64{core.Capacitance: 250, core.Temperature: 20}
+
+	dry := sqlc.AlertRule{
+		RuleID:      1,
+		DeviceID:    deviceId,
+		Measurement: core.Capacitance,
+		MinValue:    float8(300),
+		State:       AlertStateOk,
+	}
+
+	t.Run("Fires", func(t *testing.T) {
+		setupAlertSvcTests()
+		unrelated := sqlc.AlertRule{RuleID: 2, DeviceID: deviceId, Measurement: "humidity", MinValue: float8(30)}
+		alertReader.On("GetEnabledAlertRules", ctx, deviceId).Return([]sqlc.AlertRule{dry, unrelated}, nil)
+		alertWriter.On("UpdateAlertRuleState", ctx, dry.RuleID, AlertStateFiring, time.Time{}, now).Return(true, nil)
+		alertWriter.On("CreateAlertEvent", ctx, dry.RuleID, deviceId, AlertEventFired, 250.0, false, now).
+			Return(sqlc.AlertEvent{EventID: 5, Kind: AlertEventFired, Value: 250}, nil)
+		alertNotifier.On("Notify", ctx, dvc.UserID, mock.Anything).Return(nil)
+
+		events, err := alertSvc.EvaluateAlerts(ctx, dvc, readings, now)
+
+		assert.Nil(t, err)
+		assert.Len(t, events, 1)
+		alertWriter.AssertNumberOfCalls(t, "UpdateAlertRuleState", 1)
+		alertNotifier.AssertCalled(t, "Notify", ctx, dvc.UserID, mock.MatchedBy(func(n notify.Notification) bool {
+			return n.Kind == notify.KindAlert &&
+				n.DeviceId == deviceId &&
+				strings.HasPrefix(n.Subject, "Fern:") &&
+				n.DedupeKey == "alert:1:fired"
+		}))
+	})
+
+	t.Run("NotifyFailure", func(t *testing.T) {
+		setupAlertSvcTests()
+		alertReader.On("GetEnabledAlertRules", ctx, deviceId).Return([]sqlc.AlertRule{dry}, nil)
+		alertWriter.On("UpdateAlertRuleState", ctx, dry.RuleID, AlertStateFiring, time.Time{}, now).Return(true, nil)
+		alertWriter.On("CreateAlertEvent", ctx, dry.RuleID, deviceId, AlertEventFired, 250.0, false, now).
+			Return(sqlc.AlertEvent{EventID: 5, Kind: AlertEventFired}, nil)
+		alertNotifier.On("Notify", ctx, dvc.UserID, mock.Anything).Return(ErrNotificationFailed)
+
+		// the event is recorded either way
+		events, err := alertSvc.EvaluateAlerts(ctx, dvc, readings, now)
+
+		assert.Nil(t, err)
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("Snoozed", func(t *testing.T) {
+		setupAlertSvcTests()
+		snoozed := dry
+		snoozed.SnoozedUntil = pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true}
+		alertReader.On("GetEnabledAlertRules", ctx, deviceId).Return([]sqlc.AlertRule{snoozed}, nil)
+		alertWriter.On("UpdateAlertRuleState", ctx, dry.RuleID, AlertStateFiring, time.Time{}, now).Return(true, nil)
+		alertWriter.On("CreateAlertEvent", ctx, dry.RuleID, deviceId, AlertEventFired, 250.0, true, now).
+			Return(sqlc.AlertEvent{EventID: 6, Snoozed: true}, nil)
+
+		events, err := alertSvc.EvaluateAlerts(ctx, dvc, readings, now)
+
+		assert.Nil(t, err)
+		// history is kept while snoozed, but nobody is told
+		assert.Len(t, events, 1)
+		alertWriter.AssertExpectations(t)
+		alertNotifier.AssertNotCalled(t, "Notify", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("OlderReading", func(t *testing.T) {
+		setupAlertSvcTests()
+		evaluated := dry
+		evaluated.EvaluatedAt = pgtype.Timestamptz{Time: now, Valid: true}
+		alertReader.On("GetEnabledAlertRules", ctx, deviceId).Return([]sqlc.AlertRule{evaluated}, nil)
+
+		events, err := alertSvc.EvaluateAlerts(ctx, dvc, readings, now.Add(-time.Minute))
+
+		assert.Nil(t, err)
+		assert.Empty(t, events)
+		alertWriter.AssertNotCalled(t, "UpdateAlertRuleState", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("LostRace", func(t *testing.T) {
+		setupAlertSvcTests()
+		alertReader.On("GetEnabledAlertRules", ctx, deviceId).Return([]sqlc.AlertRule{dry}, nil)
+		alertWriter.On("UpdateAlertRuleState", ctx, dry.RuleID, mock.Anything, mock.Anything, now).Return(false, nil)
+
+		events, err := alertSvc.EvaluateAlerts(ctx, dvc, readings, now)
+
+		assert.Nil(t, err)
+		assert.Empty(t, events)
+		alertWriter.AssertNotCalled(t, "CreateAlertEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestCreateRule(t *testing.T) {
+	ctx := context.Background()
+	dvc := sqlc.Device{DeviceID: 12, UserID: 1}
+	min, max := 5.0, 30.0
+
+	t.Run("Success", func(t *testing.T) {
+		setupAlertSvcTests()
+		req := AlertRuleRequest{Measurement: core.Temperature, Min: &min, Max: &max, DurationSec: 300, Hysteresis: 1}
+		alertDevGetter.On("GetEditableDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		alertWriter.On("CreateAlertRule", ctx, mock.Anything).Return(sqlc.AlertRule{RuleID: 3}, nil)
+
+		rule, err := alertSvc.CreateRule(ctx, dvc.DeviceID, req)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int32(3), rule.RuleID)
+		alertWriter.AssertCalled(t, "CreateAlertRule", ctx, sqlc.AlertRule{
+			DeviceID:    dvc.DeviceID,
+			Name:        core.Temperature,
+			Measurement: core.Temperature,
+			MinValue:    float8(min),
+			MaxValue:    float8(max),
+			DurationSec: 300,
+			Hysteresis:  1,
+			Enabled:     true,
+		})
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		setupAlertSvcTests()
+		low, high := 30.0, 5.0
+		invalid := []AlertRuleRequest{
+			{Measurement: "co2", Min: &min},
+			{Measurement: core.Temperature},
+			{Measurement: core.Temperature, Min: &low, Max: &high},
+			{Measurement: core.Temperature, Min: &min, Max: &max, Hysteresis: 20},
+			{Measurement: core.Temperature, Min: &min, Hysteresis: -1},
+			{Measurement: core.Temperature, Min: &min, DurationSec: -1},
+			{Measurement: core.Temperature, Min: &min, DurationSec: 8 * 24 * 3600},
+		}
+
+		for _, req := range invalid {
+			_, err := alertSvc.CreateRule(ctx, dvc.DeviceID, req)
+			assert.ErrorIs(t, err, ErrInvalidAlertRule, req)
+		}
+		alertWriter.AssertNotCalled(t, "CreateAlertRule", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		setupAlertSvcTests()
+		alertDevGetter.On("GetEditableDevice", ctx, int32(99)).Return(sqlc.Device{}, ErrDeviceForbidden)
+
+		_, err := alertSvc.CreateRule(ctx, 99, AlertRuleRequest{Measurement: core.Temperature, Min: &min})
+
+		assert.ErrorIs(t, err, ErrDeviceForbidden)
+		alertWriter.AssertNotCalled(t, "CreateAlertRule", mock.Anything, mock.Anything)
+	})
+}
+
+func TestAlertRuleOwnership(t *testing.T) {
+	ctx := context.Background()
+	dvc := sqlc.Device{DeviceID: 12, UserID: 1}
+
+	t.Run("OtherDevicesRule", func(t *testing.T) {
+		setupAlertSvcTests()
+		alertDevGetter.On("GetUserDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		alertDevGetter.On("GetEditableDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		alertReader.On("GetAlertRule", ctx, int32(7)).Return(sqlc.AlertRule{RuleID: 7, DeviceID: 13}, nil)
+
+		_, err := alertSvc.GetRule(ctx, dvc.DeviceID, 7)
+		assert.ErrorIs(t, err, ErrAlertRuleNotFound)
+
+		err = alertSvc.DeleteRule(ctx, dvc.DeviceID, 7)
+		assert.ErrorIs(t, err, ErrAlertRuleNotFound)
+		alertWriter.AssertNotCalled(t, "DeleteAlertRule", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Snooze", func(t *testing.T) {
+		setupAlertSvcTests()
+		alertDevGetter.On("GetEditableDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		alertReader.On("GetAlertRule", ctx, int32(7)).Return(sqlc.AlertRule{RuleID: 7, DeviceID: dvc.DeviceID}, nil)
+		alertWriter.On("SnoozeAlertRule", ctx, int32(7), mock.Anything).Return(nil)
+
+		rule, err := alertSvc.SnoozeRule(ctx, dvc.DeviceID, 7, SnoozeRequest{DurationSec: 3600})
+
+		assert.Nil(t, err)
+		assert.True(t, rule.SnoozedUntil.Valid)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), rule.SnoozedUntil.Time, time.Minute)
+
+		_, err = alertSvc.SnoozeRule(ctx, dvc.DeviceID, 7, SnoozeRequest{DurationSec: -1})
+		assert.ErrorIs(t, err, ErrInvalidAlertRule)
+	})
+}
@@ -9,15 +9,13 @@ import (
 	"time"
 
 	"github.com/frozenkro/dirtie-srv/assets"
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
-type EmailSender interface {
-	SendEmail(ctx context.Context, emailAddress string, subject string, body string) error
-}
-
 type HtmlParser interface {
 	ReadFile(ctx context.Context, path string) (*template.Template, error)
 	ReplaceVars(ctx context.Context, data any, tmp *template.Template) ([]byte, error)
@@ -32,6 +30,8 @@ type UserWriter interface {
 	CreateUser(ctx context.Context, email string, pwHash []byte, name string) (sqlc.User, error)
 	ChangePassword(ctx context.Context, userId int32, pwHash []byte) error
 	UpdateLastLoginTime(ctx context.Context, userId int32) error
+	DisableUser(ctx context.Context, userId int32) error
+	EnableUser(ctx context.Context, userId int32) error
 }
 
 type SessionReader interface {
@@ -60,7 +60,7 @@ type AuthSvc struct {
 	pwResetReader PwResetReader
 	pwResetWriter PwResetWriter
 	htmlParser    HtmlParser
-	emailSender   EmailSender
+	notifier      Notifier
 }
 
 var (
@@ -69,6 +69,7 @@ var (
 	ErrUserExists      = fmt.Errorf("User Email already exists")
 	ErrNoUser          = fmt.Errorf("User not found")
 	ErrInvalidPassword = fmt.Errorf("Invalid Password")
+	ErrUserDisabled    = fmt.Errorf("User is disabled")
 )
 
 func NewAuthSvc(userReader UserReader,
@@ -78,7 +79,7 @@ func NewAuthSvc(userReader UserReader,
 	pwResetReader PwResetReader,
 	pwResetWriter PwResetWriter,
 	htmlParser HtmlParser,
-	emailSender EmailSender) AuthSvc {
+	notifier Notifier) AuthSvc {
 
 	return AuthSvc{
 		userReader:    userReader,
@@ -88,7 +89,7 @@ func NewAuthSvc(userReader UserReader,
 		pwResetReader: pwResetReader,
 		pwResetWriter: pwResetWriter,
 		htmlParser:    htmlParser,
-		emailSender:   emailSender,
+		notifier:      notifier,
 	}
 }
 
@@ -129,6 +130,9 @@ func (s AuthSvc) Login(ctx context.Context, email string, password string) (stri
 	if err != nil {
 		return "", fmt.Errorf("Error Login -> CompareHashAndPassword: \n%w\n", ErrInvalidPassword)
 	}
+	if user.DisabledAt.Valid {
+		return "", fmt.Errorf("Error Login (userId: %v): \n%w\n", user.UserID, ErrUserDisabled)
+	}
 
 	err = s.userWriter.UpdateLastLoginTime(ctx, user.UserID)
 	if err != nil {
@@ -170,6 +174,9 @@ func (s AuthSvc) ValidateToken(ctx context.Context, token string) (*sqlc.User, e
 	if err != nil {
 		return nil, fmt.Errorf("Error ValidateToken -> GetUser: \n%w\n", err)
 	}
+	if user.DisabledAt.Valid {
+		return nil, fmt.Errorf("ValidateToken (userId: %v): \n%w\n", user.UserID, ErrUserDisabled)
+	}
 
 	return &user, nil
 }
@@ -193,7 +200,8 @@ func (s AuthSvc) ForgotPw(ctx context.Context, email string) error {
 	if err != nil {
 		return err
 	}
-	if user.UserID <= 0 {
+	// a disabled user couldn't sign in with a new password anyway
+	if user.UserID <= 0 || user.DisabledAt.Valid {
 		return fmt.Errorf("No user found for email '%v': %w\n", email, ErrNoUser)
 	}
 	userId := user.UserID
@@ -228,9 +236,13 @@ func (s AuthSvc) ForgotPw(ctx context.Context, email string) error {
 	}
 
 	// send email
-	err = s.emailSender.SendEmail(ctx, user.Email, "Dirtie Password Reset Request", string(body))
+	err = s.notifier.Notify(ctx, userId, notify.Notification{
+		Kind:    notify.KindAccount,
+		Subject: "Dirtie Password Reset Request",
+		Body:    string(body),
+	})
 	if err != nil {
-		return fmt.Errorf("Error ForgotPw -> SendEmail: \n%w\n", err)
+		return fmt.Errorf("Error ForgotPw -> Notify: \n%w\n", err)
 	}
 
 	return nil
@@ -278,9 +290,70 @@ func (s AuthSvc) ChangePw(ctx context.Context, encToken string, newPw string) er
 		return fmt.Errorf("Error ChangePw - An error occurred after successful password change: \n%w\n", err)
 	}
 
+	// let the owner know in case it wasn't them
+	err = s.notifier.Notify(ctx, userId, notify.Notification{
+		Kind:    notify.KindAccount,
+		Subject: "Your Dirtie password was changed",
+		Body:    "The password for your Dirtie account was just changed. If this wasn't you, reset it now.",
+	})
+	if err != nil {
+		utils.LogErr(fmt.Sprintf("Error ChangePw -> Notify (userId: %v): %v\n", userId, err))
+	}
+
 	return nil
 }
 
+// DisableUser stops the user signing in and ends their sessions and
+// password resets. Their devices keep reporting.
+func (s AuthSvc) DisableUser(ctx context.Context, email string) (sqlc.User, error) {
+	user, err := s.userReader.GetUserFromEmail(ctx, email)
+	if err != nil {
+		return sqlc.User{}, fmt.Errorf("Error DisableUser -> GetUserFromEmail: \n%w\n", err)
+	}
+	if user.UserID <= 0 {
+		return sqlc.User{}, fmt.Errorf("Error DisableUser (email: '%v'): \n%w\n", email, ErrNoUser)
+	}
+
+	err = s.userWriter.DisableUser(ctx, user.UserID)
+	if err != nil {
+		return sqlc.User{}, fmt.Errorf("Error DisableUser -> DisableUser: \n%w\n", err)
+	}
+	err = s.sessionWriter.DeleteUserSessions(ctx, user.UserID)
+	if err != nil {
+		return sqlc.User{}, fmt.Errorf("Error DisableUser -> DeleteUserSessions: \n%w\n", err)
+	}
+	err = s.pwResetWriter.DeleteUserPwResetTokens(ctx, user.UserID)
+	if err != nil {
+		return sqlc.User{}, fmt.Errorf("Error DisableUser -> DeleteUserPwResetTokens: \n%w\n", err)
+	}
+
+	return s.reload(ctx, user.UserID)
+}
+
+func (s AuthSvc) EnableUser(ctx context.Context, email string) (sqlc.User, error) {
+	user, err := s.userReader.GetUserFromEmail(ctx, email)
+	if err != nil {
+		return sqlc.User{}, fmt.Errorf("Error EnableUser -> GetUserFromEmail: \n%w\n", err)
+	}
+	if user.UserID <= 0 {
+		return sqlc.User{}, fmt.Errorf("Error EnableUser (email: '%v'): \n%w\n", email, ErrNoUser)
+	}
+
+	err = s.userWriter.EnableUser(ctx, user.UserID)
+	if err != nil {
+		return sqlc.User{}, fmt.Errorf("Error EnableUser -> EnableUser: \n%w\n", err)
+	}
+	return s.reload(ctx, user.UserID)
+}
+
+func (s AuthSvc) reload(ctx context.Context, userId int32) (sqlc.User, error) {
+	user, err := s.userReader.GetUser(ctx, userId)
+	if err != nil {
+		return sqlc.User{}, fmt.Errorf("Error reloading user -> GetUser: \n%w\n", err)
+	}
+	return user, nil
+}
+
 func createToken() (string, time.Time, error) {
 
 	token := uuid.NewString()
@@ -22,7 +22,7 @@ var (
 	sessionWriter mocks.MockSessionWriter
 	pwResetReader mocks.MockPwResetReader
 	pwResetWriter mocks.MockPwResetWriter
-	emailSender   mocks.MockEmailSender
+	notifier      mocks.MockNotifier
 	htmlParser    mocks.MockHtmlParser
 	authSvc       AuthSvc
 )
@@ -35,7 +35,7 @@ func setupAuthSvcTests() {
 	pwResetReader = mocks.MockPwResetReader{Mock: new(mock.Mock)}
 	pwResetWriter = mocks.MockPwResetWriter{Mock: new(mock.Mock)}
 	htmlParser = mocks.MockHtmlParser{Mock: new(mock.Mock)}
-	emailSender = mocks.MockEmailSender{Mock: new(mock.Mock)}
+	notifier = mocks.MockNotifier{Mock: new(mock.Mock)}
 
 	authSvc = NewAuthSvc(userReader,
 		userWriter,
@@ -44,7 +44,7 @@ func setupAuthSvcTests() {
 		pwResetReader,
 		pwResetWriter,
 		htmlParser,
-		emailSender)
+		notifier)
 }
 
 func TestCreateUser(t *testing.T) {
@@ -121,6 +121,21 @@ func TestLogin(t *testing.T) {
 		assert.Empty(t, token)
 		userReader.AssertExpectations(t)
 	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		email := "disabled@example.com"
+		password := "password123"
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), 10)
+		disabledAt := pgtype.Timestamptz{Time: time.Now(), Valid: true}
+		userReader.On("GetUserFromEmail", ctx, email).Return(sqlc.User{UserID: 2, Email: email, PwHash: hashedPassword, DisabledAt: disabledAt}, nil)
+
+		token, err := authSvc.Login(ctx, email, password)
+
+		assert.True(t, errors.Is(err, ErrUserDisabled))
+		assert.Empty(t, token)
+		userWriter.AssertNotCalled(t, "UpdateLastLoginTime", ctx, int32(2))
+		sessionWriter.AssertNotCalled(t, "CreateSession", ctx, int32(2), mock.Anything, mock.Anything)
+	})
 }
 
 func TestValidateToken(t *testing.T) {
@@ -171,6 +186,21 @@ func TestValidateToken(t *testing.T) {
 		assert.True(t, errors.Is(err, ErrInvalidToken))
 		sessionReader.AssertExpectations(t)
 	})
+
+	t.Run("DisabledUser", func(t *testing.T) {
+		token := uuid.New().String()
+		userID := int32(2)
+		expiresAt := time.Now().Add(time.Hour)
+		disabledAt := pgtype.Timestamptz{Time: time.Now(), Valid: true}
+
+		sessionReader.On("GetSession", ctx, token).Return(sqlc.Session{UserID: userID, ExpiresAt: pgtype.Timestamptz{Time: expiresAt}}, nil)
+		userReader.On("GetUser", ctx, userID).Return(sqlc.User{UserID: userID, DisabledAt: disabledAt}, nil)
+
+		user, err := authSvc.ValidateToken(ctx, token)
+
+		assert.Nil(t, user)
+		assert.True(t, errors.Is(err, ErrUserDisabled))
+	})
 }
 
 func TestLogout(t *testing.T) {
@@ -190,3 +220,52 @@ func TestLogout(t *testing.T) {
 		sessionWriter.AssertExpectations(t)
 	})
 }
+
+func TestDisableUser(t *testing.T) {
+	ctx := context.Background()
+	setupAuthSvcTests()
+
+	t.Run("Success", func(t *testing.T) {
+		email := "test@example.com"
+		disabledAt := pgtype.Timestamptz{Time: time.Now(), Valid: true}
+
+		userReader.On("GetUserFromEmail", ctx, email).Return(sqlc.User{UserID: 1, Email: email}, nil)
+		userWriter.On("DisableUser", ctx, int32(1)).Return(nil)
+		sessionWriter.On("DeleteUserSessions", ctx, int32(1)).Return(nil)
+		pwResetWriter.On("DeleteUserPwResetTokens", ctx, int32(1)).Return(nil)
+		userReader.On("GetUser", ctx, int32(1)).Return(sqlc.User{UserID: 1, Email: email, DisabledAt: disabledAt}, nil)
+
+		user, err := authSvc.DisableUser(ctx, email)
+
+		assert.Nil(t, err)
+		assert.True(t, user.DisabledAt.Valid)
+		userWriter.AssertExpectations(t)
+		sessionWriter.AssertExpectations(t)
+		pwResetWriter.AssertExpectations(t)
+	})
+	t.Run("NoUser", func(t *testing.T) {
+		email := "nobody@example.com"
+		userReader.On("GetUserFromEmail", ctx, email).Return(sqlc.User{}, nil)
+
+		_, err := authSvc.DisableUser(ctx, email)
+
+		assert.True(t, errors.Is(err, ErrNoUser))
+		userWriter.AssertNotCalled(t, "DisableUser", ctx, int32(0))
+	})
+}
+
+func TestEnableUser(t *testing.T) {
+	ctx := context.Background()
+	setupAuthSvcTests()
+
+	email := "test@example.com"
+	userReader.On("GetUserFromEmail", ctx, email).Return(sqlc.User{UserID: 1, Email: email}, nil)
+	userWriter.On("EnableUser", ctx, int32(1)).Return(nil)
+	userReader.On("GetUser", ctx, int32(1)).Return(sqlc.User{UserID: 1, Email: email}, nil)
+
+	user, err := authSvc.EnableUser(ctx, email)
+
+	assert.Nil(t, err)
+	assert.False(t, user.DisabledAt.Valid)
+	userWriter.AssertExpectations(t)
+}
@@ -2,10 +2,14 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
 	"github.com/frozenkro/dirtie-srv/internal/db"
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 )
@@ -15,7 +19,7 @@ type DeviceGetter interface {
 }
 
 type DeviceDataRecorder interface {
-	Record(ctx context.Context, deviceId int, measurementKey string, value int64) error
+	RecordPoint(ctx context.Context, deviceId int, fields map[string]any, ts time.Time) error
 }
 
 type DeviceDataRetriever interface {
@@ -27,79 +31,359 @@ type DevicePrvCompleter interface {
 	CompleteDeviceProvision(context.Context, DevicePrvPayload) (sqlc.Device, error)
 }
 
+type MeasurementRegistry interface {
+	Get(name string) (measurements.Measurement, error)
+	All() []measurements.Measurement
+}
+
+type AlertEvaluator interface {
+	EvaluateAlerts(ctx context.Context, device sqlc.Device, readings map[string]float64, ts time.Time) ([]sqlc.AlertEvent, error)
+}
+
+// DeviceStatusRecorder tracks when devices were last heard from and
+// what they last reported
+type DeviceStatusRecorder interface {
+	RecordHeartbeat(ctx context.Context, device sqlc.Device, seenAt time.Time) error
+	RecordLatestReading(ctx context.Context, deviceId int32, readings map[string]float64, ts time.Time) error
+}
+
+type ClockSkewObserver interface {
+	ObserveClockSkew(skew time.Duration)
+}
+
+// TimestampPolicy bounds the device-reported breadcrumb times we accept
+type TimestampPolicy struct {
+	// how far ahead of the server a device clock may run
+	MaxSkew time.Duration
+	// oldest reading accepted, relative to the server clock
+	MaxBackfill time.Duration
+	// most breadcrumbs in one batch, 0 for no limit
+	MaxBatch int
+}
+
 type BrdCrmSvc struct {
 	DataRecorder  DeviceDataRecorder
 	DataRetriever DeviceDataRetriever
 	DeviceGetter  DeviceGetter
 	PrvCompleter  DevicePrvCompleter
+	Measurements  MeasurementRegistry
+	Timestamps    TimestampPolicy
+	SkewObserver  ClockSkewObserver
+	Alerts        AlertEvaluator
+	Status        DeviceStatusRecorder
 }
 type BreadCrumb struct {
-	MacAddr     string `json:"macAddr"`
-	Contract    string `json:"contract"`
-	Capacitance int64  `json:"capacitance"`
-	Temperature int64  `json:"temperature"`
+	MacAddr  string             `json:"macAddr"`
+	Contract string             `json:"contract"`
+	Readings map[string]float64 `json:"readings,omitempty"`
+	// Unix seconds when the readings were taken. Firmware without a
+	// clock leaves it out and the server receive time is used.
+	// Breadcrumbs taken in the same second need a Seq to both be kept.
+	Timestamp *int64 `json:"ts,omitempty"`
+	// Per-device counter, stored with the readings so gaps and
+	// redeliveries can be spotted
+	Seq *uint32 `json:"seq,omitempty"`
+
+	// Deprecated: firmware predating the measurement registry sends
+	// these top-level. They are folded into Readings.
+	Capacitance *int64 `json:"capacitance,omitempty"`
+	Temperature *int64 `json:"temperature,omitempty"`
 }
 
-func NewBrdCrmSvc(dataRec DeviceDataRecorder, 
-	dataRet DeviceDataRetriever, 
-	deviceGetter DeviceGetter, 
+// BreadCrumbBatch carries readings a device stored while it couldn't
+// reach the broker. Every entry needs a timestamp; entries may leave
+// out macAddr and contract, which are taken from the batch.
+type BreadCrumbBatch struct {
+	MacAddr     string       `json:"macAddr"`
+	Contract    string       `json:"contract"`
+	BreadCrumbs []BreadCrumb `json:"breadcrumbs"`
+}
+
+func NewBrdCrmSvc(dataRec DeviceDataRecorder,
+	dataRet DeviceDataRetriever,
+	deviceGetter DeviceGetter,
 	prvCompleter DevicePrvCompleter,
+	registry MeasurementRegistry,
+	timestamps TimestampPolicy,
+	skewObserver ClockSkewObserver,
+	alerts AlertEvaluator,
+	status DeviceStatusRecorder,
 ) BrdCrmSvc {
 	return BrdCrmSvc{
-		DataRecorder: dataRec, 
-		DataRetriever: dataRet, 
-		DeviceGetter: deviceGetter,
-		PrvCompleter: prvCompleter,
+		DataRecorder:  dataRec,
+		DataRetriever: dataRet,
+		DeviceGetter:  deviceGetter,
+		PrvCompleter:  prvCompleter,
+		Measurements:  registry,
+		Timestamps:    timestamps,
+		SkewObserver:  skewObserver,
+		Alerts:        alerts,
+		Status:        status,
 	}
 }
 
 var (
-	ErrNoDevice = fmt.Errorf("Device not found")
+	ErrNoDevice          = fmt.Errorf("Device not found")
+	ErrNoReadings        = fmt.Errorf("Breadcrumb has no readings")
+	ErrFutureTimestamp   = fmt.Errorf("Breadcrumb timestamp is in the future")
+	ErrStaleTimestamp    = fmt.Errorf("Breadcrumb timestamp is too old")
+	ErrMissingTimestamp  = fmt.Errorf("Batched breadcrumb has no timestamp")
+	ErrInvalidBatch      = fmt.Errorf("Invalid breadcrumb batch")
+	ErrBreadCrumbsFailed = fmt.Errorf("Some batched breadcrumbs were not recorded")
 )
 
+// AllReadings merges the legacy top-level fields into Readings.
+// Explicit entries in Readings win.
+func (b BreadCrumb) AllReadings() map[string]float64 {
+	readings := make(map[string]float64, len(b.Readings)+2)
+	if b.Capacitance != nil {
+		readings[core.Capacitance] = float64(*b.Capacitance)
+	}
+	if b.Temperature != nil {
+		readings[core.Temperature] = float64(*b.Temperature)
+	}
+	for k, v := range b.Readings {
+		readings[k] = v
+	}
+	return readings
+}
+
 func (s BrdCrmSvc) RecordBrdCrm(ctx context.Context, brdCrm BreadCrumb) error {
-	dvc, err := s.DeviceGetter.GetDeviceByMacAddress(ctx, brdCrm.MacAddr)
+	now := time.Now()
+	fields, ts, err := s.toPoint(brdCrm, now)
 	if err != nil {
-		return fmt.Errorf("Error RecordBrdCrm -> GetDeviceByMacAddr: \n%w\n", err)
+		return fmt.Errorf("Error in RecordBrdCrm (macAddr: %v): \n%w\n", brdCrm.MacAddr, err)
+	}
+	if brdCrm.Timestamp != nil {
+		s.checkSkew(brdCrm.MacAddr, ts, now)
 	}
 
-	// Lazy provisioning
-	if dvc.DeviceID <= 0 {
-		payload := DevicePrvPayload{MacAddr: brdCrm.MacAddr, Contract: brdCrm.Contract}
-		ps, err := s.PrvCompleter.CompleteDeviceProvision(ctx, payload)
-		if err != nil {
-			return fmt.Errorf("Error RecordBrdCrm -> GetProvisionStagingByContract: \n%w\n", err)
+	dvc, err := resolveDevice(ctx, s.DeviceGetter, s.PrvCompleter, brdCrm.MacAddr, brdCrm.Contract)
+	if err != nil {
+		return fmt.Errorf("Error RecordBrdCrm -> resolveDevice: \n%w\n", err)
+	}
+
+	err = s.DataRecorder.RecordPoint(ctx, int(dvc.DeviceID), fields, ts)
+	if err != nil {
+		return fmt.Errorf("Error RecordBrdCrm -> RecordPoint: \n%w\n", err)
+	}
+
+	s.recordStatus(ctx, dvc, brdCrm.AllReadings(), ts, now)
+	s.evaluateAlerts(ctx, dvc, brdCrm.AllReadings(), ts)
+	return nil
+}
+
+// RecordBrdCrmBatch records every valid entry in the batch, in any
+// order. Invalid entries are skipped and reported together in the
+// returned error, which wraps ErrBreadCrumbsFailed. Entries carry
+// their own timestamps, so replaying a batch rewrites the same points
+// rather than duplicating them.
+func (s BrdCrmSvc) RecordBrdCrmBatch(ctx context.Context, batch BreadCrumbBatch) error {
+	if len(batch.BreadCrumbs) == 0 {
+		return fmt.Errorf("Error in RecordBrdCrmBatch (macAddr: %v): \n%w\n", batch.MacAddr, ErrNoReadings)
+	}
+	if s.Timestamps.MaxBatch > 0 && len(batch.BreadCrumbs) > s.Timestamps.MaxBatch {
+		return fmt.Errorf("Error in RecordBrdCrmBatch (%v breadcrumbs, max %v): \n%w\n",
+			len(batch.BreadCrumbs), s.Timestamps.MaxBatch, ErrInvalidBatch)
+	}
+
+	type point struct {
+		fields   map[string]any
+		readings map[string]float64
+		ts       time.Time
+	}
+	now := time.Now()
+	points := make([]point, 0, len(batch.BreadCrumbs))
+	var errs []error
+
+	for i, b := range batch.BreadCrumbs {
+		if b.MacAddr != "" && !strings.EqualFold(b.MacAddr, batch.MacAddr) {
+			errs = append(errs, fmt.Errorf("breadcrumb %v macAddr '%v': %w", i, b.MacAddr, ErrInvalidBatch))
+			continue
+		}
+		if b.Timestamp == nil {
+			errs = append(errs, fmt.Errorf("breadcrumb %v: %w", i, ErrMissingTimestamp))
+			continue
 		}
-		if ps.MacAddr.String == "" {
-			// No device or provision staging record found for this contract / mac address
-			return fmt.Errorf("Error in RecordBrdCrm (macAddr: %v): \n%w\n", brdCrm.MacAddr, ErrNoDevice)
+		fields, ts, err := s.toPoint(b, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("breadcrumb %v: %w", i, err))
+			continue
 		}
+		points = append(points, point{fields: fields, readings: b.AllReadings(), ts: ts})
 	}
 
-	err = s.DataRecorder.Record(ctx, int(dvc.DeviceID), core.Capacitance, brdCrm.Capacitance)
-	if err != nil {
-		return fmt.Errorf("Error RecordBrdCrm -> Record capacitance: \n%w\n", err)
+	if len(points) > 0 {
+		dvc, err := resolveDevice(ctx, s.DeviceGetter, s.PrvCompleter, batch.MacAddr, batch.Contract)
+		if err != nil {
+			return fmt.Errorf("Error RecordBrdCrmBatch -> resolveDevice: \n%w\n", err)
+		}
+		latest := points[0]
+		for _, p := range points {
+			if err = s.DataRecorder.RecordPoint(ctx, int(dvc.DeviceID), p.fields, p.ts); err != nil {
+				return fmt.Errorf("Error RecordBrdCrmBatch -> RecordPoint: \n%w\n", err)
+			}
+			if p.ts.After(latest.ts) {
+				latest = p
+			}
+		}
+		s.recordStatus(ctx, dvc, latest.readings, latest.ts, now)
+		// Only the device's current state is checked against its alert
+		// rules; replaying hours of backfill would raise stale alerts
+		s.evaluateAlerts(ctx, dvc, latest.readings, latest.ts)
 	}
-	err = s.DataRecorder.Record(ctx, int(dvc.DeviceID), core.Temperature, brdCrm.Temperature)
-	if err != nil {
-		return fmt.Errorf("Error RecordBrdCrm -> Record temperature: \n%w\n", err)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Error in RecordBrdCrmBatch (macAddr: %v, %v of %v skipped): \n%w\n%w\n",
+			batch.MacAddr, len(errs), len(batch.BreadCrumbs), ErrBreadCrumbsFailed, errors.Join(errs...))
 	}
 	return nil
 }
 
-func (s BrdCrmSvc) GetLatestBrdCrm(ctx context.Context, deviceId int) (*BreadCrumb, error) {
-	cap, err := s.DataRetriever.GetLatestValue(ctx, deviceId, core.Capacitance)
+// toPoint validates a breadcrumb and returns its influx fields and
+// time. Everything is checked up front so a bad breadcrumb is rejected
+// whole rather than half written.
+func (s BrdCrmSvc) toPoint(brdCrm BreadCrumb, now time.Time) (map[string]any, time.Time, error) {
+	readings := brdCrm.AllReadings()
+	if len(readings) == 0 {
+		return nil, time.Time{}, ErrNoReadings
+	}
+
+	fields := make(map[string]any, len(readings)+1)
+	for name, value := range readings {
+		m, err := s.Measurements.Get(name)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if err = m.Validate(value); err != nil {
+			return nil, time.Time{}, err
+		}
+		fields[m.Name] = m.FieldValue(value)
+	}
+	if brdCrm.Seq != nil {
+		fields[measurements.SeqField] = int64(*brdCrm.Seq)
+	}
+
+	if brdCrm.Timestamp == nil {
+		return fields, now, nil
+	}
+	ts := time.Unix(*brdCrm.Timestamp, 0)
+	if ts.After(now.Add(s.Timestamps.MaxSkew)) {
+		return nil, time.Time{}, fmt.Errorf("%v is %v ahead of the server: %w",
+			ts.UTC().Format(time.RFC3339), ts.Sub(now).Round(time.Second), ErrFutureTimestamp)
+	}
+	if s.Timestamps.MaxBackfill > 0 && ts.Before(now.Add(-s.Timestamps.MaxBackfill)) {
+		return nil, time.Time{}, fmt.Errorf("%v is older than %v: %w",
+			ts.UTC().Format(time.RFC3339), s.Timestamps.MaxBackfill, ErrStaleTimestamp)
+	}
+	// Device timestamps are whole seconds, and influx keeps one point
+	// per series and time, so readings taken in the same second would
+	// overwrite each other. Offsetting by seq keeps them apart while a
+	// redelivered breadcrumb still lands on its own point.
+	if brdCrm.Seq != nil {
+		ts = ts.Add(time.Duration(*brdCrm.Seq % seqOffsetSpan))
+	}
+	return fields, ts, nil
+}
+
+// seq offsets stay under a millisecond
+const seqOffsetSpan = 1_000_000
+
+// checkSkew reports how far a live breadcrumb's timestamp is from the
+// time we received it. Readings that are merely late are still stored
+// at the device's time, but a device that is consistently off has a
+// bad clock.
+func (s BrdCrmSvc) checkSkew(macAddr string, ts time.Time, now time.Time) {
+	skew := ts.Sub(now)
+	if s.SkewObserver != nil {
+		s.SkewObserver.ObserveClockSkew(skew)
+	}
+	if skew.Abs() > s.Timestamps.MaxSkew {
+		utils.LogInfo(fmt.Sprintf("breadcrumb from %v is %v off the server clock\n", macAddr, skew.Round(time.Second)))
+	}
+}
+
+// evaluateAlerts runs the readings through the device's alert rules.
+// The readings are already stored, so failures are logged rather than
+// failing the breadcrumb.
+func (s BrdCrmSvc) evaluateAlerts(ctx context.Context, device sqlc.Device, readings map[string]float64, ts time.Time) {
+	if s.Alerts == nil {
+		return
+	}
+	if _, err := s.Alerts.EvaluateAlerts(ctx, device, readings, ts); err != nil {
+		utils.LogErr(fmt.Sprintf("Error RecordBrdCrm -> EvaluateAlerts (deviceId: %v): %v\n", device.DeviceID, err))
+	}
+}
+
+// recordStatus marks the device as heard from at receive time and
+// keeps its newest readings. Like alerts, failures are only logged.
+func (s BrdCrmSvc) recordStatus(ctx context.Context, device sqlc.Device, readings map[string]float64, ts time.Time, now time.Time) {
+	if s.Status == nil {
+		return
+	}
+	if err := s.Status.RecordHeartbeat(ctx, device, now); err != nil {
+		utils.LogErr(fmt.Sprintf("Error RecordBrdCrm -> RecordHeartbeat (deviceId: %v): %v\n", device.DeviceID, err))
+	}
+	if err := s.Status.RecordLatestReading(ctx, device.DeviceID, readings, ts); err != nil {
+		utils.LogErr(fmt.Sprintf("Error RecordBrdCrm -> RecordLatestReading (deviceId: %v): %v\n", device.DeviceID, err))
+	}
+}
+
+// resolveDevice looks up the device by mac address, completing its
+// provisioning first if this is the first message it has sent. Devices
+// may lead with a breadcrumb or a birth message.
+func resolveDevice(ctx context.Context,
+	deviceGetter DeviceGetter,
+	prvCompleter DevicePrvCompleter,
+	macAddr string,
+	contract string) (sqlc.Device, error) {
+
+	dvc, err := deviceGetter.GetDeviceByMacAddress(ctx, macAddr)
 	if err != nil {
-		return nil, fmt.Errorf("Error GetLatestBrdCrm -> GetLatestValue (capacitance): \n%w\n", err)
+		return sqlc.Device{}, fmt.Errorf("Error resolveDevice -> GetDeviceByMacAddr: \n%w\n", err)
+	}
+	if dvc.DeviceID > 0 {
+		return dvc, nil
 	}
 
-	temp, err := s.DataRetriever.GetLatestValue(ctx, deviceId, core.Temperature)
+	// Lazy provisioning
+	payload := DevicePrvPayload{MacAddr: macAddr, Contract: contract}
+	dvc, err = prvCompleter.CompleteDeviceProvision(ctx, payload)
 	if err != nil {
-		return nil, fmt.Errorf("Error GetLatestBrdCrm -> GetLatestValue (temperature): \n%w\n", err)
+		return sqlc.Device{}, fmt.Errorf("Error resolveDevice -> CompleteDeviceProvision: \n%w\n", err)
+	}
+	if dvc.DeviceID <= 0 || dvc.MacAddr.String == "" {
+		// No device or provision staging record found for this contract / mac address
+		return sqlc.Device{}, fmt.Errorf("Error in resolveDevice (macAddr: %v): \n%w\n", macAddr, ErrNoDevice)
+	}
+	return dvc, nil
+}
+
+// GetLatestBrdCrm returns the most recent value of every registered
+// measurement the device has reported, stamped with the newest of
+// their times
+func (s BrdCrmSvc) GetLatestBrdCrm(ctx context.Context, deviceId int) (*BreadCrumb, error) {
+	brdCrm := &BreadCrumb{Readings: map[string]float64{}}
+	var newest time.Time
+
+	for _, m := range s.Measurements.All() {
+		p, err := s.DataRetriever.GetLatestValue(ctx, deviceId, m.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Error GetLatestBrdCrm -> GetLatestValue (%v): \n%w\n", m.Name, err)
+		}
+		if p.Time.IsZero() && p.Key == "" {
+			// never reported
+			continue
+		}
+		brdCrm.Readings[m.Name] = p.Value
+		if p.Time.After(newest) {
+			newest = p.Time
+		}
+	}
+	if !newest.IsZero() {
+		ts := newest.Unix()
+		brdCrm.Timestamp = &ts
 	}
 
-	return &BreadCrumb{
-		Capacitance: cap.Value,
-		Temperature: temp.Value,
-	}, nil
+	return brdCrm, nil
 }
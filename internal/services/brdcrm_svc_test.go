@@ -2,9 +2,12 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
 	"github.com/frozenkro/dirtie-srv/internal/db"
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
@@ -13,102 +16,421 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+// lives here rather than in mocks since DevicePrvPayload is in this package
+type mockDevicePrvCompleter struct {
+	*mock.Mock
+}
+
+func (m mockDevicePrvCompleter) CompleteDeviceProvision(ctx context.Context, payload DevicePrvPayload) (sqlc.Device, error) {
+	args := m.Called(ctx, payload)
+	return args.Get(0).(sqlc.Device), args.Error(1)
+}
+
 var (
-	dataRec   mocks.MockDeviceDataRecorder
-	devGet    mocks.MockDeviceGetter
-	brdCrmSvc BrdCrmSvc
+	dataRec      mocks.MockDeviceDataRecorder
+	devGet       mocks.MockDeviceGetter
+	prvCompleter mockDevicePrvCompleter
+	alertEval    mocks.MockAlertEvaluator
+	statusRec    mocks.MockDeviceStatusRecorder
+	registry     *measurements.Registry
+	brdCrmSvc    BrdCrmSvc
 )
 
 func setupBrdCrmSvcTests() {
 	dataRec = mocks.MockDeviceDataRecorder{Mock: new(mock.Mock)}
 	dataRet = mocks.MockDeviceDataRetriever{Mock: new(mock.Mock)}
 	devGet = mocks.MockDeviceGetter{Mock: new(mock.Mock)}
+	prvCompleter = mockDevicePrvCompleter{Mock: new(mock.Mock)}
+	alertEval = mocks.MockAlertEvaluator{Mock: new(mock.Mock)}
+	alertEval.On("EvaluateAlerts", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]sqlc.AlertEvent{}, nil)
+	statusRec = mocks.MockDeviceStatusRecorder{Mock: new(mock.Mock)}
+	statusRec.On("RecordHeartbeat", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	statusRec.On("RecordLatestReading", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	registry, _ = measurements.NewRegistry(measurements.Defaults...)
+
+	brdCrmSvc = NewBrdCrmSvc(dataRec, dataRet, devGet, prvCompleter, registry, testTimestamps, nil, alertEval, statusRec)
+}
+
+var testTimestamps = TimestampPolicy{
+	MaxSkew:     time.Minute,
+	MaxBackfill: 7 * 24 * time.Hour,
+	MaxBatch:    10,
+}
 
-	brdCrmSvc = NewBrdCrmSvc(dataRec, dataRet, devGet)
+func unixPtr(t time.Time) *int64 {
+	ts := t.Unix()
+	return &ts
 }
 
 func TestRecordBrdCrm(t *testing.T) {
 	ctx := context.Background()
-	setupBrdCrmSvcTests()
+
+	dvc := sqlc.Device{
+		DeviceID: 111,
+		UserID:   222,
+		MacAddr: pgtype.Text{
+			String: "TestMacAddr",
+			Valid:  true,
+		},
+		DisplayName: pgtype.Text{
+			String: "Testie",
+			Valid:  true,
+		},
+	}
 
 	t.Run("Success", func(t *testing.T) {
+		setupBrdCrmSvcTests()
 		brdCrm := BreadCrumb{
-			MacAddr:     "TestMacAddr",
-			Capacitance: 420,
-			Temperature: 69,
-		}
-		dvc := sqlc.Device{
-			DeviceID: 111,
-			UserID:   222,
-			MacAddr: pgtype.Text{
-				String: brdCrm.MacAddr,
-				Valid:  true,
-			},
-			DisplayName: pgtype.Text{
-				String: "Testie",
-				Valid:  true,
+			MacAddr: dvc.MacAddr.String,
+			Readings: map[string]float64{
+				core.Capacitance:  420,
+				"humidity":        55.5,
+				"battery_voltage": 3.7,
 			},
 		}
 
 		devGet.On("GetDeviceByMacAddress", ctx, brdCrm.MacAddr).Return(dvc, nil)
-		dataRec.On("Record", ctx, int(dvc.DeviceID), core.Capacitance, brdCrm.Capacitance).Return(nil)
-		dataRec.On("Record", ctx, int(dvc.DeviceID), core.Temperature, brdCrm.Temperature).Return(nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).Return(nil)
 
 		err := brdCrmSvc.RecordBrdCrm(ctx, brdCrm)
 		assert.Nil(t, err)
 
 		devGet.AssertCalled(t, "GetDeviceByMacAddress", ctx, brdCrm.MacAddr)
-		dataRec.AssertCalled(t, "Record", ctx, int(dvc.DeviceID), core.Capacitance, brdCrm.Capacitance)
-		dataRec.AssertCalled(t, "Record", ctx, int(dvc.DeviceID), core.Temperature, brdCrm.Temperature)
+		// one point carrying every reading
+		dataRec.AssertNumberOfCalls(t, "RecordPoint", 1)
+		dataRec.AssertCalled(t, "RecordPoint", ctx, int(dvc.DeviceID), map[string]any{
+			core.Capacitance:  int64(420),
+			"humidity":        55.5,
+			"battery_voltage": 3.7,
+		}, mock.AnythingOfType("time.Time"))
+		alertEval.AssertCalled(t, "EvaluateAlerts", ctx, dvc, brdCrm.Readings, mock.AnythingOfType("time.Time"))
+		statusRec.AssertCalled(t, "RecordHeartbeat", ctx, dvc, mock.AnythingOfType("time.Time"))
+		statusRec.AssertCalled(t, "RecordLatestReading", ctx, dvc.DeviceID, brdCrm.Readings, mock.AnythingOfType("time.Time"))
 	})
 
-	t.Run("NoDevice", func(t *testing.T) {
+	t.Run("AlertFailure", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		alertEval = mocks.MockAlertEvaluator{Mock: new(mock.Mock)}
+		brdCrmSvc.Alerts = alertEval
+		brdCrm := BreadCrumb{MacAddr: dvc.MacAddr.String, Readings: map[string]float64{core.Capacitance: 420}}
+
+		devGet.On("GetDeviceByMacAddress", ctx, brdCrm.MacAddr).Return(dvc, nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).Return(nil)
+		alertEval.On("EvaluateAlerts", ctx, dvc, mock.Anything, mock.Anything).Return([]sqlc.AlertEvent{}, fmt.Errorf("db down"))
+
+		// the reading is stored, so a broken alert rule mustn't fail it
+		err := brdCrmSvc.RecordBrdCrm(ctx, brdCrm)
+		assert.Nil(t, err)
+		dataRec.AssertNumberOfCalls(t, "RecordPoint", 1)
+	})
+
+	t.Run("StatusFailure", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		statusRec = mocks.MockDeviceStatusRecorder{Mock: new(mock.Mock)}
+		brdCrmSvc.Status = statusRec
+		brdCrm := BreadCrumb{MacAddr: dvc.MacAddr.String, Readings: map[string]float64{core.Capacitance: 420}}
+
+		devGet.On("GetDeviceByMacAddress", ctx, brdCrm.MacAddr).Return(dvc, nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).Return(nil)
+		statusRec.On("RecordHeartbeat", ctx, dvc, mock.Anything).Return(fmt.Errorf("db down"))
+		statusRec.On("RecordLatestReading", ctx, dvc.DeviceID, mock.Anything, mock.Anything).Return(fmt.Errorf("db down"))
+
+		err := brdCrmSvc.RecordBrdCrm(ctx, brdCrm)
+		assert.Nil(t, err)
+		alertEval.AssertNumberOfCalls(t, "EvaluateAlerts", 1)
+	})
+
+	t.Run("LegacyFields", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		capacitance, temperature := int64(420), int64(69)
 		brdCrm := BreadCrumb{
-			MacAddr:     "UnrecognizedMacAddr",
-			Capacitance: 420,
-			Temperature: 69,
+			MacAddr:     dvc.MacAddr.String,
+			Capacitance: &capacitance,
+			Temperature: &temperature,
 		}
-		dvc := sqlc.Device{}
 
 		devGet.On("GetDeviceByMacAddress", ctx, brdCrm.MacAddr).Return(dvc, nil)
-		dataRec.On("Record", ctx, int(dvc.DeviceID), core.Capacitance, brdCrm.Capacitance).Return(nil)
-		dataRec.On("Record", ctx, int(dvc.DeviceID), core.Temperature, brdCrm.Temperature).Return(nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).Return(nil)
+
+		err := brdCrmSvc.RecordBrdCrm(ctx, brdCrm)
+		assert.Nil(t, err)
+
+		dataRec.AssertCalled(t, "RecordPoint", ctx, int(dvc.DeviceID), map[string]any{
+			core.Capacitance: capacitance,
+			core.Temperature: temperature,
+		}, mock.Anything)
+	})
+
+	t.Run("InvalidReadings", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		invalid := []struct {
+			readings map[string]float64
+			err      error
+		}{
+			{map[string]float64{}, ErrNoReadings},
+			{map[string]float64{"co2": 400}, measurements.ErrUnknownMeasurement},
+			{map[string]float64{"humidity": 140}, measurements.ErrInvalidReading},
+			{map[string]float64{core.Capacitance: 1.5}, measurements.ErrInvalidReading},
+			{map[string]float64{"humidity": 40, "battery_voltage": -1}, measurements.ErrInvalidReading},
+		}
+
+		for _, tt := range invalid {
+			err := brdCrmSvc.RecordBrdCrm(ctx, BreadCrumb{MacAddr: dvc.MacAddr.String, Readings: tt.readings})
+			assert.ErrorIs(t, err, tt.err)
+		}
+		devGet.AssertNotCalled(t, "GetDeviceByMacAddress", mock.Anything, mock.Anything)
+		dataRec.AssertNotCalled(t, "RecordPoint", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("LazyProvision", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		brdCrm := BreadCrumb{
+			MacAddr:  dvc.MacAddr.String,
+			Contract: "contract",
+			Readings: map[string]float64{"light": 1200},
+		}
+
+		devGet.On("GetDeviceByMacAddress", ctx, brdCrm.MacAddr).Return(sqlc.Device{}, nil)
+		prvCompleter.On("CompleteDeviceProvision", ctx, DevicePrvPayload{MacAddr: brdCrm.MacAddr, Contract: brdCrm.Contract}).Return(dvc, nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), map[string]any{"light": 1200.0}, mock.Anything).Return(nil)
+
+		err := brdCrmSvc.RecordBrdCrm(ctx, brdCrm)
+		assert.Nil(t, err)
+		dataRec.AssertExpectations(t)
+	})
+
+	t.Run("NoDevice", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		brdCrm := BreadCrumb{
+			MacAddr:  "UnrecognizedMacAddr",
+			Readings: map[string]float64{core.Capacitance: 420},
+		}
+		noDvc := sqlc.Device{}
+
+		devGet.On("GetDeviceByMacAddress", ctx, brdCrm.MacAddr).Return(noDvc, nil)
+		prvCompleter.On("CompleteDeviceProvision", ctx, mock.Anything).Return(noDvc, nil)
 
 		err := brdCrmSvc.RecordBrdCrm(ctx, brdCrm)
 		assert.ErrorIs(t, err, ErrNoDevice)
+		alertEval.AssertNotCalled(t, "EvaluateAlerts", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 
 		devGet.AssertCalled(t, "GetDeviceByMacAddress", ctx, brdCrm.MacAddr)
-		dataRec.AssertNotCalled(t, "Record", ctx, int(dvc.DeviceID), core.Capacitance, brdCrm.Capacitance)
-		dataRec.AssertNotCalled(t, "Record", ctx, int(dvc.DeviceID), core.Temperature, brdCrm.Temperature)
+		dataRec.AssertNotCalled(t, "RecordPoint", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 	})
 }
 
-func TestGetLatestBrdCrm(t *testing.T) {
+func TestRecordBrdCrmTimestamps(t *testing.T) {
 	ctx := context.Background()
-	setupBrdCrmSvcTests()
+	dvc := sqlc.Device{DeviceID: 111, MacAddr: pgtype.Text{String: "TestMacAddr", Valid: true}}
+	readings := map[string]float64{core.Capacitance: 420}
 
-	t.Run("Success", func(t *testing.T) {
-		testCapValue := int64(420)
-		testTempValue := int64(69)
-		deviceID := 5432
+	t.Run("DeviceTimestamp", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		taken := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+		seq := uint32(17)
+
+		devGet.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(dvc, nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).Return(nil)
+
+		err := brdCrmSvc.RecordBrdCrm(ctx, BreadCrumb{
+			MacAddr:   dvc.MacAddr.String,
+			Readings:  readings,
+			Timestamp: unixPtr(taken),
+			Seq:       &seq,
+		})
+		assert.Nil(t, err)
+
+		dataRec.AssertCalled(t, "RecordPoint", ctx, int(dvc.DeviceID), map[string]any{
+			core.Capacitance:      int64(420),
+			measurements.SeqField: int64(17),
+		}, mock.MatchedBy(func(ts time.Time) bool { return ts.Equal(taken.Add(17)) }))
+	})
+
+	t.Run("SameSecond", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		taken := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+		var times []time.Time
 
-		testCapResult := db.DeviceDataPoint{
-			Value: testCapValue,
-			Key:   core.Capacitance,
+		devGet.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(dvc, nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { times = append(times, args.Get(3).(time.Time)) }).
+			Return(nil)
+
+		for _, seq := range []uint32{17, 18} {
+			err := brdCrmSvc.RecordBrdCrm(ctx, BreadCrumb{
+				MacAddr:   dvc.MacAddr.String,
+				Readings:  readings,
+				Timestamp: unixPtr(taken),
+				Seq:       &seq,
+			})
+			assert.Nil(t, err)
 		}
 
-		testTempResult := db.DeviceDataPoint{
-			Value: testTempValue,
-			Key:   core.Temperature,
+		// each keeps its own influx point
+		assert.Len(t, times, 2)
+		assert.False(t, times[0].Equal(times[1]))
+		assert.Equal(t, taken.Unix(), times[1].Unix())
+	})
+
+	t.Run("WithinSkew", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		devGet.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(dvc, nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).Return(nil)
+
+		err := brdCrmSvc.RecordBrdCrm(ctx, BreadCrumb{
+			MacAddr:   dvc.MacAddr.String,
+			Readings:  readings,
+			Timestamp: unixPtr(time.Now().Add(30 * time.Second)),
+		})
+		assert.Nil(t, err)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		invalid := []struct {
+			name string
+			ts   time.Time
+			err  error
+		}{
+			{"Future", time.Now().Add(time.Hour), ErrFutureTimestamp},
+			{"Stale", time.Now().Add(-8 * 24 * time.Hour), ErrStaleTimestamp},
+			{"Epoch", time.Unix(0, 0), ErrStaleTimestamp},
 		}
 
-		dataRet.On("GetLatestValue", ctx, deviceID, core.Capacitance).Return(testCapResult, nil)
-		dataRet.On("GetLatestValue", ctx, deviceID, core.Temperature).Return(testTempResult, nil)
+		for _, tt := range invalid {
+			err := brdCrmSvc.RecordBrdCrm(ctx, BreadCrumb{
+				MacAddr:   dvc.MacAddr.String,
+				Readings:  readings,
+				Timestamp: unixPtr(tt.ts),
+			})
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		}
+		devGet.AssertNotCalled(t, "GetDeviceByMacAddress", mock.Anything, mock.Anything)
+		dataRec.AssertNotCalled(t, "RecordPoint", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestRecordBrdCrmBatch(t *testing.T) {
+	ctx := context.Background()
+	dvc := sqlc.Device{DeviceID: 111, MacAddr: pgtype.Text{String: "TestMacAddr", Valid: true}}
+	now := time.Now().Truncate(time.Second)
+
+	crumb := func(age time.Duration, capacitance float64) BreadCrumb {
+		return BreadCrumb{
+			Readings:  map[string]float64{core.Capacitance: capacitance},
+			Timestamp: unixPtr(now.Add(-age)),
+		}
+	}
+	atTime := func(t time.Time) any {
+		return mock.MatchedBy(func(ts time.Time) bool { return ts.Equal(t) })
+	}
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		batch := BreadCrumbBatch{
+			MacAddr: dvc.MacAddr.String,
+			BreadCrumbs: []BreadCrumb{
+				crumb(time.Hour, 401),
+				crumb(3*time.Hour, 403),
+				crumb(2*time.Hour, 402),
+			},
+		}
+
+		devGet.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(dvc, nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).Return(nil)
+
+		err := brdCrmSvc.RecordBrdCrmBatch(ctx, batch)
+		assert.Nil(t, err)
+
+		devGet.AssertNumberOfCalls(t, "GetDeviceByMacAddress", 1)
+		dataRec.AssertNumberOfCalls(t, "RecordPoint", 3)
+		for i, age := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour} {
+			dataRec.AssertCalled(t, "RecordPoint", ctx, int(dvc.DeviceID),
+				map[string]any{core.Capacitance: int64(401 + i)}, atTime(now.Add(-age)))
+		}
+		// alerts only see the newest reading
+		alertEval.AssertNumberOfCalls(t, "EvaluateAlerts", 1)
+		alertEval.AssertCalled(t, "EvaluateAlerts", ctx, dvc,
+			map[string]float64{core.Capacitance: 401}, atTime(now.Add(-time.Hour)))
+		// as does the device record
+		statusRec.AssertNumberOfCalls(t, "RecordHeartbeat", 1)
+		statusRec.AssertCalled(t, "RecordLatestReading", ctx, dvc.DeviceID,
+			map[string]float64{core.Capacitance: 401}, atTime(now.Add(-time.Hour)))
+	})
+
+	t.Run("PartialFailure", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		noTs := crumb(0, 404)
+		noTs.Timestamp = nil
+		otherDevice := crumb(time.Hour, 405)
+		otherDevice.MacAddr = "OtherMacAddr"
+
+		batch := BreadCrumbBatch{
+			MacAddr: dvc.MacAddr.String,
+			BreadCrumbs: []BreadCrumb{
+				crumb(time.Hour, 401),
+				noTs,
+				crumb(-time.Hour, 402),
+				crumb(time.Hour, 1.5),
+				otherDevice,
+			},
+		}
+
+		devGet.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(dvc, nil)
+		dataRec.On("RecordPoint", ctx, int(dvc.DeviceID), mock.Anything, mock.Anything).Return(nil)
+
+		err := brdCrmSvc.RecordBrdCrmBatch(ctx, batch)
+		assert.ErrorIs(t, err, ErrBreadCrumbsFailed)
+		assert.ErrorIs(t, err, ErrMissingTimestamp)
+		assert.ErrorIs(t, err, ErrFutureTimestamp)
+		assert.ErrorIs(t, err, measurements.ErrInvalidReading)
+		assert.ErrorIs(t, err, ErrInvalidBatch)
+
+		// the valid reading is still kept
+		dataRec.AssertNumberOfCalls(t, "RecordPoint", 1)
+		dataRec.AssertCalled(t, "RecordPoint", ctx, int(dvc.DeviceID),
+			map[string]any{core.Capacitance: int64(401)}, atTime(now.Add(-time.Hour)))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		setupBrdCrmSvcTests()
+		tooMany := make([]BreadCrumb, testTimestamps.MaxBatch+1)
+		for i := range tooMany {
+			tooMany[i] = crumb(time.Duration(i)*time.Minute, 400)
+		}
+
+		err := brdCrmSvc.RecordBrdCrmBatch(ctx, BreadCrumbBatch{MacAddr: dvc.MacAddr.String})
+		assert.ErrorIs(t, err, ErrNoReadings)
+
+		err = brdCrmSvc.RecordBrdCrmBatch(ctx, BreadCrumbBatch{MacAddr: dvc.MacAddr.String, BreadCrumbs: tooMany})
+		assert.ErrorIs(t, err, ErrInvalidBatch)
+
+		devGet.AssertNotCalled(t, "GetDeviceByMacAddress", mock.Anything, mock.Anything)
+		dataRec.AssertNotCalled(t, "RecordPoint", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestGetLatestBrdCrm(t *testing.T) {
+	ctx := context.Background()
+	setupBrdCrmSvcTests()
+
+	t.Run("Success", func(t *testing.T) {
+		deviceID := 5432
+		now := time.Now()
+
+		dataRet.On("GetLatestValue", ctx, deviceID, core.Capacitance).
+			Return(db.DeviceDataPoint{Value: 420, Time: now, Key: core.Capacitance}, nil)
+		dataRet.On("GetLatestValue", ctx, deviceID, core.Temperature).
+			Return(db.DeviceDataPoint{Value: 69, Time: now, Key: core.Temperature}, nil)
+		dataRet.On("GetLatestValue", ctx, deviceID, mock.Anything).Return(db.DeviceDataPoint{}, nil)
 
 		brdCrm, err := brdCrmSvc.GetLatestBrdCrm(ctx, int(deviceID))
 		assert.Nil(t, err)
 
-		assert.Equal(t, brdCrm.Capacitance, testCapValue)
-		assert.Equal(t, brdCrm.Temperature, testTempValue)
+		assert.Equal(t, map[string]float64{
+			core.Capacitance: 420,
+			core.Temperature: 69,
+		}, brdCrm.Readings)
+		assert.Equal(t, now.Unix(), *brdCrm.Timestamp)
 	})
 }
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/calibration"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Reference points recorded from the device's current reading
+const (
+	CalibrationDry = "dry"
+	CalibrationWet = "wet"
+)
+
+var maxCalibrationCurvePoints = 20
+
+var (
+	ErrInvalidCalibration   = fmt.Errorf("Invalid calibration")
+	ErrCalibrationNotFound  = fmt.Errorf("Device has no calibration profile")
+	ErrNoCalibrationReading = fmt.Errorf("Device has no current reading to calibrate with")
+)
+
+type CalibrationReader interface {
+	GetCalibrationProfile(ctx context.Context, deviceId int32) (sqlc.CalibrationProfile, error)
+}
+type CalibrationWriter interface {
+	UpsertCalibrationProfile(ctx context.Context, profile sqlc.CalibrationProfile) (sqlc.CalibrationProfile, error)
+	DeleteCalibrationProfile(ctx context.Context, deviceId int32) error
+}
+
+// Sent by the user via rest api, replaces the whole profile
+type CalibrationRequest struct {
+	SoilType string              `json:"soilType"`
+	Dry      *float64            `json:"dry,omitempty"`
+	Wet      *float64            `json:"wet,omitempty"`
+	Curve    []calibration.Point `json:"curve,omitempty"`
+}
+
+type CalibrationSvc struct {
+	reader           CalibrationReader
+	writer           CalibrationWriter
+	userDeviceGetter UserDeviceGetter
+}
+
+func NewCalibrationSvc(reader CalibrationReader,
+	writer CalibrationWriter,
+	userDeviceGetter UserDeviceGetter) CalibrationSvc {
+
+	return CalibrationSvc{
+		reader:           reader,
+		writer:           writer,
+		userDeviceGetter: userDeviceGetter,
+	}
+}
+
+func (s CalibrationSvc) GetProfile(ctx context.Context, deviceId int32) (sqlc.CalibrationProfile, error) {
+	device, err := s.userDeviceGetter.GetUserDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error GetProfile -> GetUserDevice: \n%w\n", err)
+	}
+
+	row, err := s.reader.GetCalibrationProfile(ctx, device.DeviceID)
+	if err != nil {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error GetProfile -> GetCalibrationProfile: \n%w\n", err)
+	}
+	if row.DeviceID <= 0 {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error GetProfile (deviceId: %v): \n%w\n", deviceId, ErrCalibrationNotFound)
+	}
+	return row, nil
+}
+
+func (s CalibrationSvc) SetProfile(ctx context.Context, deviceId int32, req CalibrationRequest) (sqlc.CalibrationProfile, error) {
+	device, err := s.userDeviceGetter.GetEditableDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error SetProfile -> GetEditableDevice: \n%w\n", err)
+	}
+	if len(req.Curve) > maxCalibrationCurvePoints {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error SetProfile (at most %v curve points): \n%w\n", maxCalibrationCurvePoints, ErrInvalidCalibration)
+	}
+
+	profile := calibration.Profile{Dry: req.Dry, Wet: req.Wet, Curve: req.Curve}
+	return s.save(ctx, device.DeviceID, req.SoilType, profile)
+}
+
+// RecordReference stores the device's current capacitance as its dry
+// or wet reference, keeping the rest of the profile. The device has to
+// be online, so the reading is one taken in the soil as it is now.
+func (s CalibrationSvc) RecordReference(ctx context.Context, deviceId int32, reference string) (sqlc.CalibrationProfile, error) {
+	if reference != CalibrationDry && reference != CalibrationWet {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error RecordReference (reference: '%v'): \n%w\n", reference, ErrInvalidCalibration)
+	}
+
+	device, err := s.userDeviceGetter.GetEditableDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error RecordReference -> GetEditableDevice: \n%w\n", err)
+	}
+
+	var readings map[string]float64
+	if len(device.LatestReadings) > 0 {
+		if err = json.Unmarshal(device.LatestReadings, &readings); err != nil {
+			return sqlc.CalibrationProfile{}, fmt.Errorf("Error RecordReference -> Unmarshal latest readings: \n%w\n", err)
+		}
+	}
+	raw, ok := readings[core.Capacitance]
+	if !device.Online || !ok {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error RecordReference (deviceId: %v): \n%w\n", deviceId, ErrNoCalibrationReading)
+	}
+
+	row, err := s.reader.GetCalibrationProfile(ctx, device.DeviceID)
+	if err != nil {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error RecordReference -> GetCalibrationProfile: \n%w\n", err)
+	}
+	profile, err := profileFromRow(row)
+	if err != nil {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error RecordReference -> profileFromRow: \n%w\n", err)
+	}
+
+	if reference == CalibrationDry {
+		profile.Dry = &raw
+	} else {
+		profile.Wet = &raw
+	}
+	return s.save(ctx, device.DeviceID, row.SoilType, profile)
+}
+
+func (s CalibrationSvc) DeleteProfile(ctx context.Context, deviceId int32) error {
+	device, err := s.userDeviceGetter.GetEditableDevice(ctx, deviceId)
+	if err != nil {
+		return fmt.Errorf("Error DeleteProfile -> GetEditableDevice: \n%w\n", err)
+	}
+
+	err = s.writer.DeleteCalibrationProfile(ctx, device.DeviceID)
+	if err != nil {
+		return fmt.Errorf("Error DeleteProfile -> DeleteCalibrationProfile: \n%w\n", err)
+	}
+	return nil
+}
+
+func (s CalibrationSvc) save(ctx context.Context, deviceId int32, soilType string, profile calibration.Profile) (sqlc.CalibrationProfile, error) {
+	if err := profile.Validate(); err != nil {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error saving calibration profile: \n%w\n%w\n", ErrInvalidCalibration, err)
+	}
+
+	row := sqlc.CalibrationProfile{
+		DeviceID: deviceId,
+		SoilType: soilType,
+	}
+	if profile.Dry != nil {
+		row.DryValue = pgtype.Float8{Float64: *profile.Dry, Valid: true}
+	}
+	if profile.Wet != nil {
+		row.WetValue = pgtype.Float8{Float64: *profile.Wet, Valid: true}
+	}
+	if len(profile.Curve) > 0 {
+		curve, err := json.Marshal(profile.Curve)
+		if err != nil {
+			return sqlc.CalibrationProfile{}, fmt.Errorf("Error saving calibration profile -> Marshal curve: \n%w\n", err)
+		}
+		row.Curve = curve
+	}
+
+	saved, err := s.writer.UpsertCalibrationProfile(ctx, row)
+	if err != nil {
+		return sqlc.CalibrationProfile{}, fmt.Errorf("Error saving calibration profile -> UpsertCalibrationProfile: \n%w\n", err)
+	}
+	return saved, nil
+}
+
+// profileFromRow converts a stored profile, a zero row gives an empty
+// profile
+func profileFromRow(row sqlc.CalibrationProfile) (calibration.Profile, error) {
+	var p calibration.Profile
+	if row.DryValue.Valid {
+		p.Dry = &row.DryValue.Float64
+	}
+	if row.WetValue.Valid {
+		p.Wet = &row.WetValue.Float64
+	}
+	if len(row.Curve) > 0 {
+		if err := json.Unmarshal(row.Curve, &p.Curve); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
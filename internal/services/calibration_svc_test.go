@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/calibration"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	calReader      mocks.MockCalibrationReader
+	calWriter      mocks.MockCalibrationWriter
+	calUserDevGet  mocks.MockUserDeviceGetter
+	calibrationSvc CalibrationSvc
+)
+
+func setupCalibrationSvcTests() {
+	calReader = mocks.MockCalibrationReader{Mock: new(mock.Mock)}
+	calWriter = mocks.MockCalibrationWriter{Mock: new(mock.Mock)}
+	calUserDevGet = mocks.MockUserDeviceGetter{Mock: new(mock.Mock)}
+	calWriter.On("UpsertCalibrationProfile", mock.Anything, mock.Anything).Return(sqlc.CalibrationProfile{DeviceID: 12}, nil)
+
+	calibrationSvc = NewCalibrationSvc(calReader, calWriter, calUserDevGet)
+}
+
+func TestGetCalibrationProfile(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("NotFound", func(t *testing.T) {
+		setupCalibrationSvcTests()
+		calUserDevGet.On("GetUserDevice", ctx, int32(12)).Return(sqlc.Device{DeviceID: 12}, nil)
+		calReader.On("GetCalibrationProfile", ctx, int32(12)).Return(sqlc.CalibrationProfile{}, nil)
+
+		_, err := calibrationSvc.GetProfile(ctx, 12)
+
+		assert.ErrorIs(t, err, ErrCalibrationNotFound)
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		setupCalibrationSvcTests()
+		calUserDevGet.On("GetUserDevice", ctx, int32(12)).Return(sqlc.Device{}, ErrDeviceForbidden)
+
+		_, err := calibrationSvc.GetProfile(ctx, 12)
+
+		assert.ErrorIs(t, err, ErrDeviceForbidden)
+		calReader.AssertNotCalled(t, "GetCalibrationProfile", mock.Anything, mock.Anything)
+	})
+}
+
+func TestSetCalibrationProfile(t *testing.T) {
+	ctx := context.Background()
+	dry, wet := 3000.0, 1000.0
+
+	t.Run("Success", func(t *testing.T) {
+		setupCalibrationSvcTests()
+		calUserDevGet.On("GetEditableDevice", ctx, int32(12)).Return(sqlc.Device{DeviceID: 12}, nil)
+
+		_, err := calibrationSvc.SetProfile(ctx, 12, CalibrationRequest{
+			SoilType: "clay",
+			Dry:      &dry,
+			Wet:      &wet,
+			Curve:    []calibration.Point{{Raw: 1500, Percent: 80}},
+		})
+
+		assert.Nil(t, err)
+		calWriter.AssertCalled(t, "UpsertCalibrationProfile", ctx, sqlc.CalibrationProfile{
+			DeviceID: 12,
+			SoilType: "clay",
+			DryValue: pgtype.Float8{Float64: dry, Valid: true},
+			WetValue: pgtype.Float8{Float64: wet, Valid: true},
+			Curve:    []byte(`[{"raw":1500,"percent":80}]`),
+		})
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		setupCalibrationSvcTests()
+		calUserDevGet.On("GetEditableDevice", ctx, int32(12)).Return(sqlc.Device{DeviceID: 12}, nil)
+
+		_, err := calibrationSvc.SetProfile(ctx, 12, CalibrationRequest{Dry: &dry, Wet: &dry})
+
+		assert.ErrorIs(t, err, ErrInvalidCalibration)
+		calWriter.AssertNotCalled(t, "UpsertCalibrationProfile", mock.Anything, mock.Anything)
+	})
+}
+
+func TestRecordCalibrationReference(t *testing.T) {
+	ctx := context.Background()
+	dvc := sqlc.Device{DeviceID: 12, Online: true, LatestReadings: []byte(`{"capacitance":1100,"temperature":21}`)}
+	existing := sqlc.CalibrationProfile{
+		DeviceID: 12,
+		SoilType: "loam",
+		DryValue: pgtype.Float8{Float64: 3000, Valid: true},
+	}
+
+	t.Run("Wet", func(t *testing.T) {
+		setupCalibrationSvcTests()
+		calUserDevGet.On("GetEditableDevice", ctx, int32(12)).Return(dvc, nil)
+		calReader.On("GetCalibrationProfile", ctx, int32(12)).Return(existing, nil)
+
+		_, err := calibrationSvc.RecordReference(ctx, 12, CalibrationWet)
+
+		assert.Nil(t, err)
+		calWriter.AssertCalled(t, "UpsertCalibrationProfile", ctx, sqlc.CalibrationProfile{
+			DeviceID: 12,
+			SoilType: "loam",
+			DryValue: existing.DryValue,
+			WetValue: pgtype.Float8{Float64: 1100, Valid: true},
+		})
+	})
+
+	t.Run("FirstReference", func(t *testing.T) {
+		setupCalibrationSvcTests()
+		calUserDevGet.On("GetEditableDevice", ctx, int32(12)).Return(dvc, nil)
+		calReader.On("GetCalibrationProfile", ctx, int32(12)).Return(sqlc.CalibrationProfile{}, nil)
+
+		_, err := calibrationSvc.RecordReference(ctx, 12, CalibrationDry)
+
+		assert.Nil(t, err)
+		calWriter.AssertCalled(t, "UpsertCalibrationProfile", ctx, sqlc.CalibrationProfile{
+			DeviceID: 12,
+			DryValue: pgtype.Float8{Float64: 1100, Valid: true},
+		})
+	})
+
+	t.Run("Offline", func(t *testing.T) {
+		setupCalibrationSvcTests()
+		offline := dvc
+		offline.Online = false
+		calUserDevGet.On("GetEditableDevice", ctx, int32(12)).Return(offline, nil)
+
+		_, err := calibrationSvc.RecordReference(ctx, 12, CalibrationDry)
+
+		assert.ErrorIs(t, err, ErrNoCalibrationReading)
+		calWriter.AssertNotCalled(t, "UpsertCalibrationProfile", mock.Anything, mock.Anything)
+	})
+
+	t.Run("UnknownReference", func(t *testing.T) {
+		setupCalibrationSvcTests()
+
+		_, err := calibrationSvc.RecordReference(ctx, 12, "damp")
+
+		assert.ErrorIs(t, err, ErrInvalidCalibration)
+		calUserDevGet.AssertNotCalled(t, "GetEditableDevice", mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	core_topics "github.com/frozenkro/dirtie-srv/internal/core/topics"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/google/uuid"
+)
+
+type CommandType string
+
+const (
+	CommandSetSampleInterval CommandType = "set_sample_interval"
+	CommandReadNow           CommandType = "read_now"
+	CommandDumpLogs          CommandType = "dump_logs"
+	CommandReboot            CommandType = "reboot"
+)
+
+const (
+	CommandStatusPending = "pending"
+	CommandStatusSent    = "sent"
+	CommandStatusAcked   = "acked"
+	CommandStatusFailed  = "failed"
+	CommandStatusExpired = "expired"
+)
+
+var (
+	defaultCommandTtl      = 5 * time.Minute
+	maxCommandTtl          = 24 * time.Hour
+	commandQos        byte = 1
+	// device_commands.error is VARCHAR(250)
+	maxCommandErrorLen = 250
+)
+
+var (
+	ErrInvalidCommand       = fmt.Errorf("Invalid device command")
+	ErrCommandNotFound      = fmt.Errorf("Device command not found")
+	ErrCommandExpired       = fmt.Errorf("Device command expired")
+	ErrDeviceNotProvisioned = fmt.Errorf("Device has not completed provisioning")
+)
+
+type CommandReader interface {
+	GetDeviceCommand(ctx context.Context, commandId string) (sqlc.DeviceCommand, error)
+	GetDeviceCommands(ctx context.Context, deviceId int32) ([]sqlc.DeviceCommand, error)
+}
+type CommandWriter interface {
+	CreateDeviceCommand(ctx context.Context, commandId string, deviceId int32, commandType string, args []byte, status string, expiresAt time.Time) (sqlc.DeviceCommand, error)
+	UpdateDeviceCommandStatus(ctx context.Context, commandId string, status string, errMsg string) error
+	AckDeviceCommand(ctx context.Context, commandId string, status string, errMsg string) error
+	ExpireDeviceCommands(ctx context.Context) error
+}
+
+type MessagePublisher interface {
+	Publish(ctx context.Context, topic string, qos byte, payload []byte) error
+}
+
+type UserDeviceGetter interface {
+	GetUserDevice(ctx context.Context, deviceId int32) (sqlc.Device, error)
+	GetEditableDevice(ctx context.Context, deviceId int32) (sqlc.Device, error)
+}
+
+type ReportIntervalWriter interface {
+	UpdateDeviceReportInterval(ctx context.Context, deviceId int32, intervalSec int32) error
+}
+
+type CommandSvc struct {
+	cmdReader        CommandReader
+	cmdWriter        CommandWriter
+	publisher        MessagePublisher
+	userDeviceGetter UserDeviceGetter
+	deviceGetter     DeviceGetter
+	intervalWriter   ReportIntervalWriter
+}
+
+// Sent by the user via rest api
+type CommandRequest struct {
+	Type   CommandType     `json:"type"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	TtlSec int             `json:"ttlSec,omitempty"`
+}
+
+type SampleIntervalArgs struct {
+	IntervalSec int `json:"intervalSec"`
+}
+
+// Published to dirtie/<mac>/command
+type CommandMessage struct {
+	CommandId string          `json:"commandId"`
+	Type      CommandType     `json:"type"`
+	Args      json.RawMessage `json:"args,omitempty"`
+	ExpiresAt int64           `json:"expiresAt"`
+}
+
+// Received on dirtie/<mac>/ack
+type CommandAckPayload struct {
+	MacAddr   string `json:"macAddr"`
+	CommandId string `json:"commandId"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error"`
+}
+
+func NewCommandSvc(cmdReader CommandReader,
+	cmdWriter CommandWriter,
+	publisher MessagePublisher,
+	userDeviceGetter UserDeviceGetter,
+	deviceGetter DeviceGetter,
+	intervalWriter ReportIntervalWriter) CommandSvc {
+
+	return CommandSvc{
+		cmdReader:        cmdReader,
+		cmdWriter:        cmdWriter,
+		publisher:        publisher,
+		userDeviceGetter: userDeviceGetter,
+		deviceGetter:     deviceGetter,
+		intervalWriter:   intervalWriter,
+	}
+}
+
+// Called by user via rest api
+func (s CommandSvc) SendCommand(ctx context.Context, deviceId int32, req CommandRequest) (sqlc.DeviceCommand, error) {
+	if err := validateCommand(req); err != nil {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error SendCommand -> validateCommand: \n%w\n", err)
+	}
+
+	device, err := s.userDeviceGetter.GetEditableDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error SendCommand -> GetEditableDevice: \n%w\n", err)
+	}
+	if device.MacAddr.String == "" {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error SendCommand (deviceId: %v): \n%w\n", deviceId, ErrDeviceNotProvisioned)
+	}
+
+	ttl := defaultCommandTtl
+	if req.TtlSec > 0 {
+		ttl = time.Duration(req.TtlSec) * time.Second
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	cmd, err := s.cmdWriter.CreateDeviceCommand(ctx,
+		uuid.NewString(),
+		device.DeviceID,
+		string(req.Type),
+		req.Args,
+		CommandStatusPending,
+		expiresAt)
+	if err != nil {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error SendCommand -> CreateDeviceCommand: \n%w\n", err)
+	}
+
+	msg, err := json.Marshal(CommandMessage{
+		CommandId: cmd.CommandID,
+		Type:      req.Type,
+		Args:      req.Args,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error SendCommand -> Marshal: \n%w\n", err)
+	}
+
+	topic := core_topics.DeviceTopic(device.MacAddr.String, core_topics.DeviceCommand)
+	err = s.publisher.Publish(ctx, topic, commandQos, msg)
+	if err != nil {
+		if updErr := s.cmdWriter.UpdateDeviceCommandStatus(ctx, cmd.CommandID, CommandStatusFailed, err.Error()); updErr != nil {
+			err = fmt.Errorf("%w\n%w", err, updErr)
+		}
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error SendCommand -> Publish: \n%w\n", err)
+	}
+
+	err = s.cmdWriter.UpdateDeviceCommandStatus(ctx, cmd.CommandID, CommandStatusSent, "")
+	if err != nil {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error SendCommand -> UpdateDeviceCommandStatus: \n%w\n", err)
+	}
+	cmd.Status = CommandStatusSent
+
+	return cmd, nil
+}
+
+func (s CommandSvc) GetCommands(ctx context.Context, deviceId int32) ([]sqlc.DeviceCommand, error) {
+	device, err := s.userDeviceGetter.GetUserDevice(ctx, deviceId)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetCommands -> GetUserDevice: \n%w\n", err)
+	}
+
+	err = s.cmdWriter.ExpireDeviceCommands(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetCommands -> ExpireDeviceCommands: \n%w\n", err)
+	}
+
+	cmds, err := s.cmdReader.GetDeviceCommands(ctx, device.DeviceID)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetCommands -> GetDeviceCommands: \n%w\n", err)
+	}
+	return cmds, nil
+}
+
+func (s CommandSvc) GetCommand(ctx context.Context, deviceId int32, commandId string) (sqlc.DeviceCommand, error) {
+	device, err := s.userDeviceGetter.GetUserDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error GetCommand -> GetUserDevice: \n%w\n", err)
+	}
+
+	err = s.cmdWriter.ExpireDeviceCommands(ctx)
+	if err != nil {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error GetCommand -> ExpireDeviceCommands: \n%w\n", err)
+	}
+
+	cmd, err := s.cmdReader.GetDeviceCommand(ctx, commandId)
+	if err != nil {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error GetCommand -> GetDeviceCommand: \n%w\n", err)
+	}
+	if cmd.CommandID == "" || cmd.DeviceID != device.DeviceID {
+		return sqlc.DeviceCommand{}, fmt.Errorf("Error GetCommand (commandId: %v): \n%w\n", commandId, ErrCommandNotFound)
+	}
+	return cmd, nil
+}
+
+// Called by device via mqtt hub
+func (s CommandSvc) AckCommand(ctx context.Context, payload CommandAckPayload) error {
+	cmd, err := s.cmdReader.GetDeviceCommand(ctx, payload.CommandId)
+	if err != nil {
+		return fmt.Errorf("Error AckCommand -> GetDeviceCommand: \n%w\n", err)
+	}
+
+	dvc, err := s.deviceGetter.GetDeviceByMacAddress(ctx, payload.MacAddr)
+	if err != nil {
+		return fmt.Errorf("Error AckCommand -> GetDeviceByMacAddress: \n%w\n", err)
+	}
+	if cmd.CommandID == "" || dvc.DeviceID <= 0 || cmd.DeviceID != dvc.DeviceID {
+		return fmt.Errorf("Error AckCommand (commandId: %v, macAddr: %v): \n%w\n", payload.CommandId, payload.MacAddr, ErrCommandNotFound)
+	}
+
+	switch cmd.Status {
+	case CommandStatusAcked, CommandStatusFailed:
+		// duplicate delivery of a QoS 1 ack
+		return nil
+	case CommandStatusExpired:
+		return fmt.Errorf("Error AckCommand (commandId: %v): \n%w\n", cmd.CommandID, ErrCommandExpired)
+	}
+	if time.Now().After(cmd.ExpiresAt.Time) {
+		err = s.cmdWriter.UpdateDeviceCommandStatus(ctx, cmd.CommandID, CommandStatusExpired, "")
+		if err != nil {
+			return fmt.Errorf("Error AckCommand -> UpdateDeviceCommandStatus: \n%w\n", err)
+		}
+		return fmt.Errorf("Error AckCommand (commandId: %v): \n%w\n", cmd.CommandID, ErrCommandExpired)
+	}
+
+	status := CommandStatusAcked
+	if !payload.Success {
+		status = CommandStatusFailed
+	}
+
+	// The offline sweeper needs to know how often the device now reports.
+	// Done before the ack is stored so a redelivered ack retries it.
+	if payload.Success && CommandType(cmd.CommandType) == CommandSetSampleInterval {
+		var args SampleIntervalArgs
+		if err = json.Unmarshal(cmd.Args, &args); err != nil {
+			return fmt.Errorf("Error AckCommand -> Unmarshal (commandId: %v): \n%w\n", cmd.CommandID, err)
+		}
+		err = s.intervalWriter.UpdateDeviceReportInterval(ctx, dvc.DeviceID, int32(args.IntervalSec))
+		if err != nil {
+			return fmt.Errorf("Error AckCommand -> UpdateDeviceReportInterval: \n%w\n", err)
+		}
+	}
+
+	// a longer message would fail the insert and leave the command
+	// unacked until it expires
+	ackErr := payload.Error
+	if r := []rune(ackErr); len(r) > maxCommandErrorLen {
+		ackErr = string(r[:maxCommandErrorLen])
+	}
+
+	err = s.cmdWriter.AckDeviceCommand(ctx, cmd.CommandID, status, ackErr)
+	if err != nil {
+		return fmt.Errorf("Error AckCommand -> AckDeviceCommand: \n%w\n", err)
+	}
+	return nil
+}
+
+func validateCommand(req CommandRequest) error {
+	if req.TtlSec < 0 || time.Duration(req.TtlSec)*time.Second > maxCommandTtl {
+		return fmt.Errorf("ttlSec must be between 0 and %v: %w", maxCommandTtl.Seconds(), ErrInvalidCommand)
+	}
+
+	switch req.Type {
+	case CommandSetSampleInterval:
+		var args SampleIntervalArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil || args.IntervalSec <= 0 {
+			return fmt.Errorf("'%v' requires a positive args.intervalSec: %w", req.Type, ErrInvalidCommand)
+		}
+	case CommandReadNow, CommandDumpLogs, CommandReboot:
+	default:
+		return fmt.Errorf("unknown command type '%v': %w", req.Type, ErrInvalidCommand)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	cmdReader        mocks.MockCommandReader
+	cmdWriter        mocks.MockCommandWriter
+	msgPublisher     mocks.MockMessagePublisher
+	userDeviceGetter mocks.MockUserDeviceGetter
+	cmdDeviceGetter  mocks.MockDeviceGetter
+	intervalWriter   mocks.MockReportIntervalWriter
+	commandSvc       CommandSvc
+)
+
+func setupCommandSvcTests() {
+	cmdReader = mocks.MockCommandReader{Mock: new(mock.Mock)}
+	cmdWriter = mocks.MockCommandWriter{Mock: new(mock.Mock)}
+	msgPublisher = mocks.MockMessagePublisher{Mock: new(mock.Mock)}
+	userDeviceGetter = mocks.MockUserDeviceGetter{Mock: new(mock.Mock)}
+	cmdDeviceGetter = mocks.MockDeviceGetter{Mock: new(mock.Mock)}
+	intervalWriter = mocks.MockReportIntervalWriter{Mock: new(mock.Mock)}
+
+	commandSvc = NewCommandSvc(cmdReader,
+		cmdWriter,
+		msgPublisher,
+		userDeviceGetter,
+		cmdDeviceGetter,
+		intervalWriter)
+}
+
+func TestSendCommand(t *testing.T) {
+	ctx := context.Background()
+
+	dvc := sqlc.Device{
+		DeviceID: 12,
+		UserID:   1,
+		MacAddr:  pgtype.Text{String: "aabbccddeeff", Valid: true},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		setupCommandSvcTests()
+		req := CommandRequest{
+			Type: CommandSetSampleInterval,
+			Args: json.RawMessage(`{"intervalSec":300}`),
+		}
+		created := sqlc.DeviceCommand{
+			CommandID:   "cmd-1",
+			DeviceID:    dvc.DeviceID,
+			CommandType: string(req.Type),
+			Status:      CommandStatusPending,
+		}
+
+		userDeviceGetter.On("GetEditableDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		cmdWriter.On("CreateDeviceCommand", ctx, mock.Anything, dvc.DeviceID, string(req.Type),
+			[]byte(req.Args), CommandStatusPending, mock.AnythingOfType("time.Time")).Return(created, nil)
+		msgPublisher.On("Publish", ctx, "dirtie/aabbccddeeff/command", byte(1),
+			mock.MatchedBy(func(b []byte) bool {
+				var msg CommandMessage
+				return json.Unmarshal(b, &msg) == nil && msg.CommandId == created.CommandID
+			})).Return(nil)
+		cmdWriter.On("UpdateDeviceCommandStatus", ctx, created.CommandID, CommandStatusSent, "").Return(nil)
+
+		cmd, err := commandSvc.SendCommand(ctx, dvc.DeviceID, req)
+
+		assert.Nil(t, err)
+		assert.Equal(t, CommandStatusSent, cmd.Status)
+		msgPublisher.AssertExpectations(t)
+		cmdWriter.AssertExpectations(t)
+	})
+
+	t.Run("InvalidCommand", func(t *testing.T) {
+		setupCommandSvcTests()
+		reqs := []CommandRequest{
+			{Type: "self_destruct"},
+			{Type: CommandSetSampleInterval},
+			{Type: CommandReboot, TtlSec: -1},
+		}
+
+		for _, req := range reqs {
+			_, err := commandSvc.SendCommand(ctx, dvc.DeviceID, req)
+			assert.ErrorIs(t, err, ErrInvalidCommand)
+		}
+		userDeviceGetter.AssertNotCalled(t, "GetEditableDevice", mock.Anything, mock.Anything)
+	})
+
+	t.Run("NotProvisioned", func(t *testing.T) {
+		setupCommandSvcTests()
+		unprovisioned := sqlc.Device{DeviceID: 13, UserID: 1}
+		userDeviceGetter.On("GetEditableDevice", ctx, unprovisioned.DeviceID).Return(unprovisioned, nil)
+
+		_, err := commandSvc.SendCommand(ctx, unprovisioned.DeviceID, CommandRequest{Type: CommandReboot})
+
+		assert.ErrorIs(t, err, ErrDeviceNotProvisioned)
+		cmdWriter.AssertNotCalled(t, "CreateDeviceCommand", mock.Anything, mock.Anything, mock.Anything,
+			mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("PublishFails", func(t *testing.T) {
+		setupCommandSvcTests()
+		created := sqlc.DeviceCommand{CommandID: "cmd-2", DeviceID: dvc.DeviceID}
+		pubErr := fmt.Errorf("broker unavailable")
+
+		userDeviceGetter.On("GetEditableDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		cmdWriter.On("CreateDeviceCommand", ctx, mock.Anything, dvc.DeviceID, mock.Anything,
+			mock.Anything, CommandStatusPending, mock.Anything).Return(created, nil)
+		msgPublisher.On("Publish", ctx, mock.Anything, mock.Anything, mock.Anything).Return(pubErr)
+		cmdWriter.On("UpdateDeviceCommandStatus", ctx, created.CommandID, CommandStatusFailed, pubErr.Error()).Return(nil)
+
+		_, err := commandSvc.SendCommand(ctx, dvc.DeviceID, CommandRequest{Type: CommandReadNow})
+
+		assert.ErrorIs(t, err, pubErr)
+		cmdWriter.AssertExpectations(t)
+	})
+}
+
+func TestAckCommand(t *testing.T) {
+	ctx := context.Background()
+
+	dvc := sqlc.Device{
+		DeviceID: 12,
+		MacAddr:  pgtype.Text{String: "aabbccddeeff", Valid: true},
+	}
+	sentCmd := sqlc.DeviceCommand{
+		CommandID: "cmd-1",
+		DeviceID:  dvc.DeviceID,
+		Status:    CommandStatusSent,
+		ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(time.Minute), Valid: true},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		setupCommandSvcTests()
+		payload := CommandAckPayload{MacAddr: dvc.MacAddr.String, CommandId: sentCmd.CommandID, Success: true}
+
+		cmdReader.On("GetDeviceCommand", ctx, sentCmd.CommandID).Return(sentCmd, nil)
+		cmdDeviceGetter.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(dvc, nil)
+		cmdWriter.On("AckDeviceCommand", ctx, sentCmd.CommandID, CommandStatusAcked, "").Return(nil)
+
+		err := commandSvc.AckCommand(ctx, payload)
+
+		assert.Nil(t, err)
+		cmdWriter.AssertExpectations(t)
+		intervalWriter.AssertNotCalled(t, "UpdateDeviceReportInterval", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("SampleInterval", func(t *testing.T) {
+		setupCommandSvcTests()
+		intervalCmd := sentCmd
+		intervalCmd.CommandType = string(CommandSetSampleInterval)
+		intervalCmd.Args = []byte(`{"intervalSec":600}`)
+		payload := CommandAckPayload{MacAddr: dvc.MacAddr.String, CommandId: intervalCmd.CommandID, Success: true}
+
+		cmdReader.On("GetDeviceCommand", ctx, intervalCmd.CommandID).Return(intervalCmd, nil)
+		cmdDeviceGetter.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(dvc, nil)
+		intervalWriter.On("UpdateDeviceReportInterval", ctx, dvc.DeviceID, int32(600)).Return(nil)
+		cmdWriter.On("AckDeviceCommand", ctx, intervalCmd.CommandID, CommandStatusAcked, "").Return(nil)
+
+		err := commandSvc.AckCommand(ctx, payload)
+
+		assert.Nil(t, err)
+		intervalWriter.AssertExpectations(t)
+		cmdWriter.AssertExpectations(t)
+	})
+
+	t.Run("DeviceFailure", func(t *testing.T) {
+		setupCommandSvcTests()
+		payload := CommandAckPayload{MacAddr: dvc.MacAddr.String, CommandId: sentCmd.CommandID, Error: "sensor busy"}
+
+		cmdReader.On("GetDeviceCommand", ctx, sentCmd.CommandID).Return(sentCmd, nil)
+		cmdDeviceGetter.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(dvc, nil)
+		cmdWriter.On("AckDeviceCommand", ctx, sentCmd.CommandID, CommandStatusFailed, payload.Error).Return(nil)
+
+		err := commandSvc.AckCommand(ctx, payload)
+
+		assert.Nil(t, err)
+		cmdWriter.AssertExpectations(t)
+	})
+
+	t.Run("LongError", func(t *testing.T) {
+		setupCommandSvcTests()
+		payload := CommandAckPayload{MacAddr: dvc.MacAddr.String, CommandId: sentCmd.CommandID, Error: strings.Repeat("é", 300)}
+
+		cmdReader.On("GetDeviceCommand", ctx, sentCmd.CommandID).Return(sentCmd, nil)
+		cmdDeviceGetter.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(dvc, nil)
+		cmdWriter.On("AckDeviceCommand", ctx, sentCmd.CommandID, CommandStatusFailed, strings.Repeat("é", 250)).Return(nil)
+
+		err := commandSvc.AckCommand(ctx, payload)
+
+		assert.Nil(t, err)
+		cmdWriter.AssertExpectations(t)
+	})
+
+	t.Run("OtherDevice", func(t *testing.T) {
+		setupCommandSvcTests()
+		other := sqlc.Device{DeviceID: 99, MacAddr: pgtype.Text{String: "ffeeddccbbaa", Valid: true}}
+		payload := CommandAckPayload{MacAddr: other.MacAddr.String, CommandId: sentCmd.CommandID, Success: true}
+
+		cmdReader.On("GetDeviceCommand", ctx, sentCmd.CommandID).Return(sentCmd, nil)
+		cmdDeviceGetter.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(other, nil)
+
+		err := commandSvc.AckCommand(ctx, payload)
+
+		assert.ErrorIs(t, err, ErrCommandNotFound)
+		cmdWriter.AssertNotCalled(t, "AckDeviceCommand", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		setupCommandSvcTests()
+		expiredCmd := sentCmd
+		expiredCmd.ExpiresAt = pgtype.Timestamptz{Time: time.Now().Add(-time.Minute), Valid: true}
+		payload := CommandAckPayload{MacAddr: dvc.MacAddr.String, CommandId: expiredCmd.CommandID, Success: true}
+
+		cmdReader.On("GetDeviceCommand", ctx, expiredCmd.CommandID).Return(expiredCmd, nil)
+		cmdDeviceGetter.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(dvc, nil)
+		cmdWriter.On("UpdateDeviceCommandStatus", ctx, expiredCmd.CommandID, CommandStatusExpired, "").Return(nil)
+
+		err := commandSvc.AckCommand(ctx, payload)
+
+		assert.ErrorIs(t, err, ErrCommandExpired)
+		cmdWriter.AssertNotCalled(t, "AckDeviceCommand", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -3,44 +3,263 @@ package services
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/calibration"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
 	"github.com/frozenkro/dirtie-srv/internal/db"
 )
 
+type Aggregate string
+
+const (
+	AggregateMean Aggregate = "mean"
+	AggregateMin  Aggregate = "min"
+	AggregateMax  Aggregate = "max"
+	AggregateLast Aggregate = "last"
+)
+
+var (
+	minDataWindow = time.Minute
+	maxDataWindow = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidStartTime = fmt.Errorf("Invalid startTime, expected RFC3339")
+	ErrInvalidDataQuery = fmt.Errorf("Invalid data query")
+	ErrTooManyPoints    = fmt.Errorf("Data query would return too many points, use a wider window")
+)
+
+type DeviceDataQuerier interface {
+	QueryRange(ctx context.Context, deviceId int, measurementKey string, q db.RangeQuery) ([]db.DeviceDataPoint, error)
+}
+
 type DataSvc struct {
-	DataRetriever DeviceDataRetriever
+	DataQuerier      DeviceDataQuerier
+	Measurements     MeasurementRegistry
+	UserDeviceGetter UserDeviceGetter
+	Calibrations     CalibrationReader
+	Tiers            []db.BucketTier
+	MaxPoints        int
+}
+
+// Raw query parameters from the rest api. Only Measurement and
+// StartTime are required; EndTime defaults to now, and Aggregate
+// defaults to mean when a Window is given.
+type DataQuery struct {
+	Measurement string
+	StartTime   string
+	EndTime     string
+	Window      string
+	Aggregate   string
+}
+
+type DataSeries struct {
+	Measurement measurements.Measurement
+	Start       time.Time
+	End         time.Time
+	// zero for raw points
+	Window    time.Duration
+	Aggregate Aggregate
+	Points    []db.DeviceDataPoint
+	// moisture percent for each of Points, when the measurement is
+	// capacitance and the device has a complete calibration profile
+	Moisture []db.DeviceDataPoint
+}
+
+func NewDataSvc(dataQuerier DeviceDataQuerier,
+	registry MeasurementRegistry,
+	userDeviceGetter UserDeviceGetter,
+	calibrations CalibrationReader,
+	tiers []db.BucketTier,
+	maxPoints int) DataSvc {
+
+	return DataSvc{
+		DataQuerier:      dataQuerier,
+		Measurements:     registry,
+		UserDeviceGetter: userDeviceGetter,
+		Calibrations:     calibrations,
+		Tiers:            tiers,
+		MaxPoints:        maxPoints,
+	}
 }
 
-func NewDataSvc(dataRet DeviceDataRetriever) DataSvc {
-	return DataSvc{DataRetriever: dataRet}
+func (s DataSvc) ListMeasurements() []measurements.Measurement {
+	return s.Measurements.All()
 }
 
-func (s DataSvc) CapacitanceData(ctx context.Context, deviceId int, startTime string) ([]db.DeviceDataPoint, error) {
-	return s.dataSince(ctx, deviceId, startTime, core.Capacitance)
+func (s DataSvc) MeasurementData(ctx context.Context, deviceId int, q DataQuery) (DataSeries, error) {
+	m, err := s.Measurements.Get(q.Measurement)
+	if err != nil {
+		return DataSeries{}, fmt.Errorf("Error MeasurementData -> Get measurement: \n%w\n", err)
+	}
+
+	// only the owner of a device may read its data
+	device, err := s.UserDeviceGetter.GetUserDevice(ctx, int32(deviceId))
+	if err != nil {
+		return DataSeries{}, fmt.Errorf("Error MeasurementData -> GetUserDevice: \n%w\n", err)
+	}
+
+	series, err := s.parseQuery(q)
+	if err != nil {
+		return DataSeries{}, fmt.Errorf("Error MeasurementData -> parseQuery: \n%w\n", err)
+	}
+	series.Measurement = m
+
+	rq := db.RangeQuery{
+		Start: series.Start,
+		End:   series.End,
+		Every: series.Window,
+		Fn:    string(series.Aggregate),
+	}
+	if tier := pickTier(s.Tiers, series.Start, series.Window, time.Now()); tier.Resolution > 0 {
+		rq.Bucket = tier.Bucket
+		rq.RollupAgg = string(series.Aggregate)
+	}
+	if series.Window > 0 {
+		windows := int((series.End.Sub(series.Start) + series.Window - 1) / series.Window)
+		if s.MaxPoints > 0 && windows > s.MaxPoints {
+			return DataSeries{}, fmt.Errorf("Error MeasurementData (%v windows, max %v): \n%w\n", windows, s.MaxPoints, ErrTooManyPoints)
+		}
+	} else if s.MaxPoints > 0 {
+		// one extra so we can tell when raw data was cut off
+		rq.Limit = s.MaxPoints + 1
+	}
+
+	series.Points, err = s.DataQuerier.QueryRange(ctx, int(device.DeviceID), m.Name, rq)
+	if err != nil {
+		return DataSeries{}, fmt.Errorf("Error in DataSvc -> QueryRange: \n%w\n", err)
+	}
+	if rq.Limit > 0 && len(series.Points) > s.MaxPoints {
+		return DataSeries{}, fmt.Errorf("Error MeasurementData (more than %v raw points): \n%w\n", s.MaxPoints, ErrTooManyPoints)
+	}
+
+	if m.Name == core.Capacitance {
+		series.Moisture, err = s.moisture(ctx, device.DeviceID, series.Points)
+		if err != nil {
+			return DataSeries{}, fmt.Errorf("Error MeasurementData -> moisture: \n%w\n", err)
+		}
+	}
+	return series, nil
 }
 
-func (s DataSvc) TemperatureData(ctx context.Context, deviceId int, startTime string) ([]db.DeviceDataPoint, error) {
-	return s.dataSince(ctx, deviceId, startTime, core.Temperature)
+// moisture converts capacitance points with the device's calibration
+// profile, nil when the device isn't calibrated
+func (s DataSvc) moisture(ctx context.Context, deviceId int32, points []db.DeviceDataPoint) ([]db.DeviceDataPoint, error) {
+	if s.Calibrations == nil {
+		return nil, nil
+	}
+
+	row, err := s.Calibrations.GetCalibrationProfile(ctx, deviceId)
+	if err != nil {
+		return nil, fmt.Errorf("Error moisture -> GetCalibrationProfile: \n%w\n", err)
+	}
+	profile, err := profileFromRow(row)
+	if err != nil {
+		return nil, fmt.Errorf("Error moisture -> profileFromRow: \n%w\n", err)
+	}
+	if !profile.Complete() {
+		return nil, nil
+	}
+
+	moisture := make([]db.DeviceDataPoint, len(points))
+	for i, p := range points {
+		percent, _ := profile.Moisture(p.Value)
+		moisture[i] = db.DeviceDataPoint{Value: percent, Time: p.Time, Key: calibration.Moisture}
+	}
+	return moisture, nil
 }
 
-func (s DataSvc) dataSince(ctx context.Context, deviceId int, startTime string, measurement string) ([]db.DeviceDataPoint, error) {
-	startTimeT, err := time.Parse(time.RFC3339, startTime)
+func (s DataSvc) parseQuery(q DataQuery) (DataSeries, error) {
+	series := DataSeries{}
+
+	start, err := time.Parse(time.RFC3339, q.StartTime)
 	if err != nil {
-		return nil, fmt.Errorf(
-			`Error parsing time in DataSvc - measurement '%v', startTime '%v: \nError:\n%w\n`,
-			measurement, startTime, err)
+		return series, fmt.Errorf(
+			`Error parsing time in DataSvc - measurement '%v', startTime '%v: \nError:\n%w\n%w\n`,
+			q.Measurement, q.StartTime, ErrInvalidStartTime, err)
+	}
+	series.Start = start
+
+	series.End = time.Now()
+	if q.EndTime != "" {
+		series.End, err = time.Parse(time.RFC3339, q.EndTime)
+		if err != nil {
+			return series, fmt.Errorf("endTime '%v' is not RFC3339: %w", q.EndTime, ErrInvalidDataQuery)
+		}
+	}
+	if !series.End.After(series.Start) {
+		return series, fmt.Errorf("endTime must be after startTime: %w", ErrInvalidDataQuery)
+	}
+
+	if q.Window == "" {
+		if q.Aggregate != "" {
+			return series, fmt.Errorf("aggregate requires a window: %w", ErrInvalidDataQuery)
+		}
+		return series, nil
 	}
 
-	endTimeT := time.Now()
-	data, err := s.DataRetriever.GetValuesRange(ctx,
-		deviceId,
-		measurement,
-		startTimeT,
-		endTimeT)
+	series.Window, err = parseWindow(q.Window)
 	if err != nil {
-		return nil, fmt.Errorf("Error in DataSvc -> GetValuesRange: \n%w\n", err)
+		return series, err
+	}
+
+	series.Aggregate = AggregateMean
+	if q.Aggregate != "" {
+		series.Aggregate = Aggregate(q.Aggregate)
+	}
+	switch series.Aggregate {
+	case AggregateMean, AggregateMin, AggregateMax, AggregateLast:
+	default:
+		return series, fmt.Errorf("unknown aggregate '%v': %w", q.Aggregate, ErrInvalidDataQuery)
+	}
+	return series, nil
+}
+
+// pickTier returns the finest tier that still holds data back to start
+// without being coarser than the window. When nothing that fine reaches
+// back far enough, complete history wins over resolution. Raw queries
+// always read the raw tier.
+func pickTier(tiers []db.BucketTier, start time.Time, window time.Duration, now time.Time) db.BucketTier {
+	if len(tiers) == 0 || window == 0 {
+		return db.BucketTier{}
+	}
+
+	age := now.Sub(start)
+	covers := func(t db.BucketTier) bool {
+		return t.Retention == 0 || age <= t.Retention
+	}
+
+	for _, t := range tiers {
+		if t.Resolution <= window && covers(t) {
+			return t
+		}
+	}
+	for _, t := range tiers {
+		if covers(t) {
+			return t
+		}
+	}
+	return tiers[len(tiers)-1]
+}
+
+// parseWindow accepts go durations plus a 'd' suffix for whole days
+func parseWindow(w string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(w, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(w)
+	}
+
+	if err != nil || d < minDataWindow || d > maxDataWindow || d%time.Second != 0 {
+		return 0, fmt.Errorf("window '%v' must be between %v and %v: %w", w, minDataWindow, maxDataWindow, ErrInvalidDataQuery)
 	}
-	return data, nil
+	return d, nil
 }
@@ -7,34 +7,49 @@ import (
 	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/measurements"
 	"github.com/frozenkro/dirtie-srv/internal/db"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 var (
-	dataRet   mocks.MockDeviceDataRetriever
-	deviceGet mocks.MockDeviceGetter
-	dataSvc   DataSvc
+	dataRet        mocks.MockDeviceDataRetriever
+	dataQuerier    mocks.MockDeviceDataQuerier
+	dataUserDevGet mocks.MockUserDeviceGetter
+	dataCalReader  mocks.MockCalibrationReader
+	dataSvc        DataSvc
 )
 
+const testMaxPoints = 100
+
+var testTiers = []db.BucketTier{
+	{Bucket: "raw", Retention: 30 * 24 * time.Hour},
+	{Bucket: "raw_hourly", Retention: 365 * 24 * time.Hour, Resolution: time.Hour, Source: "raw"},
+	{Bucket: "raw_daily", Resolution: 24 * time.Hour, Source: "raw_hourly"},
+}
+
 func setupDataSvcTests() {
 	dataRet = mocks.MockDeviceDataRetriever{Mock: new(mock.Mock)}
-	deviceGet = mocks.MockDeviceGetter{Mock: new(mock.Mock)}
-	dataSvc = NewDataSvc(dataRet)
+	dataQuerier = mocks.MockDeviceDataQuerier{Mock: new(mock.Mock)}
+	dataUserDevGet = mocks.MockUserDeviceGetter{Mock: new(mock.Mock)}
+	dataCalReader = mocks.MockCalibrationReader{Mock: new(mock.Mock)}
+	registry, _ = measurements.NewRegistry(measurements.Defaults...)
+	dataSvc = NewDataSvc(dataQuerier, registry, dataUserDevGet, dataCalReader, testTiers, testMaxPoints)
 }
 
-func TestCapacitanceData(t *testing.T) {
+func TestMeasurementData(t *testing.T) {
 	ctx := context.Background()
-	setupDataSvcTests()
-
-	t.Run("Success", func(t *testing.T) {
-		deviceId := 123
-		now := time.Now()
-		startTimeT := now.Add(-3 * time.Hour)
-		startTime := startTimeT.Format(time.RFC3339)
+	deviceId := 123
+	now := time.Now()
+	startTimeT := now.Add(-3 * time.Hour)
+	startTime := startTimeT.Format(time.RFC3339)
 
+	t.Run("Raw", func(t *testing.T) {
+		setupDataSvcTests()
 		expData := []db.DeviceDataPoint{
 			db.DeviceDataPoint{
 				Value: 1234,
@@ -48,83 +63,251 @@ func TestCapacitanceData(t *testing.T) {
 			},
 		}
 
-		dataRet.On("GetValuesRange",
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
+		dataQuerier.On("QueryRange",
 			ctx, deviceId, core.Capacitance,
-			mock.MatchedBy(func(tm time.Time) bool {
-				return tm.Format(time.RFC3339) == startTime
-			}),
-			mock.MatchedBy(func(tm time.Time) bool {
-				return tm.Unix() > startTimeT.Unix()
+			mock.MatchedBy(func(q db.RangeQuery) bool {
+				return q.Start.Format(time.RFC3339) == startTime &&
+					q.End.Unix() > startTimeT.Unix() &&
+					q.Every == 0 &&
+					q.Limit == testMaxPoints+1
 			}),
 		).Return(expData, nil)
+		dataCalReader.On("GetCalibrationProfile", ctx, int32(deviceId)).Return(sqlc.CalibrationProfile{}, nil)
 
-		result, err := dataSvc.CapacitanceData(ctx,
-			deviceId,
-			startTime)
+		result, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{
+			Measurement: core.Capacitance,
+			StartTime:   startTime,
+		})
 		if err != nil {
-			t.Fatalf("Error in data_svc.CapacitanceData: %v", err)
+			t.Fatalf("Error in data_svc.MeasurementData: %v", err)
+		}
+
+		assert.Equal(t, core.Capacitance, result.Measurement.Name)
+		assert.Zero(t, result.Window)
+		assert.Equal(t, expData, result.Points)
+		assert.Nil(t, result.Moisture)
+	})
+
+	t.Run("Moisture", func(t *testing.T) {
+		setupDataSvcTests()
+		points := []db.DeviceDataPoint{
+			{Value: 2000, Time: now.Add(-2 * time.Hour), Key: core.Capacitance},
+			{Value: 3500, Time: now.Add(-1 * time.Hour), Key: core.Capacitance},
 		}
+		profile := sqlc.CalibrationProfile{
+			DeviceID: int32(deviceId),
+			DryValue: pgtype.Float8{Float64: 3000, Valid: true},
+			WetValue: pgtype.Float8{Float64: 1000, Valid: true},
+		}
+
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
+		dataQuerier.On("QueryRange", ctx, deviceId, core.Capacitance, mock.Anything).Return(points, nil)
+		dataCalReader.On("GetCalibrationProfile", ctx, int32(deviceId)).Return(profile, nil)
+
+		result, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{
+			Measurement: core.Capacitance,
+			StartTime:   startTime,
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, points, result.Points)
+		assert.Equal(t, []db.DeviceDataPoint{
+			{Value: 50, Time: points[0].Time, Key: "moisture"},
+			{Value: 0, Time: points[1].Time, Key: "moisture"},
+		}, result.Moisture)
+	})
+
+	t.Run("Windowed", func(t *testing.T) {
+		setupDataSvcTests()
+		endTime := now.Add(-time.Hour).Format(time.RFC3339)
+
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
+		dataQuerier.On("QueryRange",
+			ctx, deviceId, core.Temperature,
+			mock.MatchedBy(func(q db.RangeQuery) bool {
+				return q.End.Format(time.RFC3339) == endTime &&
+					q.Every == 5*time.Minute &&
+					q.Fn == "max" &&
+					q.Limit == 0
+			}),
+		).Return([]db.DeviceDataPoint{}, nil)
+
+		result, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{
+			Measurement: core.Temperature,
+			StartTime:   startTime,
+			EndTime:     endTime,
+			Window:      "5m",
+			Aggregate:   "max",
+		})
 
-		assert.Len(t, result, 2)
-		assert.Equal(t, expData[0], result[0])
-		assert.Equal(t, expData[1], result[1])
+		assert.Nil(t, err)
+		assert.Equal(t, 5*time.Minute, result.Window)
+		assert.Equal(t, AggregateMax, result.Aggregate)
+		dataQuerier.AssertExpectations(t)
+	})
+
+	t.Run("DefaultAggregate", func(t *testing.T) {
+		setupDataSvcTests()
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
+		dataQuerier.On("QueryRange", ctx, deviceId, core.Capacitance,
+			mock.MatchedBy(func(q db.RangeQuery) bool {
+				return q.Every == 24*time.Hour && q.Fn == "mean"
+			}),
+		).Return([]db.DeviceDataPoint{}, nil)
+		dataCalReader.On("GetCalibrationProfile", ctx, int32(deviceId)).Return(sqlc.CalibrationProfile{}, nil)
+
+		result, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{
+			Measurement: core.Capacitance,
+			StartTime:   now.Add(-90 * 24 * time.Hour).Format(time.RFC3339),
+			Window:      "1d",
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, AggregateMean, result.Aggregate)
+	})
+
+	t.Run("RollupTier", func(t *testing.T) {
+		setupDataSvcTests()
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
+		dataQuerier.On("QueryRange", ctx, deviceId, core.Capacitance,
+			mock.MatchedBy(func(q db.RangeQuery) bool {
+				return q.Bucket == "raw_hourly" && q.RollupAgg == "min" && q.Fn == "min"
+			}),
+		).Return([]db.DeviceDataPoint{}, nil)
+		dataCalReader.On("GetCalibrationProfile", ctx, int32(deviceId)).Return(sqlc.CalibrationProfile{}, nil)
+
+		_, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{
+			Measurement: core.Capacitance,
+			StartTime:   now.Add(-60 * 24 * time.Hour).Format(time.RFC3339),
+			Window:      "1d",
+			Aggregate:   "min",
+		})
+
+		assert.Nil(t, err)
+		dataQuerier.AssertExpectations(t)
 	})
 
 	t.Run("InvalidTime", func(t *testing.T) {
-		deviceId := 123
+		setupDataSvcTests()
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
 
-		result, err := dataSvc.CapacitanceData(ctx,
-			deviceId,
-			"N0tv@lidTimeFMT")
-		assert.Nil(t, result)
-		assert.NotNil(t, err)
+		_, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{
+			Measurement: core.Capacitance,
+			StartTime:   "N0tv@lidTimeFMT",
+		})
+		assert.ErrorIs(t, err, ErrInvalidStartTime)
 		assert.True(t, strings.Contains(err.Error(), "Error parsing time"))
+	})
 
+	t.Run("InvalidQuery", func(t *testing.T) {
+		setupDataSvcTests()
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
+
+		queries := []DataQuery{
+			{StartTime: startTime, EndTime: "yesterday"},
+			{StartTime: startTime, EndTime: now.Add(-4 * time.Hour).Format(time.RFC3339)},
+			{StartTime: startTime, Aggregate: "mean"},
+			{StartTime: startTime, Window: "10s"},
+			{StartTime: startTime, Window: "90d"},
+			{StartTime: startTime, Window: "xd"},
+			{StartTime: startTime, Window: "1h", Aggregate: "median"},
+		}
+		for _, q := range queries {
+			q.Measurement = core.Capacitance
+			_, err := dataSvc.MeasurementData(ctx, deviceId, q)
+			assert.ErrorIs(t, err, ErrInvalidDataQuery, q)
+		}
+		dataQuerier.AssertNotCalled(t, "QueryRange", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("TooManyWindows", func(t *testing.T) {
+		setupDataSvcTests()
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
+
+		// 90 days of 5 minute windows
+		_, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{
+			Measurement: core.Capacitance,
+			StartTime:   now.Add(-90 * 24 * time.Hour).Format(time.RFC3339),
+			Window:      "5m",
+		})
+
+		assert.ErrorIs(t, err, ErrTooManyPoints)
+		dataQuerier.AssertNotCalled(t, "QueryRange", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("TooManyRawPoints", func(t *testing.T) {
+		setupDataSvcTests()
+		dataUserDevGet.On("GetUserDevice", ctx, int32(deviceId)).Return(sqlc.Device{DeviceID: int32(deviceId)}, nil)
+		dataQuerier.On("QueryRange", ctx, deviceId, core.Capacitance, mock.Anything).
+			Return(make([]db.DeviceDataPoint, testMaxPoints+1), nil)
+
+		_, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{
+			Measurement: core.Capacitance,
+			StartTime:   startTime,
+		})
+
+		assert.ErrorIs(t, err, ErrTooManyPoints)
+	})
+
+	t.Run("UnknownMeasurement", func(t *testing.T) {
+		setupDataSvcTests()
+
+		_, err := dataSvc.MeasurementData(ctx, deviceId, DataQuery{Measurement: "co2", StartTime: startTime})
+
+		assert.ErrorIs(t, err, measurements.ErrUnknownMeasurement)
+		dataQuerier.AssertNotCalled(t, "QueryRange", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 	})
 }
 
-func TestTemperatureData(t *testing.T) {
+func TestMeasurementDataOwnership(t *testing.T) {
 	ctx := context.Background()
-	setupDataSvcTests()
+	startTime := time.Now().Add(-time.Hour).Format(time.RFC3339)
 
-	t.Run("Success", func(t *testing.T) {
-		deviceId := 123
-		now := time.Now()
-		startTimeT := now.Add(-3 * time.Hour)
-		startTime := startTimeT.Format(time.RFC3339)
+	t.Run("Forbidden", func(t *testing.T) {
+		setupDataSvcTests()
+		dataUserDevGet.On("GetUserDevice", ctx, int32(7)).Return(sqlc.Device{}, ErrDeviceForbidden)
 
-		expData := []db.DeviceDataPoint{
-			db.DeviceDataPoint{
-				Value: 1234,
-				Time:  now.Add(-1 * time.Hour),
-				Key:   core.Temperature,
-			},
-			db.DeviceDataPoint{
-				Value: 1233,
-				Time:  now.Add(-2 * time.Hour),
-				Key:   core.Temperature,
-			},
-		}
+		_, err := dataSvc.MeasurementData(ctx, 7, DataQuery{Measurement: core.Capacitance, StartTime: startTime})
 
-		dataRet.On("GetValuesRange",
-			ctx, deviceId, core.Temperature,
-			mock.MatchedBy(func(tm time.Time) bool {
-				return tm.Format(time.RFC3339) == startTime
-			}),
-			mock.MatchedBy(func(tm time.Time) bool {
-				return tm.Unix() > startTimeT.Unix()
-			}),
-		).Return(expData, nil)
+		assert.ErrorIs(t, err, ErrDeviceForbidden)
+		dataQuerier.AssertNotCalled(t, "QueryRange", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
 
-		result, err := dataSvc.TemperatureData(ctx,
-			deviceId,
-			startTime)
-		if err != nil {
-			t.Fatalf("Error in data_svc.TemperatureData: %v", err)
-		}
+	t.Run("NoDevice", func(t *testing.T) {
+		setupDataSvcTests()
+		dataUserDevGet.On("GetUserDevice", ctx, int32(8)).Return(sqlc.Device{}, ErrNoDevice)
+
+		_, err := dataSvc.MeasurementData(ctx, 8, DataQuery{Measurement: core.Capacitance, StartTime: startTime})
 
-		assert.Len(t, result, 2)
-		assert.Equal(t, expData[0], result[0])
-		assert.Equal(t, expData[1], result[1])
+		assert.ErrorIs(t, err, ErrNoDevice)
+		dataQuerier.AssertNotCalled(t, "QueryRange", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 	})
 }
+
+func TestPickTier(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name   string
+		age    time.Duration
+		window time.Duration
+		bucket string
+	}{
+		{"Raw", 2 * day, 0, ""},
+		{"RawOutsideRetention", 90 * day, 0, ""},
+		{"RecentWindowed", 2 * day, time.Hour, "raw"},
+		{"HourlyRollup", 60 * day, time.Hour, "raw_hourly"},
+		{"PreferFinest", 60 * day, day, "raw_hourly"},
+		{"DailyRollup", 400 * day, day, "raw_daily"},
+		{"HistoryOverResolution", 400 * day, time.Hour, "raw_daily"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier := pickTier(testTiers, now.Add(-tt.age), tt.window, now)
+			assert.Equal(t, tt.bucket, tier.Bucket)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+const (
+	defaultDeadLetterLimit int32 = 50
+	maxDeadLetterLimit     int32 = 500
+)
+
+var (
+	ErrDeadLetterNotFound = fmt.Errorf("Dead letter not found")
+	ErrReplayFailed       = fmt.Errorf("Dead letter replay failed")
+)
+
+type DeadLetterReader interface {
+	GetDeadLetter(ctx context.Context, deadLetterId int64) (sqlc.DeadLetter, error)
+	GetDeadLetters(ctx context.Context, limit int32, offset int32) ([]sqlc.DeadLetter, error)
+}
+type DeadLetterWriter interface {
+	CreateDeadLetter(ctx context.Context, topic string, payload []byte, errMsg string) (sqlc.DeadLetter, error)
+	UpdateDeadLetterAttempt(ctx context.Context, deadLetterId int64, errMsg string) error
+	DeleteDeadLetter(ctx context.Context, deadLetterId int64) error
+	DeleteDeadLettersBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+type TopicDispatcher interface {
+	Dispatch(ctx context.Context, topic string, payload []byte) error
+}
+
+type DeadLetterSvc struct {
+	dlReader   DeadLetterReader
+	dlWriter   DeadLetterWriter
+	dispatcher TopicDispatcher
+}
+
+func NewDeadLetterSvc(dlReader DeadLetterReader,
+	dlWriter DeadLetterWriter,
+	dispatcher TopicDispatcher) DeadLetterSvc {
+
+	return DeadLetterSvc{
+		dlReader:   dlReader,
+		dlWriter:   dlWriter,
+		dispatcher: dispatcher,
+	}
+}
+
+// Called by the mqtt hub when a TopicInvoker fails
+func (s DeadLetterSvc) Save(ctx context.Context, topic string, payload []byte, cause error) error {
+	_, err := s.dlWriter.CreateDeadLetter(ctx, topic, payload, cause.Error())
+	if err != nil {
+		return fmt.Errorf("Error DeadLetterSvc Save -> CreateDeadLetter: \n%w\n", err)
+	}
+	return nil
+}
+
+func (s DeadLetterSvc) List(ctx context.Context, limit int32, offset int32) ([]sqlc.DeadLetter, error) {
+	if limit <= 0 {
+		limit = defaultDeadLetterLimit
+	}
+	if limit > maxDeadLetterLimit {
+		limit = maxDeadLetterLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	dls, err := s.dlReader.GetDeadLetters(ctx, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("Error DeadLetterSvc List -> GetDeadLetters: \n%w\n", err)
+	}
+	return dls, nil
+}
+
+func (s DeadLetterSvc) Get(ctx context.Context, deadLetterId int64) (sqlc.DeadLetter, error) {
+	dl, err := s.dlReader.GetDeadLetter(ctx, deadLetterId)
+	if err != nil {
+		return sqlc.DeadLetter{}, fmt.Errorf("Error DeadLetterSvc Get -> GetDeadLetter: \n%w\n", err)
+	}
+	if dl.DeadLetterID <= 0 {
+		return sqlc.DeadLetter{}, fmt.Errorf("Error DeadLetterSvc Get (deadLetterId: %v): \n%w\n", deadLetterId, ErrDeadLetterNotFound)
+	}
+	return dl, nil
+}
+
+// Replay pushes the stored payload back through the hub's topic routing.
+// The dead letter is removed on success, otherwise its attempt count
+// and error are updated and it stays in the store.
+func (s DeadLetterSvc) Replay(ctx context.Context, deadLetterId int64) (sqlc.DeadLetter, error) {
+	dl, err := s.Get(ctx, deadLetterId)
+	if err != nil {
+		return sqlc.DeadLetter{}, fmt.Errorf("Error DeadLetterSvc Replay -> Get: \n%w\n", err)
+	}
+
+	replayErr := s.dispatcher.Dispatch(ctx, dl.Topic, dl.Payload)
+	if replayErr != nil {
+		err = s.dlWriter.UpdateDeadLetterAttempt(ctx, dl.DeadLetterID, replayErr.Error())
+		if err != nil {
+			return sqlc.DeadLetter{}, fmt.Errorf("Error DeadLetterSvc Replay -> UpdateDeadLetterAttempt: \n%w\n", err)
+		}
+		dl.Attempts++
+		dl.Error = replayErr.Error()
+		return dl, fmt.Errorf("Error DeadLetterSvc Replay -> Dispatch: %w\n%w\n", ErrReplayFailed, replayErr)
+	}
+
+	err = s.dlWriter.DeleteDeadLetter(ctx, dl.DeadLetterID)
+	if err != nil {
+		return sqlc.DeadLetter{}, fmt.Errorf("Error DeadLetterSvc Replay -> DeleteDeadLetter: \n%w\n", err)
+	}
+	return dl, nil
+}
+
+func (s DeadLetterSvc) Purge(ctx context.Context, deadLetterId int64) error {
+	if _, err := s.Get(ctx, deadLetterId); err != nil {
+		return fmt.Errorf("Error DeadLetterSvc Purge -> Get: \n%w\n", err)
+	}
+
+	err := s.dlWriter.DeleteDeadLetter(ctx, deadLetterId)
+	if err != nil {
+		return fmt.Errorf("Error DeadLetterSvc Purge -> DeleteDeadLetter: \n%w\n", err)
+	}
+	return nil
+}
+
+// PurgeBefore removes every dead letter created before the given time
+// and returns how many were deleted
+func (s DeadLetterSvc) PurgeBefore(ctx context.Context, before time.Time) (int64, error) {
+	n, err := s.dlWriter.DeleteDeadLettersBefore(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("Error DeadLetterSvc PurgeBefore -> DeleteDeadLettersBefore: \n%w\n", err)
+	}
+	return n, nil
+}
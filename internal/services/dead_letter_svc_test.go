@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	dlReader      mocks.MockDeadLetterReader
+	dlWriter      mocks.MockDeadLetterWriter
+	dlDispatcher  mocks.MockTopicDispatcher
+	deadLetterSvc DeadLetterSvc
+)
+
+func setupDeadLetterSvcTests() {
+	dlReader = mocks.MockDeadLetterReader{Mock: new(mock.Mock)}
+	dlWriter = mocks.MockDeadLetterWriter{Mock: new(mock.Mock)}
+	dlDispatcher = mocks.MockTopicDispatcher{Mock: new(mock.Mock)}
+
+	deadLetterSvc = NewDeadLetterSvc(dlReader, dlWriter, dlDispatcher)
+}
+
+func TestDeadLetterSave(t *testing.T) {
+	ctx := context.Background()
+	setupDeadLetterSvcTests()
+
+	topic := "dirtie/aabbccddeeff/breadcrumb"
+	payload := []byte(`{"macAddr":`)
+	cause := fmt.Errorf("unexpected end of JSON input")
+
+	dlWriter.On("CreateDeadLetter", ctx, topic, payload, cause.Error()).Return(sqlc.DeadLetter{DeadLetterID: 1}, nil)
+
+	err := deadLetterSvc.Save(ctx, topic, payload, cause)
+
+	assert.Nil(t, err)
+	dlWriter.AssertExpectations(t)
+}
+
+func TestDeadLetterList(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("DefaultLimit", func(t *testing.T) {
+		setupDeadLetterSvcTests()
+		dlReader.On("GetDeadLetters", ctx, defaultDeadLetterLimit, int32(0)).Return([]sqlc.DeadLetter{}, nil)
+
+		_, err := deadLetterSvc.List(ctx, 0, -5)
+
+		assert.Nil(t, err)
+		dlReader.AssertExpectations(t)
+	})
+
+	t.Run("MaxLimit", func(t *testing.T) {
+		setupDeadLetterSvcTests()
+		dlReader.On("GetDeadLetters", ctx, maxDeadLetterLimit, int32(10)).Return([]sqlc.DeadLetter{}, nil)
+
+		_, err := deadLetterSvc.List(ctx, 10000, 10)
+
+		assert.Nil(t, err)
+		dlReader.AssertExpectations(t)
+	})
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	ctx := context.Background()
+	dl := sqlc.DeadLetter{
+		DeadLetterID: 7,
+		Topic:        "dirtie/aabbccddeeff/breadcrumb",
+		Payload:      []byte(`{"macAddr":"aabbccddeeff","capacitance":300}`),
+		Error:        "influx unavailable",
+		Attempts:     1,
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		setupDeadLetterSvcTests()
+		dlReader.On("GetDeadLetter", ctx, dl.DeadLetterID).Return(dl, nil)
+		dlDispatcher.On("Dispatch", ctx, dl.Topic, dl.Payload).Return(nil)
+		dlWriter.On("DeleteDeadLetter", ctx, dl.DeadLetterID).Return(nil)
+
+		_, err := deadLetterSvc.Replay(ctx, dl.DeadLetterID)
+
+		assert.Nil(t, err)
+		dlWriter.AssertExpectations(t)
+		dlWriter.AssertNotCalled(t, "UpdateDeadLetterAttempt", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("StillFailing", func(t *testing.T) {
+		setupDeadLetterSvcTests()
+		replayErr := fmt.Errorf("influx still unavailable")
+		dlReader.On("GetDeadLetter", ctx, dl.DeadLetterID).Return(dl, nil)
+		dlDispatcher.On("Dispatch", ctx, dl.Topic, dl.Payload).Return(replayErr)
+		dlWriter.On("UpdateDeadLetterAttempt", ctx, dl.DeadLetterID, replayErr.Error()).Return(nil)
+
+		res, err := deadLetterSvc.Replay(ctx, dl.DeadLetterID)
+
+		assert.ErrorIs(t, err, ErrReplayFailed)
+		assert.ErrorIs(t, err, replayErr)
+		assert.Equal(t, int32(2), res.Attempts)
+		dlWriter.AssertExpectations(t)
+		dlWriter.AssertNotCalled(t, "DeleteDeadLetter", mock.Anything, mock.Anything)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		setupDeadLetterSvcTests()
+		dlReader.On("GetDeadLetter", ctx, int64(99)).Return(sqlc.DeadLetter{}, nil)
+
+		_, err := deadLetterSvc.Replay(ctx, 99)
+
+		assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+		dlDispatcher.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestDeadLetterPurgeBefore(t *testing.T) {
+	ctx := context.Background()
+	setupDeadLetterSvcTests()
+	before := time.Now()
+
+	dlWriter.On("DeleteDeadLettersBefore", ctx, before).Return(int64(3), nil)
+
+	n, err := deadLetterSvc.PurgeBefore(ctx, before)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+}
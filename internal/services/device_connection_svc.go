@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+)
+
+// Presence reported by the device on dirtie/<mac>/status. The birth
+// message is published by the device once connected, the will by the
+// broker when the connection drops without a clean disconnect.
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// Recorded in device_connection_events
+const (
+	DeviceConnected    = "connected"
+	DeviceDisconnected = "disconnected"
+)
+
+var (
+	defaultConnectionEventLimit int32 = 50
+	maxConnectionEventLimit     int32 = 500
+)
+
+var ErrInvalidPresence = fmt.Errorf("Invalid device presence message")
+
+type ConnectionEventReader interface {
+	GetLatestDeviceConnectionEvent(ctx context.Context, deviceId int32) (sqlc.DeviceConnectionEvent, error)
+	GetDeviceConnectionEvents(ctx context.Context, deviceId int32, limit int32) ([]sqlc.DeviceConnectionEvent, error)
+}
+type ConnectionEventWriter interface {
+	CreateDeviceConnectionEvent(ctx context.Context, deviceId int32, event string, occurredAt time.Time) (sqlc.DeviceConnectionEvent, error)
+}
+
+type PresenceStatusRecorder interface {
+	RecordHeartbeat(ctx context.Context, device sqlc.Device, seenAt time.Time) error
+	MarkOffline(ctx context.Context, device sqlc.Device) error
+}
+
+type PresencePayload struct {
+	MacAddr string `json:"macAddr"`
+	// only needed on the birth message of a device that hasn't
+	// completed provisioning yet
+	Contract string `json:"contract,omitempty"`
+	Status   string `json:"status"`
+}
+
+type DeviceConnectionSvc struct {
+	eventReader      ConnectionEventReader
+	eventWriter      ConnectionEventWriter
+	deviceGetter     DeviceGetter
+	prvCompleter     DevicePrvCompleter
+	userDeviceGetter UserDeviceGetter
+	status           PresenceStatusRecorder
+}
+
+func NewDeviceConnectionSvc(eventReader ConnectionEventReader,
+	eventWriter ConnectionEventWriter,
+	deviceGetter DeviceGetter,
+	prvCompleter DevicePrvCompleter,
+	userDeviceGetter UserDeviceGetter,
+	status PresenceStatusRecorder) DeviceConnectionSvc {
+
+	return DeviceConnectionSvc{
+		eventReader:      eventReader,
+		eventWriter:      eventWriter,
+		deviceGetter:     deviceGetter,
+		prvCompleter:     prvCompleter,
+		userDeviceGetter: userDeviceGetter,
+		status:           status,
+	}
+}
+
+// RecordPresence stores a connect or disconnect event and updates the
+// device's online status to match. Birth and will messages are
+// retained, so the same one can arrive more than once; an event that
+// repeats the device's current state is dropped.
+func (s DeviceConnectionSvc) RecordPresence(ctx context.Context, payload PresencePayload) error {
+	var event string
+	switch payload.Status {
+	case PresenceOnline:
+		event = DeviceConnected
+	case PresenceOffline:
+		event = DeviceDisconnected
+	default:
+		return fmt.Errorf("Error RecordPresence (status: '%v'): \n%w\n", payload.Status, ErrInvalidPresence)
+	}
+	now := time.Now()
+
+	var device sqlc.Device
+	var err error
+	if event == DeviceConnected {
+		device, err = resolveDevice(ctx, s.deviceGetter, s.prvCompleter, payload.MacAddr, payload.Contract)
+		if err != nil {
+			return fmt.Errorf("Error RecordPresence -> resolveDevice: \n%w\n", err)
+		}
+	} else {
+		device, err = s.deviceGetter.GetDeviceByMacAddress(ctx, payload.MacAddr)
+		if err != nil {
+			return fmt.Errorf("Error RecordPresence -> GetDeviceByMacAddress: \n%w\n", err)
+		}
+		if device.DeviceID <= 0 {
+			// never provisioned, so there's nothing to disconnect
+			utils.LogInfo(fmt.Sprintf("ignoring will of unknown device %v\n", payload.MacAddr))
+			return nil
+		}
+	}
+
+	last, err := s.eventReader.GetLatestDeviceConnectionEvent(ctx, device.DeviceID)
+	if err != nil {
+		return fmt.Errorf("Error RecordPresence -> GetLatestDeviceConnectionEvent: \n%w\n", err)
+	}
+	if last.EventID > 0 && last.Event == event {
+		return nil
+	}
+
+	_, err = s.eventWriter.CreateDeviceConnectionEvent(ctx, device.DeviceID, event, now)
+	if err != nil {
+		return fmt.Errorf("Error RecordPresence -> CreateDeviceConnectionEvent: \n%w\n", err)
+	}
+
+	if s.status == nil {
+		return nil
+	}
+	if event == DeviceConnected {
+		err = s.status.RecordHeartbeat(ctx, device, now)
+	} else {
+		err = s.status.MarkOffline(ctx, device)
+	}
+	if err != nil {
+		return fmt.Errorf("Error RecordPresence -> update device status: \n%w\n", err)
+	}
+	return nil
+}
+
+// GetHistory returns the device's most recent connection events,
+// newest first
+func (s DeviceConnectionSvc) GetHistory(ctx context.Context, deviceId int32, limit int32) ([]sqlc.DeviceConnectionEvent, error) {
+	if limit <= 0 {
+		limit = defaultConnectionEventLimit
+	}
+	if limit > maxConnectionEventLimit {
+		limit = maxConnectionEventLimit
+	}
+
+	device, err := s.userDeviceGetter.GetUserDevice(ctx, deviceId)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetHistory -> GetUserDevice: \n%w\n", err)
+	}
+
+	events, err := s.eventReader.GetDeviceConnectionEvents(ctx, device.DeviceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetHistory -> GetDeviceConnectionEvents: \n%w\n", err)
+	}
+	return events, nil
+}
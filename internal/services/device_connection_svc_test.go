@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	connEventReader   mocks.MockConnectionEventReader
+	connEventWriter   mocks.MockConnectionEventWriter
+	connDeviceGetter  mocks.MockDeviceGetter
+	connPrvCompleter  mockDevicePrvCompleter
+	connUserDevGetter mocks.MockUserDeviceGetter
+	presenceStatus    mocks.MockPresenceStatusRecorder
+	deviceConnSvc     DeviceConnectionSvc
+)
+
+func setupDeviceConnectionSvcTests() {
+	connEventReader = mocks.MockConnectionEventReader{Mock: new(mock.Mock)}
+	connEventWriter = mocks.MockConnectionEventWriter{Mock: new(mock.Mock)}
+	connDeviceGetter = mocks.MockDeviceGetter{Mock: new(mock.Mock)}
+	connPrvCompleter = mockDevicePrvCompleter{Mock: new(mock.Mock)}
+	connUserDevGetter = mocks.MockUserDeviceGetter{Mock: new(mock.Mock)}
+	presenceStatus = mocks.MockPresenceStatusRecorder{Mock: new(mock.Mock)}
+	presenceStatus.On("RecordHeartbeat", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	presenceStatus.On("MarkOffline", mock.Anything, mock.Anything).Return(nil)
+
+	deviceConnSvc = NewDeviceConnectionSvc(connEventReader,
+		connEventWriter,
+		connDeviceGetter,
+		connPrvCompleter,
+		connUserDevGetter,
+		presenceStatus)
+}
+
+func TestRecordPresence(t *testing.T) {
+	ctx := context.Background()
+	dvc := sqlc.Device{
+		DeviceID: 12,
+		UserID:   3,
+		MacAddr:  pgtype.Text{String: "aabbccddeeff", Valid: true},
+	}
+
+	t.Run("Birth", func(t *testing.T) {
+		setupDeviceConnectionSvcTests()
+		connDeviceGetter.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(dvc, nil)
+		connEventReader.On("GetLatestDeviceConnectionEvent", ctx, dvc.DeviceID).
+			Return(sqlc.DeviceConnectionEvent{EventID: 1, Event: DeviceDisconnected}, nil)
+		connEventWriter.On("CreateDeviceConnectionEvent", ctx, dvc.DeviceID, DeviceConnected, mock.AnythingOfType("time.Time")).
+			Return(sqlc.DeviceConnectionEvent{}, nil)
+
+		err := deviceConnSvc.RecordPresence(ctx, PresencePayload{MacAddr: dvc.MacAddr.String, Status: PresenceOnline})
+
+		assert.Nil(t, err)
+		connEventWriter.AssertExpectations(t)
+		presenceStatus.AssertCalled(t, "RecordHeartbeat", ctx, dvc, mock.AnythingOfType("time.Time"))
+	})
+
+	t.Run("Will", func(t *testing.T) {
+		setupDeviceConnectionSvcTests()
+		connDeviceGetter.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(dvc, nil)
+		connEventReader.On("GetLatestDeviceConnectionEvent", ctx, dvc.DeviceID).
+			Return(sqlc.DeviceConnectionEvent{EventID: 1, Event: DeviceConnected}, nil)
+		connEventWriter.On("CreateDeviceConnectionEvent", ctx, dvc.DeviceID, DeviceDisconnected, mock.AnythingOfType("time.Time")).
+			Return(sqlc.DeviceConnectionEvent{}, nil)
+
+		err := deviceConnSvc.RecordPresence(ctx, PresencePayload{MacAddr: dvc.MacAddr.String, Status: PresenceOffline})
+
+		assert.Nil(t, err)
+		connEventWriter.AssertExpectations(t)
+		presenceStatus.AssertCalled(t, "MarkOffline", ctx, dvc)
+	})
+
+	t.Run("RetainedRepeat", func(t *testing.T) {
+		setupDeviceConnectionSvcTests()
+		connDeviceGetter.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(dvc, nil)
+		connEventReader.On("GetLatestDeviceConnectionEvent", ctx, dvc.DeviceID).
+			Return(sqlc.DeviceConnectionEvent{EventID: 1, Event: DeviceConnected}, nil)
+
+		err := deviceConnSvc.RecordPresence(ctx, PresencePayload{MacAddr: dvc.MacAddr.String, Status: PresenceOnline})
+
+		assert.Nil(t, err)
+		connEventWriter.AssertNotCalled(t, "CreateDeviceConnectionEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		presenceStatus.AssertNotCalled(t, "RecordHeartbeat", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("LazyProvision", func(t *testing.T) {
+		setupDeviceConnectionSvcTests()
+		payload := PresencePayload{MacAddr: dvc.MacAddr.String, Contract: "contract", Status: PresenceOnline}
+		connDeviceGetter.On("GetDeviceByMacAddress", ctx, dvc.MacAddr.String).Return(sqlc.Device{}, nil)
+		connPrvCompleter.On("CompleteDeviceProvision", ctx, DevicePrvPayload{MacAddr: payload.MacAddr, Contract: payload.Contract}).Return(dvc, nil)
+		connEventReader.On("GetLatestDeviceConnectionEvent", ctx, dvc.DeviceID).Return(sqlc.DeviceConnectionEvent{}, nil)
+		connEventWriter.On("CreateDeviceConnectionEvent", ctx, dvc.DeviceID, DeviceConnected, mock.AnythingOfType("time.Time")).
+			Return(sqlc.DeviceConnectionEvent{}, nil)
+
+		err := deviceConnSvc.RecordPresence(ctx, payload)
+
+		assert.Nil(t, err)
+		connPrvCompleter.AssertExpectations(t)
+		connEventWriter.AssertExpectations(t)
+	})
+
+	t.Run("UnknownWill", func(t *testing.T) {
+		setupDeviceConnectionSvcTests()
+		connDeviceGetter.On("GetDeviceByMacAddress", ctx, "ffeeddccbbaa").Return(sqlc.Device{}, nil)
+
+		err := deviceConnSvc.RecordPresence(ctx, PresencePayload{MacAddr: "ffeeddccbbaa", Status: PresenceOffline})
+
+		assert.Nil(t, err)
+		connPrvCompleter.AssertNotCalled(t, "CompleteDeviceProvision", mock.Anything, mock.Anything)
+		connEventWriter.AssertNotCalled(t, "CreateDeviceConnectionEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("InvalidStatus", func(t *testing.T) {
+		setupDeviceConnectionSvcTests()
+
+		err := deviceConnSvc.RecordPresence(ctx, PresencePayload{MacAddr: dvc.MacAddr.String, Status: "sleeping"})
+
+		assert.ErrorIs(t, err, ErrInvalidPresence)
+	})
+}
+
+func TestGetConnectionHistory(t *testing.T) {
+	setupDeviceConnectionSvcTests()
+	ctx := context.Background()
+	dvc := sqlc.Device{DeviceID: 12, UserID: 3}
+	events := []sqlc.DeviceConnectionEvent{{EventID: 2, DeviceID: 12, Event: DeviceConnected}}
+
+	connUserDevGetter.On("GetUserDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+	connEventReader.On("GetDeviceConnectionEvents", ctx, dvc.DeviceID, maxConnectionEventLimit).Return(events, nil)
+
+	res, err := deviceConnSvc.GetHistory(ctx, dvc.DeviceID, 10000)
+
+	assert.Nil(t, err)
+	assert.Equal(t, events, res)
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
+)
+
+type DeviceStatusReader interface {
+	GetOverdueDevices(ctx context.Context, now time.Time, upSince time.Time, defaultIntervalSec int32, missedIntervals int32) ([]sqlc.Device, error)
+}
+type DeviceStatusWriter interface {
+	MarkDeviceOnline(ctx context.Context, deviceId int32, seenAt time.Time) (bool, error)
+	UpdateDeviceLastSeen(ctx context.Context, deviceId int32, seenAt time.Time) error
+	MarkDeviceOffline(ctx context.Context, deviceId int32, lastSeen time.Time) (bool, error)
+	UpdateDeviceLatestReading(ctx context.Context, deviceId int32, readings []byte, ts time.Time) error
+}
+
+type DeviceStatusObserver interface {
+	ObserveDeviceStatus(online bool)
+}
+
+// HeartbeatPolicy decides when a quiet device counts as offline
+type HeartbeatPolicy struct {
+	// expected time between readings for devices that haven't been
+	// sent a sample interval
+	DefaultInterval time.Duration
+	// intervals a device may miss before it is marked offline
+	MissedIntervals int
+}
+
+type DeviceStatusSvc struct {
+	statusReader DeviceStatusReader
+	statusWriter DeviceStatusWriter
+	notifier     Notifier
+	observer     DeviceStatusObserver
+	policy       HeartbeatPolicy
+	// devices aren't held responsible for time the server was down
+	upSince time.Time
+}
+
+func NewDeviceStatusSvc(statusReader DeviceStatusReader,
+	statusWriter DeviceStatusWriter,
+	notifier Notifier,
+	observer DeviceStatusObserver,
+	policy HeartbeatPolicy) DeviceStatusSvc {
+
+	return DeviceStatusSvc{
+		statusReader: statusReader,
+		statusWriter: statusWriter,
+		notifier:     notifier,
+		observer:     observer,
+		policy:       policy,
+		upSince:      time.Now(),
+	}
+}
+
+// RecordHeartbeat notes that the device was heard from at seenAt,
+// bringing it back online if the sweeper had marked it offline. The
+// owner is told it's back unless this is the first time it was heard
+// from.
+func (s DeviceStatusSvc) RecordHeartbeat(ctx context.Context, device sqlc.Device, seenAt time.Time) error {
+	if !device.Online {
+		cameOnline, err := s.statusWriter.MarkDeviceOnline(ctx, device.DeviceID, seenAt)
+		if err != nil {
+			return fmt.Errorf("Error RecordHeartbeat -> MarkDeviceOnline: \n%w\n", err)
+		}
+		if cameOnline {
+			s.statusChanged(ctx, device, true)
+			return nil
+		}
+		// someone else brought it online first
+	}
+
+	err := s.statusWriter.UpdateDeviceLastSeen(ctx, device.DeviceID, seenAt)
+	if err != nil {
+		return fmt.Errorf("Error RecordHeartbeat -> UpdateDeviceLastSeen: \n%w\n", err)
+	}
+	return nil
+}
+
+// RecordLatestReading keeps the newest readings on the device record
+// so device lists don't have to go to influx
+func (s DeviceStatusSvc) RecordLatestReading(ctx context.Context, deviceId int32, readings map[string]float64, ts time.Time) error {
+	b, err := json.Marshal(readings)
+	if err != nil {
+		return fmt.Errorf("Error RecordLatestReading -> Marshal: \n%w\n", err)
+	}
+
+	err = s.statusWriter.UpdateDeviceLatestReading(ctx, deviceId, b, ts)
+	if err != nil {
+		return fmt.Errorf("Error RecordLatestReading -> UpdateDeviceLatestReading: \n%w\n", err)
+	}
+	return nil
+}
+
+// Sweep marks overdue devices offline and returns how many it marked.
+// A device heard from while the sweep runs keeps its online status.
+func (s DeviceStatusSvc) Sweep(ctx context.Context, now time.Time) (int, error) {
+	devices, err := s.statusReader.GetOverdueDevices(ctx,
+		now,
+		s.upSince,
+		int32(s.policy.DefaultInterval.Seconds()),
+		int32(s.policy.MissedIntervals))
+	if err != nil {
+		return 0, fmt.Errorf("Error Sweep -> GetOverdueDevices: \n%w\n", err)
+	}
+
+	marked := 0
+	for _, device := range devices {
+		wentOffline, err := s.markOffline(ctx, device)
+		if err != nil {
+			return marked, fmt.Errorf("Error Sweep -> markOffline: \n%w\n", err)
+		}
+		if wentOffline {
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+// MarkOffline takes the device offline straight away, for when the
+// broker tells us it's gone rather than waiting for the sweeper
+func (s DeviceStatusSvc) MarkOffline(ctx context.Context, device sqlc.Device) error {
+	if !device.Online {
+		return nil
+	}
+	if _, err := s.markOffline(ctx, device); err != nil {
+		return fmt.Errorf("Error MarkOffline -> markOffline: \n%w\n", err)
+	}
+	return nil
+}
+
+// markOffline only applies if the device hasn't been heard from since
+// it was read, so a late heartbeat always wins
+func (s DeviceStatusSvc) markOffline(ctx context.Context, device sqlc.Device) (bool, error) {
+	wentOffline, err := s.statusWriter.MarkDeviceOffline(ctx, device.DeviceID, device.LastSeen.Time)
+	if err != nil {
+		return false, fmt.Errorf("Error markOffline -> MarkDeviceOffline (deviceId: %v): \n%w\n", device.DeviceID, err)
+	}
+	if wentOffline {
+		s.statusChanged(ctx, device, false)
+	}
+	return wentOffline, nil
+}
+
+// RunSweeper sweeps every interval until ctx is cancelled. Replicas
+// may all run one; only one of them wins each state change.
+func (s DeviceStatusSvc) RunSweeper(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := s.Sweep(ctx, now); err != nil {
+				utils.LogErr(fmt.Sprintf("device sweep failed: %v\n", err))
+			}
+		}
+	}
+}
+
+func (s DeviceStatusSvc) statusChanged(ctx context.Context, device sqlc.Device, online bool) {
+	state := "offline"
+	if online {
+		state = "online"
+	}
+	utils.LogInfo(fmt.Sprintf("device %v is %v\n", device.DeviceID, state))
+
+	if s.observer != nil {
+		s.observer.ObserveDeviceStatus(online)
+	}
+	// a new device's first report isn't worth telling the owner about
+	if s.notifier == nil || (online && !device.LastSeen.Valid) {
+		return
+	}
+
+	deviceName := device.DisplayName.String
+	if deviceName == "" {
+		deviceName = fmt.Sprintf("Device %v", device.DeviceID)
+	}
+	body := fmt.Sprintf("%v is reporting again.", deviceName)
+	if !online {
+		body = fmt.Sprintf("%v hasn't reported since %v.", deviceName, device.LastSeen.Time.UTC().Format(time.RFC1123))
+	}
+
+	n := notify.Notification{
+		Kind:      notify.KindDevice,
+		Subject:   fmt.Sprintf("%v is %v", deviceName, state),
+		Body:      body,
+		DeviceId:  device.DeviceID,
+		DedupeKey: fmt.Sprintf("device:%v:%v", device.DeviceID, state),
+	}
+	if err := s.notifier.Notify(ctx, device.UserID, n); err != nil {
+		utils.LogErr(fmt.Sprintf("Error statusChanged -> Notify (deviceId: %v): %v\n", device.DeviceID, err))
+	}
+}
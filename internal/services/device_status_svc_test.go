@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
+	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	statusReader    mocks.MockDeviceStatusReader
+	statusWriter    mocks.MockDeviceStatusWriter
+	statusNotifier  mocks.MockNotifier
+	deviceStatusSvc DeviceStatusSvc
+)
+
+var testHeartbeat = HeartbeatPolicy{
+	DefaultInterval: 5 * time.Minute,
+	MissedIntervals: 3,
+}
+
+func setupDeviceStatusSvcTests() {
+	statusReader = mocks.MockDeviceStatusReader{Mock: new(mock.Mock)}
+	statusWriter = mocks.MockDeviceStatusWriter{Mock: new(mock.Mock)}
+	statusNotifier = mocks.MockNotifier{Mock: new(mock.Mock)}
+	statusNotifier.On("Notify", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	deviceStatusSvc = NewDeviceStatusSvc(statusReader, statusWriter, statusNotifier, nil, testHeartbeat)
+}
+
+func deviceEvent(deviceId int32, state string) interface{} {
+	return mock.MatchedBy(func(n notify.Notification) bool {
+		return n.Kind == notify.KindDevice && n.DeviceId == deviceId && n.DedupeKey == fmt.Sprintf("device:%v:%v", deviceId, state)
+	})
+}
+
+func TestRecordHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	dvc := sqlc.Device{
+		DeviceID:    12,
+		UserID:      3,
+		DisplayName: pgtype.Text{String: "Fern", Valid: true},
+		LastSeen:    pgtype.Timestamptz{Time: now.Add(-time.Hour), Valid: true},
+	}
+
+	t.Run("StillOnline", func(t *testing.T) {
+		setupDeviceStatusSvcTests()
+		online := dvc
+		online.Online = true
+		statusWriter.On("UpdateDeviceLastSeen", ctx, dvc.DeviceID, now).Return(nil)
+
+		err := deviceStatusSvc.RecordHeartbeat(ctx, online, now)
+
+		assert.Nil(t, err)
+		statusWriter.AssertNotCalled(t, "MarkDeviceOnline", mock.Anything, mock.Anything, mock.Anything)
+		statusNotifier.AssertNotCalled(t, "Notify", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("BackOnline", func(t *testing.T) {
+		setupDeviceStatusSvcTests()
+		statusWriter.On("MarkDeviceOnline", ctx, dvc.DeviceID, now).Return(true, nil)
+
+		err := deviceStatusSvc.RecordHeartbeat(ctx, dvc, now)
+
+		assert.Nil(t, err)
+		statusWriter.AssertNotCalled(t, "UpdateDeviceLastSeen", mock.Anything, mock.Anything, mock.Anything)
+		statusNotifier.AssertCalled(t, "Notify", ctx, dvc.UserID, deviceEvent(dvc.DeviceID, "online"))
+	})
+
+	t.Run("FirstReport", func(t *testing.T) {
+		setupDeviceStatusSvcTests()
+		added := dvc
+		added.LastSeen = pgtype.Timestamptz{}
+		statusWriter.On("MarkDeviceOnline", ctx, dvc.DeviceID, now).Return(true, nil)
+
+		err := deviceStatusSvc.RecordHeartbeat(ctx, added, now)
+
+		assert.Nil(t, err)
+		statusWriter.AssertCalled(t, "MarkDeviceOnline", ctx, dvc.DeviceID, now)
+		statusNotifier.AssertNotCalled(t, "Notify", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("LostRace", func(t *testing.T) {
+		setupDeviceStatusSvcTests()
+		statusWriter.On("MarkDeviceOnline", ctx, dvc.DeviceID, now).Return(false, nil)
+		statusWriter.On("UpdateDeviceLastSeen", ctx, dvc.DeviceID, now).Return(nil)
+
+		err := deviceStatusSvc.RecordHeartbeat(ctx, dvc, now)
+
+		// another replica already announced it
+		assert.Nil(t, err)
+		statusWriter.AssertCalled(t, "UpdateDeviceLastSeen", ctx, dvc.DeviceID, now)
+		statusNotifier.AssertNotCalled(t, "Notify", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestRecordLatestReading(t *testing.T) {
+	setupDeviceStatusSvcTests()
+	ctx := context.Background()
+	ts := time.Now()
+	statusWriter.On("UpdateDeviceLatestReading", ctx, int32(12), []byte(`{"capacitance":420}`), ts).Return(nil)
+
+	err := deviceStatusSvc.RecordLatestReading(ctx, 12, map[string]float64{"capacitance": 420}, ts)
+
+	assert.Nil(t, err)
+	statusWriter.AssertExpectations(t)
+}
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	lastSeen := now.Add(-time.Hour)
+	overdue := []sqlc.Device{
+		{DeviceID: 12, UserID: 3, Online: true, LastSeen: pgtype.Timestamptz{Time: lastSeen, Valid: true}},
+		{DeviceID: 13, UserID: 3, Online: true, LastSeen: pgtype.Timestamptz{Time: lastSeen, Valid: true}},
+	}
+
+	setupDeviceStatusSvcTests()
+	statusReader.On("GetOverdueDevices", ctx, now, mock.AnythingOfType("time.Time"), int32(300), int32(3)).Return(overdue, nil)
+	statusWriter.On("MarkDeviceOffline", ctx, int32(12), lastSeen).Return(true, nil)
+	// heard from since the query ran
+	statusWriter.On("MarkDeviceOffline", ctx, int32(13), lastSeen).Return(false, nil)
+
+	marked, err := deviceStatusSvc.Sweep(ctx, now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, marked)
+	statusNotifier.AssertNumberOfCalls(t, "Notify", 1)
+	statusNotifier.AssertCalled(t, "Notify", ctx, int32(3), deviceEvent(12, "offline"))
+}
+
+func TestMarkOffline(t *testing.T) {
+	ctx := context.Background()
+	lastSeen := time.Now().Add(-time.Minute)
+	dvc := sqlc.Device{DeviceID: 12, UserID: 3, Online: true, LastSeen: pgtype.Timestamptz{Time: lastSeen, Valid: true}}
+
+	t.Run("Online", func(t *testing.T) {
+		setupDeviceStatusSvcTests()
+		statusWriter.On("MarkDeviceOffline", ctx, dvc.DeviceID, lastSeen).Return(true, nil)
+
+		err := deviceStatusSvc.MarkOffline(ctx, dvc)
+
+		assert.Nil(t, err)
+		statusNotifier.AssertCalled(t, "Notify", ctx, dvc.UserID, deviceEvent(dvc.DeviceID, "offline"))
+	})
+
+	t.Run("AlreadyOffline", func(t *testing.T) {
+		setupDeviceStatusSvcTests()
+		offline := dvc
+		offline.Online = false
+
+		err := deviceStatusSvc.MarkOffline(ctx, offline)
+
+		assert.Nil(t, err)
+		statusWriter.AssertNotCalled(t, "MarkDeviceOffline", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -3,28 +3,44 @@ package services
 import (
 	"context"
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type DeviceReader interface {
+	GetDevice(ctx context.Context, deviceId int32) (sqlc.Device, error)
 	GetDeviceByMacAddress(ctx context.Context, macAddr string) (sqlc.Device, error)
-	GetDevicesByUser(ctx context.Context, userId int32) ([]sqlc.Device, error)
+	GetAccessibleDevices(ctx context.Context, userId int32) ([]sqlc.Device, error)
 }
 
 type ProvisionStagingReader interface {
 	GetProvisionStagingByContract(ctx context.Context, contract string) (sqlc.ProvisionStaging, error)
+	GetProvisionStagingByDevice(ctx context.Context, deviceId int32) (sqlc.ProvisionStaging, error)
 }
 type ProvisionStagingWriter interface {
-	CreateProvisionStaging(ctx context.Context, deviceId int32, contract string) error
+	CreateProvisionStaging(ctx context.Context, deviceId int32, contract string, expiresAt time.Time) (sqlc.ProvisionStaging, error)
+	ConsumeProvisionStaging(ctx context.Context, contract string, now time.Time) (sqlc.ProvisionStaging, error)
 	DeleteProvisionStaging(ctx context.Context, deviceId int32) error
 }
 
 type DeviceWriter interface {
 	CreateDevice(ctx context.Context, userId int32, displayName string) (sqlc.Device, error)
-	RenameDevice(ctx context.Context, deviceId int32, displayName string) error
+	UpdateDeviceDetails(ctx context.Context, device sqlc.Device) error
 	UpdateDeviceMacAddress(ctx context.Context, deviceId int32, macAddr string) error
+	ResetDeviceMacAddress(ctx context.Context, deviceId int32) error
+	ForceDeviceMacAddress(ctx context.Context, deviceId int32, macAddr string) error
+	DeleteDevice(ctx context.Context, deviceId int32) error
+	SetDeviceHousehold(ctx context.Context, deviceId int32, householdId *int32) error
+}
+
+// DeviceDataDeleter removes a device's readings from the time series store
+type DeviceDataDeleter interface {
+	DeleteDeviceData(ctx context.Context, deviceId int) error
 }
 
 type UserCtxReader interface {
@@ -37,6 +53,33 @@ type DeviceSvc struct {
 	prvStgReader  ProvisionStagingReader
 	prvStgWriter  ProvisionStagingWriter
 	userCtxReader UserCtxReader
+	memberReader  HouseholdMemberReader
+	dataDeleter   DeviceDataDeleter
+	// how long a provision contract stays valid
+	provisionTtl time.Duration
+}
+
+var (
+	ErrDeviceForbidden = fmt.Errorf("Device belongs to another user")
+	ErrDeviceReadOnly  = fmt.Errorf("Device is shared with this user read-only")
+	ErrInvalidDevice   = fmt.Errorf("Invalid device details")
+	ErrContractInvalid = fmt.Errorf("Provision contract is invalid, expired or already used")
+	ErrMacInUse        = fmt.Errorf("MAC address is bound to another device")
+	ErrNoProvision     = fmt.Errorf("Device has no pending provision")
+)
+
+var (
+	maxDeviceFieldLen = 250
+	maxDeviceNotesLen = 2000
+)
+
+// Sent by the user via rest api. Fields left out are unchanged, empty
+// strings clear them; the display name can't be cleared.
+type DeviceUpdateRequest struct {
+	DisplayName  *string `json:"displayName,omitempty"`
+	Location     *string `json:"location,omitempty"`
+	PlantSpecies *string `json:"plantSpecies,omitempty"`
+	Notes        *string `json:"notes,omitempty"`
 }
 
 type DevicePrvPayload struct {
@@ -48,7 +91,10 @@ func NewDeviceSvc(deviceReader DeviceReader,
 	deviceWriter DeviceWriter,
 	prvStgReader ProvisionStagingReader,
 	prvStgWriter ProvisionStagingWriter,
-	userCtxReader UserCtxReader) *DeviceSvc {
+	userCtxReader UserCtxReader,
+	memberReader HouseholdMemberReader,
+	dataDeleter DeviceDataDeleter,
+	provisionTtl time.Duration) *DeviceSvc {
 
 	return &DeviceSvc{
 		deviceReader:  deviceReader,
@@ -56,22 +102,85 @@ func NewDeviceSvc(deviceReader DeviceReader,
 		prvStgReader:  prvStgReader,
 		prvStgWriter:  prvStgWriter,
 		userCtxReader: userCtxReader,
+		memberReader:  memberReader,
+		dataDeleter:   dataDeleter,
+		provisionTtl:  provisionTtl,
 	}
 }
 
+// GetUserDevices returns the devices the user in context owns, and
+// those shared with any of their households
 func (s DeviceSvc) GetUserDevices(ctx context.Context) ([]sqlc.Device, error) {
 	user, err := s.userCtxReader.GetUser(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("Error GetUserDevices -> GetUser: \n%w\n", err)
 	}
 
-	devices, err := s.deviceReader.GetDevicesByUser(ctx, user.UserID)
+	devices, err := s.deviceReader.GetAccessibleDevices(ctx, user.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("Error GetUserDevices -> GetDevicesByUser: \n%w\n", err)
+		return nil, fmt.Errorf("Error GetUserDevices -> GetAccessibleDevices: \n%w\n", err)
 	}
 	return devices, nil
 }
 
+// GetUserDevice returns the device if the user in context may see it:
+// they own it, or it is shared with a household they belong to
+func (s DeviceSvc) GetUserDevice(ctx context.Context, deviceId int32) (sqlc.Device, error) {
+	device, err := s.deviceWithRole(ctx, deviceId, RoleViewer)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error GetUserDevice -> deviceWithRole: \n%w\n", err)
+	}
+	return device, nil
+}
+
+// GetEditableDevice returns the device if the user in context may
+// change its settings, alert rules and calibration, or send it
+// commands. Household viewers get ErrDeviceReadOnly.
+func (s DeviceSvc) GetEditableDevice(ctx context.Context, deviceId int32) (sqlc.Device, error) {
+	device, err := s.deviceWithRole(ctx, deviceId, RoleEditor)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error GetEditableDevice -> deviceWithRole: \n%w\n", err)
+	}
+	return device, nil
+}
+
+// deviceWithRole loads the device and checks the user in context has at
+// least role on it. The device's own user is always its owner; anyone
+// else gets the role they hold in the household it is shared with, up
+// to editor. Owner access to a device never comes from a household.
+func (s DeviceSvc) deviceWithRole(ctx context.Context, deviceId int32, role string) (sqlc.Device, error) {
+	user, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error deviceWithRole -> GetUser: \n%w\n", err)
+	}
+
+	device, err := s.deviceReader.GetDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error deviceWithRole -> GetDevice: \n%w\n", err)
+	}
+	if device.DeviceID <= 0 {
+		return sqlc.Device{}, fmt.Errorf("Error deviceWithRole (deviceId: %v): \n%w\n", deviceId, ErrNoDevice)
+	}
+	if device.UserID == user.UserID {
+		return device, nil
+	}
+
+	if role == RoleOwner || !device.HouseholdID.Valid || s.memberReader == nil {
+		return sqlc.Device{}, fmt.Errorf("Error deviceWithRole (deviceId: %v): \n%w\n", deviceId, ErrDeviceForbidden)
+	}
+	member, err := s.memberReader.GetHouseholdMember(ctx, device.HouseholdID.Int32, user.UserID)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error deviceWithRole -> GetHouseholdMember: \n%w\n", err)
+	}
+	if member.UserID <= 0 {
+		return sqlc.Device{}, fmt.Errorf("Error deviceWithRole (deviceId: %v): \n%w\n", deviceId, ErrDeviceForbidden)
+	}
+	if !roleAtLeast(member.Role, role) {
+		return sqlc.Device{}, fmt.Errorf("Error deviceWithRole (deviceId: %v, role: %v): \n%w\n", deviceId, member.Role, ErrDeviceReadOnly)
+	}
+	return device, nil
+}
+
 func (s DeviceSvc) GetDeviceByMacAddress(ctx context.Context, macAddr string) (sqlc.Device, error) {
 	device, err := s.deviceReader.GetDeviceByMacAddress(ctx, macAddr)
 	if err != nil {
@@ -80,34 +189,56 @@ func (s DeviceSvc) GetDeviceByMacAddress(ctx context.Context, macAddr string) (s
 	return device, nil
 }
 
-// Called by user via rest api
-func (s DeviceSvc) CreateDeviceProvision(ctx context.Context, displayName string) (string, error) {
+// Called by user via rest api. The returned contract is handed to the
+// device, which presents it once to bind its mac address.
+func (s DeviceSvc) CreateDeviceProvision(ctx context.Context, displayName string) (sqlc.ProvisionStaging, error) {
 	user, err := s.userCtxReader.GetUser(ctx)
 	if err != nil {
-		return "", fmt.Errorf("Error CreateDeviceProvision -> GetUser: \n%w\n", err)
+		return sqlc.ProvisionStaging{}, fmt.Errorf("Error CreateDeviceProvision -> GetUser: \n%w\n", err)
 	}
 
 	device, err := s.deviceWriter.CreateDevice(ctx, user.UserID, displayName)
 	if err != nil {
-		return "", fmt.Errorf("Error CreateDeviceProvision -> CreateDevice: \n%w\n", err)
+		return sqlc.ProvisionStaging{}, fmt.Errorf("Error CreateDeviceProvision -> CreateDevice: \n%w\n", err)
 	}
-	uuid := uuid.NewString()
-	err = s.prvStgWriter.CreateProvisionStaging(ctx, device.DeviceID, uuid)
+	prv, err := s.prvStgWriter.CreateProvisionStaging(ctx, device.DeviceID, uuid.NewString(), time.Now().Add(s.provisionTtl))
 	if err != nil {
-		return "", fmt.Errorf("Error CreateDeviceProvision -> CreateProvisionStaging: \n%w\n", err)
+		return sqlc.ProvisionStaging{}, fmt.Errorf("Error CreateDeviceProvision -> CreateProvisionStaging: \n%w\n", err)
 	}
-	return uuid, nil
+	return prv, nil
 }
 
 // Called by device via mqtt hub
 func (s DeviceSvc) CompleteDeviceProvision(ctx context.Context, data DevicePrvPayload) (sqlc.Device, error) {
-	// lookup contract (uuid) from provision staging table
-	prv, err := s.prvStgReader.GetProvisionStagingByContract(ctx, data.Contract)
+	if data.Contract == "" {
+		return sqlc.Device{}, fmt.Errorf("Error CompleteDeviceProvision (macAddr: %v): \n%w\n", data.MacAddr, ErrContractInvalid)
+	}
+
+	// A bound mac address is never moved by a contract; the owner has to
+	// reset the device first. A redelivered provision message for a
+	// device that is already bound just returns it.
+	bound, err := s.deviceReader.GetDeviceByMacAddress(ctx, data.MacAddr)
 	if err != nil {
-		return sqlc.Device{}, fmt.Errorf("Error CompleteDeviceProvision -> GetProvisionStagingByContract: \n%w\n", err)
+		return sqlc.Device{}, fmt.Errorf("Error CompleteDeviceProvision -> GetDeviceByMacAddress: \n%w\n", err)
 	}
-	if prv.Contract.String == "" {
-		return sqlc.Device{}, fmt.Errorf("Error CompleteDeviceProvision -> GetProvisionStagingByContract: No Provision Staging record found")
+	if bound.DeviceID > 0 {
+		pending, err := s.prvStgReader.GetProvisionStagingByContract(ctx, data.Contract)
+		if err != nil {
+			return sqlc.Device{}, fmt.Errorf("Error CompleteDeviceProvision -> GetProvisionStagingByContract: \n%w\n", err)
+		}
+		if pending.DeviceID > 0 && pending.DeviceID != bound.DeviceID {
+			return sqlc.Device{}, fmt.Errorf("Error CompleteDeviceProvision (macAddr: %v): \n%w\n", data.MacAddr, ErrMacInUse)
+		}
+		return bound, nil
+	}
+
+	// single use: the staging record is deleted as it's read
+	prv, err := s.prvStgWriter.ConsumeProvisionStaging(ctx, data.Contract, time.Now())
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error CompleteDeviceProvision -> ConsumeProvisionStaging: \n%w\n", err)
+	}
+	if prv.DeviceID <= 0 {
+		return sqlc.Device{}, fmt.Errorf("Error CompleteDeviceProvision (macAddr: %v): \n%w\n", data.MacAddr, ErrContractInvalid)
 	}
 
 	// update mac address of device record
@@ -122,3 +253,185 @@ func (s DeviceSvc) CompleteDeviceProvision(ctx context.Context, data DevicePrvPa
 	}
 	return device, nil
 }
+
+// CancelDeviceProvision revokes the device's pending contract. A device
+// that was never bound to hardware is removed along with it.
+func (s DeviceSvc) CancelDeviceProvision(ctx context.Context, deviceId int32) error {
+	device, err := s.deviceWithRole(ctx, deviceId, RoleOwner)
+	if err != nil {
+		return fmt.Errorf("Error CancelDeviceProvision -> deviceWithRole: \n%w\n", err)
+	}
+
+	prv, err := s.prvStgReader.GetProvisionStagingByDevice(ctx, device.DeviceID)
+	if err != nil {
+		return fmt.Errorf("Error CancelDeviceProvision -> GetProvisionStagingByDevice: \n%w\n", err)
+	}
+	if prv.DeviceID <= 0 {
+		return fmt.Errorf("Error CancelDeviceProvision (deviceId: %v): \n%w\n", deviceId, ErrNoProvision)
+	}
+
+	err = s.prvStgWriter.DeleteProvisionStaging(ctx, device.DeviceID)
+	if err != nil {
+		return fmt.Errorf("Error CancelDeviceProvision -> DeleteProvisionStaging: \n%w\n", err)
+	}
+	if device.MacAddr.String == "" {
+		err = s.deviceWriter.DeleteDevice(ctx, device.DeviceID)
+		if err != nil {
+			return fmt.Errorf("Error CancelDeviceProvision -> DeleteDevice: \n%w\n", err)
+		}
+	}
+	return nil
+}
+
+// ResetDevice unbinds the device's mac address so the hardware can be
+// provisioned again, by this user or another. The device keeps its
+// history; the hardware's old contract stays spent.
+func (s DeviceSvc) ResetDevice(ctx context.Context, deviceId int32) (sqlc.Device, error) {
+	device, err := s.deviceWithRole(ctx, deviceId, RoleOwner)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error ResetDevice -> deviceWithRole: \n%w\n", err)
+	}
+	if device.MacAddr.String == "" {
+		return sqlc.Device{}, fmt.Errorf("Error ResetDevice (deviceId: %v): \n%w\n", deviceId, ErrDeviceNotProvisioned)
+	}
+
+	err = s.deviceWriter.ResetDeviceMacAddress(ctx, device.DeviceID)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error ResetDevice -> ResetDeviceMacAddress: \n%w\n", err)
+	}
+	device.MacAddr = pgtype.Text{}
+	device.Online = false
+	return device, nil
+}
+
+// NormalizeMacAddr returns macAddr in the form it is stored and looked
+// up in
+func NormalizeMacAddr(macAddr string) string {
+	return strings.TrimSpace(macAddr)
+}
+
+// ForceBindDevice binds macAddr to the device without a contract, for
+// operators moving hardware by hand. Whichever device held the address
+// loses it, and the device's pending provision is dropped.
+func (s DeviceSvc) ForceBindDevice(ctx context.Context, deviceId int32, macAddr string) (sqlc.Device, error) {
+	macAddr = NormalizeMacAddr(macAddr)
+	if _, err := net.ParseMAC(macAddr); err != nil || len(macAddr) > 17 {
+		return sqlc.Device{}, fmt.Errorf("Error ForceBindDevice (macAddr: '%v'): \n%w\n", macAddr, ErrInvalidDevice)
+	}
+
+	device, err := s.deviceReader.GetDevice(ctx, deviceId)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error ForceBindDevice -> GetDevice: \n%w\n", err)
+	}
+	if device.DeviceID <= 0 {
+		return sqlc.Device{}, fmt.Errorf("Error ForceBindDevice (deviceId: %v): \n%w\n", deviceId, ErrNoDevice)
+	}
+
+	err = s.deviceWriter.ForceDeviceMacAddress(ctx, device.DeviceID, macAddr)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error ForceBindDevice -> ForceDeviceMacAddress: \n%w\n", err)
+	}
+	device.MacAddr = pgtype.Text{String: macAddr, Valid: true}
+	return device, nil
+}
+
+// UpdateDevice changes the device's name and the details kept about its
+// plant. Household editors can change them too.
+func (s DeviceSvc) UpdateDevice(ctx context.Context, deviceId int32, req DeviceUpdateRequest) (sqlc.Device, error) {
+	device, err := s.deviceWithRole(ctx, deviceId, RoleEditor)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error UpdateDevice -> deviceWithRole: \n%w\n", err)
+	}
+
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if name == "" || len(name) > maxDeviceFieldLen {
+			return sqlc.Device{}, fmt.Errorf("Error UpdateDevice (displayName must be 1-%v characters): \n%w\n", maxDeviceFieldLen, ErrInvalidDevice)
+		}
+		device.DisplayName = pgtype.Text{String: name, Valid: true}
+	}
+	fields := []struct {
+		name  string
+		value *string
+		max   int
+		dest  *pgtype.Text
+	}{
+		{"location", req.Location, maxDeviceFieldLen, &device.Location},
+		{"plantSpecies", req.PlantSpecies, maxDeviceFieldLen, &device.PlantSpecies},
+		{"notes", req.Notes, maxDeviceNotesLen, &device.Notes},
+	}
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		v := strings.TrimSpace(*f.value)
+		if len(v) > f.max {
+			return sqlc.Device{}, fmt.Errorf("Error UpdateDevice (%v is longer than %v characters): \n%w\n", f.name, f.max, ErrInvalidDevice)
+		}
+		*f.dest = pgtype.Text{String: v, Valid: v != ""}
+	}
+
+	err = s.deviceWriter.UpdateDeviceDetails(ctx, device)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error UpdateDevice -> UpdateDeviceDetails: \n%w\n", err)
+	}
+	return device, nil
+}
+
+// DeleteDevice removes the device with its readings, pending provision
+// and everything stored against it. Readings go first, so a failure
+// leaves the device in place to delete again.
+func (s DeviceSvc) DeleteDevice(ctx context.Context, deviceId int32) error {
+	device, err := s.deviceWithRole(ctx, deviceId, RoleOwner)
+	if err != nil {
+		return fmt.Errorf("Error DeleteDevice -> deviceWithRole: \n%w\n", err)
+	}
+
+	if s.dataDeleter != nil {
+		err = s.dataDeleter.DeleteDeviceData(ctx, int(device.DeviceID))
+		if err != nil {
+			return fmt.Errorf("Error DeleteDevice -> DeleteDeviceData: \n%w\n", err)
+		}
+	}
+	err = s.prvStgWriter.DeleteProvisionStaging(ctx, device.DeviceID)
+	if err != nil {
+		return fmt.Errorf("Error DeleteDevice -> DeleteProvisionStaging: \n%w\n", err)
+	}
+	// alert rules, commands, calibration and connection history cascade
+	err = s.deviceWriter.DeleteDevice(ctx, device.DeviceID)
+	if err != nil {
+		return fmt.Errorf("Error DeleteDevice -> DeleteDevice: \n%w\n", err)
+	}
+	return nil
+}
+
+// ShareDevice shares the device with a household the user in context
+// can edit in, or makes it private again when householdId is nil. Only
+// the device's own user may move it, not a household owner.
+func (s DeviceSvc) ShareDevice(ctx context.Context, deviceId int32, householdId *int32) (sqlc.Device, error) {
+	device, err := s.deviceWithRole(ctx, deviceId, RoleOwner)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error ShareDevice -> deviceWithRole: \n%w\n", err)
+	}
+
+	device.HouseholdID = pgtype.Int4{}
+	if householdId != nil {
+		if s.memberReader == nil {
+			return sqlc.Device{}, fmt.Errorf("Error ShareDevice (householdId: %v): \n%w\n", *householdId, ErrHouseholdForbidden)
+		}
+		member, err := s.memberReader.GetHouseholdMember(ctx, *householdId, device.UserID)
+		if err != nil {
+			return sqlc.Device{}, fmt.Errorf("Error ShareDevice -> GetHouseholdMember: \n%w\n", err)
+		}
+		if member.UserID <= 0 || !roleAtLeast(member.Role, RoleEditor) {
+			return sqlc.Device{}, fmt.Errorf("Error ShareDevice (householdId: %v): \n%w\n", *householdId, ErrHouseholdForbidden)
+		}
+		device.HouseholdID = pgtype.Int4{Int32: *householdId, Valid: true}
+	}
+
+	err = s.deviceWriter.SetDeviceHousehold(ctx, device.DeviceID, householdId)
+	if err != nil {
+		return sqlc.Device{}, fmt.Errorf("Error ShareDevice -> SetDeviceHousehold: \n%w\n", err)
+	}
+	return device, nil
+}
@@ -2,10 +2,14 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -16,6 +20,8 @@ var (
 	prvStgReader  mocks.MockPrvStgReader
 	prvStgWriter  mocks.MockPrvStgWriter
 	userCtxReader mocks.MockUserCtxReader
+	memberReader  mocks.MockHouseholdReader
+	dataDeleter   mocks.MockDeviceDataDeleter
 	deviceSvc     DeviceSvc
 )
 
@@ -25,12 +31,17 @@ func setupDeviceSvcTests() {
 	prvStgReader = mocks.MockPrvStgReader{Mock: new(mock.Mock)}
 	prvStgWriter = mocks.MockPrvStgWriter{Mock: new(mock.Mock)}
 	userCtxReader = mocks.MockUserCtxReader{Mock: new(mock.Mock)}
+	memberReader = mocks.MockHouseholdReader{Mock: new(mock.Mock)}
+	dataDeleter = mocks.MockDeviceDataDeleter{Mock: new(mock.Mock)}
 	deviceSvc = *NewDeviceSvc(
 		deviceReader,
 		deviceWriter,
 		prvStgReader,
 		prvStgWriter,
 		userCtxReader,
+		memberReader,
+		dataDeleter,
+		time.Hour,
 	)
 }
 
@@ -55,7 +66,7 @@ func TestGetUserDevices(t *testing.T) {
 		}
 
 		userCtxReader.On("GetUser", ctx).Return(user, nil)
-		deviceReader.On("GetDevicesByUser", ctx, user.UserID).Return(dvcs, nil)
+		deviceReader.On("GetAccessibleDevices", ctx, user.UserID).Return(dvcs, nil)
 
 		result, err := deviceSvc.GetUserDevices(ctx)
 		assert.Nil(t, err)
@@ -63,3 +74,438 @@ func TestGetUserDevices(t *testing.T) {
 		assert.Equal(t, dvcs, result)
 	})
 }
+
+func TestCreateDeviceProvision(t *testing.T) {
+	ctx := context.Background()
+	setupDeviceSvcTests()
+	user := sqlc.User{UserID: 1234}
+	dvc := sqlc.Device{DeviceID: 12, UserID: user.UserID}
+	prv := sqlc.ProvisionStaging{DeviceID: dvc.DeviceID, Contract: pgtype.Text{String: "contract", Valid: true}}
+
+	userCtxReader.On("GetUser", ctx).Return(user, nil)
+	deviceWriter.On("CreateDevice", ctx, user.UserID, "Fern").Return(dvc, nil)
+	prvStgWriter.On("CreateProvisionStaging", ctx, dvc.DeviceID, mock.Anything,
+		mock.MatchedBy(func(expiresAt time.Time) bool {
+			return expiresAt.After(time.Now().Add(59*time.Minute)) && expiresAt.Before(time.Now().Add(time.Hour))
+		})).Return(prv, nil)
+
+	result, err := deviceSvc.CreateDeviceProvision(ctx, "Fern")
+
+	assert.Nil(t, err)
+	assert.Equal(t, prv, result)
+}
+
+func TestCompleteDeviceProvision(t *testing.T) {
+	ctx := context.Background()
+	payload := DevicePrvPayload{MacAddr: "aabbccddeeff", Contract: "contract"}
+	dvc := sqlc.Device{DeviceID: 12, MacAddr: pgtype.Text{String: payload.MacAddr, Valid: true}}
+
+	t.Run("Success", func(t *testing.T) {
+		setupDeviceSvcTests()
+		deviceReader.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(sqlc.Device{}, nil).Once()
+		prvStgWriter.On("ConsumeProvisionStaging", ctx, payload.Contract, mock.AnythingOfType("time.Time")).
+			Return(sqlc.ProvisionStaging{DeviceID: dvc.DeviceID}, nil)
+		deviceWriter.On("UpdateDeviceMacAddress", ctx, dvc.DeviceID, payload.MacAddr).Return(nil)
+		deviceReader.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(dvc, nil).Once()
+
+		result, err := deviceSvc.CompleteDeviceProvision(ctx, payload)
+
+		assert.Nil(t, err)
+		assert.Equal(t, dvc, result)
+		deviceWriter.AssertExpectations(t)
+	})
+
+	t.Run("SpentContract", func(t *testing.T) {
+		setupDeviceSvcTests()
+		deviceReader.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(sqlc.Device{}, nil)
+		// expired, already used or never issued
+		prvStgWriter.On("ConsumeProvisionStaging", ctx, payload.Contract, mock.AnythingOfType("time.Time")).
+			Return(sqlc.ProvisionStaging{}, nil)
+
+		_, err := deviceSvc.CompleteDeviceProvision(ctx, payload)
+
+		assert.ErrorIs(t, err, ErrContractInvalid)
+		deviceWriter.AssertNotCalled(t, "UpdateDeviceMacAddress", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("MacBoundElsewhere", func(t *testing.T) {
+		setupDeviceSvcTests()
+		deviceReader.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(dvc, nil)
+		prvStgReader.On("GetProvisionStagingByContract", ctx, payload.Contract).
+			Return(sqlc.ProvisionStaging{DeviceID: 99}, nil)
+
+		_, err := deviceSvc.CompleteDeviceProvision(ctx, payload)
+
+		assert.ErrorIs(t, err, ErrMacInUse)
+		prvStgWriter.AssertNotCalled(t, "ConsumeProvisionStaging", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Redelivered", func(t *testing.T) {
+		setupDeviceSvcTests()
+		deviceReader.On("GetDeviceByMacAddress", ctx, payload.MacAddr).Return(dvc, nil)
+		prvStgReader.On("GetProvisionStagingByContract", ctx, payload.Contract).Return(sqlc.ProvisionStaging{}, nil)
+
+		result, err := deviceSvc.CompleteDeviceProvision(ctx, payload)
+
+		assert.Nil(t, err)
+		assert.Equal(t, dvc, result)
+		deviceWriter.AssertNotCalled(t, "UpdateDeviceMacAddress", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestCancelDeviceProvision(t *testing.T) {
+	ctx := context.Background()
+	user := sqlc.User{UserID: 1234}
+	pending := sqlc.Device{DeviceID: 12, UserID: user.UserID}
+
+	t.Run("Success", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, pending.DeviceID).Return(pending, nil)
+		prvStgReader.On("GetProvisionStagingByDevice", ctx, pending.DeviceID).
+			Return(sqlc.ProvisionStaging{DeviceID: pending.DeviceID}, nil)
+		prvStgWriter.On("DeleteProvisionStaging", ctx, pending.DeviceID).Return(nil)
+		deviceWriter.On("DeleteDevice", ctx, pending.DeviceID).Return(nil)
+
+		err := deviceSvc.CancelDeviceProvision(ctx, pending.DeviceID)
+
+		assert.Nil(t, err)
+		prvStgWriter.AssertExpectations(t)
+		deviceWriter.AssertExpectations(t)
+	})
+
+	t.Run("NothingPending", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, pending.DeviceID).Return(pending, nil)
+		prvStgReader.On("GetProvisionStagingByDevice", ctx, pending.DeviceID).Return(sqlc.ProvisionStaging{}, nil)
+
+		err := deviceSvc.CancelDeviceProvision(ctx, pending.DeviceID)
+
+		assert.ErrorIs(t, err, ErrNoProvision)
+		deviceWriter.AssertNotCalled(t, "DeleteDevice", mock.Anything, mock.Anything)
+	})
+}
+
+func TestResetDevice(t *testing.T) {
+	ctx := context.Background()
+	user := sqlc.User{UserID: 1234}
+	dvc := sqlc.Device{
+		DeviceID: 12,
+		UserID:   user.UserID,
+		MacAddr:  pgtype.Text{String: "aabbccddeeff", Valid: true},
+		Online:   true,
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		deviceWriter.On("ResetDeviceMacAddress", ctx, dvc.DeviceID).Return(nil)
+
+		result, err := deviceSvc.ResetDevice(ctx, dvc.DeviceID)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "", result.MacAddr.String)
+		assert.False(t, result.Online)
+		deviceWriter.AssertExpectations(t)
+	})
+
+	t.Run("OtherUser", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(sqlc.User{UserID: 99}, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+
+		_, err := deviceSvc.ResetDevice(ctx, dvc.DeviceID)
+
+		assert.ErrorIs(t, err, ErrDeviceForbidden)
+		deviceWriter.AssertNotCalled(t, "ResetDeviceMacAddress", mock.Anything, mock.Anything)
+	})
+
+	t.Run("HouseholdOwner", func(t *testing.T) {
+		setupDeviceSvcTests()
+		shared := dvc
+		shared.HouseholdID = pgtype.Int4{Int32: 5, Valid: true}
+		userCtxReader.On("GetUser", ctx).Return(sqlc.User{UserID: 99}, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(shared, nil)
+		memberReader.On("GetHouseholdMember", ctx, int32(5), int32(99)).
+			Return(sqlc.HouseholdMember{HouseholdID: 5, UserID: 99, Role: RoleOwner}, nil)
+
+		_, err := deviceSvc.ResetDevice(ctx, dvc.DeviceID)
+
+		assert.ErrorIs(t, err, ErrDeviceForbidden)
+		deviceWriter.AssertNotCalled(t, "ResetDeviceMacAddress", mock.Anything, mock.Anything)
+	})
+}
+
+func TestUpdateDevice(t *testing.T) {
+	ctx := context.Background()
+	user := sqlc.User{UserID: 1234}
+	dvc := sqlc.Device{
+		DeviceID:    12,
+		UserID:      user.UserID,
+		DisplayName: pgtype.Text{String: "Fern", Valid: true},
+		Notes:       pgtype.Text{String: "water weekly", Valid: true},
+	}
+	str := func(s string) *string { return &s }
+
+	t.Run("Success", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		deviceWriter.On("UpdateDeviceDetails", ctx, mock.Anything).Return(nil)
+
+		result, err := deviceSvc.UpdateDevice(ctx, dvc.DeviceID, DeviceUpdateRequest{
+			DisplayName:  str(" Big Fern "),
+			PlantSpecies: str("Nephrolepis exaltata"),
+			Notes:        str(""),
+		})
+
+		assert.Nil(t, err)
+		expected := sqlc.Device{
+			DeviceID:     12,
+			UserID:       user.UserID,
+			DisplayName:  pgtype.Text{String: "Big Fern", Valid: true},
+			PlantSpecies: pgtype.Text{String: "Nephrolepis exaltata", Valid: true},
+		}
+		assert.Equal(t, expected, result)
+		deviceWriter.AssertCalled(t, "UpdateDeviceDetails", ctx, expected)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		reqs := []DeviceUpdateRequest{
+			{DisplayName: str("  ")},
+			{Location: str(strings.Repeat("a", 251))},
+			{Notes: str(strings.Repeat("a", 2001))},
+		}
+
+		for _, req := range reqs {
+			_, err := deviceSvc.UpdateDevice(ctx, dvc.DeviceID, req)
+			assert.ErrorIs(t, err, ErrInvalidDevice)
+		}
+		deviceWriter.AssertNotCalled(t, "UpdateDeviceDetails", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Viewer", func(t *testing.T) {
+		setupDeviceSvcTests()
+		shared := sqlc.Device{DeviceID: 12, UserID: 99, HouseholdID: pgtype.Int4{Int32: 5, Valid: true}}
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(shared, nil)
+		memberReader.On("GetHouseholdMember", ctx, int32(5), user.UserID).
+			Return(sqlc.HouseholdMember{HouseholdID: 5, UserID: user.UserID, Role: RoleViewer}, nil)
+
+		_, err := deviceSvc.UpdateDevice(ctx, dvc.DeviceID, DeviceUpdateRequest{Notes: str("mine now")})
+
+		assert.ErrorIs(t, err, ErrDeviceReadOnly)
+		deviceWriter.AssertNotCalled(t, "UpdateDeviceDetails", mock.Anything, mock.Anything)
+	})
+}
+
+func TestForceBindDevice(t *testing.T) {
+	ctx := context.Background()
+	dvc := sqlc.Device{DeviceID: 12, UserID: 1234}
+
+	t.Run("Success", func(t *testing.T) {
+		setupDeviceSvcTests()
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		deviceWriter.On("ForceDeviceMacAddress", ctx, dvc.DeviceID, "aa:bb:cc:dd:ee:ff").Return(nil)
+
+		result, err := deviceSvc.ForceBindDevice(ctx, dvc.DeviceID, " aa:bb:cc:dd:ee:ff ")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "aa:bb:cc:dd:ee:ff", result.MacAddr.String)
+		deviceWriter.AssertExpectations(t)
+		userCtxReader.AssertNotCalled(t, "GetUser", mock.Anything)
+	})
+
+	t.Run("InvalidMac", func(t *testing.T) {
+		setupDeviceSvcTests()
+
+		_, err := deviceSvc.ForceBindDevice(ctx, dvc.DeviceID, "not-a-mac")
+
+		assert.ErrorIs(t, err, ErrInvalidDevice)
+		deviceReader.AssertNotCalled(t, "GetDevice", mock.Anything, mock.Anything)
+	})
+
+	t.Run("NoDevice", func(t *testing.T) {
+		setupDeviceSvcTests()
+		deviceReader.On("GetDevice", ctx, int32(99)).Return(sqlc.Device{}, nil)
+
+		_, err := deviceSvc.ForceBindDevice(ctx, 99, "aa:bb:cc:dd:ee:ff")
+
+		assert.ErrorIs(t, err, ErrNoDevice)
+		deviceWriter.AssertNotCalled(t, "ForceDeviceMacAddress", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestDeleteDevice(t *testing.T) {
+	ctx := context.Background()
+	user := sqlc.User{UserID: 1234}
+	dvc := sqlc.Device{DeviceID: 12, UserID: user.UserID}
+
+	t.Run("Success", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		dataDeleter.On("DeleteDeviceData", ctx, 12).Return(nil)
+		prvStgWriter.On("DeleteProvisionStaging", ctx, dvc.DeviceID).Return(nil)
+		deviceWriter.On("DeleteDevice", ctx, dvc.DeviceID).Return(nil)
+
+		err := deviceSvc.DeleteDevice(ctx, dvc.DeviceID)
+
+		assert.Nil(t, err)
+		dataDeleter.AssertExpectations(t)
+		prvStgWriter.AssertExpectations(t)
+		deviceWriter.AssertExpectations(t)
+	})
+
+	t.Run("DataDeleteFails", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		dataDeleter.On("DeleteDeviceData", ctx, 12).Return(fmt.Errorf("influx unavailable"))
+
+		err := deviceSvc.DeleteDevice(ctx, dvc.DeviceID)
+
+		assert.NotNil(t, err)
+		deviceWriter.AssertNotCalled(t, "DeleteDevice", mock.Anything, mock.Anything)
+	})
+
+	t.Run("HouseholdEditor", func(t *testing.T) {
+		setupDeviceSvcTests()
+		shared := sqlc.Device{DeviceID: 12, UserID: 99, HouseholdID: pgtype.Int4{Int32: 5, Valid: true}}
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(shared, nil)
+
+		err := deviceSvc.DeleteDevice(ctx, dvc.DeviceID)
+
+		assert.ErrorIs(t, err, ErrDeviceForbidden)
+		dataDeleter.AssertNotCalled(t, "DeleteDeviceData", mock.Anything, mock.Anything)
+	})
+}
+
+func TestHouseholdDeviceAccess(t *testing.T) {
+	ctx := context.Background()
+	user := sqlc.User{UserID: 1234}
+	shared := sqlc.Device{
+		DeviceID:    12,
+		UserID:      99,
+		HouseholdID: pgtype.Int4{Int32: 5, Valid: true},
+	}
+
+	tests := []struct {
+		name     string
+		role     string
+		viewErr  error
+		writeErr error
+	}{
+		{"Viewer", RoleViewer, nil, ErrDeviceReadOnly},
+		{"Editor", RoleEditor, nil, nil},
+		{"Owner", RoleOwner, nil, nil},
+		{"NotMember", "", ErrDeviceForbidden, ErrDeviceForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupDeviceSvcTests()
+			member := sqlc.HouseholdMember{}
+			if tt.role != "" {
+				member = sqlc.HouseholdMember{HouseholdID: 5, UserID: user.UserID, Role: tt.role}
+			}
+			userCtxReader.On("GetUser", ctx).Return(user, nil)
+			deviceReader.On("GetDevice", ctx, shared.DeviceID).Return(shared, nil)
+			memberReader.On("GetHouseholdMember", ctx, int32(5), user.UserID).Return(member, nil)
+
+			_, err := deviceSvc.GetUserDevice(ctx, shared.DeviceID)
+			assertErrorIs(t, err, tt.viewErr)
+
+			_, err = deviceSvc.GetEditableDevice(ctx, shared.DeviceID)
+			assertErrorIs(t, err, tt.writeErr)
+		})
+	}
+
+	t.Run("NotShared", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, shared.DeviceID).Return(sqlc.Device{DeviceID: 12, UserID: 99}, nil)
+
+		_, err := deviceSvc.GetUserDevice(ctx, shared.DeviceID)
+
+		assert.ErrorIs(t, err, ErrDeviceForbidden)
+		memberReader.AssertNotCalled(t, "GetHouseholdMember", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestShareDevice(t *testing.T) {
+	ctx := context.Background()
+	user := sqlc.User{UserID: 1234}
+	dvc := sqlc.Device{DeviceID: 12, UserID: user.UserID}
+	householdId := int32(5)
+
+	t.Run("Success", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		memberReader.On("GetHouseholdMember", ctx, householdId, user.UserID).
+			Return(sqlc.HouseholdMember{HouseholdID: householdId, UserID: user.UserID, Role: RoleEditor}, nil)
+		deviceWriter.On("SetDeviceHousehold", ctx, dvc.DeviceID, &householdId).Return(nil)
+
+		result, err := deviceSvc.ShareDevice(ctx, dvc.DeviceID, &householdId)
+
+		assert.Nil(t, err)
+		assert.Equal(t, pgtype.Int4{Int32: householdId, Valid: true}, result.HouseholdID)
+		deviceWriter.AssertExpectations(t)
+	})
+
+	t.Run("Unshare", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		sharedDvc := dvc
+		sharedDvc.HouseholdID = pgtype.Int4{Int32: householdId, Valid: true}
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(sharedDvc, nil)
+		deviceWriter.On("SetDeviceHousehold", ctx, dvc.DeviceID, (*int32)(nil)).Return(nil)
+
+		result, err := deviceSvc.ShareDevice(ctx, dvc.DeviceID, nil)
+
+		assert.Nil(t, err)
+		assert.False(t, result.HouseholdID.Valid)
+	})
+
+	t.Run("ViewerInHousehold", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(dvc, nil)
+		memberReader.On("GetHouseholdMember", ctx, householdId, user.UserID).
+			Return(sqlc.HouseholdMember{HouseholdID: householdId, UserID: user.UserID, Role: RoleViewer}, nil)
+
+		_, err := deviceSvc.ShareDevice(ctx, dvc.DeviceID, &householdId)
+
+		assert.ErrorIs(t, err, ErrHouseholdForbidden)
+		deviceWriter.AssertNotCalled(t, "SetDeviceHousehold", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("HouseholdOwnerNotDeviceOwner", func(t *testing.T) {
+		setupDeviceSvcTests()
+		userCtxReader.On("GetUser", ctx).Return(user, nil)
+		otherDvc := sqlc.Device{DeviceID: 12, UserID: 99, HouseholdID: pgtype.Int4{Int32: householdId, Valid: true}}
+		deviceReader.On("GetDevice", ctx, dvc.DeviceID).Return(otherDvc, nil)
+		memberReader.On("GetHouseholdMember", ctx, householdId, user.UserID).
+			Return(sqlc.HouseholdMember{HouseholdID: householdId, UserID: user.UserID, Role: RoleOwner}, nil)
+
+		_, err := deviceSvc.ShareDevice(ctx, dvc.DeviceID, nil)
+
+		assert.ErrorIs(t, err, ErrDeviceForbidden)
+		deviceWriter.AssertNotCalled(t, "SetDeviceHousehold", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func assertErrorIs(t *testing.T, err error, target error) {
+	if target == nil {
+		assert.Nil(t, err)
+	} else {
+		assert.ErrorIs(t, err, target)
+	}
+}
@@ -0,0 +1,423 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/assets"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Household roles, in increasing order of access. Viewers can see the
+// household's devices, their data and alert rules; editors can also
+// change them and send commands; owners manage members and invites.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+var roleRank = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+var maxHouseholdNameLen = 250
+
+var (
+	ErrInvalidHousehold   = fmt.Errorf("Invalid household request")
+	ErrHouseholdNotFound  = fmt.Errorf("Household not found")
+	ErrHouseholdForbidden = fmt.Errorf("Household role does not allow this")
+	ErrInviteInvalid      = fmt.Errorf("Household invite is invalid, expired or already used")
+	ErrLastOwner          = fmt.Errorf("Household must keep at least one owner")
+)
+
+type HouseholdMemberReader interface {
+	GetHouseholdMember(ctx context.Context, householdId int32, userId int32) (sqlc.HouseholdMember, error)
+}
+
+type HouseholdReader interface {
+	GetHousehold(ctx context.Context, householdId int32) (sqlc.Household, error)
+	GetHouseholdsByUser(ctx context.Context, userId int32) ([]sqlc.GetHouseholdsByUserRow, error)
+	GetHouseholdMember(ctx context.Context, householdId int32, userId int32) (sqlc.HouseholdMember, error)
+	GetHouseholdMembers(ctx context.Context, householdId int32) ([]sqlc.GetHouseholdMembersRow, error)
+	CountHouseholdOwners(ctx context.Context, householdId int32) (int64, error)
+}
+type HouseholdWriter interface {
+	CreateHousehold(ctx context.Context, name string, ownerId int32) (sqlc.Household, error)
+	DeleteHousehold(ctx context.Context, householdId int32) error
+	UpdateHouseholdMemberRole(ctx context.Context, householdId int32, userId int32, role string) error
+	DeleteHouseholdMember(ctx context.Context, householdId int32, userId int32) error
+}
+
+type HouseholdInviteReader interface {
+	GetHouseholdInviteByToken(ctx context.Context, token string) (sqlc.HouseholdInvite, error)
+	GetHouseholdInvites(ctx context.Context, householdId int32, now time.Time) ([]sqlc.HouseholdInvite, error)
+}
+type HouseholdInviteWriter interface {
+	CreateHouseholdInvite(ctx context.Context, invite sqlc.HouseholdInvite) (sqlc.HouseholdInvite, error)
+	AcceptHouseholdInvite(ctx context.Context, token string, userId int32, now time.Time) (sqlc.HouseholdMember, error)
+	DeleteHouseholdInvite(ctx context.Context, householdId int32, inviteId int64) error
+}
+
+// AddressNotifier emails someone who may not have an account yet
+type AddressNotifier interface {
+	NotifyAddress(ctx context.Context, fromUserId int32, email string, n notify.Notification) error
+}
+
+type HouseholdSvc struct {
+	reader        HouseholdReader
+	writer        HouseholdWriter
+	inviteReader  HouseholdInviteReader
+	inviteWriter  HouseholdInviteWriter
+	userCtxReader UserCtxReader
+	htmlParser    HtmlParser
+	notifier      AddressNotifier
+	// how long an emailed invite stays valid
+	inviteTtl time.Duration
+}
+
+type HouseholdRequest struct {
+	Name string `json:"name"`
+}
+
+type InviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type MemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// Filled into the invite email template
+type HouseholdInviteVars struct {
+	Inviter   string
+	Household string
+	Role      string
+	Code      string
+	ExpiresAt string
+}
+
+func NewHouseholdSvc(reader HouseholdReader,
+	writer HouseholdWriter,
+	inviteReader HouseholdInviteReader,
+	inviteWriter HouseholdInviteWriter,
+	userCtxReader UserCtxReader,
+	htmlParser HtmlParser,
+	notifier AddressNotifier,
+	inviteTtl time.Duration) HouseholdSvc {
+
+	return HouseholdSvc{
+		reader:        reader,
+		writer:        writer,
+		inviteReader:  inviteReader,
+		inviteWriter:  inviteWriter,
+		userCtxReader: userCtxReader,
+		htmlParser:    htmlParser,
+		notifier:      notifier,
+		inviteTtl:     inviteTtl,
+	}
+}
+
+func roleAtLeast(role string, min string) bool {
+	return roleRank[role] >= roleRank[min] && roleRank[role] > 0
+}
+
+// CreateHousehold makes the user in context the new household's owner
+func (s HouseholdSvc) CreateHousehold(ctx context.Context, req HouseholdRequest) (sqlc.Household, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxHouseholdNameLen {
+		return sqlc.Household{}, fmt.Errorf("Error CreateHousehold (name must be 1-%v characters): \n%w\n", maxHouseholdNameLen, ErrInvalidHousehold)
+	}
+
+	user, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return sqlc.Household{}, fmt.Errorf("Error CreateHousehold -> GetUser: \n%w\n", err)
+	}
+
+	household, err := s.writer.CreateHousehold(ctx, name, user.UserID)
+	if err != nil {
+		return sqlc.Household{}, fmt.Errorf("Error CreateHousehold -> CreateHousehold: \n%w\n", err)
+	}
+	if household.HouseholdID <= 0 {
+		return sqlc.Household{}, fmt.Errorf("Error CreateHousehold: household was not created")
+	}
+	return household, nil
+}
+
+// GetHouseholds returns the households the user in context belongs to,
+// with their role in each
+func (s HouseholdSvc) GetHouseholds(ctx context.Context) ([]sqlc.GetHouseholdsByUserRow, error) {
+	user, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetHouseholds -> GetUser: \n%w\n", err)
+	}
+
+	households, err := s.reader.GetHouseholdsByUser(ctx, user.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetHouseholds -> GetHouseholdsByUser: \n%w\n", err)
+	}
+	return households, nil
+}
+
+func (s HouseholdSvc) DeleteHousehold(ctx context.Context, householdId int32) error {
+	_, err := s.requireRole(ctx, householdId, RoleOwner)
+	if err != nil {
+		return fmt.Errorf("Error DeleteHousehold -> requireRole: \n%w\n", err)
+	}
+
+	// shared devices go back to being private to their owners
+	err = s.writer.DeleteHousehold(ctx, householdId)
+	if err != nil {
+		return fmt.Errorf("Error DeleteHousehold -> DeleteHousehold: \n%w\n", err)
+	}
+	return nil
+}
+
+func (s HouseholdSvc) GetMembers(ctx context.Context, householdId int32) ([]sqlc.GetHouseholdMembersRow, error) {
+	_, err := s.requireRole(ctx, householdId, RoleViewer)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetMembers -> requireRole: \n%w\n", err)
+	}
+
+	members, err := s.reader.GetHouseholdMembers(ctx, householdId)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetMembers -> GetHouseholdMembers: \n%w\n", err)
+	}
+	return members, nil
+}
+
+func (s HouseholdSvc) UpdateMemberRole(ctx context.Context, householdId int32, userId int32, req MemberRoleRequest) error {
+	if roleRank[req.Role] == 0 {
+		return fmt.Errorf("Error UpdateMemberRole (role: '%v'): \n%w\n", req.Role, ErrInvalidHousehold)
+	}
+	_, err := s.requireRole(ctx, householdId, RoleOwner)
+	if err != nil {
+		return fmt.Errorf("Error UpdateMemberRole -> requireRole: \n%w\n", err)
+	}
+
+	member, err := s.reader.GetHouseholdMember(ctx, householdId, userId)
+	if err != nil {
+		return fmt.Errorf("Error UpdateMemberRole -> GetHouseholdMember: \n%w\n", err)
+	}
+	if member.UserID <= 0 {
+		return fmt.Errorf("Error UpdateMemberRole (userId: %v): \n%w\n", userId, ErrNoUser)
+	}
+	if member.Role == RoleOwner && req.Role != RoleOwner {
+		if err = s.keepsAnOwner(ctx, householdId); err != nil {
+			return fmt.Errorf("Error UpdateMemberRole -> keepsAnOwner: \n%w\n", err)
+		}
+	}
+
+	err = s.writer.UpdateHouseholdMemberRole(ctx, householdId, userId, req.Role)
+	if err != nil {
+		return fmt.Errorf("Error UpdateMemberRole -> UpdateHouseholdMemberRole: \n%w\n", err)
+	}
+	return nil
+}
+
+// RemoveMember is for owners removing someone, or any member leaving.
+// Devices the member had shared with the household stop being shared.
+func (s HouseholdSvc) RemoveMember(ctx context.Context, householdId int32, userId int32) error {
+	self, err := s.requireRole(ctx, householdId, RoleViewer)
+	if err != nil {
+		return fmt.Errorf("Error RemoveMember -> requireRole: \n%w\n", err)
+	}
+	if self.UserID != userId && self.Role != RoleOwner {
+		return fmt.Errorf("Error RemoveMember (householdId: %v): \n%w\n", householdId, ErrHouseholdForbidden)
+	}
+
+	member := self
+	if self.UserID != userId {
+		member, err = s.reader.GetHouseholdMember(ctx, householdId, userId)
+		if err != nil {
+			return fmt.Errorf("Error RemoveMember -> GetHouseholdMember: \n%w\n", err)
+		}
+		if member.UserID <= 0 {
+			return fmt.Errorf("Error RemoveMember (userId: %v): \n%w\n", userId, ErrNoUser)
+		}
+	}
+	if member.Role == RoleOwner {
+		if err = s.keepsAnOwner(ctx, householdId); err != nil {
+			return fmt.Errorf("Error RemoveMember -> keepsAnOwner: \n%w\n", err)
+		}
+	}
+
+	err = s.writer.DeleteHouseholdMember(ctx, householdId, userId)
+	if err != nil {
+		return fmt.Errorf("Error RemoveMember -> DeleteHouseholdMember: \n%w\n", err)
+	}
+	return nil
+}
+
+// Invite emails a single use invite code to the address. It works
+// whether or not the address has an account yet; the code can only be
+// redeemed by an account with that email.
+func (s HouseholdSvc) Invite(ctx context.Context, householdId int32, req InviteRequest) (sqlc.HouseholdInvite, error) {
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil || addr.Name != "" {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite (email: '%v'): \n%w\n", req.Email, ErrInvalidHousehold)
+	}
+	if roleRank[req.Role] == 0 {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite (role: '%v'): \n%w\n", req.Role, ErrInvalidHousehold)
+	}
+
+	inviter, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite -> GetUser: \n%w\n", err)
+	}
+	_, err = s.requireRole(ctx, householdId, RoleOwner)
+	if err != nil {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite -> requireRole: \n%w\n", err)
+	}
+	household, err := s.reader.GetHousehold(ctx, householdId)
+	if err != nil {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite -> GetHousehold: \n%w\n", err)
+	}
+
+	invite, err := s.inviteWriter.CreateHouseholdInvite(ctx, sqlc.HouseholdInvite{
+		HouseholdID: householdId,
+		Email:       strings.ToLower(addr.Address),
+		Role:        req.Role,
+		Token:       uuid.NewString(),
+		InvitedBy:   inviter.UserID,
+		ExpiresAt:   pgtype.Timestamptz{Time: time.Now().Add(s.inviteTtl), Valid: true},
+	})
+	if err != nil {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite -> CreateHouseholdInvite: \n%w\n", err)
+	}
+
+	// load and fill html template
+	template, err := s.htmlParser.ReadFile(ctx, assets.HouseholdInviteEmailKey)
+	if err != nil {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite -> ReadFile: \n%w\n", err)
+	}
+	vars := &HouseholdInviteVars{
+		Inviter:   inviter.Name,
+		Household: household.Name,
+		Role:      invite.Role,
+		Code:      invite.Token,
+		ExpiresAt: invite.ExpiresAt.Time.UTC().Format("January 2, 2006 15:04 MST"),
+	}
+	body, err := s.htmlParser.ReplaceVars(ctx, vars, template)
+	if err != nil {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite -> ReplaceVars: \n%w\n", err)
+	}
+
+	err = s.notifier.NotifyAddress(ctx, inviter.UserID, invite.Email, notify.Notification{
+		Kind:    notify.KindAccount,
+		Subject: fmt.Sprintf("%v invited you to %v on Dirtie", inviter.Name, household.Name),
+		Body:    string(body),
+	})
+	if err != nil {
+		return sqlc.HouseholdInvite{}, fmt.Errorf("Error Invite -> NotifyAddress: \n%w\n", err)
+	}
+	return invite, nil
+}
+
+// GetInvites returns the household's pending invites
+func (s HouseholdSvc) GetInvites(ctx context.Context, householdId int32) ([]sqlc.HouseholdInvite, error) {
+	_, err := s.requireRole(ctx, householdId, RoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetInvites -> requireRole: \n%w\n", err)
+	}
+
+	invites, err := s.inviteReader.GetHouseholdInvites(ctx, householdId, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("Error GetInvites -> GetHouseholdInvites: \n%w\n", err)
+	}
+	return invites, nil
+}
+
+func (s HouseholdSvc) RevokeInvite(ctx context.Context, householdId int32, inviteId int64) error {
+	_, err := s.requireRole(ctx, householdId, RoleOwner)
+	if err != nil {
+		return fmt.Errorf("Error RevokeInvite -> requireRole: \n%w\n", err)
+	}
+
+	err = s.inviteWriter.DeleteHouseholdInvite(ctx, householdId, inviteId)
+	if err != nil {
+		return fmt.Errorf("Error RevokeInvite -> DeleteHouseholdInvite: \n%w\n", err)
+	}
+	return nil
+}
+
+// AcceptInvite adds the user in context to the invite's household.
+// Someone who is already a member keeps their current role.
+func (s HouseholdSvc) AcceptInvite(ctx context.Context, token string) (sqlc.HouseholdMember, error) {
+	user, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error AcceptInvite -> GetUser: \n%w\n", err)
+	}
+
+	invite, err := s.inviteReader.GetHouseholdInviteByToken(ctx, token)
+	if err != nil {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error AcceptInvite -> GetHouseholdInviteByToken: \n%w\n", err)
+	}
+	if invite.InviteID <= 0 || !strings.EqualFold(invite.Email, user.Email) {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error AcceptInvite: \n%w\n", ErrInviteInvalid)
+	}
+
+	existing, err := s.reader.GetHouseholdMember(ctx, invite.HouseholdID, user.UserID)
+	if err != nil {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error AcceptInvite -> GetHouseholdMember: \n%w\n", err)
+	}
+	if existing.UserID > 0 {
+		err = s.inviteWriter.DeleteHouseholdInvite(ctx, invite.HouseholdID, invite.InviteID)
+		if err != nil {
+			return sqlc.HouseholdMember{}, fmt.Errorf("Error AcceptInvite -> DeleteHouseholdInvite: \n%w\n", err)
+		}
+		return existing, nil
+	}
+
+	// spends the invite, so a second accept of the same code fails
+	member, err := s.inviteWriter.AcceptHouseholdInvite(ctx, token, user.UserID, time.Now())
+	if err != nil {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error AcceptInvite -> AcceptHouseholdInvite: \n%w\n", err)
+	}
+	if member.UserID <= 0 {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error AcceptInvite: \n%w\n", ErrInviteInvalid)
+	}
+	return member, nil
+}
+
+// requireRole returns the membership of the user in context, if they
+// have at least role. Non-members get ErrHouseholdNotFound so ids of
+// other households can't be probed.
+func (s HouseholdSvc) requireRole(ctx context.Context, householdId int32, role string) (sqlc.HouseholdMember, error) {
+	user, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error requireRole -> GetUser: \n%w\n", err)
+	}
+
+	member, err := s.reader.GetHouseholdMember(ctx, householdId, user.UserID)
+	if err != nil {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error requireRole -> GetHouseholdMember: \n%w\n", err)
+	}
+	if member.UserID <= 0 {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error requireRole (householdId: %v): \n%w\n", householdId, ErrHouseholdNotFound)
+	}
+	if !roleAtLeast(member.Role, role) {
+		return sqlc.HouseholdMember{}, fmt.Errorf("Error requireRole (householdId: %v, role: %v): \n%w\n", householdId, member.Role, ErrHouseholdForbidden)
+	}
+	return member, nil
+}
+
+func (s HouseholdSvc) keepsAnOwner(ctx context.Context, householdId int32) error {
+	owners, err := s.reader.CountHouseholdOwners(ctx, householdId)
+	if err != nil {
+		return fmt.Errorf("Error keepsAnOwner -> CountHouseholdOwners: \n%w\n", err)
+	}
+	if owners <= 1 {
+		return fmt.Errorf("Error keepsAnOwner (householdId: %v): \n%w\n", householdId, ErrLastOwner)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/assets"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
+	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	hhReader       mocks.MockHouseholdReader
+	hhWriter       mocks.MockHouseholdWriter
+	hhInviteReader mocks.MockHouseholdInviteReader
+	hhInviteWriter mocks.MockHouseholdInviteWriter
+	hhUserCtx      mocks.MockUserCtxReader
+	hhHtmlParser   mocks.MockHtmlParser
+	hhNotifier     mocks.MockAddressNotifier
+	householdSvc   HouseholdSvc
+)
+
+func setupHouseholdSvcTests() {
+	hhReader = mocks.MockHouseholdReader{Mock: new(mock.Mock)}
+	hhWriter = mocks.MockHouseholdWriter{Mock: new(mock.Mock)}
+	hhInviteReader = mocks.MockHouseholdInviteReader{Mock: new(mock.Mock)}
+	hhInviteWriter = mocks.MockHouseholdInviteWriter{Mock: new(mock.Mock)}
+	hhUserCtx = mocks.MockUserCtxReader{Mock: new(mock.Mock)}
+	hhHtmlParser = mocks.MockHtmlParser{Mock: new(mock.Mock)}
+	hhNotifier = mocks.MockAddressNotifier{Mock: new(mock.Mock)}
+
+	householdSvc = NewHouseholdSvc(
+		hhReader,
+		hhWriter,
+		hhInviteReader,
+		hhInviteWriter,
+		hhUserCtx,
+		hhHtmlParser,
+		hhNotifier,
+		48*time.Hour,
+	)
+}
+
+func TestCreateHousehold(t *testing.T) {
+	ctx := context.Background()
+	user := sqlc.User{UserID: 1234}
+
+	t.Run("Success", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		hhUserCtx.On("GetUser", ctx).Return(user, nil)
+		hhWriter.On("CreateHousehold", ctx, "Home", user.UserID).Return(sqlc.Household{HouseholdID: 5, Name: "Home"}, nil)
+
+		household, err := householdSvc.CreateHousehold(ctx, HouseholdRequest{Name: "  Home "})
+
+		assert.Nil(t, err)
+		assert.Equal(t, int32(5), household.HouseholdID)
+	})
+
+	t.Run("NoName", func(t *testing.T) {
+		setupHouseholdSvcTests()
+
+		_, err := householdSvc.CreateHousehold(ctx, HouseholdRequest{Name: "  "})
+
+		assert.ErrorIs(t, err, ErrInvalidHousehold)
+		hhWriter.AssertNotCalled(t, "CreateHousehold", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestHouseholdInvite(t *testing.T) {
+	ctx := context.Background()
+	owner := sqlc.User{UserID: 1234, Name: "Sam"}
+	household := sqlc.Household{HouseholdID: 5, Name: "Home"}
+	tmpl := template.New("invite")
+
+	t.Run("Success", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		hhUserCtx.On("GetUser", ctx).Return(owner, nil)
+		hhReader.On("GetHouseholdMember", ctx, household.HouseholdID, owner.UserID).
+			Return(sqlc.HouseholdMember{HouseholdID: 5, UserID: owner.UserID, Role: RoleOwner}, nil)
+		hhReader.On("GetHousehold", ctx, household.HouseholdID).Return(household, nil)
+		hhInviteWriter.On("CreateHouseholdInvite", ctx, mock.MatchedBy(func(i sqlc.HouseholdInvite) bool {
+			return i.Email == "friend@email.com" && i.Role == RoleViewer && i.Token != "" &&
+				i.InvitedBy == owner.UserID && i.ExpiresAt.Time.After(time.Now().Add(47*time.Hour))
+		})).Return(sqlc.HouseholdInvite{InviteID: 3, HouseholdID: 5, Email: "friend@email.com", Role: RoleViewer, Token: "code"}, nil)
+		hhHtmlParser.On("ReadFile", ctx, assets.HouseholdInviteEmailKey).Return(tmpl, nil)
+		hhHtmlParser.On("ReplaceVars", ctx, mock.MatchedBy(func(v *HouseholdInviteVars) bool {
+			return v.Code == "code" && v.Household == household.Name && v.Inviter == owner.Name
+		}), tmpl).Return([]byte("body"), nil)
+		hhNotifier.On("NotifyAddress", ctx, owner.UserID, "friend@email.com", mock.MatchedBy(func(n notify.Notification) bool {
+			return n.Kind == notify.KindAccount && n.Body == "body"
+		})).Return(nil)
+
+		invite, err := householdSvc.Invite(ctx, household.HouseholdID, InviteRequest{Email: "Friend@Email.com", Role: RoleViewer})
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), invite.InviteID)
+		hhNotifier.AssertExpectations(t)
+	})
+
+	t.Run("NotOwner", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		hhUserCtx.On("GetUser", ctx).Return(owner, nil)
+		hhReader.On("GetHouseholdMember", ctx, household.HouseholdID, owner.UserID).
+			Return(sqlc.HouseholdMember{HouseholdID: 5, UserID: owner.UserID, Role: RoleEditor}, nil)
+
+		_, err := householdSvc.Invite(ctx, household.HouseholdID, InviteRequest{Email: "friend@email.com", Role: RoleViewer})
+
+		assert.ErrorIs(t, err, ErrHouseholdForbidden)
+		hhInviteWriter.AssertNotCalled(t, "CreateHouseholdInvite", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		reqs := []InviteRequest{
+			{Email: "not an email", Role: RoleViewer},
+			{Email: "friend@email.com", Role: "admin"},
+		}
+
+		for _, req := range reqs {
+			_, err := householdSvc.Invite(ctx, household.HouseholdID, req)
+			assert.ErrorIs(t, err, ErrInvalidHousehold)
+		}
+		hhInviteWriter.AssertNotCalled(t, "CreateHouseholdInvite", mock.Anything, mock.Anything)
+	})
+}
+
+func TestAcceptHouseholdInvite(t *testing.T) {
+	ctx := context.Background()
+	user := sqlc.User{UserID: 77, Email: "Friend@email.com"}
+	invite := sqlc.HouseholdInvite{InviteID: 3, HouseholdID: 5, Email: "friend@email.com", Role: RoleEditor, Token: "code"}
+
+	t.Run("Success", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		member := sqlc.HouseholdMember{HouseholdID: 5, UserID: user.UserID, Role: RoleEditor}
+		hhUserCtx.On("GetUser", ctx).Return(user, nil)
+		hhInviteReader.On("GetHouseholdInviteByToken", ctx, "code").Return(invite, nil)
+		hhReader.On("GetHouseholdMember", ctx, invite.HouseholdID, user.UserID).Return(sqlc.HouseholdMember{}, nil)
+		hhInviteWriter.On("AcceptHouseholdInvite", ctx, "code", user.UserID, mock.AnythingOfType("time.Time")).Return(member, nil)
+
+		result, err := householdSvc.AcceptInvite(ctx, "code")
+
+		assert.Nil(t, err)
+		assert.Equal(t, member, result)
+	})
+
+	t.Run("OtherEmail", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		hhUserCtx.On("GetUser", ctx).Return(sqlc.User{UserID: 78, Email: "someone@email.com"}, nil)
+		hhInviteReader.On("GetHouseholdInviteByToken", ctx, "code").Return(invite, nil)
+
+		_, err := householdSvc.AcceptInvite(ctx, "code")
+
+		assert.ErrorIs(t, err, ErrInviteInvalid)
+		hhInviteWriter.AssertNotCalled(t, "AcceptHouseholdInvite", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Spent", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		hhUserCtx.On("GetUser", ctx).Return(user, nil)
+		hhInviteReader.On("GetHouseholdInviteByToken", ctx, "code").Return(invite, nil)
+		hhReader.On("GetHouseholdMember", ctx, invite.HouseholdID, user.UserID).Return(sqlc.HouseholdMember{}, nil)
+		// used or expired between the read and the accept
+		hhInviteWriter.On("AcceptHouseholdInvite", ctx, "code", user.UserID, mock.Anything).Return(sqlc.HouseholdMember{}, nil)
+
+		_, err := householdSvc.AcceptInvite(ctx, "code")
+
+		assert.ErrorIs(t, err, ErrInviteInvalid)
+	})
+
+	t.Run("AlreadyMember", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		existing := sqlc.HouseholdMember{HouseholdID: 5, UserID: user.UserID, Role: RoleOwner}
+		hhUserCtx.On("GetUser", ctx).Return(user, nil)
+		hhInviteReader.On("GetHouseholdInviteByToken", ctx, "code").Return(invite, nil)
+		hhReader.On("GetHouseholdMember", ctx, invite.HouseholdID, user.UserID).Return(existing, nil)
+		hhInviteWriter.On("DeleteHouseholdInvite", ctx, invite.HouseholdID, invite.InviteID).Return(nil)
+
+		result, err := householdSvc.AcceptInvite(ctx, "code")
+
+		assert.Nil(t, err)
+		assert.Equal(t, RoleOwner, result.Role)
+		hhInviteWriter.AssertNotCalled(t, "AcceptHouseholdInvite", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestHouseholdMembers(t *testing.T) {
+	ctx := context.Background()
+	owner := sqlc.User{UserID: 1234}
+	ownerMember := sqlc.HouseholdMember{HouseholdID: 5, UserID: owner.UserID, Role: RoleOwner}
+
+	t.Run("DemoteLastOwner", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		hhUserCtx.On("GetUser", ctx).Return(owner, nil)
+		hhReader.On("GetHouseholdMember", ctx, int32(5), owner.UserID).Return(ownerMember, nil)
+		hhReader.On("CountHouseholdOwners", ctx, int32(5)).Return(int64(1), nil)
+
+		err := householdSvc.UpdateMemberRole(ctx, 5, owner.UserID, MemberRoleRequest{Role: RoleEditor})
+
+		assert.ErrorIs(t, err, ErrLastOwner)
+		hhWriter.AssertNotCalled(t, "UpdateHouseholdMemberRole", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("LastOwnerLeaves", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		hhUserCtx.On("GetUser", ctx).Return(owner, nil)
+		hhReader.On("GetHouseholdMember", ctx, int32(5), owner.UserID).Return(ownerMember, nil)
+		hhReader.On("CountHouseholdOwners", ctx, int32(5)).Return(int64(1), nil)
+
+		err := householdSvc.RemoveMember(ctx, 5, owner.UserID)
+
+		assert.ErrorIs(t, err, ErrLastOwner)
+		hhWriter.AssertNotCalled(t, "DeleteHouseholdMember", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("ViewerLeaves", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		viewer := sqlc.User{UserID: 77}
+		hhUserCtx.On("GetUser", ctx).Return(viewer, nil)
+		hhReader.On("GetHouseholdMember", ctx, int32(5), viewer.UserID).
+			Return(sqlc.HouseholdMember{HouseholdID: 5, UserID: viewer.UserID, Role: RoleViewer}, nil)
+		hhWriter.On("DeleteHouseholdMember", ctx, int32(5), viewer.UserID).Return(nil)
+
+		err := householdSvc.RemoveMember(ctx, 5, viewer.UserID)
+
+		assert.Nil(t, err)
+		hhWriter.AssertExpectations(t)
+	})
+
+	t.Run("ViewerRemovesOther", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		viewer := sqlc.User{UserID: 77}
+		hhUserCtx.On("GetUser", ctx).Return(viewer, nil)
+		hhReader.On("GetHouseholdMember", ctx, int32(5), viewer.UserID).
+			Return(sqlc.HouseholdMember{HouseholdID: 5, UserID: viewer.UserID, Role: RoleViewer}, nil)
+
+		err := householdSvc.RemoveMember(ctx, 5, owner.UserID)
+
+		assert.ErrorIs(t, err, ErrHouseholdForbidden)
+		hhWriter.AssertNotCalled(t, "DeleteHouseholdMember", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("NotMember", func(t *testing.T) {
+		setupHouseholdSvcTests()
+		hhUserCtx.On("GetUser", ctx).Return(owner, nil)
+		hhReader.On("GetHouseholdMember", ctx, int32(6), owner.UserID).Return(sqlc.HouseholdMember{}, nil)
+
+		_, err := householdSvc.GetMembers(ctx, 6)
+
+		assert.ErrorIs(t, err, ErrHouseholdNotFound)
+	})
+}
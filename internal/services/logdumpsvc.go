@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
 	"github.com/frozenkro/dirtie-srv/internal/db"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
 )
 
 
@@ -21,17 +24,23 @@ type LogPoster interface {
 	PostLogs(payload db.LokiLogData) error
 }
 
+type HeartbeatRecorder interface {
+	RecordHeartbeat(ctx context.Context, device sqlc.Device, seenAt time.Time) error
+}
+
 type LogDumpSvc struct {
 	dg  DeviceGetter
 	dpc DevicePrvCompleter
 	lp  LogPoster
+	hr  HeartbeatRecorder
 }
 
-func NewLogDumpSvc(dg DeviceGetter, dpc DevicePrvCompleter, lp LogPoster) LogDumpSvc {
+func NewLogDumpSvc(dg DeviceGetter, dpc DevicePrvCompleter, lp LogPoster, hr HeartbeatRecorder) LogDumpSvc {
 	return LogDumpSvc{
 		dg:  dg,
 		dpc: dpc,
 		lp:  lp,
+		hr:  hr,
 	}
 }
 
@@ -53,6 +62,14 @@ func (s LogDumpSvc) DumpLogs(ctx context.Context, payload LogDumpPayload) error
 			// No device or provision staging record found for this contract / mac address
 			return fmt.Errorf("Error in LogDumpSvc.DumpLogs (macAddr: %v): \n%w\n", payload.MacAddr, ErrNoDevice)
 		}
+		dvc = ps
+	}
+
+	// A log dump is as good a sign of life as a breadcrumb
+	if s.hr != nil {
+		if err := s.hr.RecordHeartbeat(ctx, dvc, time.Now()); err != nil {
+			utils.LogErr(fmt.Sprintf("Error in LogDumpSvc.DumpLogs -> RecordHeartbeat (deviceId: %v): %v\n", dvc.DeviceID, err))
+		}
 	}
 
 	devIdStr := strconv.Itoa(int(dvc.DeviceID))
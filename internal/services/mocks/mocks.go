@@ -8,6 +8,7 @@ import (
 
 	"github.com/frozenkro/dirtie-srv/internal/db"
 	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -38,7 +39,7 @@ type MockHtmlParser struct {
 	*mock.Mock
 }
 
-type MockEmailSender struct {
+type MockNotifier struct {
 	*mock.Mock
 }
 
@@ -46,6 +47,10 @@ type MockDeviceDataRetriever struct {
 	*mock.Mock
 }
 
+type MockDeviceDataQuerier struct {
+	*mock.Mock
+}
+
 type MockDeviceDataRecorder struct {
 	*mock.Mock
 }
@@ -71,6 +76,95 @@ type MockUserCtxReader struct {
 	*mock.Mock
 }
 
+type MockCommandReader struct {
+	*mock.Mock
+}
+type MockCommandWriter struct {
+	*mock.Mock
+}
+type MockMessagePublisher struct {
+	*mock.Mock
+}
+type MockUserDeviceGetter struct {
+	*mock.Mock
+}
+
+type MockDeadLetterReader struct {
+	*mock.Mock
+}
+type MockDeadLetterWriter struct {
+	*mock.Mock
+}
+type MockTopicDispatcher struct {
+	*mock.Mock
+}
+type MockAlertRuleReader struct {
+	*mock.Mock
+}
+type MockAlertRuleWriter struct {
+	*mock.Mock
+}
+type MockAlertEvaluator struct {
+	*mock.Mock
+}
+type MockNotificationPrefReader struct {
+	*mock.Mock
+}
+type MockNotificationPrefWriter struct {
+	*mock.Mock
+}
+type MockNotificationLogReader struct {
+	*mock.Mock
+}
+type MockNotificationLogWriter struct {
+	*mock.Mock
+}
+type MockDeviceStatusReader struct {
+	*mock.Mock
+}
+type MockDeviceStatusWriter struct {
+	*mock.Mock
+}
+type MockDeviceStatusRecorder struct {
+	*mock.Mock
+}
+type MockReportIntervalWriter struct {
+	*mock.Mock
+}
+type MockConnectionEventReader struct {
+	*mock.Mock
+}
+type MockConnectionEventWriter struct {
+	*mock.Mock
+}
+type MockPresenceStatusRecorder struct {
+	*mock.Mock
+}
+type MockCalibrationReader struct {
+	*mock.Mock
+}
+type MockCalibrationWriter struct {
+	*mock.Mock
+}
+type MockHouseholdReader struct {
+	*mock.Mock
+}
+type MockHouseholdWriter struct {
+	*mock.Mock
+}
+type MockHouseholdInviteReader struct {
+	*mock.Mock
+}
+type MockHouseholdInviteWriter struct {
+	*mock.Mock
+}
+type MockAddressNotifier struct {
+	*mock.Mock
+}
+type MockDeviceDataDeleter struct {
+	*mock.Mock
+}
+
 // Implement UserRepo interface methods for MockUserRepo
 func (m MockUserReader) GetUserFromEmail(ctx context.Context, email string) (sqlc.User, error) {
 	args := m.Called(ctx, email)
@@ -150,8 +244,8 @@ func (m MockHtmlParser) ReplaceAndWrite(ctx context.Context, data any, tmp *temp
 	return args.Error(0)
 }
 
-func (m MockEmailSender) SendEmail(ctx context.Context, emailAddress string, subject string, body string) error {
-	args := m.Called(ctx, emailAddress, subject, body)
+func (m MockNotifier) Notify(ctx context.Context, userId int32, n notify.Notification) error {
+	args := m.Called(ctx, userId, n)
 	return args.Error(0)
 }
 
@@ -170,12 +264,21 @@ func (m MockDeviceDataRetriever) GetValuesRange(
 	return args.Get(0).([]db.DeviceDataPoint), args.Error(1)
 }
 
-func (m MockDeviceDataRecorder) Record(
+func (m MockDeviceDataQuerier) QueryRange(
 	ctx context.Context,
 	deviceId int,
 	measurementKey string,
-	value int64) error {
-	args := m.Called(ctx, deviceId, measurementKey, value)
+	q db.RangeQuery) ([]db.DeviceDataPoint, error) {
+	args := m.Called(ctx, deviceId, measurementKey, q)
+	return args.Get(0).([]db.DeviceDataPoint), args.Error(1)
+}
+
+func (m MockDeviceDataRecorder) RecordPoint(
+	ctx context.Context,
+	deviceId int,
+	fields map[string]any,
+	ts time.Time) error {
+	args := m.Called(ctx, deviceId, fields, ts)
 	return args.Error(0)
 }
 
@@ -184,12 +287,17 @@ func (m MockDeviceGetter) GetDeviceByMacAddress(ctx context.Context, macAddr str
 	return args.Get(0).(sqlc.Device), args.Error(1)
 }
 
+func (m MockDeviceReader) GetDevice(ctx context.Context, deviceId int32) (sqlc.Device, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).(sqlc.Device), args.Error(1)
+}
+
 func (m MockDeviceReader) GetDeviceByMacAddress(ctx context.Context, macAddr string) (sqlc.Device, error) {
 	args := m.Called(ctx, macAddr)
 	return args.Get(0).(sqlc.Device), args.Error(1)
 }
 
-func (m MockDeviceReader) GetDevicesByUser(ctx context.Context, userId int32) ([]sqlc.Device, error) {
+func (m MockDeviceReader) GetAccessibleDevices(ctx context.Context, userId int32) ([]sqlc.Device, error) {
 	args := m.Called(ctx, userId)
 	return args.Get(0).([]sqlc.Device), args.Error(1)
 }
@@ -199,8 +307,8 @@ func (m MockDeviceWriter) CreateDevice(ctx context.Context, userId int32, displa
 	return args.Get(0).(sqlc.Device), args.Error(1)
 }
 
-func (m MockDeviceWriter) RenameDevice(ctx context.Context, deviceId int32, displayName string) error {
-	args := m.Called(ctx, deviceId, displayName)
+func (m MockDeviceWriter) UpdateDeviceDetails(ctx context.Context, device sqlc.Device) error {
+	args := m.Called(ctx, device)
 	return args.Error(0)
 }
 
@@ -209,11 +317,31 @@ func (m MockDeviceWriter) UpdateDeviceMacAddress(ctx context.Context, deviceId i
 	return args.Error(0)
 }
 
-func (m MockPrvStgWriter) CreateProvisionStaging(ctx context.Context, deviceId int32, contract string) error {
-	args := m.Called(ctx, deviceId, contract)
+func (m MockDeviceWriter) ResetDeviceMacAddress(ctx context.Context, deviceId int32) error {
+	args := m.Called(ctx, deviceId)
+	return args.Error(0)
+}
+
+func (m MockDeviceWriter) DeleteDevice(ctx context.Context, deviceId int32) error {
+	args := m.Called(ctx, deviceId)
 	return args.Error(0)
 }
 
+func (m MockDeviceWriter) SetDeviceHousehold(ctx context.Context, deviceId int32, householdId *int32) error {
+	args := m.Called(ctx, deviceId, householdId)
+	return args.Error(0)
+}
+
+func (m MockPrvStgWriter) CreateProvisionStaging(ctx context.Context, deviceId int32, contract string, expiresAt time.Time) (sqlc.ProvisionStaging, error) {
+	args := m.Called(ctx, deviceId, contract, expiresAt)
+	return args.Get(0).(sqlc.ProvisionStaging), args.Error(1)
+}
+
+func (m MockPrvStgWriter) ConsumeProvisionStaging(ctx context.Context, contract string, now time.Time) (sqlc.ProvisionStaging, error) {
+	args := m.Called(ctx, contract, now)
+	return args.Get(0).(sqlc.ProvisionStaging), args.Error(1)
+}
+
 func (m MockPrvStgWriter) DeleteProvisionStaging(ctx context.Context, deviceId int32) error {
 	args := m.Called(ctx, deviceId)
 	return args.Error(0)
@@ -224,7 +352,358 @@ func (m MockPrvStgReader) GetProvisionStagingByContract(ctx context.Context, con
 	return args.Get(0).(sqlc.ProvisionStaging), args.Error(1)
 }
 
+func (m MockPrvStgReader) GetProvisionStagingByDevice(ctx context.Context, deviceId int32) (sqlc.ProvisionStaging, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).(sqlc.ProvisionStaging), args.Error(1)
+}
+
 func (m MockUserCtxReader) GetUser(ctx context.Context) (sqlc.User, error) {
 	args := m.Called(ctx)
 	return args.Get(0).(sqlc.User), args.Error(1)
 }
+
+func (m MockCommandReader) GetDeviceCommand(ctx context.Context, commandId string) (sqlc.DeviceCommand, error) {
+	args := m.Called(ctx, commandId)
+	return args.Get(0).(sqlc.DeviceCommand), args.Error(1)
+}
+
+func (m MockCommandReader) GetDeviceCommands(ctx context.Context, deviceId int32) ([]sqlc.DeviceCommand, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).([]sqlc.DeviceCommand), args.Error(1)
+}
+
+func (m MockCommandWriter) CreateDeviceCommand(ctx context.Context,
+	commandId string,
+	deviceId int32,
+	commandType string,
+	cmdArgs []byte,
+	status string,
+	expiresAt time.Time) (sqlc.DeviceCommand, error) {
+	args := m.Called(ctx, commandId, deviceId, commandType, cmdArgs, status, expiresAt)
+	return args.Get(0).(sqlc.DeviceCommand), args.Error(1)
+}
+
+func (m MockCommandWriter) UpdateDeviceCommandStatus(ctx context.Context, commandId string, status string, errMsg string) error {
+	args := m.Called(ctx, commandId, status, errMsg)
+	return args.Error(0)
+}
+
+func (m MockCommandWriter) AckDeviceCommand(ctx context.Context, commandId string, status string, errMsg string) error {
+	args := m.Called(ctx, commandId, status, errMsg)
+	return args.Error(0)
+}
+
+func (m MockCommandWriter) ExpireDeviceCommands(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m MockMessagePublisher) Publish(ctx context.Context, topic string, qos byte, payload []byte) error {
+	args := m.Called(ctx, topic, qos, payload)
+	return args.Error(0)
+}
+
+func (m MockUserDeviceGetter) GetUserDevice(ctx context.Context, deviceId int32) (sqlc.Device, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).(sqlc.Device), args.Error(1)
+}
+
+func (m MockUserDeviceGetter) GetEditableDevice(ctx context.Context, deviceId int32) (sqlc.Device, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).(sqlc.Device), args.Error(1)
+}
+
+func (m MockDeadLetterReader) GetDeadLetter(ctx context.Context, deadLetterId int64) (sqlc.DeadLetter, error) {
+	args := m.Called(ctx, deadLetterId)
+	return args.Get(0).(sqlc.DeadLetter), args.Error(1)
+}
+
+func (m MockDeadLetterReader) GetDeadLetters(ctx context.Context, limit int32, offset int32) ([]sqlc.DeadLetter, error) {
+	args := m.Called(ctx, limit, offset)
+	return args.Get(0).([]sqlc.DeadLetter), args.Error(1)
+}
+
+func (m MockDeadLetterWriter) CreateDeadLetter(ctx context.Context, topic string, payload []byte, errMsg string) (sqlc.DeadLetter, error) {
+	args := m.Called(ctx, topic, payload, errMsg)
+	return args.Get(0).(sqlc.DeadLetter), args.Error(1)
+}
+
+func (m MockDeadLetterWriter) UpdateDeadLetterAttempt(ctx context.Context, deadLetterId int64, errMsg string) error {
+	args := m.Called(ctx, deadLetterId, errMsg)
+	return args.Error(0)
+}
+
+func (m MockDeadLetterWriter) DeleteDeadLetter(ctx context.Context, deadLetterId int64) error {
+	args := m.Called(ctx, deadLetterId)
+	return args.Error(0)
+}
+
+func (m MockDeadLetterWriter) DeleteDeadLettersBefore(ctx context.Context, before time.Time) (int64, error) {
+	args := m.Called(ctx, before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m MockTopicDispatcher) Dispatch(ctx context.Context, topic string, payload []byte) error {
+	args := m.Called(ctx, topic, payload)
+	return args.Error(0)
+}
+
+func (m MockAlertRuleReader) GetAlertRule(ctx context.Context, ruleId int32) (sqlc.AlertRule, error) {
+	args := m.Called(ctx, ruleId)
+	return args.Get(0).(sqlc.AlertRule), args.Error(1)
+}
+
+func (m MockAlertRuleReader) GetAlertRulesByDevice(ctx context.Context, deviceId int32) ([]sqlc.AlertRule, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).([]sqlc.AlertRule), args.Error(1)
+}
+
+func (m MockAlertRuleReader) GetEnabledAlertRules(ctx context.Context, deviceId int32) ([]sqlc.AlertRule, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).([]sqlc.AlertRule), args.Error(1)
+}
+
+func (m MockAlertRuleReader) GetAlertEventsByDevice(ctx context.Context, deviceId int32, limit int32) ([]sqlc.AlertEvent, error) {
+	args := m.Called(ctx, deviceId, limit)
+	return args.Get(0).([]sqlc.AlertEvent), args.Error(1)
+}
+
+func (m MockAlertRuleWriter) CreateAlertRule(ctx context.Context, rule sqlc.AlertRule) (sqlc.AlertRule, error) {
+	args := m.Called(ctx, rule)
+	return args.Get(0).(sqlc.AlertRule), args.Error(1)
+}
+
+func (m MockAlertRuleWriter) UpdateAlertRule(ctx context.Context, rule sqlc.AlertRule) (sqlc.AlertRule, error) {
+	args := m.Called(ctx, rule)
+	return args.Get(0).(sqlc.AlertRule), args.Error(1)
+}
+
+func (m MockAlertRuleWriter) UpdateAlertRuleState(ctx context.Context, ruleId int32, state string, pendingSince time.Time, evaluatedAt time.Time) (bool, error) {
+	args := m.Called(ctx, ruleId, state, pendingSince, evaluatedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m MockAlertRuleWriter) SnoozeAlertRule(ctx context.Context, ruleId int32, until time.Time) error {
+	args := m.Called(ctx, ruleId, until)
+	return args.Error(0)
+}
+
+func (m MockAlertRuleWriter) DeleteAlertRule(ctx context.Context, ruleId int32) error {
+	args := m.Called(ctx, ruleId)
+	return args.Error(0)
+}
+
+func (m MockAlertRuleWriter) CreateAlertEvent(ctx context.Context, ruleId int32, deviceId int32, kind string, value float64, snoozed bool, occurredAt time.Time) (sqlc.AlertEvent, error) {
+	args := m.Called(ctx, ruleId, deviceId, kind, value, snoozed, occurredAt)
+	return args.Get(0).(sqlc.AlertEvent), args.Error(1)
+}
+
+func (m MockAlertEvaluator) EvaluateAlerts(ctx context.Context, device sqlc.Device, readings map[string]float64, ts time.Time) ([]sqlc.AlertEvent, error) {
+	args := m.Called(ctx, device, readings, ts)
+	return args.Get(0).([]sqlc.AlertEvent), args.Error(1)
+}
+
+func (m MockNotificationPrefReader) GetNotificationPrefs(ctx context.Context, userId int32) ([]sqlc.NotificationPref, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).([]sqlc.NotificationPref), args.Error(1)
+}
+
+func (m MockNotificationPrefWriter) UpsertNotificationPref(ctx context.Context, pref sqlc.NotificationPref) (sqlc.NotificationPref, error) {
+	args := m.Called(ctx, pref)
+	return args.Get(0).(sqlc.NotificationPref), args.Error(1)
+}
+
+func (m MockNotificationLogReader) CountSentNotifications(ctx context.Context, userId int32, channel string, since time.Time) (int64, error) {
+	args := m.Called(ctx, userId, channel, since)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m MockNotificationLogReader) CountSentNotificationsByKey(ctx context.Context, userId int32, channel string, dedupeKey string, since time.Time) (int64, error) {
+	args := m.Called(ctx, userId, channel, dedupeKey, since)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m MockNotificationLogReader) GetNotificationLog(ctx context.Context, userId int32, limit int32) ([]sqlc.NotificationLog, error) {
+	args := m.Called(ctx, userId, limit)
+	return args.Get(0).([]sqlc.NotificationLog), args.Error(1)
+}
+
+func (m MockNotificationLogWriter) CreateNotificationLog(ctx context.Context, entry sqlc.NotificationLog) (sqlc.NotificationLog, error) {
+	args := m.Called(ctx, entry)
+	return args.Get(0).(sqlc.NotificationLog), args.Error(1)
+}
+
+func (m MockDeviceStatusReader) GetOverdueDevices(ctx context.Context, now time.Time, upSince time.Time, defaultIntervalSec int32, missedIntervals int32) ([]sqlc.Device, error) {
+	args := m.Called(ctx, now, upSince, defaultIntervalSec, missedIntervals)
+	return args.Get(0).([]sqlc.Device), args.Error(1)
+}
+
+func (m MockDeviceStatusWriter) MarkDeviceOnline(ctx context.Context, deviceId int32, seenAt time.Time) (bool, error) {
+	args := m.Called(ctx, deviceId, seenAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m MockDeviceStatusWriter) UpdateDeviceLastSeen(ctx context.Context, deviceId int32, seenAt time.Time) error {
+	args := m.Called(ctx, deviceId, seenAt)
+	return args.Error(0)
+}
+
+func (m MockDeviceStatusWriter) MarkDeviceOffline(ctx context.Context, deviceId int32, lastSeen time.Time) (bool, error) {
+	args := m.Called(ctx, deviceId, lastSeen)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m MockDeviceStatusWriter) UpdateDeviceLatestReading(ctx context.Context, deviceId int32, readings []byte, ts time.Time) error {
+	args := m.Called(ctx, deviceId, readings, ts)
+	return args.Error(0)
+}
+
+func (m MockDeviceStatusRecorder) RecordHeartbeat(ctx context.Context, device sqlc.Device, seenAt time.Time) error {
+	args := m.Called(ctx, device, seenAt)
+	return args.Error(0)
+}
+
+func (m MockDeviceStatusRecorder) RecordLatestReading(ctx context.Context, deviceId int32, readings map[string]float64, ts time.Time) error {
+	args := m.Called(ctx, deviceId, readings, ts)
+	return args.Error(0)
+}
+
+func (m MockReportIntervalWriter) UpdateDeviceReportInterval(ctx context.Context, deviceId int32, intervalSec int32) error {
+	args := m.Called(ctx, deviceId, intervalSec)
+	return args.Error(0)
+}
+
+func (m MockConnectionEventReader) GetLatestDeviceConnectionEvent(ctx context.Context, deviceId int32) (sqlc.DeviceConnectionEvent, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).(sqlc.DeviceConnectionEvent), args.Error(1)
+}
+
+func (m MockConnectionEventReader) GetDeviceConnectionEvents(ctx context.Context, deviceId int32, limit int32) ([]sqlc.DeviceConnectionEvent, error) {
+	args := m.Called(ctx, deviceId, limit)
+	return args.Get(0).([]sqlc.DeviceConnectionEvent), args.Error(1)
+}
+
+func (m MockConnectionEventWriter) CreateDeviceConnectionEvent(ctx context.Context, deviceId int32, event string, occurredAt time.Time) (sqlc.DeviceConnectionEvent, error) {
+	args := m.Called(ctx, deviceId, event, occurredAt)
+	return args.Get(0).(sqlc.DeviceConnectionEvent), args.Error(1)
+}
+
+func (m MockPresenceStatusRecorder) RecordHeartbeat(ctx context.Context, device sqlc.Device, seenAt time.Time) error {
+	args := m.Called(ctx, device, seenAt)
+	return args.Error(0)
+}
+
+func (m MockPresenceStatusRecorder) MarkOffline(ctx context.Context, device sqlc.Device) error {
+	args := m.Called(ctx, device)
+	return args.Error(0)
+}
+
+func (m MockCalibrationReader) GetCalibrationProfile(ctx context.Context, deviceId int32) (sqlc.CalibrationProfile, error) {
+	args := m.Called(ctx, deviceId)
+	return args.Get(0).(sqlc.CalibrationProfile), args.Error(1)
+}
+
+func (m MockCalibrationWriter) UpsertCalibrationProfile(ctx context.Context, profile sqlc.CalibrationProfile) (sqlc.CalibrationProfile, error) {
+	args := m.Called(ctx, profile)
+	return args.Get(0).(sqlc.CalibrationProfile), args.Error(1)
+}
+
+func (m MockCalibrationWriter) DeleteCalibrationProfile(ctx context.Context, deviceId int32) error {
+	args := m.Called(ctx, deviceId)
+	return args.Error(0)
+}
+
+func (m MockHouseholdReader) GetHousehold(ctx context.Context, householdId int32) (sqlc.Household, error) {
+	args := m.Called(ctx, householdId)
+	return args.Get(0).(sqlc.Household), args.Error(1)
+}
+
+func (m MockHouseholdReader) GetHouseholdsByUser(ctx context.Context, userId int32) ([]sqlc.GetHouseholdsByUserRow, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).([]sqlc.GetHouseholdsByUserRow), args.Error(1)
+}
+
+func (m MockHouseholdReader) GetHouseholdMember(ctx context.Context, householdId int32, userId int32) (sqlc.HouseholdMember, error) {
+	args := m.Called(ctx, householdId, userId)
+	return args.Get(0).(sqlc.HouseholdMember), args.Error(1)
+}
+
+func (m MockHouseholdReader) GetHouseholdMembers(ctx context.Context, householdId int32) ([]sqlc.GetHouseholdMembersRow, error) {
+	args := m.Called(ctx, householdId)
+	return args.Get(0).([]sqlc.GetHouseholdMembersRow), args.Error(1)
+}
+
+func (m MockHouseholdReader) CountHouseholdOwners(ctx context.Context, householdId int32) (int64, error) {
+	args := m.Called(ctx, householdId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m MockHouseholdWriter) CreateHousehold(ctx context.Context, name string, ownerId int32) (sqlc.Household, error) {
+	args := m.Called(ctx, name, ownerId)
+	return args.Get(0).(sqlc.Household), args.Error(1)
+}
+
+func (m MockHouseholdWriter) DeleteHousehold(ctx context.Context, householdId int32) error {
+	args := m.Called(ctx, householdId)
+	return args.Error(0)
+}
+
+func (m MockHouseholdWriter) UpdateHouseholdMemberRole(ctx context.Context, householdId int32, userId int32, role string) error {
+	args := m.Called(ctx, householdId, userId, role)
+	return args.Error(0)
+}
+
+func (m MockHouseholdWriter) DeleteHouseholdMember(ctx context.Context, householdId int32, userId int32) error {
+	args := m.Called(ctx, householdId, userId)
+	return args.Error(0)
+}
+
+func (m MockHouseholdInviteReader) GetHouseholdInviteByToken(ctx context.Context, token string) (sqlc.HouseholdInvite, error) {
+	args := m.Called(ctx, token)
+	return args.Get(0).(sqlc.HouseholdInvite), args.Error(1)
+}
+
+func (m MockHouseholdInviteReader) GetHouseholdInvites(ctx context.Context, householdId int32, now time.Time) ([]sqlc.HouseholdInvite, error) {
+	args := m.Called(ctx, householdId, now)
+	return args.Get(0).([]sqlc.HouseholdInvite), args.Error(1)
+}
+
+func (m MockHouseholdInviteWriter) CreateHouseholdInvite(ctx context.Context, invite sqlc.HouseholdInvite) (sqlc.HouseholdInvite, error) {
+	args := m.Called(ctx, invite)
+	return args.Get(0).(sqlc.HouseholdInvite), args.Error(1)
+}
+
+func (m MockHouseholdInviteWriter) AcceptHouseholdInvite(ctx context.Context, token string, userId int32, now time.Time) (sqlc.HouseholdMember, error) {
+	args := m.Called(ctx, token, userId, now)
+	return args.Get(0).(sqlc.HouseholdMember), args.Error(1)
+}
+
+func (m MockHouseholdInviteWriter) DeleteHouseholdInvite(ctx context.Context, householdId int32, inviteId int64) error {
+	args := m.Called(ctx, householdId, inviteId)
+	return args.Error(0)
+}
+
+func (m MockAddressNotifier) NotifyAddress(ctx context.Context, fromUserId int32, email string, n notify.Notification) error {
+	args := m.Called(ctx, fromUserId, email, n)
+	return args.Error(0)
+}
+
+func (m MockDeviceDataDeleter) DeleteDeviceData(ctx context.Context, deviceId int) error {
+	args := m.Called(ctx, deviceId)
+	return args.Error(0)
+}
+
+func (m MockUserWriter) DisableUser(ctx context.Context, userID int32) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m MockUserWriter) EnableUser(ctx context.Context, userID int32) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m MockDeviceWriter) ForceDeviceMacAddress(ctx context.Context, deviceId int32, macAddr string) error {
+	args := m.Called(ctx, deviceId, macAddr)
+	return args.Error(0)
+}
@@ -0,0 +1,399 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
+)
+
+const (
+	NotificationSent        = "sent"
+	NotificationFailed      = "failed"
+	NotificationDeduped     = "deduped"
+	NotificationRateLimited = "rate_limited"
+)
+
+var (
+	defaultNotificationLogLimit int32 = 50
+	maxNotificationLogLimit     int32 = 500
+)
+
+var (
+	ErrInvalidNotificationPref = fmt.Errorf("Invalid notification preference")
+	ErrNotificationFailed      = fmt.Errorf("Notification delivery failed")
+	ErrNotificationLimited     = fmt.Errorf("Notification held back by the rate limit")
+)
+
+type NotificationPrefReader interface {
+	GetNotificationPrefs(ctx context.Context, userId int32) ([]sqlc.NotificationPref, error)
+}
+type NotificationPrefWriter interface {
+	UpsertNotificationPref(ctx context.Context, pref sqlc.NotificationPref) (sqlc.NotificationPref, error)
+}
+
+type NotificationLogReader interface {
+	CountSentNotifications(ctx context.Context, userId int32, channel string, since time.Time) (int64, error)
+	CountSentNotificationsByKey(ctx context.Context, userId int32, channel string, dedupeKey string, since time.Time) (int64, error)
+	GetNotificationLog(ctx context.Context, userId int32, limit int32) ([]sqlc.NotificationLog, error)
+}
+type NotificationLogWriter interface {
+	CreateNotificationLog(ctx context.Context, entry sqlc.NotificationLog) (sqlc.NotificationLog, error)
+}
+
+type NotificationChannel interface {
+	Name() string
+	Send(ctx context.Context, to notify.Recipient, n notify.Notification) error
+}
+
+// NotificationTargetValidator is implemented by channels that check a
+// user's target before it is saved
+type NotificationTargetValidator interface {
+	ValidateTarget(ctx context.Context, target string) error
+}
+
+type NotificationObserver interface {
+	ObserveNotification(channel string, status string)
+}
+
+// Notifier is what other services use to reach a user
+type Notifier interface {
+	Notify(ctx context.Context, userId int32, n notify.Notification) error
+}
+
+// NotificationPolicy limits how often a user is contacted. Both limits
+// are per channel, and are counted from the delivery log so they hold
+// across restarts and replicas.
+type NotificationPolicy struct {
+	// notifications with the same DedupeKey are sent once per window
+	DedupeWindow time.Duration
+	// at most RateLimit notifications per RateWindow, 0 for no limit
+	RateLimit  int
+	RateWindow time.Duration
+}
+
+type NotificationSvc struct {
+	prefReader    NotificationPrefReader
+	prefWriter    NotificationPrefWriter
+	logReader     NotificationLogReader
+	logWriter     NotificationLogWriter
+	userReader    UserReader
+	userCtxReader UserCtxReader
+	policy        NotificationPolicy
+	observer      NotificationObserver
+	channels      map[string]NotificationChannel
+	// channel names in delivery order
+	order []string
+}
+
+// Sent by the user via rest api. Everything but Target defaults to true.
+type NotificationPrefRequest struct {
+	Enabled *bool `json:"enabled,omitempty"`
+	// email address or webhook url; ignored for push
+	Target       string `json:"target"`
+	Alerts       *bool  `json:"alerts,omitempty"`
+	DeviceEvents *bool  `json:"deviceEvents,omitempty"`
+}
+
+func NewNotificationSvc(prefReader NotificationPrefReader,
+	prefWriter NotificationPrefWriter,
+	logReader NotificationLogReader,
+	logWriter NotificationLogWriter,
+	userReader UserReader,
+	userCtxReader UserCtxReader,
+	policy NotificationPolicy,
+	observer NotificationObserver,
+	channels ...NotificationChannel) NotificationSvc {
+
+	s := NotificationSvc{
+		prefReader:    prefReader,
+		prefWriter:    prefWriter,
+		logReader:     logReader,
+		logWriter:     logWriter,
+		userReader:    userReader,
+		userCtxReader: userCtxReader,
+		policy:        policy,
+		observer:      observer,
+		channels:      make(map[string]NotificationChannel),
+	}
+	for _, c := range channels {
+		s.channels[c.Name()] = c
+		s.order = append(s.order, c.Name())
+	}
+	sort.Strings(s.order)
+	return s
+}
+
+// Notify delivers n to the user on every channel their preferences
+// allow. Channels are independent: one failing doesn't stop the rest,
+// but any failure is returned wrapped in ErrNotificationFailed.
+func (s NotificationSvc) Notify(ctx context.Context, userId int32, n notify.Notification) error {
+	user, err := s.userReader.GetUser(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("Error Notify -> GetUser: \n%w\n", err)
+	}
+	if user.UserID <= 0 {
+		return fmt.Errorf("Error Notify (userId: %v): \n%w\n", userId, ErrNoUser)
+	}
+
+	prefs, err := s.prefsByChannel(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("Error Notify -> prefsByChannel: \n%w\n", err)
+	}
+
+	var errs []error
+	for _, name := range s.order {
+		pref := prefs[name]
+		if !wantsNotification(pref, n.Kind) {
+			continue
+		}
+
+		to := notify.Recipient{UserId: userId, Email: user.Email, Target: pref.Target}
+		// account mail is asked for by the user, so a burst of alerts
+		// mustn't hold back their password reset
+		if err := s.deliver(ctx, s.channels[name], to, n, n.Kind != notify.KindAccount); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Error Notify (userId: %v, kind: %v): \n%w\n%w\n", userId, n.Kind, ErrNotificationFailed, errors.Join(errs...))
+	}
+	return nil
+}
+
+// NotifyAddress emails someone who may not have an account, such as a
+// household invitee. It is logged and rate limited against the user
+// it was sent on behalf of, so one user can't flood an inbox. Held
+// back mail is returned as ErrNotificationLimited rather than dropped.
+func (s NotificationSvc) NotifyAddress(ctx context.Context, fromUserId int32, email string, n notify.Notification) error {
+	ch, ok := s.channels[notify.ChannelEmail]
+	if !ok {
+		return fmt.Errorf("Error NotifyAddress (no email channel): \n%w\n", ErrNotificationFailed)
+	}
+
+	to := notify.Recipient{UserId: fromUserId, Email: email}
+	if err := s.deliver(ctx, ch, to, n, true); err != nil {
+		return fmt.Errorf("Error NotifyAddress (fromUserId: %v): \n%w\n%w\n", fromUserId, ErrNotificationFailed, err)
+	}
+	return nil
+}
+
+// deliver sends on one channel unless limited is set and the dedupe
+// window or rate limit says otherwise, and records the outcome in the
+// delivery log. Account mail that is held back is returned as
+// ErrNotificationLimited, since the caller is waiting on it.
+func (s NotificationSvc) deliver(ctx context.Context, ch NotificationChannel, to notify.Recipient, n notify.Notification, limited bool) error {
+	status := NotificationSent
+	if limited {
+		var err error
+		status, err = s.checkLimits(ctx, ch.Name(), to.UserId, n)
+		if err != nil {
+			return fmt.Errorf("Error deliver -> checkLimits (%v): \n%w\n", ch.Name(), err)
+		}
+	}
+
+	var sendErr error
+	if status == NotificationSent {
+		sendErr = ch.Send(ctx, to, n)
+		if sendErr != nil {
+			status = NotificationFailed
+			sendErr = fmt.Errorf("Error deliver -> Send (%v): \n%w\n", ch.Name(), sendErr)
+		}
+	} else if n.Kind == notify.KindAccount {
+		sendErr = fmt.Errorf("Error deliver (%v, %v): \n%w\n", ch.Name(), status, ErrNotificationLimited)
+	}
+
+	entry := sqlc.NotificationLog{
+		UserID:    to.UserId,
+		Channel:   ch.Name(),
+		Kind:      string(n.Kind),
+		DedupeKey: n.DedupeKey,
+		Subject:   n.Subject,
+		Status:    status,
+	}
+	if sendErr != nil {
+		entry.Error = sendErr.Error()
+	}
+	if _, err := s.logWriter.CreateNotificationLog(ctx, entry); err != nil {
+		utils.LogErr(fmt.Sprintf("Error deliver -> CreateNotificationLog (%v, userId: %v): %v\n", ch.Name(), to.UserId, err))
+	}
+	if s.observer != nil {
+		s.observer.ObserveNotification(ch.Name(), status)
+	}
+	return sendErr
+}
+
+func (s NotificationSvc) checkLimits(ctx context.Context, channel string, userId int32, n notify.Notification) (string, error) {
+	now := time.Now()
+
+	if n.DedupeKey != "" && s.policy.DedupeWindow > 0 {
+		sent, err := s.logReader.CountSentNotificationsByKey(ctx, userId, channel, n.DedupeKey, now.Add(-s.policy.DedupeWindow))
+		if err != nil {
+			return "", err
+		}
+		if sent > 0 {
+			return NotificationDeduped, nil
+		}
+	}
+
+	if s.policy.RateLimit > 0 {
+		sent, err := s.logReader.CountSentNotifications(ctx, userId, channel, now.Add(-s.policy.RateWindow))
+		if err != nil {
+			return "", err
+		}
+		if sent >= int64(s.policy.RateLimit) {
+			return NotificationRateLimited, nil
+		}
+	}
+	return NotificationSent, nil
+}
+
+// GetPrefs returns the current user's preferences for every channel,
+// with defaults filled in for channels they haven't configured
+func (s NotificationSvc) GetPrefs(ctx context.Context) ([]sqlc.NotificationPref, error) {
+	user, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetPrefs -> GetUser: \n%w\n", err)
+	}
+
+	prefs, err := s.prefsByChannel(ctx, user.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetPrefs -> prefsByChannel: \n%w\n", err)
+	}
+
+	res := make([]sqlc.NotificationPref, len(s.order))
+	for i, name := range s.order {
+		res[i] = prefs[name]
+	}
+	return res, nil
+}
+
+func (s NotificationSvc) UpdatePref(ctx context.Context, channel string, req NotificationPrefRequest) (sqlc.NotificationPref, error) {
+	user, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return sqlc.NotificationPref{}, fmt.Errorf("Error UpdatePref -> GetUser: \n%w\n", err)
+	}
+
+	pref, err := s.prefFromRequest(ctx, channel, req)
+	if err != nil {
+		return sqlc.NotificationPref{}, fmt.Errorf("Error UpdatePref -> prefFromRequest: \n%w\n", err)
+	}
+	pref.UserID = user.UserID
+
+	pref, err = s.prefWriter.UpsertNotificationPref(ctx, pref)
+	if err != nil {
+		return sqlc.NotificationPref{}, fmt.Errorf("Error UpdatePref -> UpsertNotificationPref: \n%w\n", err)
+	}
+	return pref, nil
+}
+
+// GetLog returns the current user's most recent delivery attempts
+func (s NotificationSvc) GetLog(ctx context.Context, limit int32) ([]sqlc.NotificationLog, error) {
+	user, err := s.userCtxReader.GetUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetLog -> GetUser: \n%w\n", err)
+	}
+
+	if limit <= 0 {
+		limit = defaultNotificationLogLimit
+	}
+	limit = min(limit, maxNotificationLogLimit)
+
+	entries, err := s.logReader.GetNotificationLog(ctx, user.UserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error GetLog -> GetNotificationLog: \n%w\n", err)
+	}
+	return entries, nil
+}
+
+// prefsByChannel returns the user's stored preferences, or the default
+// for each channel without any
+func (s NotificationSvc) prefsByChannel(ctx context.Context, userId int32) (map[string]sqlc.NotificationPref, error) {
+	stored, err := s.prefReader.GetNotificationPrefs(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := make(map[string]sqlc.NotificationPref, len(s.order))
+	for _, name := range s.order {
+		prefs[name] = defaultNotificationPref(userId, name)
+	}
+	for _, p := range stored {
+		if _, ok := s.channels[p.Channel]; ok {
+			prefs[p.Channel] = p
+		}
+	}
+	return prefs, nil
+}
+
+// Only email is on until the user opts in to anything else
+func defaultNotificationPref(userId int32, channel string) sqlc.NotificationPref {
+	return sqlc.NotificationPref{
+		UserID:       userId,
+		Channel:      channel,
+		Enabled:      channel == notify.ChannelEmail,
+		Alerts:       true,
+		DeviceEvents: true,
+	}
+}
+
+func wantsNotification(pref sqlc.NotificationPref, kind notify.Kind) bool {
+	switch kind {
+	case notify.KindAccount:
+		// account mail may carry reset links, so it never leaves by
+		// any other channel, and can't be turned off
+		return pref.Channel == notify.ChannelEmail
+	case notify.KindAlert:
+		return pref.Enabled && pref.Alerts
+	case notify.KindDevice:
+		return pref.Enabled && pref.DeviceEvents
+	default:
+		return pref.Enabled
+	}
+}
+
+func (s NotificationSvc) prefFromRequest(ctx context.Context, channel string, req NotificationPrefRequest) (sqlc.NotificationPref, error) {
+	ch, ok := s.channels[channel]
+	if !ok {
+		return sqlc.NotificationPref{}, fmt.Errorf("unknown channel '%v': %w", channel, ErrInvalidNotificationPref)
+	}
+
+	pref := sqlc.NotificationPref{
+		Channel:      channel,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+		Target:       req.Target,
+		Alerts:       req.Alerts == nil || *req.Alerts,
+		DeviceEvents: req.DeviceEvents == nil || *req.DeviceEvents,
+	}
+
+	switch channel {
+	case notify.ChannelEmail:
+		if pref.Target != "" {
+			if _, err := mail.ParseAddress(pref.Target); err != nil {
+				return sqlc.NotificationPref{}, fmt.Errorf("target '%v' is not an email address: %w", pref.Target, ErrInvalidNotificationPref)
+			}
+		}
+	case notify.ChannelWebhook:
+		u, err := url.Parse(pref.Target)
+		if pref.Enabled && (err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "") {
+			return sqlc.NotificationPref{}, fmt.Errorf("webhook target must be an http(s) url: %w", ErrInvalidNotificationPref)
+		}
+		if v, ok := ch.(NotificationTargetValidator); ok && pref.Target != "" {
+			if err := v.ValidateTarget(ctx, pref.Target); err != nil {
+				return sqlc.NotificationPref{}, fmt.Errorf("webhook target '%v' not allowed: %v: %w", pref.Target, err, ErrInvalidNotificationPref)
+			}
+		}
+	case notify.ChannelPush:
+		// the topic is derived from the user id
+		pref.Target = ""
+	}
+	return pref, nil
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/db/sqlc"
+	"github.com/frozenkro/dirtie-srv/internal/notify"
+	"github.com/frozenkro/dirtie-srv/internal/services/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	prefReader      mocks.MockNotificationPrefReader
+	prefWriter      mocks.MockNotificationPrefWriter
+	notifyLogReader mocks.MockNotificationLogReader
+	notifyLogWriter mocks.MockNotificationLogWriter
+	notifyUserRead  mocks.MockUserReader
+	notifyUserCtx   mocks.MockUserCtxReader
+	fakeEmail       *notify.FakeChannel
+	fakeWebhook     *notify.FakeChannel
+	fakePush        *notify.FakeChannel
+	notificationSvc NotificationSvc
+)
+
+var testNotificationPolicy = NotificationPolicy{
+	DedupeWindow: time.Hour,
+	RateLimit:    10,
+	RateWindow:   time.Hour,
+}
+
+var notifyUser = sqlc.User{UserID: 3, Email: "owner@dirtie.app"}
+
+func setupNotificationSvcTests() {
+	prefReader = mocks.MockNotificationPrefReader{Mock: new(mock.Mock)}
+	prefWriter = mocks.MockNotificationPrefWriter{Mock: new(mock.Mock)}
+	notifyLogReader = mocks.MockNotificationLogReader{Mock: new(mock.Mock)}
+	notifyLogWriter = mocks.MockNotificationLogWriter{Mock: new(mock.Mock)}
+	notifyUserRead = mocks.MockUserReader{Mock: new(mock.Mock)}
+	notifyUserCtx = mocks.MockUserCtxReader{Mock: new(mock.Mock)}
+	fakeEmail = notify.NewFakeChannel(notify.ChannelEmail)
+	fakeWebhook = notify.NewFakeChannel(notify.ChannelWebhook)
+	fakePush = notify.NewFakeChannel(notify.ChannelPush)
+
+	notificationSvc = NewNotificationSvc(prefReader,
+		prefWriter,
+		notifyLogReader,
+		notifyLogWriter,
+		notifyUserRead,
+		notifyUserCtx,
+		testNotificationPolicy,
+		nil,
+		fakeEmail,
+		fakeWebhook,
+		fakePush)
+
+	notifyUserRead.On("GetUser", mock.Anything, notifyUser.UserID).Return(notifyUser, nil)
+	notifyUserCtx.On("GetUser", mock.Anything).Return(notifyUser, nil)
+	notifyLogWriter.On("CreateNotificationLog", mock.Anything, mock.Anything).Return(sqlc.NotificationLog{}, nil)
+}
+
+// under every limit unless a test says otherwise
+func stubNotificationLimits() {
+	notifyLogReader.On("CountSentNotificationsByKey", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(int64(0), nil)
+	notifyLogReader.On("CountSentNotifications", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(int64(0), nil)
+}
+
+func loggedStatus(channel string, status string) interface{} {
+	return mock.MatchedBy(func(e sqlc.NotificationLog) bool {
+		return e.Channel == channel && e.Status == status
+	})
+}
+
+func TestNotify(t *testing.T) {
+	ctx := context.Background()
+	alert := notify.Notification{Kind: notify.KindAlert, Subject: "Fern: dry fired", DeviceId: 12, DedupeKey: "alert:1:fired"}
+
+	t.Run("DefaultsToEmail", func(t *testing.T) {
+		setupNotificationSvcTests()
+		stubNotificationLimits()
+		prefReader.On("GetNotificationPrefs", ctx, notifyUser.UserID).Return([]sqlc.NotificationPref{}, nil)
+
+		err := notificationSvc.Notify(ctx, notifyUser.UserID, alert)
+
+		assert.Nil(t, err)
+		assert.Len(t, fakeEmail.Sent(), 1)
+		assert.Equal(t, notifyUser.Email, fakeEmail.Sent()[0].To.Email)
+		assert.Empty(t, fakeWebhook.Sent())
+		assert.Empty(t, fakePush.Sent())
+		notifyLogWriter.AssertCalled(t, "CreateNotificationLog", ctx, loggedStatus(notify.ChannelEmail, NotificationSent))
+	})
+
+	t.Run("Preferences", func(t *testing.T) {
+		setupNotificationSvcTests()
+		stubNotificationLimits()
+		prefReader.On("GetNotificationPrefs", ctx, notifyUser.UserID).Return([]sqlc.NotificationPref{
+			{UserID: notifyUser.UserID, Channel: notify.ChannelEmail, Enabled: true, Alerts: false, DeviceEvents: true},
+			{UserID: notifyUser.UserID, Channel: notify.ChannelWebhook, Enabled: true, Target: "https://hooks.example.com/x", Alerts: true},
+		}, nil)
+
+		err := notificationSvc.Notify(ctx, notifyUser.UserID, alert)
+
+		assert.Nil(t, err)
+		assert.Empty(t, fakeEmail.Sent())
+		assert.Len(t, fakeWebhook.Sent(), 1)
+		assert.Equal(t, "https://hooks.example.com/x", fakeWebhook.Sent()[0].To.Target)
+	})
+
+	t.Run("AccountEmailOnly", func(t *testing.T) {
+		setupNotificationSvcTests()
+		stubNotificationLimits()
+		prefReader.On("GetNotificationPrefs", ctx, notifyUser.UserID).Return([]sqlc.NotificationPref{
+			{UserID: notifyUser.UserID, Channel: notify.ChannelEmail, Enabled: false},
+			{UserID: notifyUser.UserID, Channel: notify.ChannelWebhook, Enabled: true, Target: "https://hooks.example.com/x", Alerts: true, DeviceEvents: true},
+			{UserID: notifyUser.UserID, Channel: notify.ChannelPush, Enabled: true, Alerts: true, DeviceEvents: true},
+		}, nil)
+
+		err := notificationSvc.Notify(ctx, notifyUser.UserID, notify.Notification{Kind: notify.KindAccount, Subject: "reset"})
+
+		assert.Nil(t, err)
+		assert.Len(t, fakeEmail.Sent(), 1)
+		assert.Empty(t, fakeWebhook.Sent())
+		assert.Empty(t, fakePush.Sent())
+	})
+
+	t.Run("Deduped", func(t *testing.T) {
+		setupNotificationSvcTests()
+		prefReader.On("GetNotificationPrefs", ctx, notifyUser.UserID).Return([]sqlc.NotificationPref{}, nil)
+		notifyLogReader.On("CountSentNotificationsByKey", ctx, notifyUser.UserID, notify.ChannelEmail, alert.DedupeKey, mock.Anything).Return(int64(1), nil)
+
+		err := notificationSvc.Notify(ctx, notifyUser.UserID, alert)
+
+		assert.Nil(t, err)
+		assert.Empty(t, fakeEmail.Sent())
+		notifyLogWriter.AssertCalled(t, "CreateNotificationLog", ctx, loggedStatus(notify.ChannelEmail, NotificationDeduped))
+	})
+
+	t.Run("RateLimited", func(t *testing.T) {
+		setupNotificationSvcTests()
+		prefReader.On("GetNotificationPrefs", ctx, notifyUser.UserID).Return([]sqlc.NotificationPref{}, nil)
+		notifyLogReader.On("CountSentNotificationsByKey", ctx, notifyUser.UserID, notify.ChannelEmail, alert.DedupeKey, mock.Anything).Return(int64(0), nil)
+		notifyLogReader.On("CountSentNotifications", ctx, notifyUser.UserID, notify.ChannelEmail, mock.Anything).
+			Return(int64(testNotificationPolicy.RateLimit), nil)
+
+		err := notificationSvc.Notify(ctx, notifyUser.UserID, alert)
+
+		assert.Nil(t, err)
+		assert.Empty(t, fakeEmail.Sent())
+		notifyLogWriter.AssertCalled(t, "CreateNotificationLog", ctx, loggedStatus(notify.ChannelEmail, NotificationRateLimited))
+	})
+
+	t.Run("AccountMailNotLimited", func(t *testing.T) {
+		setupNotificationSvcTests()
+		prefReader.On("GetNotificationPrefs", ctx, notifyUser.UserID).Return([]sqlc.NotificationPref{}, nil)
+
+		err := notificationSvc.Notify(ctx, notifyUser.UserID, notify.Notification{Kind: notify.KindAccount, Subject: "reset"})
+
+		assert.Nil(t, err)
+		assert.Len(t, fakeEmail.Sent(), 1)
+		notifyLogReader.AssertNotCalled(t, "CountSentNotifications", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("ChannelFailure", func(t *testing.T) {
+		setupNotificationSvcTests()
+		stubNotificationLimits()
+		fakeWebhook.Err = fmt.Errorf("connection refused")
+		prefReader.On("GetNotificationPrefs", ctx, notifyUser.UserID).Return([]sqlc.NotificationPref{
+			{UserID: notifyUser.UserID, Channel: notify.ChannelWebhook, Enabled: true, Target: "https://hooks.example.com/x", Alerts: true},
+		}, nil)
+
+		err := notificationSvc.Notify(ctx, notifyUser.UserID, alert)
+
+		// email still goes out
+		assert.ErrorIs(t, err, ErrNotificationFailed)
+		assert.Len(t, fakeEmail.Sent(), 1)
+		notifyLogWriter.AssertCalled(t, "CreateNotificationLog", ctx, loggedStatus(notify.ChannelWebhook, NotificationFailed))
+	})
+
+	t.Run("NoUser", func(t *testing.T) {
+		setupNotificationSvcTests()
+		notifyUserRead.On("GetUser", ctx, int32(99)).Return(sqlc.User{}, nil)
+
+		err := notificationSvc.Notify(ctx, 99, alert)
+
+		assert.ErrorIs(t, err, ErrNoUser)
+		assert.Empty(t, fakeEmail.Sent())
+	})
+}
+
+func TestNotifyAddress(t *testing.T) {
+	ctx := context.Background()
+	invite := notify.Notification{Kind: notify.KindAccount, Subject: "join my household"}
+
+	t.Run("Success", func(t *testing.T) {
+		setupNotificationSvcTests()
+		stubNotificationLimits()
+
+		err := notificationSvc.NotifyAddress(ctx, notifyUser.UserID, "friend@dirtie.app", invite)
+
+		assert.Nil(t, err)
+		assert.Len(t, fakeEmail.Sent(), 1)
+		assert.Equal(t, "friend@dirtie.app", fakeEmail.Sent()[0].To.Email)
+	})
+
+	t.Run("RateLimited", func(t *testing.T) {
+		setupNotificationSvcTests()
+		notifyLogReader.On("CountSentNotifications", ctx, notifyUser.UserID, notify.ChannelEmail, mock.Anything).
+			Return(int64(testNotificationPolicy.RateLimit), nil)
+
+		err := notificationSvc.NotifyAddress(ctx, notifyUser.UserID, "friend@dirtie.app", invite)
+
+		assert.ErrorIs(t, err, ErrNotificationLimited)
+		assert.Empty(t, fakeEmail.Sent())
+		notifyLogWriter.AssertCalled(t, "CreateNotificationLog", ctx, loggedStatus(notify.ChannelEmail, NotificationRateLimited))
+	})
+}
+
+func TestUpdatePref(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Defaults", func(t *testing.T) {
+		setupNotificationSvcTests()
+		exp := sqlc.NotificationPref{
+			UserID:       notifyUser.UserID,
+			Channel:      notify.ChannelWebhook,
+			Enabled:      true,
+			Target:       "https://hooks.example.com/x",
+			Alerts:       true,
+			DeviceEvents: true,
+		}
+		prefWriter.On("UpsertNotificationPref", ctx, exp).Return(exp, nil)
+
+		pref, err := notificationSvc.UpdatePref(ctx, notify.ChannelWebhook, NotificationPrefRequest{Target: exp.Target})
+
+		assert.Nil(t, err)
+		assert.Equal(t, exp, pref)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		setupNotificationSvcTests()
+		no := false
+
+		tests := []struct {
+			channel string
+			req     NotificationPrefRequest
+		}{
+			{"sms", NotificationPrefRequest{}},
+			{notify.ChannelWebhook, NotificationPrefRequest{}},
+			{notify.ChannelWebhook, NotificationPrefRequest{Target: "ftp://example.com"}},
+			{notify.ChannelEmail, NotificationPrefRequest{Target: "not an address", Enabled: &no}},
+		}
+		for _, tt := range tests {
+			_, err := notificationSvc.UpdatePref(ctx, tt.channel, tt.req)
+			assert.ErrorIs(t, err, ErrInvalidNotificationPref, tt)
+		}
+		prefWriter.AssertNotCalled(t, "UpsertNotificationPref", mock.Anything, mock.Anything)
+	})
+
+	t.Run("TargetRejectedByChannel", func(t *testing.T) {
+		setupNotificationSvcTests()
+		webhook := validatingChannel{
+			FakeChannel: notify.NewFakeChannel(notify.ChannelWebhook),
+			err:         notify.ErrForbiddenTarget,
+		}
+		svc := NewNotificationSvc(prefReader, prefWriter, notifyLogReader, notifyLogWriter,
+			notifyUserRead, notifyUserCtx, testNotificationPolicy, nil, fakeEmail, webhook)
+
+		_, err := svc.UpdatePref(ctx, notify.ChannelWebhook, NotificationPrefRequest{Target: "http://169.254.169.254/"})
+
+		assert.ErrorIs(t, err, ErrInvalidNotificationPref)
+		prefWriter.AssertNotCalled(t, "UpsertNotificationPref", mock.Anything, mock.Anything)
+	})
+}
+
+type validatingChannel struct {
+	*notify.FakeChannel
+	err error
+}
+
+func (c validatingChannel) ValidateTarget(ctx context.Context, target string) error {
+	return c.err
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/core/utils"
+	"github.com/frozenkro/dirtie-srv/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const sessionCountTimeout = 2 * time.Second
+
+type SessionCounter interface {
+	CountActiveSessions(ctx context.Context) (int64, error)
+}
+
+// NewActiveSessionsCollector reports unexpired sessions. The count is
+// queried on every scrape and reported as NaN if the query fails.
+func NewActiveSessionsCollector(counter SessionCounter) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "active_sessions",
+		Help:      "Unexpired user sessions.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), sessionCountTimeout)
+		defer cancel()
+
+		n, err := counter.CountActiveSessions(ctx)
+		if err != nil {
+			utils.LogErr(fmt.Sprintf("Error ActiveSessionsCollector -> CountActiveSessions: %v\n", err))
+			return math.NaN()
+		}
+		return float64(n)
+	})
+}
@@ -0,0 +1,146 @@
+package api_tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/frozenkro/dirtie-srv/internal/api/handlers"
+	"github.com/frozenkro/dirtie-srv/internal/api/middleware"
+	"github.com/frozenkro/dirtie-srv/internal/core"
+	"github.com/frozenkro/dirtie-srv/internal/core/int_tst"
+	"github.com/frozenkro/dirtie-srv/internal/di"
+	"github.com/frozenkro/dirtie-srv/internal/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetData(t *testing.T) {
+	ctx := int_tst.TestContext(t)
+	pg := int_tst.SetupTests(ctx, t)
+	defer pg.Close(ctx)
+
+	deps := di.NewDeps(ctx)
+	mux := http.NewServeMux()
+	mux.Handle("GET /data/{measurement}", middleware.Adapt(
+		handlers.GetDataHandler(deps.DataSvc),
+		middleware.Authorize(deps.AuthSvc),
+	))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// each tenant's device gets a distinct reading so leaks are visible
+	ownValue, otherValue := int64(1111), int64(2222)
+	startTime := time.Now().Add(-time.Minute)
+	err := deps.InfluxRepo.RecordPoint(ctx, int(int_tst.TestDevice.DeviceID), map[string]any{core.Capacitance: ownValue}, time.Now())
+	if err != nil {
+		t.Fatalf("Error recording test data point: %v", err)
+	}
+	err = deps.InfluxRepo.RecordPoint(ctx, int(int_tst.TestOtherDevice.DeviceID), map[string]any{core.Capacitance: otherValue}, time.Now())
+	if err != nil {
+		t.Fatalf("Error recording test data point: %v", err)
+	}
+	deps.InfluxRepo.Flush()
+
+	cookie := getCookie(deps.AuthSvc, t)
+
+	get := func(t *testing.T, measurement string, deviceId int32, extra ...string) *http.Response {
+		q := url.Values{}
+		q.Set("deviceId", fmt.Sprint(deviceId))
+		q.Set("startTime", startTime.Format(time.RFC3339))
+		for i := 0; i+1 < len(extra); i += 2 {
+			q.Set(extra[i], extra[i+1])
+		}
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+			server.URL+"/data/"+measurement+"?"+q.Encode(), nil)
+		if err != nil {
+			t.Fatalf("Error creating http request: %v", err)
+		}
+		req.AddCookie(cookie)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error thrown by http client: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("OwnDevice", func(t *testing.T) {
+		resp := get(t, core.Capacitance, int_tst.TestDevice.DeviceID)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var series dto.DataSeriesDto
+		err := json.NewDecoder(resp.Body).Decode(&series)
+		if err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+
+		assert.Equal(t, core.Capacitance, series.Measurement)
+		assert.NotEmpty(t, series.Points)
+		for _, p := range series.Points {
+			assert.NotEqual(t, float64(otherValue), p.Value, "response includes another tenant's reading")
+		}
+	})
+
+	t.Run("Windowed", func(t *testing.T) {
+		resp := get(t, core.Capacitance, int_tst.TestDevice.DeviceID, "window", "1h", "aggregate", "max")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var series dto.DataSeriesDto
+		err := json.NewDecoder(resp.Body).Decode(&series)
+		if err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+
+		assert.Equal(t, int64(3600), series.WindowSec)
+		assert.Equal(t, "max", series.Aggregate)
+		// the influx bucket is shared between test runs, so other
+		// readings for this device id may land in the same window
+		assert.NotEmpty(t, series.Points)
+		for _, p := range series.Points {
+			assert.GreaterOrEqual(t, p.Value, float64(ownValue))
+			assert.NotEqual(t, float64(otherValue), p.Value, "response includes another tenant's reading")
+		}
+	})
+
+	t.Run("InvalidWindow", func(t *testing.T) {
+		resp := get(t, core.Capacitance, int_tst.TestDevice.DeviceID, "window", "1s")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("OtherTenantsDevice", func(t *testing.T) {
+		resp := get(t, core.Capacitance, int_tst.TestOtherDevice.DeviceID)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("UnknownDevice", func(t *testing.T) {
+		resp := get(t, core.Capacitance, 9999)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("UnknownMeasurement", func(t *testing.T) {
+		resp := get(t, "co2", int_tst.TestDevice.DeviceID)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/data/" + core.Capacitance + "?deviceId=1&startTime=" +
+			url.QueryEscape(startTime.Format(time.RFC3339)))
+		if err != nil {
+			t.Fatalf("Error thrown by http client: %v", err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}